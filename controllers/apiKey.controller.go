@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"gorm.io/gorm"
+)
+
+const maxApiKeysPerUser = 20
+
+type ApiKeyController struct {
+	DB *gorm.DB
+}
+
+func NewApiKeyController(DB *gorm.DB) ApiKeyController {
+	return ApiKeyController{DB}
+}
+
+func mapApiKey(apiKey ApiKey) ApiKeyResponse {
+	return ApiKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     strings.Fields(apiKey.Scopes),
+		CreatedAt:  apiKey.CreatedAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		ExpiresAt:  apiKey.ExpiresAt,
+		RevokedAt:  apiKey.RevokedAt,
+	}
+}
+
+// CreateApiKey godoc
+//
+//	@Summary		Creates a personal API key
+//	@Description	Creates an API key of the current user, limited to the given "object:action" scopes of the access policy. The key is returned only once, keep it safe. API keys are accepted in the X-Api-Key header by privileged endpoints. Moderators, admins and owners can't create keys: staff routes need the second factor, which a key never stands in for.
+//	@Tags			API keys
+//	@Accept			json
+//	@Produce		json
+//	@Param			CreateApiKeyRequest	body		CreateApiKeyRequest	true	"Name, scopes and lifetime of the key"
+//	@Success		201					{object}	SuccessResponse[CreatedApiKeyResponse]
+//	@Failure		400					{object}	ErrorResponse
+//	@Failure		403					{object}	ErrorResponse
+//	@Failure		502					{object}	ErrorResponse
+//	@Router			/api-keys [post]
+func (kc *ApiKeyController) CreateApiKey(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	if !utils.ApiKeyAllowed(currentUser.Role) {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "Staff accounts can't have API keys, staff routes need the second factor"})
+		return
+	}
+
+	var payload *CreateApiKeyRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	scopes := make([]string, 0, len(payload.Scopes))
+	for _, scope := range payload.Scopes {
+		if !utils.ValidScope(scope) {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: fmt.Sprintf("Invalid scope %q, use \"object:action\" or \"*\"", scope)})
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+
+	var activeKeys int64
+	if err := kc.DB.Model(&ApiKey{}).Where("user_id = ? AND revoked_at IS NULL", currentUser.ID).Count(&activeKeys).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to create api key"})
+		return
+	}
+
+	if activeKeys >= maxApiKeysPerUser {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: fmt.Sprintf("At most %d API keys can be active", maxApiKeysPerUser)})
+		return
+	}
+
+	key, prefix, err := utils.GenerateApiKey()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to create api key"})
+		return
+	}
+
+	now := time.Now()
+
+	apiKey := ApiKey{
+		UserID:    currentUser.ID,
+		Name:      payload.Name,
+		Prefix:    prefix,
+		KeyHash:   utils.HashToken(key),
+		Scopes:    strings.Join(scopes, " "),
+		CreatedAt: now,
+	}
+
+	if payload.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, payload.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := kc.DB.Create(&apiKey).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to create api key"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, SuccessResponse[CreatedApiKeyResponse]{
+		Status: "success",
+		Data:   CreatedApiKeyResponse{ApiKeyResponse: mapApiKey(apiKey), Key: key},
+	})
+}
+
+// ListApiKeys godoc
+//
+//	@Summary		Lists API keys of the current user
+//	@Description	Returns the API keys of the current user, including revoked ones, newest first. The keys themselves are never returned again.
+//	@Tags			API keys
+//	@Produce		json
+//	@Param			page	query		int		false	"Page number"		default(1)
+//	@Param			limit	query		int		false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
+//	@Success		200		{object}	SuccessPageResponse[[]ApiKeyResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/api-keys [get]
+func (kc *ApiKeyController) ListApiKeys(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	params, err := pagination.FromQuery(ctx, pagination.By("created_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	var apiKeys []ApiKey
+	page, err := pagination.Find(kc.DB.Model(&ApiKey{}).Where("user_id = ?", currentUser.ID), params, &apiKeys)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to retrieve api keys"})
+		return
+	}
+
+	apiKeyResponses := make([]ApiKeyResponse, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		apiKeyResponses[i] = mapApiKey(apiKey)
+	}
+
+	ctx.JSON(http.StatusOK, pagination.Response(apiKeyResponses, len(apiKeys), page))
+}
+
+// RevokeApiKey godoc
+//
+//	@Summary		Revokes an API key of the current user
+//	@Description	The key stops working immediately.
+//	@Tags			API keys
+//	@Produce		json
+//	@Param			id	path		string	true	"API key ID"
+//	@Success		204	{object}	nil
+//	@Failure		400	{object}	ErrorResponse
+//	@Failure		404	{object}	ErrorResponse
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/api-keys/{id} [delete]
+func (kc *ApiKeyController) RevokeApiKey(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	apiKeyID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid api key id"})
+		return
+	}
+
+	result := kc.DB.Model(&ApiKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", apiKeyID, currentUser.ID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to revoke api key"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "API key not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
@@ -1,10 +1,14 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
 	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"log"
 	"math/rand/v2"
 	"net/http"
 	"strconv"
@@ -12,30 +16,188 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type AuthController struct {
-	DB *gorm.DB
+	DB             *gorm.DB
+	SmsSender      utils.SmsSender
+	TelegramSender utils.TelegramSender
+	LoginGuard     *utils.LoginGuard
 }
 
-func NewAuthController(DB *gorm.DB) AuthController {
-	return AuthController{DB}
+func NewAuthController(DB *gorm.DB, smsSender utils.SmsSender, telegramSender utils.TelegramSender, loginGuard *utils.LoginGuard) AuthController {
+	return AuthController{DB, smsSender, telegramSender, loginGuard}
 }
 
 const defaultUserAvatar = ""
 
+// startSession creates a new session for the user and returns an access token
+// together with the first refresh token of the session.
+func (ac *AuthController) startSession(ctx *gin.Context, user *User, twoFactor bool, config *initializers.Config) (string, string, error) {
+	now := time.Now()
+
+	userAgent := ctx.Request.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	device := ctx.GetHeader("X-Device-Name")
+	if device == "" || len(device) > 100 {
+		device = utils.DetectDevice(userAgent)
+	}
+
+	session := Session{
+		UserID:     user.ID,
+		Device:     device,
+		IP:         ctx.ClientIP(),
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(config.RefreshTokenExpiresIn),
+		TwoFactor:  twoFactor,
+	}
+
+	var refreshToken string
+
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		refreshToken, err = ac.issueRefreshToken(tx, &session, config)
+		return err
+	})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := createAccessToken(user, &session, config)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// createAccessToken signs an access token bound to the session, so the token
+// stops working as soon as the session is revoked.
+func createAccessToken(user *User, session *Session, config *initializers.Config) (string, error) {
+	return utils.CreateToken(
+		config.AccessTokenExpiresIn,
+		utils.TokenClaims{
+			StandardClaims: jwt.StandardClaims{Subject: user.ID.String()},
+			Type:           utils.AccessTokenType,
+			SessionID:      session.ID.String(),
+		},
+		config.AccessTokenPrivateKey)
+}
+
+// issueRefreshToken stores a new refresh token for the session, extends the
+// session lifetime and returns the signed token.
+func (ac *AuthController) issueRefreshToken(tx *gorm.DB, session *Session, config *initializers.Config) (string, error) {
+	now := time.Now()
+	expiresAt := now.Add(config.RefreshTokenExpiresIn)
+
+	refreshToken := RefreshToken{
+		SessionID: session.ID,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+
+	if err := tx.Model(session).Updates(Session{ExpiresAt: expiresAt, UpdatedAt: now, LastSeenAt: now}).Error; err != nil {
+		return "", err
+	}
+
+	return utils.CreateToken(
+		config.RefreshTokenExpiresIn,
+		utils.TokenClaims{
+			StandardClaims: jwt.StandardClaims{Subject: session.UserID.String(), Id: refreshToken.ID.String()},
+			Type:           utils.RefreshTokenType,
+			SessionID:      session.ID.String(),
+		},
+		config.RefreshTokenPrivateKey)
+}
+
+// revokeSession ends the session, so none of its refresh tokens can be used anymore.
+func (ac *AuthController) revokeSession(sessionID uuid.UUID) error {
+	defer initializers.ForgetSession(sessionID)
+
+	return ac.DB.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserSessions ends every session of the user.
+func (ac *AuthController) revokeUserSessions(userID uuid.UUID) error {
+	defer initializers.ForgetUser(userID)
+
+	return revokeUserSessionsExcept(ac.DB, userID, uuid.Nil)
+}
+
+// revokeUserSessionsExcept ends every session of the user but keep. Callers
+// running it in a transaction drop the user from AuthCache after the commit.
+func revokeUserSessionsExcept(tx *gorm.DB, userID uuid.UUID, keep uuid.UUID) error {
+	return tx.Model(&Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+		Update("revoked_at", time.Now()).Error
+}
+
+const defaultTelegramAuthMaxAge = 24 * time.Hour
+
+// verifyTelegramUser checks the signed Telegram payload and makes sure it
+// belongs to the claimed Telegram user.
+func verifyTelegramUser(telegram *TelegramLoginData, initData string, telegramUserId string, config *initializers.Config) error {
+	maxAge := config.TelegramAuthMaxAge
+	if maxAge <= 0 {
+		maxAge = defaultTelegramAuthMaxAge
+	}
+
+	var verifiedId int64
+	var err error
+
+	switch {
+	case initData != "":
+		verifiedId, err = utils.VerifyTelegramInitData(initData, config.TelegramBotToken, maxAge)
+	case telegram != nil:
+		verifiedId, err = utils.VerifyTelegramLogin(telegram, config.TelegramBotToken, maxAge)
+	default:
+		return fmt.Errorf("signed telegram payload is required")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if strconv.FormatInt(verifiedId, 10) != telegramUserId {
+		return fmt.Errorf("telegram payload does not match telegramUserId")
+	}
+
+	return nil
+}
+
 // BotSignUpUser godoc
 //
 //	@Summary		Registers a new user via bot
-//	@Description	Registers a new user by accepting Telegram user ID and other basic details. Automatically generates password.
+//	@Description	Registers a new user by accepting Telegram user ID, its signed Telegram login payload and other basic details. Automatically generates password. Requires the bot credential in X-Bot-Token header.
 //	@Tags			Auth
 //	@Accept			json
 //	@Produce		json
-//	@Param			body	body		BotSignUpRequest	true	"Bot Signup Input"
-//	@Success		201		{object}	UserResponse
-//	@Failure		400		{object}	ErrorResponse
-//	@Failure		502		{object}	ErrorResponse
+//	@Param			X-Bot-Token	header		string				true	"Bot credential"
+//	@Param			body		body		BotSignUpRequest	true	"Bot Signup Input"
+//	@Success		201			{object}	UserResponse
+//	@Failure		400			{object}	ErrorResponse
+//	@Failure		401			{object}	ErrorResponse
+//	@Failure		502			{object}	ErrorResponse
 //	@Router			/auth/bot/signup [post]
 func (ac *AuthController) BotSignUpUser(ctx *gin.Context) {
 	var payload *BotSignUpRequest
@@ -45,6 +207,13 @@ func (ac *AuthController) BotSignUpUser(ctx *gin.Context) {
 		return
 	}
 
+	config, _ := initializers.LoadConfig(".")
+
+	if err := verifyTelegramUser(payload.Telegram, payload.InitData, payload.TelegramUserId, &config); err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
 	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
 	generatedPassword := utils.GenerateRandomStringWithPrefix(random, 13, "")
 	hashedPassword, err := utils.HashPassword(generatedPassword)
@@ -57,6 +226,7 @@ func (ac *AuthController) BotSignUpUser(ctx *gin.Context) {
 	telegramUserId, err := strconv.ParseInt(payload.TelegramUserId, 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
 	}
 
 	now := time.Now()
@@ -171,13 +341,16 @@ func (ac *AuthController) SignUpUser(ctx *gin.Context) {
 // BotSignInUser godoc
 //
 //	@Summary		Logs in a bot user
-//	@Description	Authenticates a bot user by accepting Telegram User ID.
+//	@Description	Authenticates a bot user by accepting Telegram User ID and its signed Telegram login payload. Requires the bot credential in X-Bot-Token header.
 //	@Tags			Auth
 //	@Accept			json
 //	@Produce		json
-//	@Param			body	body		BotSignInRequest	true	"Bot SignIn Input"
-//	@Success		200		{object}	TokenResponse
-//	@Failure		400		{object}	ErrorResponse
+//	@Param			X-Bot-Token	header		string				true	"Bot credential"
+//	@Param			body		body		BotSignInRequest	true	"Bot SignIn Input"
+//	@Success		200			{object}	TokenResponse
+//	@Success		202			{object}	TwoFactorChallengeResponse
+//	@Failure		400			{object}	ErrorResponse
+//	@Failure		401			{object}	ErrorResponse
 //	@Router			/auth/bot/login [post]
 func (ac *AuthController) BotSignInUser(ctx *gin.Context) {
 	var payload *BotSignInRequest
@@ -187,32 +360,21 @@ func (ac *AuthController) BotSignInUser(ctx *gin.Context) {
 		return
 	}
 
-	var user User
-	result := ac.DB.First(&user, "telegram_user_id = ?", strings.ToLower(payload.TelegramUserId))
-	if result.Error != nil {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid phone or Password"})
-		return
-	}
-
 	config, _ := initializers.LoadConfig(".")
 
-	access_token, err := utils.CreateToken(config.AccessTokenExpiresIn, user.ID, config.AccessTokenPrivateKey)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+	if err := verifyTelegramUser(payload.Telegram, payload.InitData, payload.TelegramUserId, &config); err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
-	refresh_token, err := utils.CreateToken(config.RefreshTokenExpiresIn, user.ID, config.RefreshTokenPrivateKey)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+	var user User
+	result := ac.DB.First(&user, "telegram_user_id = ?", strings.ToLower(payload.TelegramUserId))
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid phone or Password"})
 		return
 	}
 
-	ctx.SetCookie("access_token", access_token, config.AccessTokenMaxAge*60, "/", "localhost", false, true)
-	ctx.SetCookie("refresh_token", refresh_token, config.RefreshTokenMaxAge*60, "/", "localhost", false, true)
-	ctx.SetCookie("logged_in", "true", config.AccessTokenMaxAge*60, "/", "localhost", false, false)
-
-	ctx.JSON(http.StatusOK, TokenResponse{Status: "success", AccessToken: access_token})
+	ac.completeSignIn(ctx, &user, &config)
 }
 
 // SignInUser godoc
@@ -224,7 +386,9 @@ func (ac *AuthController) BotSignInUser(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			body	body		SignInRequest	true	"SignIn Input"
 //	@Success		200		{object}	TokenResponse
+//	@Success		202		{object}	TwoFactorChallengeResponse
 //	@Failure		400		{object}	ErrorResponse
+//	@Failure		429		{object}	ErrorResponse
 //	@Router			/auth/login [post]
 func (ac *AuthController) SignInUser(ctx *gin.Context) {
 	var payload *SignInRequest
@@ -234,43 +398,69 @@ func (ac *AuthController) SignInUser(ctx *gin.Context) {
 		return
 	}
 
+	phone := strings.ToLower(payload.Phone)
+	ip := ctx.ClientIP()
+	now := time.Now()
+
+	// the attempt counts as failed until the password is checked
+	if wait := ac.LoginGuard.Attempt(phone, ip, now); wait > 0 {
+		ac.recordFailedLogin(ctx, phone, nil, "throttled")
+		ctx.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		ctx.JSON(http.StatusTooManyRequests, ErrorResponse{Status: "error", Message: "Too many failed login attempts, try again later"})
+		return
+	}
+
 	var user User
-	result := ac.DB.First(&user, "phone = ?", strings.ToLower(payload.Phone))
+	result := ac.DB.First(&user, "phone = ?", phone)
 	if result.Error != nil {
+		ac.recordFailedLogin(ctx, phone, nil, "unknown_user")
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid phone or Password"})
 		return
 	}
 
 	if err := utils.VerifyPassword(user.Password, payload.Password); err != nil {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid email or Password"})
+		ac.recordFailedLogin(ctx, phone, &user.ID, "invalid_password")
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid phone or Password"})
 		return
 	}
 
+	ac.LoginGuard.Success(phone, ip)
+
 	config, _ := initializers.LoadConfig(".")
 
-	access_token, err := utils.CreateToken(config.AccessTokenExpiresIn, user.ID, config.AccessTokenPrivateKey)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
-		return
+	ac.completeSignIn(ctx, &user, &config)
+}
+
+// recordFailedLogin adds the failed attempt to the audit trail. The login is
+// refused anyway, so a failed write is only logged.
+func (ac *AuthController) recordFailedLogin(ctx *gin.Context, phone string, userID *uuid.UUID, reason string) {
+	userAgent := ctx.Request.UserAgent()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
 	}
 
-	refresh_token, err := utils.CreateToken(config.RefreshTokenExpiresIn, user.ID, config.RefreshTokenPrivateKey)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
-		return
+	if len(phone) > 30 {
+		phone = phone[:30]
 	}
 
-	ctx.SetCookie("access_token", access_token, config.AccessTokenMaxAge*60, "/", "localhost", false, true)
-	ctx.SetCookie("refresh_token", refresh_token, config.RefreshTokenMaxAge*60, "/", "localhost", false, true)
-	ctx.SetCookie("logged_in", "true", config.AccessTokenMaxAge*60, "/", "localhost", false, false)
+	failedLogin := FailedLogin{
+		Phone:     phone,
+		UserID:    userID,
+		IP:        ctx.ClientIP(),
+		UserAgent: userAgent,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
 
-	ctx.JSON(http.StatusOK, TokenResponse{Status: "success", AccessToken: access_token})
+	if err := ac.DB.Create(&failedLogin).Error; err != nil {
+		log.Printf("failed to record failed login: %v", err)
+	}
 }
 
 // RefreshAccessToken godoc
 //
 //	@Summary		Refreshes access token
-//	@Description	Refreshes the access token using the refresh token cookie.
+//	@Description	Refreshes the access token using the refresh token cookie. The refresh token is rotated: a new one is set and the used one is retired. Presenting a retired refresh token revokes the whole session.
 //	@Tags			Auth
 //	@Produce		json
 //	@Success		200	{object}	TokenResponse
@@ -288,26 +478,89 @@ func (ac *AuthController) RefreshAccessToken(ctx *gin.Context) {
 
 	config, _ := initializers.LoadConfig(".")
 
-	sub, err := utils.ValidateToken(cookie, config.RefreshTokenPublicKey)
+	claims, err := utils.ValidateToken(cookie, utils.RefreshTokenType, config.RefreshTokenVerificationKeys()...)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
+	tokenID, err := uuid.Parse(claims.Id)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: message})
+		return
+	}
+
+	var refreshToken RefreshToken
+	if err := ac.DB.First(&refreshToken, "id = ?", tokenID).Error; err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: message})
+		return
+	}
+
+	var session Session
+	if err := ac.DB.First(&session, "id = ?", refreshToken.SessionID).Error; err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: message})
+		return
+	}
+
+	if session.RevokedAt != nil || session.UserID.String() != claims.Subject {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "session is revoked"})
+		return
+	}
+
 	var user User
-	result := ac.DB.First(&user, "id = ?", fmt.Sprint(sub))
+	result := ac.DB.First(&user, "id = ?", session.UserID)
 	if result.Error != nil {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "user not exist"})
 		return
 	}
 
-	access_token, err := utils.CreateToken(config.AccessTokenExpiresIn, user.ID, config.AccessTokenPrivateKey)
+	reused := false
+	var refresh_token string
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		// Retire the presented token. If somebody has already used it, the token
+		// has leaked: nothing is rotated and the whole session gets revoked below.
+		retired := tx.Model(&RefreshToken{}).
+			Where("id = ? AND retired_at IS NULL", refreshToken.ID).
+			Update("retired_at", time.Now())
+
+		if retired.Error != nil {
+			return retired.Error
+		}
+
+		if retired.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		var err error
+		refresh_token, err = ac.issueRefreshToken(tx, &session, &config)
+		return err
+	})
+
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: message})
+		return
+	}
+
+	if reused {
+		if err := ac.revokeSession(session.ID); err != nil {
+			ctx.AbortWithStatusJSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: message})
+			return
+		}
+
+		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "refresh token reuse detected, session is revoked"})
+		return
+	}
+
+	access_token, err := createAccessToken(&user, &session, &config)
 	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
 	ctx.SetCookie("access_token", access_token, config.AccessTokenMaxAge*60, "/", "localhost", false, true)
+	ctx.SetCookie("refresh_token", refresh_token, config.RefreshTokenMaxAge*60, "/", "localhost", false, true)
 	ctx.SetCookie("logged_in", "true", config.AccessTokenMaxAge*60, "/", "localhost", false, false)
 
 	ctx.JSON(http.StatusOK, TokenResponse{Status: "success", AccessToken: access_token})
@@ -316,15 +569,1274 @@ func (ac *AuthController) RefreshAccessToken(ctx *gin.Context) {
 // LogoutUser godoc
 //
 //	@Summary		Logs out a user
-//	@Description	Clears the access and refresh tokens and logs out the user.
+//	@Description	Revokes the session of the refresh token cookie, clears the access and refresh tokens and logs out the user.
 //	@Tags			Auth
 //	@Produce		json
 //	@Success		200	{object}	SuccessResponse[any]
+//	@Failure		502	{object}	ErrorResponse
 //	@Router			/auth/logout [post]
 func (ac *AuthController) LogoutUser(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	if cookie, err := ctx.Cookie("refresh_token"); err == nil {
+		config, _ := initializers.LoadConfig(".")
+
+		if claims, err := utils.ValidateToken(cookie, utils.RefreshTokenType, config.RefreshTokenVerificationKeys()...); err == nil {
+			if tokenID, err := uuid.Parse(claims.Id); err == nil {
+				var refreshToken RefreshToken
+				result := ac.DB.Joins("JOIN sessions ON sessions.id = refresh_tokens.session_id").
+					Where("refresh_tokens.id = ? AND sessions.user_id = ?", tokenID, currentUser.ID).
+					First(&refreshToken)
+
+				if result.Error == nil {
+					if err := ac.revokeSession(refreshToken.SessionID); err != nil {
+						ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to revoke session"})
+						return
+					}
+				}
+			}
+		}
+	}
+
+	ctx.SetCookie("access_token", "", -1, "/", "localhost", false, true)
+	ctx.SetCookie("refresh_token", "", -1, "/", "localhost", false, true)
+	ctx.SetCookie("logged_in", "", -1, "/", "localhost", false, false)
+
+	ctx.JSON(http.StatusOK, SuccessResponse[any]{Status: "success"})
+}
+
+// ListSessions godoc
+//
+//	@Summary		Lists active sessions of the current user
+//	@Description	Returns the active logins of the current user with their device, IP, user agent and last activity time, most recently active first.
+//	@Tags			Auth
+//	@Produce		json
+//	@Param			page	query		int		false	"Page number"		default(1)
+//	@Param			limit	query		int		false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
+//	@Success		200		{object}	SuccessPageResponse[[]SessionResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/sessions [get]
+func (ac *AuthController) ListSessions(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	params, err := pagination.FromQuery(ctx, pagination.By("last_seen_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	query := ac.DB.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", currentUser.ID, time.Now())
+
+	var sessions []Session
+	page, err := pagination.Find(query, params, &sessions)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to retrieve sessions"})
+		return
+	}
+
+	currentSessionID, _ := ctx.Get("currentSessionID")
+
+	sessionResponses := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		sessionResponses[i] = SessionResponse{
+			ID:         session.ID,
+			Device:     session.Device,
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			Current:    session.ID == currentSessionID,
+			TwoFactor:  session.TwoFactor,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+		}
+	}
+
+	ctx.JSON(http.StatusOK, pagination.Response(sessionResponses, len(sessions), page))
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Revokes one session of the current user
+//	@Description	Ends the given session of the current user. Its access and refresh tokens stop working immediately.
+//	@Tags			Auth
+//	@Produce		json
+//	@Param			id	path		string	true	"Session ID"
+//	@Success		204	{object}	nil
+//	@Failure		400	{object}	ErrorResponse
+//	@Failure		404	{object}	ErrorResponse
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/auth/sessions/{id} [delete]
+func (ac *AuthController) RevokeSession(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	sessionID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid session id"})
+		return
+	}
+
+	result := ac.DB.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, currentUser.ID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to revoke session"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "Session not found"})
+		return
+	}
+
+	initializers.ForgetSession(sessionID)
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// RevokeAllSessions godoc
+//
+//	@Summary		Signs the current user out everywhere
+//	@Description	Revokes every session of the current user, including the current one, and clears the auth cookies.
+//	@Tags			Auth
+//	@Produce		json
+//	@Success		200	{object}	SuccessResponse[any]
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/auth/sessions [delete]
+func (ac *AuthController) RevokeAllSessions(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	if err := ac.revokeUserSessions(currentUser.ID); err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to revoke sessions"})
+		return
+	}
+
 	ctx.SetCookie("access_token", "", -1, "/", "localhost", false, true)
 	ctx.SetCookie("refresh_token", "", -1, "/", "localhost", false, true)
 	ctx.SetCookie("logged_in", "", -1, "/", "localhost", false, false)
 
 	ctx.JSON(http.StatusOK, SuccessResponse[any]{Status: "success"})
 }
+
+// RevokeUserSessions godoc
+//
+//	@Summary		Ends every session of a user (privileged access)
+//	@Description	Allows privileged users to sign a user out on all devices. Admins cannot end sessions of other admins or owners.
+//	@Tags			Auth
+//	@Produce		json
+//	@Param			id	path		string	true	"User ID"
+//	@Success		204	{object}	nil
+//	@Failure		403	{object}	ErrorResponse
+//	@Failure		404	{object}	ErrorResponse
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/auth/sessions/user/{id} [delete]
+func (ac *AuthController) RevokeUserSessions(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var targetUser User
+	if err := ac.DB.First(&targetUser, "id = ?", ctx.Param("id")).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "User not found"})
+		return
+	}
+
+	if currentUser.Role == "admin" && (targetUser.Role == "admin" || targetUser.Role == "owner") && targetUser.ID != currentUser.ID {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "You are not authorized to end sessions of this user"})
+		return
+	}
+
+	if err := ac.revokeUserSessions(targetUser.ID); err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to revoke sessions"})
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+const (
+	otpCodeLength            = 6
+	defaultOtpTTL            = 5 * time.Minute
+	defaultOtpResendCooldown = time.Minute
+	defaultOtpMaxAttempts    = 5
+	defaultOtpMaxSendsPerDay = 10
+)
+
+func otpSettings(config *initializers.Config) (ttl time.Duration, cooldown time.Duration, maxAttempts int, maxSendsPerDay int) {
+	ttl, cooldown, maxAttempts, maxSendsPerDay = config.OtpTTL, config.OtpResendCooldown, config.OtpMaxAttempts, config.OtpMaxSendsPerDay
+
+	if ttl <= 0 {
+		ttl = defaultOtpTTL
+	}
+	if cooldown <= 0 {
+		cooldown = defaultOtpResendCooldown
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultOtpMaxAttempts
+	}
+	if maxSendsPerDay <= 0 {
+		maxSendsPerDay = defaultOtpMaxSendsPerDay
+	}
+
+	return
+}
+
+// RequestPhoneVerification godoc
+//
+//	@Summary		Sends a phone verification code
+//	@Description	Sends a one-time code by SMS to the phone of the current user. A new code can be requested only after the resend cooldown; requesting a new code invalidates the previous one.
+//	@Tags			Auth
+//	@Produce		json
+//	@Success		200	{object}	SuccessResponse[PhoneVerificationResponse]
+//	@Failure		409	{object}	ErrorResponse
+//	@Failure		429	{object}	ErrorResponse
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/auth/phone/otp [post]
+func (ac *AuthController) RequestPhoneVerification(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	if currentUser.Verified {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "Phone is already verified"})
+		return
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	ttl, cooldown, _, maxSendsPerDay := otpSettings(&config)
+
+	now := time.Now()
+
+	var last PhoneVerification
+	result := ac.DB.Where("user_id = ?", currentUser.ID).Order("created_at DESC").Limit(1).Find(&last)
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Something bad happened"})
+		return
+	}
+
+	if result.RowsAffected != 0 && now.Before(last.CreatedAt.Add(cooldown)) {
+		ctx.Header("Retry-After", strconv.Itoa(int(last.CreatedAt.Add(cooldown).Sub(now).Seconds())+1))
+		ctx.JSON(http.StatusTooManyRequests, ErrorResponse{Status: "error", Message: "Code was sent recently, try again later"})
+		return
+	}
+
+	var sentToday int64
+	if err := ac.DB.Model(&PhoneVerification{}).Where("user_id = ? AND created_at > ?", currentUser.ID, now.Add(-24*time.Hour)).Count(&sentToday).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Something bad happened"})
+		return
+	}
+
+	if sentToday >= int64(maxSendsPerDay) {
+		ctx.JSON(http.StatusTooManyRequests, ErrorResponse{Status: "error", Message: "Too many codes requested, try again tomorrow"})
+		return
+	}
+
+	code, err := utils.GenerateOtpCode(otpCodeLength)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	codeHash, err := utils.HashPassword(code)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	verification := PhoneVerification{
+		UserID:    currentUser.ID,
+		Phone:     currentUser.Phone,
+		CodeHash:  codeHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		// only the newest code is valid
+		if err := tx.Model(&PhoneVerification{}).
+			Where("user_id = ? AND verified_at IS NULL AND expires_at > ?", currentUser.ID, now).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&verification).Error; err != nil {
+			return err
+		}
+
+		return ac.SmsSender.Send(currentUser.Phone, fmt.Sprintf("Your verification code: %s", code))
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to send verification code"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[*PhoneVerificationResponse]{
+		Status: "success",
+		Data: &PhoneVerificationResponse{
+			Phone:       verification.Phone,
+			ExpiresAt:   verification.ExpiresAt,
+			ResendAfter: now.Add(cooldown),
+		},
+	})
+}
+
+// ConfirmPhoneVerification godoc
+//
+//	@Summary		Confirms the phone verification code
+//	@Description	Checks the one-time code sent to the current user's phone and marks the phone as verified. Each code allows a limited number of attempts.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		ConfirmPhoneRequest	true	"Verification code"
+//	@Success		200		{object}	SuccessResponse[UserResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		429		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/phone/verify [post]
+func (ac *AuthController) ConfirmPhoneVerification(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *ConfirmPhoneRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	config, _ := initializers.LoadConfig(".")
+	_, _, maxAttempts, _ := otpSettings(&config)
+
+	now := time.Now()
+
+	var verification PhoneVerification
+	result := ac.DB.
+		Where("user_id = ? AND phone = ? AND verified_at IS NULL AND expires_at > ?", currentUser.ID, currentUser.Phone, now).
+		Order("created_at DESC").
+		First(&verification)
+
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Code is expired or was not requested"})
+		return
+	}
+
+	// count the attempt before checking the code, so parallel guesses can't exceed the limit
+	attempt := ac.DB.Model(&PhoneVerification{}).
+		Where("id = ? AND attempts < ?", verification.ID, maxAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+
+	if attempt.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Something bad happened"})
+		return
+	}
+
+	if attempt.RowsAffected == 0 {
+		ctx.JSON(http.StatusTooManyRequests, ErrorResponse{Status: "error", Message: "Too many attempts, request a new code"})
+		return
+	}
+
+	if err := utils.VerifyPassword(verification.CodeHash, payload.Code); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("Invalid code, %d attempts left", maxAttempts-verification.Attempts-1),
+		})
+		return
+	}
+
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&verification).Update("verified_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&currentUser).Updates(map[string]interface{}{"verified": true, "updated_at": now}).Error
+	})
+
+	initializers.ForgetUser(currentUser.ID)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to verify phone"})
+		return
+	}
+
+	currentUser.Verified = true
+	currentUser.UpdatedAt = now
+
+	userResponse := &UserResponse{
+		ID:             currentUser.ID,
+		TelegramUserID: currentUser.TelegramUserId,
+		Name:           currentUser.Name,
+		Phone:          currentUser.Phone,
+		Avatar:         currentUser.Avatar,
+		Verified:       currentUser.Verified,
+		CreatedAt:      currentUser.CreatedAt,
+		UpdatedAt:      currentUser.UpdatedAt,
+		Tier:           currentUser.Tier,
+		Role:           currentUser.Role,
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[*UserResponse]{Status: "success", Data: userResponse})
+}
+
+// ChangePassword godoc
+//
+//	@Summary		Changes the password of the current user
+//	@Description	Checks the old password and sets the new one. Every other session of the user is revoked, the current one stays signed in.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		UpdateUserPassword	true	"Old and new password"
+//	@Success		200		{object}	SuccessResponse[any]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/password [put]
+func (ac *AuthController) ChangePassword(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *UpdateUserPassword
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if err := utils.VerifyPassword(currentUser.Password, payload.OldPassword); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid password"})
+		return
+	}
+
+	if payload.OldPassword == payload.NewPassword {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "New password must differ from the old one"})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(payload.NewPassword)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	// tokens without a session id can't be told apart, so the current session id is nil and everything is revoked
+	currentSessionID, _ := ctx.Value("currentSessionID").(uuid.UUID)
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&currentUser).Updates(map[string]interface{}{"password": hashedPassword, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+
+		return revokeUserSessionsExcept(tx, currentUser.ID, currentSessionID)
+	})
+
+	initializers.ForgetUser(currentUser.ID)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to change password"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[any]{Status: "success"})
+}
+
+const (
+	passwordResetTokenBytes  = 32
+	defaultPasswordResetTTL  = 15 * time.Minute
+	passwordResetRequestStep = time.Minute
+)
+
+// RequestPasswordReset godoc
+//
+//	@Summary		Sends a password reset token
+//	@Description	Sends a single-use password reset token to the user with the given phone, by SMS or by a message from the Telegram bot. The response is the same whether the user exists or not.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		RequestPasswordResetRequest	true	"Phone and delivery channel"
+//	@Success		202		{object}	SuccessResponse[any]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/password/reset [post]
+func (ac *AuthController) RequestPasswordReset(ctx *gin.Context) {
+	var payload *RequestPasswordResetRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	accepted := SuccessResponse[any]{Status: "success"}
+
+	var user User
+	if err := ac.DB.First(&user, "phone = ?", payload.Phone).Error; err != nil {
+		ctx.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	// codes go only where we know the user is reachable
+	if (payload.Channel == "sms" && !user.Verified) || (payload.Channel == "telegram" && user.TelegramUserId <= 0) {
+		ctx.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	now := time.Now()
+
+	var recent int64
+	if err := ac.DB.Model(&PasswordReset{}).Where("user_id = ? AND created_at > ?", user.ID, now.Add(-passwordResetRequestStep)).Count(&recent).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Something bad happened"})
+		return
+	}
+
+	if recent > 0 {
+		ctx.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	config, _ := initializers.LoadConfig(".")
+
+	ttl := config.PasswordResetTTL
+	if ttl <= 0 {
+		ttl = defaultPasswordResetTTL
+	}
+
+	token, err := utils.GenerateSecureToken(passwordResetTokenBytes)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	reset := PasswordReset{
+		UserID:    user.ID,
+		TokenHash: utils.HashToken(token),
+		Channel:   payload.Channel,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	message := fmt.Sprintf("Your password reset token: %s", token)
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		// only the newest token is valid
+		if err := tx.Model(&PasswordReset{}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ?", user.ID, now).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Create(&reset).Error; err != nil {
+			return err
+		}
+
+		if payload.Channel == "telegram" {
+			return ac.TelegramSender.SendMessage(user.TelegramUserId, message)
+		}
+
+		return ac.SmsSender.Send(user.Phone, message)
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to send reset token"})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, accepted)
+}
+
+// ResetPassword godoc
+//
+//	@Summary		Sets a new password with a reset token
+//	@Description	Uses the reset token to set a new password. The token works only once, and every session of the user is revoked.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		ResetPasswordRequest	true	"Reset token and new password"
+//	@Success		200		{object}	SuccessResponse[any]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/password/reset/confirm [post]
+func (ac *AuthController) ResetPassword(ctx *gin.Context) {
+	var payload *ResetPasswordRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if payload.Password != payload.PasswordConfirm {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Passwords do not match"})
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(payload.Password)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	now := time.Now()
+	invalid := false
+
+	var reset PasswordReset
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		// marking the token used in the same statement that finds it keeps parallel requests from using it twice
+		result := tx.Model(&reset).
+			Clauses(clause.Returning{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(payload.Token), now).
+			Update("used_at", now)
+
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			invalid = true
+			return nil
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", reset.UserID).
+			Updates(map[string]interface{}{"password": hashedPassword, "updated_at": now}).Error; err != nil {
+			return err
+		}
+
+		return revokeUserSessionsExcept(tx, reset.UserID, uuid.Nil)
+	})
+
+	initializers.ForgetUser(reset.UserID)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to reset password"})
+		return
+	}
+
+	if invalid {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Reset token is invalid or expired"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[any]{Status: "success"})
+}
+
+const (
+	defaultTwoFactorIssuer  = "golang-gorm-postgres"
+	defaultTwoFactorPreAuth = 5 * time.Minute
+	twoFactorMaxAttempts    = 5
+	twoFactorLockout        = 5 * time.Minute
+	twoFactorRecoveryCodes  = 10
+)
+
+var errTwoFactorLocked = errors.New("too many invalid codes, try again later")
+
+// completeSignIn finishes a login whose credentials were already checked.
+// Users with two-factor authentication get a short-lived pre-auth token for
+// the second step instead of a session.
+func (ac *AuthController) completeSignIn(ctx *gin.Context, user *User, config *initializers.Config) {
+	var enrollment TwoFactorAuth
+	result := ac.DB.Where("user_id = ? AND confirmed_at IS NOT NULL", user.ID).Limit(1).Find(&enrollment)
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Something bad happened"})
+		return
+	}
+
+	if result.RowsAffected != 0 {
+		ttl := config.TwoFactorPreAuthTTL
+		if ttl <= 0 {
+			ttl = defaultTwoFactorPreAuth
+		}
+
+		preAuthToken, err := utils.CreateToken(
+			ttl,
+			utils.TokenClaims{StandardClaims: jwt.StandardClaims{Subject: user.ID.String()}, Type: utils.PreAuthTokenType},
+			config.AccessTokenPrivateKey)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+			return
+		}
+
+		ctx.JSON(http.StatusAccepted, TwoFactorChallengeResponse{Status: "success", TwoFactorRequired: true, PreAuthToken: preAuthToken})
+		return
+	}
+
+	ac.signInWithSession(ctx, user, false, config)
+}
+
+// signInWithSession starts a session and hands its tokens to the client.
+func (ac *AuthController) signInWithSession(ctx *gin.Context, user *User, twoFactor bool, config *initializers.Config) {
+	access_token, refresh_token, err := ac.startSession(ctx, user, twoFactor, config)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	ctx.SetCookie("access_token", access_token, config.AccessTokenMaxAge*60, "/", "localhost", false, true)
+	ctx.SetCookie("refresh_token", refresh_token, config.RefreshTokenMaxAge*60, "/", "localhost", false, true)
+	ctx.SetCookie("logged_in", "true", config.AccessTokenMaxAge*60, "/", "localhost", false, false)
+
+	ctx.JSON(http.StatusOK, TokenResponse{Status: "success", AccessToken: access_token})
+}
+
+// checkSecondFactor checks a TOTP code, or a recovery code when allowRecovery
+// is set. Used TOTP steps and recovery codes are remembered so they can't be
+// replayed, and too many invalid codes lock the second factor for a while.
+// The attempt is counted in the database before the code is checked, so
+// parallel guesses can't get past the limit.
+func (ac *AuthController) checkSecondFactor(enrollment *TwoFactorAuth, code string, allowRecovery bool) (bool, error) {
+	now := time.Now()
+
+	var counted []TwoFactorAuth
+	result := ac.DB.Model(&counted).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_attempts"}}}).
+		Where("user_id = ? AND (locked_until IS NULL OR locked_until <= ?)", enrollment.UserID, now).
+		Update("failed_attempts", gorm.Expr("failed_attempts + 1"))
+
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 0 || len(counted) == 0 {
+		return false, errTwoFactorLocked
+	}
+
+	attempts := counted[0].FailedAttempts
+	lock := map[string]interface{}{"failed_attempts": 0, "locked_until": now.Add(twoFactorLockout)}
+
+	if attempts > twoFactorMaxAttempts {
+		if err := ac.DB.Model(&TwoFactorAuth{}).Where("user_id = ?", enrollment.UserID).Updates(lock).Error; err != nil {
+			return false, err
+		}
+		return false, errTwoFactorLocked
+	}
+
+	reset := map[string]interface{}{"failed_attempts": 0, "locked_until": nil}
+
+	if step, ok := utils.ValidateTotpCode(enrollment.Secret, code, now); ok {
+		reset["last_used_step"] = step
+
+		// the step condition makes parallel requests with the same code fail
+		result := ac.DB.Model(&TwoFactorAuth{}).
+			Where("user_id = ? AND last_used_step < ?", enrollment.UserID, step).
+			Updates(reset)
+
+		if result.Error != nil {
+			return false, result.Error
+		}
+
+		if result.RowsAffected != 0 {
+			enrollment.LastUsedStep = step
+			return true, nil
+		}
+	} else if allowRecovery {
+		var recoveryCodes []RecoveryCode
+		if err := ac.DB.Where("user_id = ? AND used_at IS NULL", enrollment.UserID).Find(&recoveryCodes).Error; err != nil {
+			return false, err
+		}
+
+		normalized := strings.ToLower(strings.TrimSpace(code))
+
+		for _, recoveryCode := range recoveryCodes {
+			if utils.VerifyPassword(recoveryCode.CodeHash, normalized) != nil {
+				continue
+			}
+
+			result := ac.DB.Model(&RecoveryCode{}).
+				Where("id = ? AND used_at IS NULL", recoveryCode.ID).
+				Update("used_at", now)
+
+			if result.Error != nil {
+				return false, result.Error
+			}
+
+			if result.RowsAffected != 0 {
+				return true, ac.DB.Model(&TwoFactorAuth{}).Where("user_id = ?", enrollment.UserID).Updates(reset).Error
+			}
+		}
+	}
+
+	if attempts < twoFactorMaxAttempts {
+		return false, nil
+	}
+
+	return false, ac.DB.Model(&TwoFactorAuth{}).Where("user_id = ?", enrollment.UserID).Updates(lock).Error
+}
+
+// replaceRecoveryCodes drops the user's recovery codes and returns a new set.
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, twoFactorRecoveryCodes)
+	records := make([]RecoveryCode, twoFactorRecoveryCodes)
+
+	for i := range codes {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codeHash, err := utils.HashPassword(code)
+		if err != nil {
+			return nil, err
+		}
+
+		codes[i] = code
+		records[i] = RecoveryCode{UserID: userID, CodeHash: codeHash}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// respondSecondFactorError reports a failed second factor check.
+func respondSecondFactorError(ctx *gin.Context, err error) {
+	if errors.Is(err, errTwoFactorLocked) {
+		ctx.JSON(http.StatusTooManyRequests, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Something bad happened"})
+		return
+	}
+
+	ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid code"})
+}
+
+// EnrollTwoFactor godoc
+//
+//	@Summary		Starts two-factor authentication enrollment
+//	@Description	Generates a new TOTP secret for the current user and returns it together with the otpauth:// provisioning URI to be shown as a QR code. The enrollment takes effect after it is confirmed with a code.
+//	@Tags			Auth
+//	@Produce		json
+//	@Success		200	{object}	SuccessResponse[TwoFactorEnrollmentResponse]
+//	@Failure		409	{object}	ErrorResponse
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/auth/2fa/enroll [post]
+func (ac *AuthController) EnrollTwoFactor(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var confirmed int64
+	if err := ac.DB.Model(&TwoFactorAuth{}).Where("user_id = ? AND confirmed_at IS NOT NULL", currentUser.ID).Count(&confirmed).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Something bad happened"})
+		return
+	}
+
+	if confirmed != 0 {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	enrollment := TwoFactorAuth{UserID: currentUser.ID, Secret: secret, CreatedAt: time.Now()}
+
+	// starting over replaces a pending enrollment
+	if err := ac.DB.Clauses(clause.OnConflict{UpdateAll: true}).Create(&enrollment).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to start enrollment"})
+		return
+	}
+
+	config, _ := initializers.LoadConfig(".")
+
+	issuer := config.TwoFactorIssuer
+	if issuer == "" {
+		issuer = defaultTwoFactorIssuer
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[*TwoFactorEnrollmentResponse]{
+		Status: "success",
+		Data: &TwoFactorEnrollmentResponse{
+			Secret:          secret,
+			ProvisioningURI: utils.TotpProvisioningURI(issuer, currentUser.Phone, secret),
+		},
+	})
+}
+
+// ConfirmTwoFactor godoc
+//
+//	@Summary		Confirms two-factor authentication enrollment
+//	@Description	Checks a code from the authenticator app and enables two-factor authentication. Returns the recovery codes, they are shown only once. The current session counts as passed the second factor.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		TwoFactorCodeRequest	true	"TOTP code"
+//	@Success		200		{object}	SuccessResponse[RecoveryCodesResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		409		{object}	ErrorResponse
+//	@Failure		429		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/2fa/confirm [post]
+func (ac *AuthController) ConfirmTwoFactor(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *TwoFactorCodeRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	var enrollment TwoFactorAuth
+	if err := ac.DB.First(&enrollment, "user_id = ?", currentUser.ID).Error; err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Two-factor enrollment was not started"})
+		return
+	}
+
+	if enrollment.ConfirmedAt != nil {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "Two-factor authentication is already enabled"})
+		return
+	}
+
+	ok, err := ac.checkSecondFactor(&enrollment, payload.Code, false)
+	if !ok {
+		respondSecondFactorError(ctx, err)
+		return
+	}
+
+	currentSessionID, _ := ctx.Value("currentSessionID").(uuid.UUID)
+
+	var codes []string
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&enrollment).Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&Session{}).Where("id = ? AND user_id = ?", currentSessionID, currentUser.ID).Update("two_factor", true).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, currentUser.ID)
+		return err
+	})
+
+	initializers.ForgetSession(currentSessionID)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to enable two-factor authentication"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[*RecoveryCodesResponse]{Status: "success", Data: &RecoveryCodesResponse{RecoveryCodes: codes}})
+}
+
+// RegenerateRecoveryCodes godoc
+//
+//	@Summary		Replaces the recovery codes
+//	@Description	Checks a code from the authenticator app, invalidates the old recovery codes and returns new ones.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		TwoFactorCodeRequest	true	"TOTP code"
+//	@Success		200		{object}	SuccessResponse[RecoveryCodesResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		429		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/2fa/recovery-codes [post]
+func (ac *AuthController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *TwoFactorCodeRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	var enrollment TwoFactorAuth
+	if err := ac.DB.First(&enrollment, "user_id = ? AND confirmed_at IS NOT NULL", currentUser.ID).Error; err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Two-factor authentication is not enabled"})
+		return
+	}
+
+	ok, err := ac.checkSecondFactor(&enrollment, payload.Code, false)
+	if !ok {
+		respondSecondFactorError(ctx, err)
+		return
+	}
+
+	var codes []string
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, currentUser.ID)
+		return err
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to create recovery codes"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[*RecoveryCodesResponse]{Status: "success", Data: &RecoveryCodesResponse{RecoveryCodes: codes}})
+}
+
+// DisableTwoFactor godoc
+//
+//	@Summary		Disables two-factor authentication
+//	@Description	Checks a code from the authenticator app and removes the TOTP secret and recovery codes of the current user. Sessions of the user lose the passed second factor.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		TwoFactorCodeRequest	true	"TOTP code"
+//	@Success		200		{object}	SuccessResponse[any]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		429		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/2fa [delete]
+func (ac *AuthController) DisableTwoFactor(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *TwoFactorCodeRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	var enrollment TwoFactorAuth
+	if err := ac.DB.First(&enrollment, "user_id = ? AND confirmed_at IS NOT NULL", currentUser.ID).Error; err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Two-factor authentication is not enabled"})
+		return
+	}
+
+	ok, err := ac.checkSecondFactor(&enrollment, payload.Code, false)
+	if !ok {
+		respondSecondFactorError(ctx, err)
+		return
+	}
+
+	err = ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", currentUser.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&enrollment).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&Session{}).Where("user_id = ?", currentUser.ID).Update("two_factor", false).Error
+	})
+
+	initializers.ForgetUser(currentUser.ID)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to disable two-factor authentication"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[any]{Status: "success"})
+}
+
+// VerifyTwoFactorLogin godoc
+//
+//	@Summary		Completes a login with the second factor
+//	@Description	Exchanges the pre-auth token returned by a login endpoint and a TOTP or recovery code for a session.
+//	@Tags			Auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		TwoFactorLoginRequest	true	"Pre-auth token and code"
+//	@Success		200		{object}	TokenResponse
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		401		{object}	ErrorResponse
+//	@Failure		429		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/login/2fa [post]
+func (ac *AuthController) VerifyTwoFactorLogin(ctx *gin.Context) {
+	var payload *TwoFactorLoginRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	config, _ := initializers.LoadConfig(".")
+
+	claims, err := utils.ValidateToken(payload.PreAuthToken, utils.PreAuthTokenType, config.AccessTokenVerificationKeys()...)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{Status: "error", Message: "Invalid or expired pre-auth token"})
+		return
+	}
+
+	var user User
+	if err := ac.DB.First(&user, "id = ?", claims.Subject).Error; err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{Status: "error", Message: "Invalid or expired pre-auth token"})
+		return
+	}
+
+	var enrollment TwoFactorAuth
+	if err := ac.DB.First(&enrollment, "user_id = ? AND confirmed_at IS NOT NULL", user.ID).Error; err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{Status: "error", Message: "Two-factor authentication is not enabled"})
+		return
+	}
+
+	ok, err := ac.checkSecondFactor(&enrollment, payload.Code, true)
+	if !ok {
+		respondSecondFactorError(ctx, err)
+		return
+	}
+
+	ac.signInWithSession(ctx, &user, true, &config)
+}
+
+// JWKS godoc
+//
+//	@Summary		Lists the access token verification keys
+//	@Description	Returns the public keys access tokens are signed with, in JSON Web Key Set format, so other services can verify access tokens on their own. The kid header of a token names its key.
+//	@Tags			Auth
+//	@Produce		json
+//	@Success		200	{object}	utils.JWKS
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/.well-known/jwks.json [get]
+func (ac *AuthController) JWKS(ctx *gin.Context) {
+	config, _ := initializers.LoadConfig(".")
+
+	keys, err := utils.NewKeySet("", config.AccessTokenVerificationKeys()...)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to load keys"})
+		return
+	}
+
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, keys.JWKS())
+}
+
+// ListLoginLockouts godoc
+//
+//	@Summary		Lists failed login counters (privileged access)
+//	@Description	Returns the accounts and client IPs with recent failed password logins, including the ones that are locked out.
+//	@Tags			Auth
+//	@Produce		json
+//	@Param			kind	query		string	false	"Only accounts or only IPs"	Enums(account, ip)
+//	@Success		200		{object}	SuccessResponse[[]LoginLockoutResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Router			/auth/lockouts [get]
+func (ac *AuthController) ListLoginLockouts(ctx *gin.Context) {
+	kind := ctx.Query("kind")
+
+	var prefixes []string
+	switch kind {
+	case "":
+		prefixes = []string{utils.LoginAccountKeyPrefix, utils.LoginIPKeyPrefix}
+	case "account":
+		prefixes = []string{utils.LoginAccountKeyPrefix}
+	case "ip":
+		prefixes = []string{utils.LoginIPKeyPrefix}
+	default:
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "kind must be account or ip"})
+		return
+	}
+
+	now := time.Now()
+	lockouts := make([]LoginLockoutResponse, 0)
+
+	for _, prefix := range prefixes {
+		for _, attempts := range ac.LoginGuard.Store.List(prefix) {
+			lockouts = append(lockouts, LoginLockoutResponse{
+				Kind:        strings.TrimSuffix(prefix, ":"),
+				Value:       strings.TrimPrefix(attempts.Key, prefix),
+				Failures:    attempts.Failures,
+				LastFailure: attempts.LastFailure,
+				LockedUntil: attempts.LockedUntil,
+				Locked:      now.Before(attempts.LockedUntil),
+			})
+		}
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[[]LoginLockoutResponse]{Status: "success", Data: lockouts})
+}
+
+// ClearLoginLockout godoc
+//
+//	@Summary		Clears failed login counters (privileged access)
+//	@Description	Forgets the failed password logins of an account or a client IP, which lifts its lockout.
+//	@Tags			Auth
+//	@Produce		json
+//	@Param			account	query		string	false	"Phone of the account"
+//	@Param			ip		query		string	false	"Client IP"
+//	@Success		204		{object}	nil
+//	@Failure		400		{object}	ErrorResponse
+//	@Router			/auth/lockouts [delete]
+func (ac *AuthController) ClearLoginLockout(ctx *gin.Context) {
+	account := ctx.Query("account")
+	ip := ctx.Query("ip")
+
+	if account == "" && ip == "" {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "account or ip is required"})
+		return
+	}
+
+	if account != "" {
+		ac.LoginGuard.Store.Delete(utils.LoginAccountKeyPrefix + strings.ToLower(account))
+	}
+
+	if ip != "" {
+		ac.LoginGuard.Store.Delete(utils.LoginIPKeyPrefix + ip)
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// ListFailedLogins godoc
+//
+//	@Summary		Lists the failed login audit trail (privileged access)
+//	@Description	Returns failed password logins, newest first, optionally only of one phone or client IP.
+//	@Tags			Auth
+//	@Produce		json
+//	@Param			phone	query		string	false	"Phone"
+//	@Param			ip		query		string	false	"Client IP"
+//	@Param			page	query		int		false	"Page number"		default(1)
+//	@Param			limit	query		int		false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
+//	@Success		200		{object}	SuccessPageResponse[[]FailedLoginResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/auth/login-failures [get]
+func (ac *AuthController) ListFailedLogins(ctx *gin.Context) {
+	params, err := pagination.FromQuery(ctx, pagination.By("created_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	query := ac.DB.Model(&FailedLogin{})
+
+	if phone := ctx.Query("phone"); phone != "" {
+		query = query.Where("phone = ?", phone)
+	}
+
+	if ip := ctx.Query("ip"); ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+
+	var failedLogins []FailedLogin
+	page, err := pagination.Find(query, params, &failedLogins)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	failedLoginResponses := make([]FailedLoginResponse, len(failedLogins))
+	for i, failedLogin := range failedLogins {
+		failedLoginResponses[i] = FailedLoginResponse{
+			ID:        failedLogin.ID,
+			Phone:     failedLogin.Phone,
+			UserID:    failedLogin.UserID,
+			IP:        failedLogin.IP,
+			UserAgent: failedLogin.UserAgent,
+			Reason:    failedLogin.Reason,
+			CreatedAt: failedLogin.CreatedAt,
+		}
+	}
+
+	ctx.JSON(http.StatusOK, pagination.Response(failedLoginResponses, len(failedLogins), page))
+}
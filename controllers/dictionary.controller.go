@@ -3,10 +3,10 @@ package controllers
 import (
 	"github.com/gin-gonic/gin"
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
 	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"gorm.io/gorm"
 	"net/http"
-	"strconv"
 )
 
 type DictionaryController struct {
@@ -25,7 +25,10 @@ func NewDictionaryController(DB *gorm.DB) DictionaryController {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict [get]
 func (pc *DictionaryController) ListDict(ctx *gin.Context) {
@@ -61,26 +64,24 @@ func (pc *DictionaryController) ListDict(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[CityResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/cities [get]
 func (pc *DictionaryController) ListCities(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var cities []City
 
-	dbQuery := pc.DB.
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&cities)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&City{}), params, &cities)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -89,12 +90,7 @@ func (pc *DictionaryController) ListCities(ctx *gin.Context) {
 		response[i] = *utils.MapCity(&city) // Assuming you have the mapDictionary function
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]CityResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(cities),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(cities), page))
 }
 
 // ListEthnos godoc
@@ -105,29 +101,26 @@ func (pc *DictionaryController) ListCities(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Param			sex		query		string	female	"Sex"
 //	@Success		200		{object}	SuccessPageResponse[EthnosResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/ethnos [get]
 func (pc *DictionaryController) ListEthnos(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
 	var sex = ctx.DefaultQuery("sex", "female")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var ethnosList []Ethnos
 
-	dbQuery := pc.DB.
-		Where("sex = ?", sex).
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&ethnosList)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&Ethnos{}).Where("sex = ?", sex), params, &ethnosList)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -136,12 +129,7 @@ func (pc *DictionaryController) ListEthnos(ctx *gin.Context) {
 		response[i] = *utils.MapEthnos(&ethnos)
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]EthnosResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(ethnosList),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(ethnosList), page))
 }
 
 // ListBodyTypes godoc
@@ -152,26 +140,24 @@ func (pc *DictionaryController) ListEthnos(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[BodyTypeResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/bodies [get]
 func (pc *DictionaryController) ListBodyTypes(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var bodyTypes []BodyType
 
-	dbQuery := pc.DB.
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&bodyTypes)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&BodyType{}), params, &bodyTypes)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -180,12 +166,7 @@ func (pc *DictionaryController) ListBodyTypes(ctx *gin.Context) {
 		response[i] = *utils.MapBodyType(&bodyType)
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]BodyTypeResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(bodyTypes),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(bodyTypes), page))
 }
 
 // ListBodyArts godoc
@@ -196,26 +177,24 @@ func (pc *DictionaryController) ListBodyTypes(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[BodyArtResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/arts [get]
 func (pc *DictionaryController) ListBodyArts(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var bodyArts []BodyArt
 
-	dbQuery := pc.DB.
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&bodyArts)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&BodyArt{}), params, &bodyArts)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -224,12 +203,7 @@ func (pc *DictionaryController) ListBodyArts(ctx *gin.Context) {
 		response[i] = *utils.MapBodyArt(&bodyArt)
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]BodyArtResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(bodyArts),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(bodyArts), page))
 }
 
 // ListHairColors godoc
@@ -240,26 +214,24 @@ func (pc *DictionaryController) ListBodyArts(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[HairColorResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/colors [get]
 func (pc *DictionaryController) ListHairColors(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var hairColors []HairColor
 
-	dbQuery := pc.DB.
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&hairColors)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&HairColor{}), params, &hairColors)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -268,12 +240,7 @@ func (pc *DictionaryController) ListHairColors(ctx *gin.Context) {
 		response[i] = *utils.MapHairColor(&hairColor)
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]HairColorResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(hairColors),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(hairColors), page))
 }
 
 // ListIntimateHairCuts godoc
@@ -284,26 +251,24 @@ func (pc *DictionaryController) ListHairColors(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[IntimateHairCutResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/cuts [get]
 func (pc *DictionaryController) ListIntimateHairCuts(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var intimateHairCuts []IntimateHairCut
 
-	dbQuery := pc.DB.
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&intimateHairCuts)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&IntimateHairCut{}), params, &intimateHairCuts)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -312,12 +277,7 @@ func (pc *DictionaryController) ListIntimateHairCuts(ctx *gin.Context) {
 		response[i] = *utils.MapIntimateHairCut(&hairCut)
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]IntimateHairCutResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(intimateHairCuts),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(intimateHairCuts), page))
 }
 
 // ListProfileTags godoc
@@ -328,26 +288,24 @@ func (pc *DictionaryController) ListIntimateHairCuts(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[ProfileTagResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/profile/tags [get]
 func (pc *DictionaryController) ListProfileTags(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var profileTags []ProfileTag
 
-	dbQuery := pc.DB.
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&profileTags)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&ProfileTag{}), params, &profileTags)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -361,12 +319,7 @@ func (pc *DictionaryController) ListProfileTags(ctx *gin.Context) {
 		}
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]ProfileTagResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(profileTags),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(profileTags), page))
 }
 
 // ListUserTags godoc
@@ -377,26 +330,24 @@ func (pc *DictionaryController) ListProfileTags(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[UserTagResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/dict/user/tags [get]
 func (pc *DictionaryController) ListUserTags(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var userTags []UserTag
 
-	dbQuery := pc.DB.
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&userTags)
-
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(pc.DB.Model(&UserTag{}), params, &userTags)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
@@ -410,10 +361,5 @@ func (pc *DictionaryController) ListUserTags(ctx *gin.Context) {
 		}
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]UserTagResponse]{
-		Status:  "success",
-		Data:    response,
-		Results: len(userTags),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(response, len(userTags), page))
 }
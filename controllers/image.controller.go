@@ -307,6 +307,8 @@ func (ic *ImageController) processSingleImage(fileHeader *multipart.FileHeader,
 //	@Param			images		formData	[]file	true	"Image files"
 //	@Success		201			{object}	SuccessResponse[[]PhotoResponse]
 //	@Failure		400			{object}	ErrorResponse
+//	@Failure		403			{object}	ErrorResponse
+//	@Failure		404			{object}	ErrorResponse
 //	@Failure		500			{object}	ErrorResponse
 //	@Router			/images [post]
 func (ic *ImageController) UploadProfileImages(ctx *gin.Context) {
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"gorm.io/gorm"
+)
+
+const defaultImpersonationTTL = 15 * time.Minute
+
+type ImpersonationController struct {
+	DB *gorm.DB
+}
+
+func NewImpersonationController(DB *gorm.DB) ImpersonationController {
+	return ImpersonationController{DB}
+}
+
+func mapImpersonation(impersonation Impersonation) ImpersonationResponse {
+	requests := make([]ImpersonatedRequestResponse, len(impersonation.Requests))
+	for i, request := range impersonation.Requests {
+		requests[i] = ImpersonatedRequestResponse{
+			Method:    request.Method,
+			Path:      request.Path,
+			Status:    request.Status,
+			CreatedAt: request.CreatedAt,
+		}
+	}
+
+	return ImpersonationResponse{
+		ID:        impersonation.ID,
+		ActorID:   impersonation.ActorID,
+		SubjectID: impersonation.SubjectID,
+		Reason:    impersonation.Reason,
+		IP:        impersonation.IP,
+		CreatedAt: impersonation.CreatedAt,
+		ExpiresAt: impersonation.ExpiresAt,
+		EndedAt:   impersonation.EndedAt,
+		Requests:  requests,
+	}
+}
+
+// Impersonate godoc
+//
+//	@Summary		Starts impersonating a user (privileged access)
+//	@Description	Returns a short-lived access token that acts as the given user, so support can see exactly what the user sees. The token carries the staff member as actor, cannot be refreshed, and cannot change roles, credentials or delete anything. Every request made with it is recorded. Admins can impersonate users and moderators only, nobody can impersonate an owner.
+//	@Tags			Impersonations
+//	@Accept			json
+//	@Produce		json
+//	@Param			ImpersonateRequest	body		ImpersonateRequest	true	"User to impersonate and the reason"
+//	@Success		201					{object}	ImpersonationTokenResponse
+//	@Failure		400					{object}	ErrorResponse
+//	@Failure		403					{object}	ErrorResponse
+//	@Failure		404					{object}	ErrorResponse
+//	@Failure		502					{object}	ErrorResponse
+//	@Router			/impersonations [post]
+func (ic *ImpersonationController) Impersonate(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *ImpersonateRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if payload.UserID == currentUser.ID {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "You cannot impersonate yourself"})
+		return
+	}
+
+	var subject User
+	if err := ic.DB.First(&subject, "id = ?", payload.UserID).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "User not found"})
+		return
+	}
+
+	if subject.Role == "owner" || currentUser.Role == "admin" && subject.Role == "admin" {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "You are not authorized to impersonate this user"})
+		return
+	}
+
+	config, _ := initializers.LoadConfig(".")
+
+	ttl := config.ImpersonationTTL
+	if ttl <= 0 {
+		ttl = defaultImpersonationTTL
+	}
+
+	now := time.Now()
+
+	impersonation := Impersonation{
+		ActorID:   currentUser.ID,
+		SubjectID: subject.ID,
+		Reason:    payload.Reason,
+		IP:        ctx.ClientIP(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	if err := ic.DB.Create(&impersonation).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to start impersonation"})
+		return
+	}
+
+	accessToken, err := utils.CreateToken(
+		ttl,
+		utils.TokenClaims{
+			StandardClaims:  jwt.StandardClaims{Subject: subject.ID.String()},
+			Type:            utils.AccessTokenType,
+			ActorID:         currentUser.ID.String(),
+			ImpersonationID: impersonation.ID.String(),
+		},
+		config.AccessTokenPrivateKey)
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, ImpersonationTokenResponse{Status: "success", AccessToken: accessToken, ExpiresAt: impersonation.ExpiresAt})
+}
+
+// EndImpersonation godoc
+//
+//	@Summary		Ends the current impersonation
+//	@Description	Called with the impersonation token, which stops working immediately.
+//	@Tags			Impersonations
+//	@Produce		json
+//	@Success		204	{object}	nil
+//	@Failure		400	{object}	ErrorResponse
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/impersonations/current [delete]
+func (ic *ImpersonationController) EndImpersonation(ctx *gin.Context) {
+	impersonator, impersonated := ctx.Get("currentImpersonator")
+	if !impersonated {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "You are not impersonating anybody"})
+		return
+	}
+
+	impersonationID := impersonator.(ImpersonatorResponse).ImpersonationID
+
+	if err := ic.DB.Model(&Impersonation{}).Where("id = ? AND ended_at IS NULL", impersonationID).Update("ended_at", time.Now()).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to end impersonation"})
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// ListImpersonations godoc
+//
+//	@Summary		Lists impersonations (privileged access)
+//	@Description	Returns the audit trail of impersonations, newest first, optionally only of one actor or impersonated user.
+//	@Tags			Impersonations
+//	@Produce		json
+//	@Param			actorId		query		string	false	"Staff member who impersonated"
+//	@Param			subjectId	query		string	false	"Impersonated user"
+//	@Param			page		query		int		false	"Page number"		default(1)
+//	@Param			limit		query		int		false	"Limit per page"	default(10)
+//	@Param			cursor		query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total		query		bool	false	"Count the items across all pages"
+//	@Success		200			{object}	SuccessPageResponse[[]ImpersonationResponse]
+//	@Failure		400			{object}	ErrorResponse
+//	@Failure		502			{object}	ErrorResponse
+//	@Router			/impersonations [get]
+func (ic *ImpersonationController) ListImpersonations(ctx *gin.Context) {
+	params, err := pagination.FromQuery(ctx, pagination.By("created_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	query := ic.DB.Model(&Impersonation{})
+
+	if actorID := ctx.Query("actorId"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+
+	if subjectID := ctx.Query("subjectId"); subjectID != "" {
+		query = query.Where("subject_id = ?", subjectID)
+	}
+
+	var impersonations []Impersonation
+	page, err := pagination.Find(query, params, &impersonations)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	impersonationResponses := make([]ImpersonationResponse, len(impersonations))
+	for i, impersonation := range impersonations {
+		impersonationResponses[i] = mapImpersonation(impersonation)
+	}
+
+	ctx.JSON(http.StatusOK, pagination.Response(impersonationResponses, len(impersonations), page))
+}
+
+// GetImpersonation godoc
+//
+//	@Summary		Gets an impersonation with its requests (privileged access)
+//	@Description	Returns the impersonation together with every request made with it, oldest first.
+//	@Tags			Impersonations
+//	@Produce		json
+//	@Param			id	path		string	true	"Impersonation ID"
+//	@Success		200	{object}	SuccessResponse[ImpersonationResponse]
+//	@Failure		400	{object}	ErrorResponse
+//	@Failure		404	{object}	ErrorResponse
+//	@Router			/impersonations/{id} [get]
+func (ic *ImpersonationController) GetImpersonation(ctx *gin.Context) {
+	impersonationID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Invalid impersonation id"})
+		return
+	}
+
+	var impersonation Impersonation
+	result := ic.DB.
+		Preload("Requests", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		First(&impersonation, "id = ?", impersonationID)
+
+	if result.Error != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "Impersonation not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[ImpersonationResponse]{Status: "success", Data: mapImpersonation(impersonation)})
+}
@@ -1,41 +1,544 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"log"
+	"math"
 	"net/http"
-	"strconv"
 	"time"
 )
 
 type PaymentController struct {
-	DB                     *gorm.DB
-	PaymentProviderApiKey  string
-	PaymentProviderBaseURL string
+	DB            *gorm.DB
+	provider      utils.PaymentProvider
+	subscriptions *utils.SubscriptionService
 }
 
-func NewPaymentController(DB *gorm.DB, apiKey string, baseUrl string) PaymentController {
-	return PaymentController{DB, apiKey, baseUrl}
+func NewPaymentController(DB *gorm.DB, provider utils.PaymentProvider, subscriptions *utils.SubscriptionService) PaymentController {
+	return PaymentController{DB, provider, subscriptions}
+}
+
+var (
+	errPaymentEventProcessed = errors.New("the event has been processed already")
+	errPaymentTransition     = errors.New("the payment can't move to the status")
+	errRefundAmount          = errors.New("the refund is more than the rest of the payment")
+	errPaymentProvider       = errors.New("the payment provider failed")
+)
+
+// listPayments returns a page of the payments matching the query, newest
+// first, with the number of them across all pages.
+func listPayments(ctx *gin.Context, query *gorm.DB) {
+	params, err := pagination.FromQuery(ctx, pagination.By("payment_date DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	params.Total = true
+
+	var payments []Payment
+
+	// Retrieve payments with sorting and pagination
+	page, err := pagination.Find(query, params, &payments)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to retrieve payments",
+		})
+		return
+	}
+
+	paymentResponses := make([]PaymentResponse, len(payments))
+	for i, payment := range payments {
+		paymentResponses[i] = utils.MapPayment(payment)
+	}
+
+	ctx.JSON(http.StatusOK, pagination.CountedResponse(paymentResponses, len(payments), page))
+}
+
+// CreateCheckout godoc
+//
+//	@Summary		Starts paying for a tier
+//	@Description	Creates a pending subscription payment for the tier at its price and returns the page of the payment provider to pay on. With wallet set the payment is taken from the balance of the user and the tier is granted right away.
+//	@Tags			Payments
+//	@Accept			json
+//	@Produce		json
+//	@Param			CreateCheckoutRequest	body		CreateCheckoutRequest	true	"Tier to pay for"
+//	@Success		201						{object}	SuccessResponse[CheckoutResponse]
+//	@Failure		400						{object}	ErrorResponse
+//	@Failure		402						{object}	ErrorResponse
+//	@Failure		404						{object}	ErrorResponse
+//	@Failure		500						{object}	ErrorResponse
+//	@Failure		502						{object}	ErrorResponse
+//	@Router			/payments/checkout [post]
+func (pc *PaymentController) CreateCheckout(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *CreateCheckoutRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var tier Tier
+	if err := pc.DB.First(&tier, "name = ?", payload.Tier).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{
+			Status:  "error",
+			Message: "Tier not found",
+		})
+		return
+	}
+
+	if tier.Price <= 0 {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "The tier is free",
+		})
+		return
+	}
+
+	now := time.Now()
+
+	pc.checkout(ctx, Payment{
+		UserID:    currentUser.ID,
+		Amount:    tier.Price,
+		Status:    PaymentPending,
+		Type:      "subscription",
+		Tier:      tier.Name,
+		Provider:  pc.provider.Name(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, payload.Wallet)
+}
+
+// fulfil grants what the payment is for, when it completes, within the
+// transaction of the payment.
+func (pc *PaymentController) fulfil(tx *gorm.DB, payment Payment) error {
+	now := time.Now()
+
+	switch {
+	case payment.Type == "subscription" && payment.Tier != "":
+		_, err := pc.subscriptions.Grant(tx, payment, now)
+		return err
+	case payment.Type == "top_up":
+		return utils.TopUp(tx, payment)
+	case payment.ProfileID != nil:
+		_, err := utils.GrantBoost(tx, payment, now)
+		return err
+	}
+
+	return nil
+}
+
+// refund records the refund of the amount of the payment, or completes the
+// pending one, moves the payment to partially refunded or refunded and takes
+// back the share of what it paid for, within the transaction of the payment.
+// Payments from the wallet are paid back to it.
+func (pc *PaymentController) refund(tx *gorm.DB, payment *Payment, refund *PaymentRefund) error {
+	refunded := math.Round((payment.RefundedAmount+refund.Amount)*100) / 100
+
+	status := PaymentPartiallyRefunded
+	if refunded >= payment.Amount {
+		status = PaymentRefunded
+	}
+
+	if !utils.ValidPaymentTransition(payment.Status, status) {
+		return errPaymentTransition
+	}
+
+	now := time.Now()
+
+	refund.PaymentID = payment.ID
+	refund.Status = RefundCompleted
+
+	if refund.ID == uuid.Nil {
+		refund.CreatedAt = now
+
+		if err := tx.Omit(clause.Associations).Create(refund).Error; err != nil {
+			return err
+		}
+	} else if err := tx.Model(refund).Updates(map[string]interface{}{
+		"status":             refund.Status,
+		"provider_refund_id": refund.ProviderRefundID,
+	}).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(payment).Updates(map[string]interface{}{
+		"status":          status,
+		"refunded_amount": refunded,
+		"updated_at":      now,
+	}).Error; err != nil {
+		return err
+	}
+
+	payment.Status = status
+	payment.RefundedAmount = refunded
+
+	if payment.Provider == utils.WalletProvider {
+		if err := utils.RefundPurchase(tx, *payment, refund.Amount); err != nil {
+			return err
+		}
+	}
+
+	share := refund.Amount / payment.Amount
+
+	switch {
+	case payment.Type == "subscription" && payment.Tier != "":
+		_, err := pc.subscriptions.Revoke(tx, *payment, share, now)
+		return err
+	case payment.Type == "top_up":
+		return utils.RefundTopUp(tx, *payment, refund.Amount)
+	case payment.ProfileID != nil:
+		return utils.RevokeBoost(tx, *payment, share, now)
+	}
+
+	return nil
+}
+
+// checkout saves the pending payment and starts it with the payment provider,
+// or pays it from the balance of the user right away.
+func (pc *PaymentController) checkout(ctx *gin.Context, payment Payment, wallet bool) {
+	if wallet {
+		pc.payFromWallet(ctx, payment)
+		return
+	}
+
+	if err := pc.DB.Create(&payment).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to create payment",
+		})
+		return
+	}
+
+	checkout, err := pc.provider.CreateCheckout(payment)
+	if err != nil {
+		pc.DB.Model(&payment).Updates(Payment{Status: PaymentFailed, UpdatedAt: time.Now()})
+
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{
+			Status:  "error",
+			Message: "The payment provider is unavailable",
+		})
+		return
+	}
+
+	if err := pc.DB.Model(&payment).Update("provider_payment_id", checkout.ProviderPaymentID).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to create payment",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, SuccessResponse[CheckoutResponse]{
+		Status: "success",
+		Data: CheckoutResponse{
+			PaymentID: payment.ID,
+			URL:       checkout.URL,
+		},
+	})
+}
+
+// payFromWallet completes the payment from the balance of its user, and
+// grants what it is for, in one DB transaction.
+func (pc *PaymentController) payFromWallet(ctx *gin.Context, payment Payment) {
+	payment.Provider = utils.WalletProvider
+	payment.Status = PaymentCompleted
+	payment.PaymentDate = payment.CreatedAt
+
+	err := pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+
+		if err := utils.Purchase(tx, payment); err != nil {
+			return err
+		}
+
+		return pc.fulfil(tx, payment)
+	})
+
+	switch {
+	case errors.Is(err, utils.ErrInsufficientBalance):
+		ctx.JSON(http.StatusPaymentRequired, ErrorResponse{
+			Status:  "error",
+			Message: "The balance is too low",
+		})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to create payment",
+		})
+		return
+	}
+
+	initializers.ForgetUser(payment.UserID)
+
+	ctx.JSON(http.StatusCreated, SuccessResponse[CheckoutResponse]{
+		Status: "success",
+		Data: CheckoutResponse{
+			PaymentID: payment.ID,
+		},
+	})
+}
+
+// CreateTopUp godoc
+//
+//	@Summary		Starts topping up the balance
+//	@Description	Creates a pending payment adding the amount to the balance of the current user and returns the page of the payment provider to pay on.
+//	@Tags			Payments
+//	@Accept			json
+//	@Produce		json
+//	@Param			TopUpRequest	body		TopUpRequest	true	"Amount to add"
+//	@Success		201				{object}	SuccessResponse[CheckoutResponse]
+//	@Failure		400				{object}	ErrorResponse
+//	@Failure		500				{object}	ErrorResponse
+//	@Failure		502				{object}	ErrorResponse
+//	@Router			/payments/top-up [post]
+func (pc *PaymentController) CreateTopUp(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *TopUpRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// amounts are in cents, less than half of one rounds to nothing
+	amount := math.Round(payload.Amount*100) / 100
+	if amount <= 0 {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "amount must be at least 0.01",
+		})
+		return
+	}
+
+	now := time.Now()
+
+	pc.checkout(ctx, Payment{
+		UserID:    currentUser.ID,
+		Amount:    amount,
+		Status:    PaymentPending,
+		Type:      "top_up",
+		Provider:  pc.provider.Name(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, false)
+}
+
+// CreateBoostCheckout godoc
+//
+//	@Summary		Starts paying for a boost of an own profile
+//	@Description	Creates a pending payment for promoting the profile by the boost plan at its price and returns the page of the payment provider to pay on. Once paid, the profile is listed first in its city for the duration of the plan. With wallet set the payment is taken from the balance of the user and the boost is granted right away.
+//	@Tags			Payments
+//	@Accept			json
+//	@Produce		json
+//	@Param			CreateBoostRequest	body		CreateBoostRequest	true	"Profile and boost plan"
+//	@Success		201					{object}	SuccessResponse[CheckoutResponse]
+//	@Failure		400					{object}	ErrorResponse
+//	@Failure		402					{object}	ErrorResponse
+//	@Failure		404					{object}	ErrorResponse
+//	@Failure		500					{object}	ErrorResponse
+//	@Failure		502					{object}	ErrorResponse
+//	@Router			/payments/boosts [post]
+func (pc *PaymentController) CreateBoostCheckout(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *CreateBoostRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var profile Profile
+	if err := pc.DB.Select("id").First(&profile, "id = ? AND user_id = ?", payload.ProfileID, currentUser.ID).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{
+			Status:  "error",
+			Message: "Profile not found",
+		})
+		return
+	}
+
+	var plan BoostPlan
+	if err := pc.DB.First(&plan, "name = ?", payload.Plan).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{
+			Status:  "error",
+			Message: "Boost plan not found",
+		})
+		return
+	}
+
+	now := time.Now()
+
+	pc.checkout(ctx, Payment{
+		UserID:    currentUser.ID,
+		Amount:    plan.Price,
+		Status:    PaymentPending,
+		Type:      plan.Name,
+		ProfileID: &profile.ID,
+		Provider:  pc.provider.Name(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, payload.Wallet)
+}
+
+// ListBoostPlans godoc
+//
+//	@Summary		Lists the boost plans
+//	@Description	Retrieves the catalog of profile promotions, shortest first.
+//	@Tags			Payments
+//	@Produce		json
+//	@Success		200	{object}	SuccessResponse[[]BoostPlanResponse]
+//	@Failure		500	{object}	ErrorResponse
+//	@Router			/payments/boosts/plans [get]
+func (pc *PaymentController) ListBoostPlans(ctx *gin.Context) {
+	var plans []BoostPlan
+
+	if err := pc.DB.Order("minutes").Find(&plans).Error; err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to retrieve boost plans",
+		})
+		return
+	}
+
+	planResponses := make([]BoostPlanResponse, len(plans))
+	for i, plan := range plans {
+		planResponses[i] = utils.MapBoostPlan(plan)
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[[]BoostPlanResponse]{
+		Status: "success",
+		Data:   planResponses,
+	})
+}
+
+// GetMyBoosts godoc
+//
+//	@Summary		Get the boosts of the current user's profiles
+//	@Description	Retrieves the boosts of the profiles of the current user, or of one of them, latest first.
+//	@Tags			Payments
+//	@Produce		json
+//	@Param			profileId	query		string	false	"Profile ID"
+//	@Param			page		query		int		false	"Page number"		default(1)
+//	@Param			limit		query		int		false	"Limit per page"	default(10)
+//	@Param			cursor		query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total		query		bool	false	"Count the items across all pages"
+//	@Success		200			{object}	SuccessPageResponse[[]BoostResponse]
+//	@Failure		400			{object}	ErrorResponse
+//	@Failure		500			{object}	ErrorResponse
+//	@Router			/payments/boosts [get]
+func (pc *PaymentController) GetMyBoosts(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	params, err := pagination.FromQuery(ctx, pagination.By("boosts.starts_at DESC", "boosts.id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	query := pc.DB.Model(&Boost{}).Joins("JOIN profiles ON profiles.id = boosts.profile_id").
+		Where("profiles.user_id = ?", currentUser.ID)
+
+	if profileID := ctx.Query("profileId"); profileID != "" {
+		id, err := uuid.Parse(profileID)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{
+				Status:  "error",
+				Message: "Invalid profile id",
+			})
+			return
+		}
+
+		query = query.Where("boosts.profile_id = ?", id)
+	}
+
+	var boosts []Boost
+
+	page, err := pagination.Find(query, params, &boosts)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to retrieve boosts",
+		})
+		return
+	}
+
+	now := time.Now()
+
+	boostResponses := make([]BoostResponse, len(boosts))
+	for i, boost := range boosts {
+		boostResponses[i] = utils.MapBoost(boost, now)
+	}
+
+	ctx.JSON(http.StatusOK, pagination.Response(boostResponses, len(boosts), page))
 }
 
 // PaymentWebhook godoc
 //
 //	@Summary		Webhook for payment updates
-//	@Description	Receives payment updates and updates the payment status in the database.
+//	@Description	Receives payment events of the payment provider and moves the payment to the new status, a completed subscription payment grants or renews the subscription to its tier, a refunded one takes the share of the period refunded back. A completed boost payment promotes its profile, a refund shortens the boost by its share, a top-up moves the amount to or from the balance. Refund events carry the amount refunded, the rest of the payment when left out; the ones of refunds made here are acknowledged only. A disputed payment keeps what it paid for until the dispute is won, or lost, which refunds it. The body must be signed by the provider. Redelivered events are acknowledged without being processed again, events moving a payment backwards are refused.
 //	@Tags			Payments
 //	@Accept			json
 //	@Produce		json
-//	@Param			body	body		Payment					true	"Payment Update"
-//	@Success		200		{object}	SuccessResponse[string]	"payment updated"
-//	@Failure		400		{object}	ErrorResponse
-//	@Failure		500		{object}	ErrorResponse
+//	@Param			X-Payment-Signature	header		string					true	"Signature of the body by the payment provider"
+//	@Success		200					{object}	SuccessResponse[string]	"payment updated"
+//	@Failure		400					{object}	ErrorResponse
+//	@Failure		401					{object}	ErrorResponse
+//	@Failure		404					{object}	ErrorResponse
+//	@Failure		409					{object}	ErrorResponse
+//	@Failure		500					{object}	ErrorResponse
 //	@Router			/payments/webhook [post]
 func (pc *PaymentController) PaymentWebhook(ctx *gin.Context) {
-	var paymentUpdate Payment
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "Invalid data",
+		})
+		return
+	}
+
+	if err := pc.provider.VerifySignature(body, ctx.GetHeader("X-Payment-Signature")); err != nil {
+		ctx.JSON(http.StatusUnauthorized, ErrorResponse{
+			Status:  "error",
+			Message: "Invalid signature",
+		})
+		return
+	}
 
-	// Bind the incoming JSON data to the payment model
-	if err := ctx.ShouldBindJSON(&paymentUpdate); err != nil {
+	event, err := pc.provider.ParseEvent(body)
+	if err != nil {
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{
 			Status:  "error",
 			Message: "Invalid data",
@@ -43,100 +546,484 @@ func (pc *PaymentController) PaymentWebhook(ctx *gin.Context) {
 		return
 	}
 
-	// Update payment in the database
-	if err := pc.DB.Model(&Payment{}).Where("id = ?", paymentUpdate.ID).Updates(paymentUpdate).Error; err != nil {
+	var payment Payment
+
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+
+		// concurrent events of the payment wait for each other
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&payment, "provider = ? AND provider_payment_id = ?", pc.provider.Name(), event.ProviderPaymentID).Error; err != nil {
+			return err
+		}
+
+		recorded := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&PaymentEvent{
+			Provider:  pc.provider.Name(),
+			EventID:   event.ID,
+			PaymentID: payment.ID,
+			Status:    event.Status,
+			CreatedAt: time.Now(),
+		})
+
+		if recorded.Error != nil {
+			return recorded.Error
+		}
+
+		if recorded.RowsAffected == 0 {
+			return errPaymentEventProcessed
+		}
+
+		if event.Status == PaymentPartiallyRefunded || event.Status == PaymentRefunded {
+			return pc.refundEvent(tx, &payment, event)
+		}
+
+		if !utils.ValidPaymentTransition(payment.Status, event.Status) {
+			return errPaymentTransition
+		}
+
+		from := payment.Status
+
+		update := Payment{Status: event.Status, UpdatedAt: time.Now()}
+		if from == PaymentPending && event.Status == PaymentCompleted {
+			update.PaymentDate = event.OccurredAt
+		}
+
+		if err := tx.Model(&payment).Updates(update).Error; err != nil {
+			return err
+		}
+
+		// a won dispute keeps what has been granted
+		if from != PaymentPending || event.Status != PaymentCompleted {
+			return nil
+		}
+
+		return pc.fulfil(tx, payment)
+	})
+
+	if err == nil {
+		initializers.ForgetUser(payment.UserID)
+	}
+
+	switch {
+	case err == nil:
+		ctx.JSON(http.StatusOK, SuccessResponse[string]{
+			Status: "success",
+			Data:   "payment updated",
+		})
+	case errors.Is(err, errPaymentEventProcessed):
+		ctx.JSON(http.StatusOK, SuccessResponse[string]{
+			Status: "success",
+			Data:   "event already processed",
+		})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, ErrorResponse{
+			Status:  "error",
+			Message: "Payment not found",
+		})
+	case errors.Is(err, errPaymentTransition):
+		ctx.JSON(http.StatusConflict, ErrorResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("The payment can't become %s", event.Status),
+		})
+	default:
 		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
 			Status:  "error",
 			Message: "Failed to update payment",
 		})
+	}
+}
+
+// refundEvent applies a refund made with the provider. A refund made here is
+// known by its ID and has been applied already, unless it is still pending:
+// then the event completes it. Lost disputes and refunds without an amount
+// refund the rest of the payment.
+func (pc *PaymentController) refundEvent(tx *gorm.DB, payment *Payment, event utils.ProviderEvent) error {
+	refund := PaymentRefund{Reason: "refunded with " + pc.provider.Name()}
+
+	if event.RefundID != "" {
+		var known int64
+		if err := tx.Model(&PaymentRefund{}).Where("provider_refund_id = ?", event.RefundID).Count(&known).Error; err != nil {
+			return err
+		}
+
+		if known > 0 {
+			return errPaymentEventProcessed
+		}
+
+		// the event may come before the refund is completed here, or after
+		// completing it failed
+		var pending PaymentRefund
+		err := tx.Where("payment_id = ? AND status = ? AND amount = ?", payment.ID, RefundPending, event.Amount).
+			Order("created_at").
+			First(&pending).Error
+		if err == nil {
+			pending.ProviderRefundID = &event.RefundID
+			return pc.refund(tx, payment, &pending)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		refund.ProviderRefundID = &event.RefundID
+	}
+
+	rest := math.Round((payment.Amount-payment.RefundedAmount)*100) / 100
+
+	refund.Amount = event.Amount
+	if refund.Amount <= 0 || refund.Amount > rest || payment.Status == PaymentDisputed {
+		refund.Amount = rest
+	}
+
+	if refund.Amount <= 0 {
+		return errPaymentTransition
+	}
+
+	return pc.refund(tx, payment, &refund)
+}
+
+// RefundPayment godoc
+//
+//	@Summary		Refunds a payment (privileged access)
+//	@Description	Pays the amount of a completed payment back, the rest of it when no amount is given, and takes back the share of the tier period or the boost it paid for. Payments from the wallet are paid back to it, the others through the payment provider: the refund is held pending, out of the rest of the payment, while the provider is asked, and is applied once it has paid it back, or by its webhook event.
+//	@Tags			Payments
+//	@Accept			json
+//	@Produce		json
+//	@Param			id						path		string					true	"Payment ID"
+//	@Param			RefundPaymentRequest	body		RefundPaymentRequest	true	"Amount and reason"
+//	@Success		200						{object}	SuccessResponse[PaymentResponse]
+//	@Failure		400						{object}	ErrorResponse
+//	@Failure		404						{object}	ErrorResponse
+//	@Failure		409						{object}	ErrorResponse
+//	@Failure		500						{object}	ErrorResponse
+//	@Failure		502						{object}	ErrorResponse
+//	@Router			/payments/{id}/refund [post]
+func (pc *PaymentController) RefundPayment(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	paymentID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "Invalid payment id",
+		})
+		return
+	}
+
+	var payload *RefundPaymentRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var payment Payment
+	var refund PaymentRefund
+	var rest float64
+
+	err = pc.DB.Transaction(func(tx *gorm.DB) error {
+		// webhook events of the payment wait for the refund
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payment, "id = ?", paymentID).Error; err != nil {
+			return err
+		}
+
+		if payment.Status != PaymentCompleted && payment.Status != PaymentPartiallyRefunded {
+			return errPaymentTransition
+		}
+
+		var pending float64
+		if err := tx.Model(&PaymentRefund{}).
+			Where("payment_id = ? AND status = ?", payment.ID, RefundPending).
+			Select("COALESCE(SUM(amount), 0)").
+			Scan(&pending).Error; err != nil {
+			return err
+		}
+
+		rest = math.Round((payment.Amount-payment.RefundedAmount-pending)*100) / 100
+
+		refund = PaymentRefund{Amount: rest, Reason: payload.Reason, CreatedBy: &currentUser.ID}
+		if payload.Amount != nil {
+			refund.Amount = math.Round(*payload.Amount*100) / 100
+		}
+
+		if refund.Amount <= 0 || refund.Amount > rest {
+			return errRefundAmount
+		}
+
+		if payment.Provider == utils.WalletProvider {
+			return pc.refund(tx, &payment, &refund)
+		}
+
+		// the provider is asked once the transaction is over, so a slow
+		// provider doesn't keep the payment locked
+		refund.PaymentID = payment.ID
+		refund.Status = RefundPending
+		refund.CreatedAt = time.Now()
+
+		return tx.Omit(clause.Associations).Create(&refund).Error
+	})
+
+	if err == nil && refund.Status == RefundPending {
+		err = pc.completeRefund(&payment, &refund)
+	}
+
+	switch {
+	case err == nil:
+		initializers.ForgetUser(payment.UserID)
+
+		ctx.JSON(http.StatusOK, SuccessResponse[PaymentResponse]{
+			Status: "success",
+			Data:   utils.MapPayment(payment),
+		})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.JSON(http.StatusNotFound, ErrorResponse{
+			Status:  "error",
+			Message: "Payment not found",
+		})
+	case errors.Is(err, errRefundAmount):
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("amount must be positive and at most %.2f", rest),
+		})
+	case errors.Is(err, errPaymentTransition):
+		ctx.JSON(http.StatusConflict, ErrorResponse{
+			Status:  "error",
+			Message: fmt.Sprintf("A %s payment can't be refunded", payment.Status),
+		})
+	case errors.Is(err, errPaymentProvider):
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{
+			Status:  "error",
+			Message: "The payment provider refused the refund",
+		})
+	default:
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to refund payment",
+		})
+	}
+}
+
+// completeRefund has the provider pay the pending refund back and applies it
+// to the payment. The refund is the idempotency key with the provider, so it
+// is never paid twice. A refund the provider has paid but which fails to be
+// applied here stays pending until the webhook event of the refund applies it.
+func (pc *PaymentController) completeRefund(payment *Payment, refund *PaymentRefund) error {
+	id, err := pc.provider.Refund(*payment, refund.Amount, refund.ID.String())
+	if err != nil {
+		if err := pc.DB.Model(refund).Where("status = ?", RefundPending).Update("status", RefundFailed).Error; err != nil {
+			log.Printf("failed to mark refund %s as failed: %v", refund.ID, err)
+		}
+		return errors.Join(errPaymentProvider, err)
+	}
+
+	return pc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(payment, "id = ?", refund.PaymentID).Error; err != nil {
+			return err
+		}
+
+		// the webhook event of the refund may have applied it already
+		if err := tx.First(refund, "id = ?", refund.ID).Error; err != nil {
+			return err
+		}
+
+		if refund.Status != RefundPending {
+			return nil
+		}
+
+		refund.ProviderRefundID = &id
+		return pc.refund(tx, payment, refund)
+	})
+}
+
+// ReconcilePayments godoc
+//
+//	@Summary		Reconciles payments with a settlement file (privileged access)
+//	@Description	Compares the payments of the day with the settlement CSV of the payment provider in the body, with the payment_id, status and amount columns, and saves the mismatches found, replacing the ones found for the day before.
+//	@Tags			Payments
+//	@Accept			text/csv
+//	@Produce		json
+//	@Param			day	query		string	true	"Day in YYYY-MM-DD format"
+//	@Success		200	{object}	SuccessResponse[ReconciliationResponse]
+//	@Failure		400	{object}	ErrorResponse
+//	@Failure		500	{object}	ErrorResponse
+//	@Router			/payments/reconciliations [post]
+func (pc *PaymentController) ReconcilePayments(ctx *gin.Context) {
+	day, err := time.Parse(time.DateOnly, ctx.Query("day"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "day must be a date in YYYY-MM-DD format",
+		})
+		return
+	}
+
+	lines, err := utils.ParseSettlement(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "Invalid settlement file: " + err.Error(),
+		})
+		return
+	}
+
+	run, err := utils.Reconcile(pc.DB, pc.provider.Name(), day, lines)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to reconcile payments",
+		})
 		return
 	}
 
-	// Return success response
-	ctx.JSON(http.StatusOK, SuccessResponse[string]{
+	ctx.JSON(http.StatusOK, SuccessResponse[ReconciliationResponse]{
 		Status: "success",
-		Data:   "payment updated",
+		Data: ReconciliationResponse{
+			Day:        run.Day.Format(time.DateOnly),
+			Lines:      run.Lines,
+			Mismatches: run.Mismatches,
+		},
 	})
 }
 
+// ListPaymentMismatches godoc
+//
+//	@Summary		Lists payment mismatches (privileged access)
+//	@Description	Retrieves the mismatches found by reconciling the payments with the settlement files of the payment provider, newest day first with pagination, optionally of one day or kind.
+//	@Tags			Payments
+//	@Produce		json
+//	@Param			day		query		string	false	"Day in YYYY-MM-DD format"
+//	@Param			kind	query		string	false	"missing_locally, missing_in_settlement, status or amount"
+//	@Param			page	query		int		false	"Page number"		default(1)
+//	@Param			limit	query		int		false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Success		200		{object}	SuccessCountedPageResponse[[]PaymentMismatchResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		500		{object}	ErrorResponse
+//	@Router			/payments/mismatches [get]
+func (pc *PaymentController) ListPaymentMismatches(ctx *gin.Context) {
+	params, err := pagination.FromQuery(ctx, pagination.By("day DESC", "kind", "provider_payment_id", "id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	params.Total = true
+
+	query := pc.DB.Model(&PaymentMismatch{})
+
+	if value := ctx.Query("day"); value != "" {
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{
+				Status:  "error",
+				Message: "day must be a date in YYYY-MM-DD format",
+			})
+			return
+		}
+		query = query.Where("day = ?", day)
+	}
+
+	if kind := ctx.Query("kind"); kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+
+	var mismatches []PaymentMismatch
+	page, err := pagination.Find(query, params, &mismatches)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+			Status:  "error",
+			Message: "Failed to retrieve mismatches",
+		})
+		return
+	}
+
+	mismatchResponses := make([]PaymentMismatchResponse, len(mismatches))
+	for i, mismatch := range mismatches {
+		mismatchResponses[i] = utils.MapPaymentMismatch(mismatch)
+	}
+
+	ctx.JSON(http.StatusOK, pagination.CountedResponse(mismatchResponses, len(mismatches), page))
+}
+
 // GetPaymentHistory godoc
 //
-//	@Summary		Get payment history for a user
-//	@Description	Retrieves the payment history for a specified user between two dates.
+//	@Summary		Get payment history for a user (privileged access)
+//	@Description	Retrieves the payment history for a specified user between two dates, sorted by payment date in descending order with pagination.
 //	@Tags			Payments
 //	@Accept			json
 //	@Produce		json
 //	@Param			userID	path		string	true	"User ID"
 //	@Param			start	query		string	true	"Start Date in RFC3339 format"
 //	@Param			end		query		string	true	"End Date in RFC3339 format"
-//	@Success		200		{object}	SuccessResponse[Payment]
+//	@Param			page	query		int		false	"Page number"		default(1)
+//	@Param			limit	query		int		false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Success		200		{object}	SuccessCountedPageResponse[[]PaymentResponse]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		500		{object}	ErrorResponse
 //	@Router			/payments/history/{userID} [get]
 func (pc *PaymentController) GetPaymentHistory(ctx *gin.Context) {
 	// Get userID from path and date range from query parameters
-	userID := ctx.Param("userID")
-	startDate, _ := time.Parse(time.RFC3339, ctx.Query("start"))
-	endDate, _ := time.Parse(time.RFC3339, ctx.Query("end"))
+	userID, err := uuid.Parse(ctx.Param("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "Invalid user id",
+		})
+		return
+	}
 
-	var payments []Payment
+	startDate, err := time.Parse(time.RFC3339, ctx.Query("start"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "start must be a date in RFC3339 format",
+		})
+		return
+	}
 
-	// Fetch payments from the database for the given user and date range
-	if err := pc.DB.Where("user_id = ? AND payment_date BETWEEN ? AND ?", userID, startDate, endDate).Find(&payments).Error; err != nil {
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
+	endDate, err := time.Parse(time.RFC3339, ctx.Query("end"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
 			Status:  "error",
-			Message: "Failed to retrieve payments",
+			Message: "end must be a date in RFC3339 format",
 		})
 		return
 	}
 
-	// Return success response with the payment data
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]Payment]{
-		Status: "success",
-		Data:   payments,
-	})
+	if endDate.Before(startDate) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "end must not be before start",
+		})
+		return
+	}
+
+	// Payments of the given user in the date range
+	query := pc.DB.Model(&Payment{}).Where("user_id = ? AND payment_date BETWEEN ? AND ?", userID, startDate, endDate)
+
+	listPayments(ctx, query)
 }
 
 // ListPayments godoc
 //
-//	@Summary		List all payments
+//	@Summary		List all payments (privileged access)
 //	@Description	Retrieves all payments, sorted by payment date in descending order with pagination.
 //	@Tags			Payments
 //	@Accept			json
 //	@Produce		json
 //	@Param			page	query		int	false	"Page number"		default(1)
 //	@Param			limit	query		int	false	"Limit per page"	default(10)
-//	@Success		200		{object}	SuccessResponse[Payment[]]
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Success		200		{object}	SuccessCountedPageResponse[[]PaymentResponse]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		500		{object}	ErrorResponse
 //	@Router			/payments [get]
 func (pc *PaymentController) ListPayments(ctx *gin.Context) {
-	// Pagination parameters
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
-
-	var payments []Payment
-
-	// Retrieve payments with sorting and pagination
-	result := pc.DB.Order("payment_date DESC").
-		Limit(limit).Offset(offset).Find(&payments)
-
-	// Check if any error occurred during the query
-	if result.Error != nil {
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
-			Status:  "error",
-			Message: "Failed to retrieve payments",
-		})
-		return
-	}
-
-	// Return the payments in the response
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]Payment]{
-		Status:  "success",
-		Results: len(payments),
-		Page:    page,
-		Limit:   limit,
-		Data:    payments,
-	})
+	listPayments(ctx, pc.DB.Model(&Payment{}))
 }
 
 // GetMyPayments godoc
@@ -148,40 +1035,14 @@ func (pc *PaymentController) ListPayments(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		int	false	"Page number"		default(1)
 //	@Param			limit	query		int	false	"Limit per page"	default(10)
-//	@Success		200		{object}	SuccessResponse[Payment]
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Success		200		{object}	SuccessCountedPageResponse[[]PaymentResponse]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		500		{object}	ErrorResponse
 //	@Router			/payments/me [get]
 func (pc *PaymentController) GetMyPayments(ctx *gin.Context) {
 	// Get the current user from context (assumes middleware has set currentUser)
 	currentUser := ctx.MustGet("currentUser").(User)
 
-	// Pagination parameters
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
-
-	var payments []Payment
-
-	// Retrieve payments for the current user with sorting and pagination
-	result := pc.DB.Where("user_id = ?", currentUser.ID).
-		Order("payment_date DESC").
-		Limit(limit).Offset(offset).Find(&payments)
-
-	// Check if any error occurred during the query
-	if result.Error != nil {
-		ctx.JSON(http.StatusInternalServerError, ErrorResponse{
-			Status:  "error",
-			Message: "Failed to retrieve user payments",
-		})
-		return
-	}
-
-	// Return the user's payments in the response
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]Payment]{
-		Status:  "success",
-		Page:    page,
-		Limit:   limit,
-		Results: len(payments),
-		Data:    payments,
-	})
+	listPayments(ctx, pc.DB.Model(&Payment{}).Where("user_id = ?", currentUser.ID))
 }
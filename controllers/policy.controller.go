@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"gorm.io/gorm"
+)
+
+var policyNamePattern = regexp.MustCompile(`^(\*|[a-z-]+)$`)
+
+// PolicyController manages the rules of the access policy. Changes are saved
+// to the database right away and picked up by the other replicas within the
+// reload interval.
+type PolicyController struct {
+	DB *gorm.DB
+}
+
+func NewPolicyController(DB *gorm.DB) PolicyController {
+	return PolicyController{DB}
+}
+
+// checkPolicyRule tells why the current user cannot write the rule, if so.
+// Only owners can touch the rules of owners and admins, so admins can't
+// lock owners out or widen their own permissions.
+func checkPolicyRule(currentUser User, rule PolicyRule) (int, string) {
+	if !policyNamePattern.MatchString(rule.Obj) || !policyNamePattern.MatchString(rule.Act) {
+		return http.StatusBadRequest, "obj and act must be lowercase words or *"
+	}
+
+	if (rule.Sub == "owner" || rule.Sub == "admin") && currentUser.Role != "owner" {
+		return http.StatusForbidden, "Only owners can change the rules of owners and admins"
+	}
+
+	return 0, ""
+}
+
+// knownTier tells whether a new rule is for a tier of the catalog or for
+// any tier. Rules of removed tiers can still be removed.
+func (pc *PolicyController) knownTier(rule PolicyRule) bool {
+	return rule.Tier == "*" || pc.DB.First(&Tier{}, "name = ?", rule.Tier).Error == nil
+}
+
+// ListPolicyRules godoc
+//
+//	@Summary		Lists the rules of the access policy (privileged access)
+//	@Description	Returns the rules of the access policy as saved, oldest first. Replicas pick up changes within the reload interval.
+//	@Tags			Policies
+//	@Produce		json
+//	@Param			page	query		int		false	"Page number"		default(1)
+//	@Param			limit	query		int		false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
+//	@Success		200		{object}	SuccessPageResponse[[]PolicyRule]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/policies [get]
+func (pc *PolicyController) ListPolicyRules(ctx *gin.Context) {
+	params, err := pagination.FromQuery(ctx, pagination.By("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	var lines []CasbinRule
+	page, err := pagination.Find(pc.DB.Model(&CasbinRule{}).Where("ptype = ?", "p"), params, &lines)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	rules := make([]PolicyRule, len(lines))
+	for i, line := range lines {
+		rules[i] = NewPolicyRule([]string{line.V0, line.V1, line.V2, line.V3, line.V4, line.V5})
+	}
+
+	ctx.JSON(http.StatusOK, pagination.Response(rules, len(lines), page))
+}
+
+// AddPolicyRule godoc
+//
+//	@Summary		Adds a rule to the access policy (privileged access)
+//	@Description	Adds the rule and saves it. Only owners can add rules of owners and admins.
+//	@Tags			Policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			PolicyRule	body		PolicyRule	true	"Rule to add"
+//	@Success		201			{object}	SuccessResponse[PolicyRule]
+//	@Failure		400			{object}	ErrorResponse
+//	@Failure		403			{object}	ErrorResponse
+//	@Failure		409			{object}	ErrorResponse
+//	@Failure		502			{object}	ErrorResponse
+//	@Router			/policies [post]
+func (pc *PolicyController) AddPolicyRule(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *PolicyRule
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if status, message := checkPolicyRule(currentUser, *payload); status != 0 {
+		ctx.JSON(status, ErrorResponse{Status: "error", Message: message})
+		return
+	}
+
+	if !pc.knownTier(*payload) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Unknown tier"})
+		return
+	}
+
+	added, err := initializers.Enforcer.AddPolicy(payload.Values())
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if !added {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "The rule already exists"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, SuccessResponse[PolicyRule]{Status: "success", Data: *payload})
+}
+
+// UpdatePolicyRule godoc
+//
+//	@Summary		Replaces a rule of the access policy (privileged access)
+//	@Description	Replaces the old rule with the new one. Only owners can change rules of owners and admins.
+//	@Tags			Policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			UpdatePolicyRuleRequest	body		UpdatePolicyRuleRequest	true	"Old and new rule"
+//	@Success		200						{object}	SuccessResponse[PolicyRule]
+//	@Failure		400						{object}	ErrorResponse
+//	@Failure		403						{object}	ErrorResponse
+//	@Failure		404						{object}	ErrorResponse
+//	@Failure		409						{object}	ErrorResponse
+//	@Failure		502						{object}	ErrorResponse
+//	@Router			/policies [put]
+func (pc *PolicyController) UpdatePolicyRule(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *UpdatePolicyRuleRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	for _, rule := range []PolicyRule{payload.Old, payload.New} {
+		if status, message := checkPolicyRule(currentUser, rule); status != 0 {
+			ctx.JSON(status, ErrorResponse{Status: "error", Message: message})
+			return
+		}
+	}
+
+	if !pc.knownTier(payload.New) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Unknown tier"})
+		return
+	}
+
+	if exists, _ := initializers.Enforcer.HasPolicy(payload.New.Values()); exists {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "The new rule already exists"})
+		return
+	}
+
+	updated, err := initializers.Enforcer.UpdatePolicy(payload.Old.Values(), payload.New.Values())
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if !updated {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "Rule not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[PolicyRule]{Status: "success", Data: payload.New})
+}
+
+// RemovePolicyRule godoc
+//
+//	@Summary		Removes a rule from the access policy (privileged access)
+//	@Description	Removes the rule given in the body. Only owners can remove rules of owners and admins.
+//	@Tags			Policies
+//	@Accept			json
+//	@Produce		json
+//	@Param			PolicyRule	body		PolicyRule	true	"Rule to remove"
+//	@Success		204			{object}	nil
+//	@Failure		400			{object}	ErrorResponse
+//	@Failure		403			{object}	ErrorResponse
+//	@Failure		404			{object}	ErrorResponse
+//	@Failure		502			{object}	ErrorResponse
+//	@Router			/policies [delete]
+func (pc *PolicyController) RemovePolicyRule(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *PolicyRule
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if status, message := checkPolicyRule(currentUser, *payload); status != 0 {
+		ctx.JSON(status, ErrorResponse{Status: "error", Message: message})
+		return
+	}
+
+	removed, err := initializers.Enforcer.RemovePolicy(payload.Values())
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if !removed {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "Rule not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
+
+// ExplainPolicy godoc
+//
+//	@Summary		Explains a decision of the access policy (privileged access)
+//	@Description	Checks the user against the policy for the action on the object, the way AbacMiddleware does, and returns the decision with the attributes used. An allowed request comes with the rule that allowed it, a denied one with the rules for the object and action and the attributes each of them failed on. The second factor, ownership of the resource and API key scopes aren't stored with the user, so they are given as parameters.
+//	@Tags			Policies
+//	@Produce		json
+//	@Param			userId		query		string	true	"User ID"
+//	@Param			obj			query		string	true	"Object"
+//	@Param			act			query		string	true	"Action"
+//	@Param			twoFactor	query		bool	false	"Session passed the second factor"
+//	@Param			isOwner		query		bool	false	"User owns the resource"
+//	@Param			scopes		query		string	false	"Space separated API key scopes"	default(*)
+//	@Success		200			{object}	SuccessResponse[PolicyExplanation]
+//	@Failure		400			{object}	ErrorResponse
+//	@Failure		404			{object}	ErrorResponse
+//	@Failure		502			{object}	ErrorResponse
+//	@Router			/policies/explain [get]
+func (pc *PolicyController) ExplainPolicy(ctx *gin.Context) {
+	var query ExplainPolicyRequest
+
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	var user User
+	if err := pc.DB.First(&user, "id = ?", query.UserID).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "User not found"})
+		return
+	}
+
+	scopes := query.Scopes
+	if scopes == "" {
+		scopes = utils.AllScopes
+	}
+
+	request := utils.NewPolicyRequest(user, query.Obj, query.Act, query.TwoFactor, query.IsOwner, scopes)
+
+	explanation, err := utils.ExplainPolicy(initializers.Enforcer, request)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[PolicyExplanation]{Status: "success", Data: explanation})
+}
@@ -1,25 +1,68 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
 	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProfileController struct {
 	parsedBaseUrl string
 	DB            *gorm.DB
+	entitlements  *utils.EntitlementService
 }
 
-func NewProfileController(parsedBaseUrl string, DB *gorm.DB) ProfileController {
-	return ProfileController{parsedBaseUrl, DB}
+func NewProfileController(parsedBaseUrl string, DB *gorm.DB, entitlements *utils.EntitlementService) ProfileController {
+	return ProfileController{parsedBaseUrl, DB, entitlements}
+}
+
+// hideContacts drops the contacts of the profiles the current user neither
+// owns nor moderates. Others reveal them one profile at a time through
+// RevealContacts, within the limit of their tier.
+func hideContacts(ctx *gin.Context, profiles ...*ProfileResponse) {
+	var currentUser User
+	if user, exists := ctx.Get("currentUser"); exists {
+		currentUser = user.(User)
+	}
+
+	switch currentUser.Role {
+	case "moderator", "admin", "owner":
+		return
+	}
+
+	for _, profile := range profiles {
+		if profile.UserID != currentUser.ID.String() {
+			utils.HideContacts(profile)
+		}
+	}
+}
+
+// hideLocations drops the coordinates of the profiles in lists and searches
+// the current user doesn't own, whatever their role: only the rounded
+// distance to them is told there.
+func hideLocations(ctx *gin.Context, profiles ...*ProfileResponse) {
+	var currentUser User
+	if user, exists := ctx.Get("currentUser"); exists {
+		currentUser = user.(User)
+	}
+
+	for _, profile := range profiles {
+		if profile.UserID != currentUser.ID.String() {
+			utils.HideLocation(profile)
+		}
+	}
 }
 
 // CreateProfile godoc
@@ -47,9 +90,38 @@ func (pc *ProfileController) CreateProfile(ctx *gin.Context) {
 		return
 	}
 
+	_, profilesAllowed, err := pc.entitlements.Entitled(currentUser, utils.ProfilesAllowed)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to load the tier"})
+		return
+	}
+
 	// Start a transaction
 	tx := pc.DB.Begin()
 
+	if profilesAllowed != Unlimited {
+		// parallel creates of the user wait for each other, so they can't
+		// get past the limit
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&User{}, "id = ?", currentUser.ID).Error; err != nil {
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to count profiles"})
+			return
+		}
+
+		var profiles int64
+		if err := tx.Model(&Profile{}).Where("user_id = ?", currentUser.ID).Count(&profiles).Error; err != nil {
+			tx.Rollback()
+			ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to count profiles"})
+			return
+		}
+
+		if profiles >= int64(profilesAllowed) {
+			tx.Rollback()
+			ctx.JSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: fmt.Sprintf("Your tier allows %d profiles", profilesAllowed)})
+			return
+		}
+	}
+
 	// Create the profile
 	now := time.Now()
 
@@ -87,10 +159,10 @@ func (pc *ProfileController) CreateProfile(ctx *gin.Context) {
 	if payload.IntimateHairCutID != nil {
 		newProfile.IntimateHairCutID = payload.IntimateHairCutID
 	}
-	if payload.AddressLatitude != "" {
+	if payload.AddressLatitude != nil {
 		newProfile.AddressLatitude = payload.AddressLatitude
 	}
-	if payload.AddressLongitude != "" {
+	if payload.AddressLongitude != nil {
 		newProfile.AddressLongitude = payload.AddressLongitude
 	}
 	if payload.PriceInHouseContact != nil {
@@ -228,6 +300,7 @@ func (pc *ProfileController) CreateProfile(ctx *gin.Context) {
 //	@Param			body	body		UpdateOwnProfileRequest	true	"Profile Update Payload"
 //	@Success		200		{object}	SuccessResponse[ProfileResponse]
 //	@Failure		400		{object}	ErrorResponse
+//	@Failure		403		{object}	ErrorResponse
 //	@Failure		404		{object}	ErrorResponse
 //	@Failure		500		{object}	ErrorResponse
 //	@Router			/profiles/my/{id} [put]
@@ -316,12 +389,12 @@ func (pc *ProfileController) UpdateOwnProfile(ctx *gin.Context) {
 		updateFields["Bio"] = payload.Bio
 	}
 
-	if payload.AddressLatitude != "" && payload.AddressLatitude != existingProfile.AddressLatitude {
-		updateFields["AddressLatitude"] = payload.AddressLatitude
+	if payload.AddressLatitude != nil && (existingProfile.AddressLatitude == nil || *payload.AddressLatitude != *existingProfile.AddressLatitude) {
+		updateFields["AddressLatitude"] = *payload.AddressLatitude
 	}
 
-	if payload.AddressLongitude != "" && payload.AddressLongitude != existingProfile.AddressLongitude {
-		updateFields["AddressLongitude"] = payload.AddressLongitude
+	if payload.AddressLongitude != nil && (existingProfile.AddressLongitude == nil || *payload.AddressLongitude != *existingProfile.AddressLongitude) {
+		updateFields["AddressLongitude"] = *payload.AddressLongitude
 	}
 
 	if payload.PriceInHouseNightRatio != nil && *payload.PriceInHouseNightRatio != existingProfile.PriceInHouseNightRatio {
@@ -606,6 +679,7 @@ func (pc *ProfileController) UpdateProfile(ctx *gin.Context) {
 //	@Param			body	body		BulkUpdatePhotosRequest	true	"Photos Update Payload"
 //	@Success		200		{object}	SuccessResponse[PhotoResponse]
 //	@Failure		400		{object}	ErrorResponse
+//	@Failure		403		{object}	ErrorResponse
 //	@Failure		404		{object}	ErrorResponse
 //	@Failure		500		{object}	ErrorResponse
 //	@Router			/profiles/{id}/photos [post]
@@ -733,6 +807,8 @@ func (pc *ProfileController) FindProfileByID(ctx *gin.Context) {
 	}
 
 	profileResponse := utils.MapProfile(&profile, pc.parsedBaseUrl)
+	hideContacts(ctx, profileResponse)
+
 	ctx.JSON(http.StatusOK, SuccessResponse[*ProfileResponse]{Status: "success", Data: profileResponse})
 }
 
@@ -767,27 +843,15 @@ func (pc *ProfileController) FindProfileByPhone(ctx *gin.Context) {
 	}
 
 	profileResponse := utils.MapProfile(&profile, pc.parsedBaseUrl)
+	hideContacts(ctx, profileResponse)
+
 	ctx.JSON(http.StatusOK, SuccessResponse[*ProfileResponse]{Status: "success", Data: profileResponse})
 }
 
 func (pc *ProfileController) GetListProfilesQuery(ctx *gin.Context) (*ListProfilesQuery, error) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
 	var sex = ctx.DefaultQuery("sex", "female")
 	var cityIdParam = ctx.DefaultQuery("city", "1")
 
-	intPage, pageErr := strconv.Atoi(page)
-
-	if pageErr != nil {
-		return nil, pageErr
-	}
-
-	intLimit, limitErr := strconv.Atoi(limit)
-
-	if limitErr != nil {
-		return nil, limitErr
-	}
-
 	cityId, cityErr := strconv.Atoi(cityIdParam)
 
 	if cityErr != nil {
@@ -799,8 +863,6 @@ func (pc *ProfileController) GetListProfilesQuery(ctx *gin.Context) (*ListProfil
 	}
 
 	return &ListProfilesQuery{
-		Page:   intPage,
-		Limit:  intLimit,
 		Sex:    sex,
 		CityID: cityId,
 	}, nil
@@ -811,12 +873,15 @@ func (pc *ProfileController) GetListProfilesQuery(ctx *gin.Context) (*ListProfil
 // This route is for admins and moderators
 //
 //	@Summary		Lists all profiles with pagination, auth required
-//	@Description	Retrieves all profiles, supports pagination
+//	@Description	Retrieves all profiles of a city, supports pagination. Promoted profiles come first, taking turns on top.
 //	@Tags			Profiles
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
-//	@Param			limit	query		string	false	"Items per page"
+//	@Param			limit	query		string	false	"Items per page, at most 12"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[ProfileResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/profiles/all [get]
 func (pc *ProfileController) ListProfiles(ctx *gin.Context) {
@@ -828,35 +893,49 @@ func (pc *ProfileController) ListProfiles(ctx *gin.Context) {
 		return
 	}
 
-	offset := (query.Page - 1) * query.Limit
+	// promoted profiles take turns on top, so the pages go by offset
+	params, err := pagination.FromQueryMax(ctx, nil, ListProfilesMaxLimit)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	now := time.Now()
 
 	var profiles []Profile
 
-	dbQuery := pc.DB.
+	dbQuery := pc.DB.Model(&Profile{}).
 		Joins("LEFT JOIN cities ON cities.id = profiles.city_id").
 		Joins("LEFT JOIN body_types ON body_types.id = profiles.body_type_id").
 		Joins("LEFT JOIN ethnos ON ethnos.id = profiles.ethnos_id").
 		Joins("LEFT JOIN hair_colors ON hair_colors.id = profiles.hair_color_id").
 		Joins("LEFT JOIN intimate_hair_cuts ON intimate_hair_cuts.id = profiles.intimate_hair_cut_id").
 		Where("profiles.sex = ?", query.Sex).
-		Where("profiles.city_id = ?", query.CityID).
-		Limit(query.Limit).
-		Offset(offset)
+		Where("profiles.city_id = ?", query.CityID)
+
+	// the pages of a listing keep the turns promoted profiles had on its first page
+	snapshot := params.Snapshot(now)
 
-	results := dbQuery.Find(&profiles)
+	page, err := pagination.Find(dbQuery, params, &profiles, func(db *gorm.DB) *gorm.DB {
+		return utils.PromotedFirst(db, snapshot)
+	})
 
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
 	var profileIDs []string
-	for _, profile := range profiles {
+	ids := make([]uuid.UUID, len(profiles))
+	rank := make(map[uuid.UUID]int, len(profiles))
+	for i, profile := range profiles {
 		profileIDs = append(profileIDs, profile.ID.String())
+		ids[i] = profile.ID
+		rank[profile.ID] = i
 	}
 
 	// Use Preloads with explicit filtering by profile_id
-	pc.DB.Preload("Photos", func(db *gorm.DB) *gorm.DB {
+	result := pc.DB.Preload("Photos", func(db *gorm.DB) *gorm.DB {
 		return db.Where("photos.profile_id IN ?", profileIDs)
 	}).
 		Preload("ProfileOptions", func(db *gorm.DB) *gorm.DB {
@@ -870,28 +949,45 @@ func (pc *ProfileController) ListProfiles(ctx *gin.Context) {
 		Where("id IN ?", profileIDs).
 		Find(&profiles)
 
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: result.Error.Error()})
+		return
+	}
+
+	// the preloads load the profiles again, in no particular order
+	slices.SortFunc(profiles, func(a, b Profile) int {
+		return rank[a.ID] - rank[b.ID]
+	})
+
+	promoted, err := utils.PromotedProfiles(pc.DB, ids, now)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
 	profileResponses := make([]ProfileResponse, len(profiles))
 	for i, profile := range profiles {
 		profileResponses[i] = *utils.MapProfile(&profile, pc.parsedBaseUrl) // Assuming you have the mapProfile function
+		profileResponses[i].Promoted = promoted[profile.ID]
+		hideContacts(ctx, &profileResponses[i])
+		hideLocations(ctx, &profileResponses[i])
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]ProfileResponse]{
-		Status:  "success",
-		Data:    profileResponses,
-		Results: len(profiles),
-		Page:    query.Page,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(profileResponses, len(profiles), page))
 }
 
 // ListProfilesNonAuth godoc
 //
 //	@Summary		Lists all active profiles with pagination, no auth required
-//	@Description	Retrieves all profiles, supports pagination
+//	@Description	Retrieves all active profiles of a city, supports pagination. Promoted profiles come first, taking turns on top.
 //	@Tags			Profiles
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
-//	@Param			limit	query		string	false	"Items per page"
+//	@Param			limit	query		string	false	"Items per page, at most 12"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[ProfileResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/profiles/list [get]
 func (pc *ProfileController) ListProfilesNonAuth(ctx *gin.Context) {
@@ -902,11 +998,18 @@ func (pc *ProfileController) ListProfilesNonAuth(ctx *gin.Context) {
 		return
 	}
 
-	offset := (query.Page - 1) * query.Limit
+	// promoted profiles take turns on top, so the pages go by offset
+	params, err := pagination.FromQueryMax(ctx, nil, ListProfilesMaxLimit)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	now := time.Now()
 
 	var profiles []Profile
 
-	dbQuery := pc.DB.
+	dbQuery := pc.DB.Model(&Profile{}).
 		Joins("LEFT JOIN cities ON cities.id = profiles.city_id").
 		Joins("LEFT JOIN body_types ON body_types.id = profiles.body_type_id").
 		Joins("LEFT JOIN ethnos ON ethnos.id = profiles.ethnos_id").
@@ -914,24 +1017,31 @@ func (pc *ProfileController) ListProfilesNonAuth(ctx *gin.Context) {
 		Joins("LEFT JOIN intimate_hair_cuts ON intimate_hair_cuts.id = profiles.intimate_hair_cut_id").
 		Where("profiles.active = ?", true).
 		Where("profiles.sex = ?", query.Sex).
-		Where("profiles.city_id = ?", query.CityID).
-		Limit(query.Limit).
-		Offset(offset)
+		Where("profiles.city_id = ?", query.CityID)
 
-	results := dbQuery.Find(&profiles)
+	// the pages of a listing keep the turns promoted profiles had on its first page
+	snapshot := params.Snapshot(now)
 
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	page, err := pagination.Find(dbQuery, params, &profiles, func(db *gorm.DB) *gorm.DB {
+		return utils.PromotedFirst(db, snapshot)
+	})
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
 	var profileIDs []string
-	for _, profile := range profiles {
+	ids := make([]uuid.UUID, len(profiles))
+	rank := make(map[uuid.UUID]int, len(profiles))
+	for i, profile := range profiles {
 		profileIDs = append(profileIDs, profile.ID.String())
+		ids[i] = profile.ID
+		rank[profile.ID] = i
 	}
 
 	// Use Preloads with explicit filtering by profile_id
-	pc.DB.Preload("Photos", func(db *gorm.DB) *gorm.DB {
+	result := pc.DB.Preload("Photos", func(db *gorm.DB) *gorm.DB {
 		return db.Where("photos.profile_id IN ?", profileIDs).
 			Where("photos.disabled = ?", false).
 			Where("photos.deleted = ?", false)
@@ -947,17 +1057,31 @@ func (pc *ProfileController) ListProfilesNonAuth(ctx *gin.Context) {
 		Where("id IN ?", profileIDs).
 		Find(&profiles)
 
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: result.Error.Error()})
+		return
+	}
+
+	// the preloads load the profiles again, in no particular order
+	slices.SortFunc(profiles, func(a, b Profile) int {
+		return rank[a.ID] - rank[b.ID]
+	})
+
+	promoted, err := utils.PromotedProfiles(pc.DB, ids, now)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
 	profileResponses := make([]ProfileResponse, len(profiles))
 	for i, profile := range profiles {
 		profileResponses[i] = *utils.MapProfile(&profile, pc.parsedBaseUrl) // Assuming you have the mapProfile function
+		profileResponses[i].Promoted = promoted[profile.ID]
+		hideContacts(ctx, &profileResponses[i])
+		hideLocations(ctx, &profileResponses[i])
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]ProfileResponse]{
-		Status:  "success",
-		Data:    profileResponses,
-		Results: len(profiles),
-		Page:    query.Page,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(profileResponses, len(profiles), page))
 }
 
 // GetMyProfiles godoc
@@ -968,257 +1092,316 @@ func (pc *ProfileController) ListProfilesNonAuth(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"
 //	@Param			limit	query		string	false	"Items per page"
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[ProfileResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/profiles/my [get]
 func (pc *ProfileController) GetMyProfiles(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
 	currentUser := ctx.MustGet("currentUser").(User)
 
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("created_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var profiles []Profile
 
-	dbQuery := pc.DB.Preload("Photos").
-		Preload("City").
-		Preload("BodyType").
-		Preload("Ethnos").
-		Preload("HairColor").
-		Preload("IntimateHairCut").
-		Preload("BodyArts.BodyArt").
-		Preload("ProfileOptions.ProfileTag").
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&profiles, "user_id = ?", currentUser.ID.String())
+	page, err := pagination.Find(pc.DB.Model(&Profile{}).Where("user_id = ?", currentUser.ID), params, &profiles, preloadProfiles)
 
-	if results.Error != nil {
-		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: results.Error.Error()})
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
 		return
 	}
 
 	profileResponses := make([]ProfileResponse, len(profiles))
 	for i, profile := range profiles {
 		profileResponses[i] = *utils.MapProfile(&profile, pc.parsedBaseUrl) // Assuming you have the mapProfile function
+		hideContacts(ctx, &profileResponses[i])
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]ProfileResponse]{
-		Status:  "success",
-		Data:    profileResponses,
-		Results: len(profiles),
-		Page:    intPage,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(profileResponses, len(profiles), page))
 }
 
-// FindProfiles godoc
-//
-//	@Summary		Search for profiles
-//	@Description	Retrieves profiles based on filters provided in the query
-//	@Tags			Profiles
-//	@Accept			json
-//	@Produce		json
-//	@Param			body	body		FindProfilesQuery	true	"Search Filters"
-//	@Success		200		{object}	SuccessPageResponse[ProfileResponse[]]
-//	@Failure		400		{object}	ErrorResponse
-//	@Failure		502		{object}	ErrorResponse
-//	@Router			/profiles/search [post]
-func (pc *ProfileController) FindProfiles(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
-
-	// Bind the JSON payload to the struct
-	var query FindProfilesQuery
-	if err := ctx.ShouldBindJSON(&query); err != nil {
-		ctx.JSON(http.StatusBadRequest, ErrorResponse{
-			Status:  "error",
-			Message: err.Error(),
-		})
-		return
-	}
-
-	var profiles []Profile
-	dbQuery := pc.DB.Preload("Photos").
+// preloadProfiles loads the profiles with their photos, options and
+// dictionary values.
+func preloadProfiles(db *gorm.DB) *gorm.DB {
+	return db.Preload("Photos").
 		Preload("City").
 		Preload("BodyType").
 		Preload("Ethnos").
 		Preload("HairColor").
 		Preload("IntimateHairCut").
 		Preload("BodyArts.BodyArt").
-		Preload("ProfileOptions.ProfileTag").
-		Limit(intLimit).Offset(offset)
+		Preload("ProfileOptions.ProfileTag")
+}
 
-	// Apply filtering based on query parameters
-	if query.BodyTypeId != nil {
-		dbQuery = dbQuery.Where("body_type_id = ?", query.BodyTypeId)
+// priceFilter is the range of a price searched for, by the name of its facet.
+type priceFilter struct {
+	facet string
+	min   *int
+	max   *int
+}
+
+// filterProfiles applies the filters of the search but the one of the facet
+// except, all of them when it is empty.
+func filterProfiles(db *gorm.DB, query FindProfilesQuery, currentUser User, except string) *gorm.DB {
+	if query.BodyTypeId != nil && except != utils.FacetBodyType {
+		db = db.Where("profiles.body_type_id = ?", query.BodyTypeId)
 	}
-	if query.EthnosId != nil {
-		dbQuery = dbQuery.Where("ethnos_id = ?", query.EthnosId)
+	if query.EthnosId != nil && except != utils.FacetEthnos {
+		db = db.Where("profiles.ethnos_id = ?", query.EthnosId)
 	}
-	if query.HairColorId != nil {
-		dbQuery = dbQuery.Where("hair_color_id = ?", query.HairColorId)
+	if query.HairColorId != nil && except != utils.FacetHairColor {
+		db = db.Where("profiles.hair_color_id = ?", query.HairColorId)
 	}
 	if query.IntimateHairCutId != nil {
-		dbQuery = dbQuery.Where("intimate_hair_cut_id = ?", query.IntimateHairCutId)
+		db = db.Where("profiles.intimate_hair_cut_id = ?", query.IntimateHairCutId)
 	}
-	if query.CityID != nil {
-		dbQuery = dbQuery.Where("city_id = ?", query.CityID)
+	if query.CityID != nil && except != utils.FacetCity {
+		db = db.Where("profiles.city_id = ?", query.CityID)
 	}
 	if query.Active != nil {
-		dbQuery = dbQuery.Where("active = ?", query.Active)
+		db = db.Where("profiles.active = ?", query.Active)
 	}
 	if query.Phone != "" {
-		dbQuery = dbQuery.Where("phone = ?", query.Phone)
+		db = db.Where("profiles.phone = ?", query.Phone)
 	}
 	if query.Age != nil {
-		dbQuery = dbQuery.Where("age = ?", query.Age)
+		db = db.Where("profiles.age = ?", query.Age)
 	}
 	if query.Name != "" {
-		dbQuery = dbQuery.Where("name LIKE ?", "%"+query.Name+"%")
+		db = db.Where("profiles.name LIKE ?", "%"+query.Name+"%")
 	}
 	if query.Height != nil {
-		dbQuery = dbQuery.Where("height = ?", query.Height)
+		db = db.Where("profiles.height = ?", query.Height)
 	}
 	if query.Weight != nil {
-		dbQuery = dbQuery.Where("weight = ?", query.Weight)
+		db = db.Where("profiles.weight = ?", query.Weight)
 	}
 	if query.Bust != nil {
-		dbQuery = dbQuery.Where("bust = ?", query.Bust)
-	}
-	if query.AddressLatitude != "" {
-		dbQuery = dbQuery.Where("address_latitude = ?", query.AddressLatitude)
-	}
-	if query.AddressLongitude != "" {
-		dbQuery = dbQuery.Where("address_longitude = ?", query.AddressLongitude)
+		db = db.Where("profiles.bust = ?", query.Bust)
 	}
 	if query.Moderated != nil {
-		dbQuery = dbQuery.Where("moderated = ?", query.Moderated)
+		db = db.Where("profiles.moderated = ?", query.Moderated)
 	}
 	if query.Verified != nil {
-		dbQuery = dbQuery.Where("verified = ?", query.Verified)
+		db = db.Where("profiles.verified = ?", query.Verified)
+	}
+
+	// Subqueries rather than joins, so a profile with several of the body
+	// arts or tags is found once
+	if len(query.BodyArtIds) > 0 && except != utils.FacetBodyArt {
+		db = db.Where("profiles.id IN (SELECT profile_id FROM profile_body_arts WHERE body_art_id IN ?)", query.BodyArtIds)
 	}
 
-	// Apply filtering for BodyArt and ProfileTags if present
-	if len(query.BodyArtIds) > 0 {
-		dbQuery = dbQuery.Joins("JOIN profile_body_arts ON profiles.id = profile_body_arts.profile_id").
-			Where("profile_body_arts.body_art_id IN ?", query.BodyArtIds)
+	if len(query.ProfileTagIds) > 0 && except != utils.FacetProfileTag {
+		db = db.Where("profiles.id IN (SELECT profile_id FROM profile_options WHERE profile_tag_id IN ?)", query.ProfileTagIds)
 	}
 
-	if len(query.ProfileTagIds) > 0 {
-		dbQuery = dbQuery.Joins("JOIN profile_options ON profiles.id = profile_options.profile_id").
-			Where("profile_options.profile_tag_id IN ?", query.ProfileTagIds)
+	prices := []priceFilter{
+		{"inHouseContact", query.PriceInHouseContactMin, query.PriceInHouseContactMax},
+		{"inHouseHour", query.PriceInHouseHourMin, query.PriceInHouseHourMax},
+		{"saunaContact", query.PriceSaunaContactMin, query.PriceSaunaContactMax},
+		{"saunaHour", query.PriceSaunaHourMin, query.PriceSaunaHourMax},
+		{"visitContact", query.PriceVisitContactMin, query.PriceVisitContactMax},
+		{"visitHour", query.PriceVisitHourMin, query.PriceVisitHourMax},
+		{"carContact", query.PriceCarContactMin, query.PriceCarContactMax},
+		{"carHour", query.PriceCarHourMin, query.PriceCarHourMax},
+	}
+
+	for _, price := range prices {
+		if price.facet == except {
+			continue
+		}
+
+		column := "profiles." + utils.ProfilePrices[price.facet]
+		if price.min != nil {
+			db = db.Where(column+" >= ?", price.min)
+		}
+		if price.max != nil {
+			db = db.Where(column+" <= ?", price.max)
+		}
 	}
 
-	// Apply price range filters with cases for nil min/max values
-	if query.PriceInHouseContactMin != nil && query.PriceInHouseContactMax != nil {
-		dbQuery = dbQuery.Where("price_in_house_contact BETWEEN ? AND ?", query.PriceInHouseContactMin, query.PriceInHouseContactMax)
-	} else if query.PriceInHouseContactMin != nil {
-		dbQuery = dbQuery.Where("price_in_house_contact >= ?", query.PriceInHouseContactMin)
-	} else if query.PriceInHouseContactMax != nil {
-		dbQuery = dbQuery.Where("price_in_house_contact <= ?", query.PriceInHouseContactMax)
+	if query.RadiusKm != nil {
+		db = utils.WithinRadius(db, *query.Latitude, *query.Longitude, *query.RadiusKm)
 	}
 
-	if query.PriceInHouseHourMin != nil && query.PriceInHouseHourMax != nil {
-		dbQuery = dbQuery.Where("price_in_house_hour BETWEEN ? AND ?", query.PriceInHouseHourMin, query.PriceInHouseHourMax)
-	} else if query.PriceInHouseHourMin != nil {
-		dbQuery = dbQuery.Where("price_in_house_hour >= ?", query.PriceInHouseHourMin)
-	} else if query.PriceInHouseHourMax != nil {
-		dbQuery = dbQuery.Where("price_in_house_hour <= ?", query.PriceInHouseHourMax)
+	if query.Search != "" {
+		db = utils.MatchingProfiles(db, query.Search)
 	}
 
-	if query.PriceSaunaContactMin != nil && query.PriceSaunaContactMax != nil {
-		dbQuery = dbQuery.Where("price_sauna_contact BETWEEN ? AND ?", query.PriceSaunaContactMin, query.PriceSaunaContactMax)
-	} else if query.PriceSaunaContactMin != nil {
-		dbQuery = dbQuery.Where("price_sauna_contact >= ?", query.PriceSaunaContactMin)
-	} else if query.PriceSaunaContactMax != nil {
-		dbQuery = dbQuery.Where("price_sauna_contact <= ?", query.PriceSaunaContactMax)
+	if currentUser.Role == "user" {
+		db = db.Where("profiles.active = ?", true)
 	}
 
-	if query.PriceSaunaHourMin != nil && query.PriceSaunaHourMax != nil {
-		dbQuery = dbQuery.Where("price_sauna_hour BETWEEN ? AND ?", query.PriceSaunaHourMin, query.PriceSaunaHourMax)
-	} else if query.PriceSaunaHourMin != nil {
-		dbQuery = dbQuery.Where("price_sauna_hour >= ?", query.PriceSaunaHourMin)
-	} else if query.PriceSaunaHourMax != nil {
-		dbQuery = dbQuery.Where("price_sauna_hour <= ?", query.PriceSaunaHourMax)
+	return db
+}
+
+// FindProfiles godoc
+//
+//	@Summary		Search for profiles
+//	@Description	Retrieves profiles based on filters provided in the query, for tiers with search access. Given a latitude and longitude every profile tells its distance from the point, rounded up to whole km, instead of its coordinates, which only its owner sees, and radiusKm keeps the profiles within it while sortBy distance lists the nearest first. A search matches words of the name, bio and option comments in Russian or English, lists the most relevant profiles first and highlights the words found. With facets the counts of the profiles found per city, ethnos, body type, hair color, body art, profile tag and price bucket come along, each respecting every filter but its own. Profiles sorted by distance or relevance are paged by offset, the others newest first by cursor.
+//	@Tags			Profiles
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		FindProfilesQuery	true	"Search Filters"
+//	@Param			page	query		string				false	"Page number"
+//	@Param			limit	query		string				false	"Items per page"
+//	@Param			cursor	query		string				false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool				false	"Count the items across all pages"
+//	@Success		200		{object}	SuccessFacetedPageResponse[ProfileResponse[]]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		403		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/profiles/search [post]
+func (pc *ProfileController) FindProfiles(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	if search, _, err := pc.entitlements.Entitled(currentUser, utils.SearchProfiles); err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: "Failed to load the tier"})
+		return
+	} else if !search {
+		ctx.JSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "Your tier has no search access"})
+		return
 	}
 
-	if query.PriceVisitContactMin != nil && query.PriceVisitContactMax != nil {
-		dbQuery = dbQuery.Where("price_visit_contact BETWEEN ? AND ?", query.PriceVisitContactMin, query.PriceVisitContactMax)
-	} else if query.PriceVisitContactMin != nil {
-		dbQuery = dbQuery.Where("price_visit_contact >= ?", query.PriceVisitContactMin)
-	} else if query.PriceVisitContactMax != nil {
-		dbQuery = dbQuery.Where("price_visit_contact <= ?", query.PriceVisitContactMax)
+	// Bind the JSON payload to the struct
+	var query FindProfilesQuery
+	if err := ctx.ShouldBindJSON(&query); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
 	}
 
-	if query.PriceVisitHourMin != nil && query.PriceVisitHourMax != nil {
-		dbQuery = dbQuery.Where("price_visit_hour BETWEEN ? AND ?", query.PriceVisitHourMin, query.PriceVisitHourMax)
-	} else if query.PriceVisitHourMin != nil {
-		dbQuery = dbQuery.Where("price_visit_hour >= ?", query.PriceVisitHourMin)
-	} else if query.PriceVisitHourMax != nil {
-		dbQuery = dbQuery.Where("price_visit_hour <= ?", query.PriceVisitHourMax)
+	if (query.Latitude == nil) != (query.Longitude == nil) || query.Latitude == nil && (query.RadiusKm != nil || query.SortBy != "") {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "latitude and longitude go together, radiusKm and sortBy need them",
+		})
+		return
 	}
 
-	if query.PriceCarContactMin != nil && query.PriceCarContactMax != nil {
-		dbQuery = dbQuery.Where("price_car_contact BETWEEN ? AND ?", query.PriceCarContactMin, query.PriceCarContactMax)
-	} else if query.PriceCarContactMin != nil {
-		dbQuery = dbQuery.Where("price_car_contact >= ?", query.PriceCarContactMin)
-	} else if query.PriceCarContactMax != nil {
-		dbQuery = dbQuery.Where("price_car_contact <= ?", query.PriceCarContactMax)
+	// profiles sorted by distance or relevance are paged by offset
+	order := pagination.By("profiles.created_at DESC", "profiles.id DESC")
+	orderBy := func(db *gorm.DB) *gorm.DB { return db }
+
+	switch {
+	case query.SortBy == "distance":
+		order = nil
+		orderBy = func(db *gorm.DB) *gorm.DB {
+			// profiles without a location come last
+			return db.Clauses(clause.OrderBy{Expression: clause.Expr{
+				SQL:                "? NULLS LAST, profiles.id",
+				Vars:               []interface{}{utils.ProfileDistance(*query.Latitude, *query.Longitude)},
+				WithoutParentheses: true,
+			}})
+		}
+	case query.Search != "":
+		order = nil
+		orderBy = func(db *gorm.DB) *gorm.DB {
+			return utils.MostRelevantFirst(db, query.Search)
+		}
 	}
 
-	if query.PriceCarHourMin != nil && query.PriceCarHourMax != nil {
-		dbQuery = dbQuery.Where("price_car_hour BETWEEN ? AND ?", query.PriceCarHourMin, query.PriceCarHourMax)
-	} else if query.PriceCarHourMin != nil {
-		dbQuery = dbQuery.Where("price_car_hour >= ?", query.PriceCarHourMin)
-	} else if query.PriceCarHourMax != nil {
-		dbQuery = dbQuery.Where("price_car_hour <= ?", query.PriceCarHourMax)
+	params, err := pagination.FromQuery(ctx, order)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
 	}
 
-	currentUser := ctx.MustGet("currentUser").(User)
-	if currentUser.Role == "user" {
-		dbQuery = dbQuery.Where("active = ?", true)
+	filtered := func(except string) *gorm.DB {
+		return filterProfiles(pc.DB.Model(&Profile{}), query, currentUser, except)
 	}
 
 	// Execute the query
-	results := dbQuery.Find(&profiles)
-	if results.Error != nil {
+	var profiles []Profile
+	page, err := pagination.Find(filtered(""), params, &profiles, preloadProfiles, orderBy)
+	if err != nil {
 		ctx.JSON(http.StatusBadGateway, ErrorResponse{
 			Status:  "error",
-			Message: results.Error.Error(),
+			Message: err.Error(),
 		})
 		return
 	}
 
-	intPage, _ = strconv.Atoi(page)
+	var highlights map[uuid.UUID]string
+	if query.Search != "" {
+		ids := make([]uuid.UUID, len(profiles))
+		for i, profile := range profiles {
+			ids[i] = profile.ID
+		}
+
+		if highlights, err = utils.ProfileHighlights(pc.DB, ids, query.Search); err != nil {
+			ctx.JSON(http.StatusBadGateway, ErrorResponse{
+				Status:  "error",
+				Message: err.Error(),
+			})
+			return
+		}
+	}
 
 	profileResponses := make([]ProfileResponse, len(profiles))
 	for i, profile := range profiles {
 		profileResponses[i] = *utils.MapProfile(&profile, pc.parsedBaseUrl) // Assuming you have the mapProfile function
+		profileResponses[i].Highlight = highlights[profile.ID]
+		hideContacts(ctx, &profileResponses[i])
+		hideLocations(ctx, &profileResponses[i])
+
+		if query.Latitude != nil && profile.AddressLatitude != nil && profile.AddressLongitude != nil {
+			distance := utils.RoundDistance(utils.Distance(*query.Latitude, *query.Longitude, *profile.AddressLatitude, *profile.AddressLongitude))
+			profileResponses[i].Distance = &distance
+		}
+	}
+
+	var facets *ProfileFacets
+	if query.Facets {
+		bucket := utils.DefaultPriceBucket
+		if query.PriceBucket != nil {
+			bucket = *query.PriceBucket
+		}
+
+		counts, err := utils.ProfileFacetCounts(filtered, bucket)
+		if err != nil {
+			ctx.JSON(http.StatusBadGateway, ErrorResponse{
+				Status:  "error",
+				Message: err.Error(),
+			})
+			return
+		}
+		facets = &counts
 	}
 
 	// Return the results in the response
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]ProfileResponse]{
-		Status:  "success",
-		Results: len(profiles),
-		Page:    intPage,
-		Data:    profileResponses,
+	ctx.JSON(http.StatusOK, SuccessFacetedPageResponse[[]ProfileResponse]{
+		Status:    "success",
+		Results:   len(profiles),
+		Page:      page.Page,
+		Limit:     page.Limit,
+		Total:     page.Total,
+		PageLinks: page.Links,
+		Data:      profileResponses,
+		Facets:    facets,
 	})
 }
 
 // DeleteProfile godoc
 //
 //	@Summary		Deletes a profile by ID
-//	@Description	Deletes the profile with the given ID from the database
+//	@Description	Deletes the profile with the given ID from the database. Only the owner of the profile and staff can delete it.
 //	@Tags			Profiles
 //	@Produce		json
 //	@Param			id	path		string	true	"Profile ID"
 //	@Success		204	{object}	nil
+//	@Failure		403	{object}	ErrorResponse
 //	@Failure		404	{object}	ErrorResponse
 //	@Router			/profiles/{id} [delete]
 func (pc *ProfileController) DeleteProfile(ctx *gin.Context) {
@@ -1233,3 +1416,61 @@ func (pc *ProfileController) DeleteProfile(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusNoContent, nil)
 }
+
+// RevealContacts godoc
+//
+//	@Summary		Reveals the contacts of a profile
+//	@Description	Returns the contacts of the profile, counting against the contact reveals per day of the tier of the current user. Revealing a profile again on the same day is free, owners of the profile and staff don't count.
+//	@Tags			Profiles
+//	@Produce		json
+//	@Param			id	path		string	true	"Profile ID"
+//	@Success		200	{object}	SuccessResponse[ContactsResponse]
+//	@Failure		403	{object}	ErrorResponse
+//	@Failure		404	{object}	ErrorResponse
+//	@Failure		429	{object}	ErrorResponse
+//	@Failure		500	{object}	ErrorResponse
+//	@Router			/profiles/{id}/contacts [post]
+func (pc *ProfileController) RevealContacts(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	profileID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "No profile with that title exists"})
+		return
+	}
+
+	var profile Profile
+	if err := pc.DB.First(&profile, "id = ?", profileID).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "No profile with that title exists"})
+		return
+	}
+
+	left := Unlimited
+	staff := currentUser.Role == "moderator" || currentUser.Role == "admin" || currentUser.Role == "owner"
+
+	// the owner of the profile and staff see the contacts anyway
+	if profile.UserID != currentUser.ID && !staff {
+		left, err = pc.entitlements.RevealContacts(currentUser, profile.ID, time.Now())
+	}
+
+	switch {
+	case errors.Is(err, utils.ErrNotEntitled):
+		ctx.JSON(http.StatusForbidden, ErrorResponse{Status: "error", Message: "Your tier has no contact reveals"})
+		return
+	case errors.Is(err, utils.ErrEntitlementExhausted):
+		ctx.JSON(http.StatusTooManyRequests, ErrorResponse{Status: "error", Message: "You have revealed all the contacts your tier allows today"})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to reveal the contacts"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[ContactsResponse]{
+		Status: "success",
+		Data: ContactsResponse{
+			ProfileID:   profile.ID,
+			Contacts:    utils.MapContacts(&profile),
+			RevealsLeft: left,
+		},
+	})
+}
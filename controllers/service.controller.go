@@ -2,9 +2,10 @@ package controllers
 
 import (
 	"fmt"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
 	"github.com/ivegotanidea/golang-gorm-postgres/utils"
-	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -16,33 +17,11 @@ import (
 type ServiceController struct {
 	DB                     *gorm.DB
 	reviewUpdateLimitHours int
+	entitlements           *utils.EntitlementService
 }
 
-func NewServiceController(DB *gorm.DB, reviewUpdateLimitHours int) ServiceController {
-	return ServiceController{DB, reviewUpdateLimitHours}
-}
-
-func degToRad(deg float64) float64 {
-	return deg * (math.Pi / 180)
-}
-
-func getDistanceBetweenCoordinates(latA, lonA, latB, lonB float32) float64 {
-	const earthRadiusKm = 6371
-
-	dLat := degToRad(float64(latB - latA))
-	dLon := degToRad(float64(lonB - lonA))
-
-	latARad := degToRad(float64(latA))
-	latBRad := degToRad(float64(latB))
-
-	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
-		math.Sin(dLon/2)*math.Sin(dLon/2)*math.Cos(latARad)*math.Cos(latBRad)
-
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-	distance := earthRadiusKm * c
-
-	return distance
+func NewServiceController(DB *gorm.DB, reviewUpdateLimitHours int, entitlements *utils.EntitlementService) ServiceController {
+	return ServiceController{DB, reviewUpdateLimitHours, entitlements}
 }
 
 // CreateService godoc
@@ -55,6 +34,8 @@ func getDistanceBetweenCoordinates(latA, lonA, latB, lonB float32) float64 {
 //	@Param			body	body		CreateServiceRequest	true	"Create Service Request"
 //	@Success		201		{object}	SuccessResponse[ServiceResponse]
 //	@Failure		400		{object}	ErrorResponse
+//	@Failure		403		{object}	ErrorResponse
+//	@Failure		404		{object}	ErrorResponse
 //	@Failure		500		{object}	ErrorResponse
 //	@Router			/services [post]
 func (sc *ServiceController) CreateService(ctx *gin.Context) {
@@ -69,11 +50,23 @@ func (sc *ServiceController) CreateService(ctx *gin.Context) {
 		return
 	}
 
+	// the owner of the profile reviews the client, so it must be the real one
+	var profileOwners int64
+	sc.DB.Model(&Profile{}).Where("id = ? AND user_id = ?", payload.ProfileID, payload.ProfileOwnerID).Count(&profileOwners)
+
+	if profileOwners == 0 {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: "The profile doesn't belong to the profile owner",
+		})
+		return
+	}
+
 	now := time.Now()
 
-	distance := getDistanceBetweenCoordinates(
-		*payload.ClientUserLatitude, *payload.ClientUserLongitude,
-		*payload.ProfileUserLatitude, *payload.ProfileUserLongitude)
+	distance := utils.Distance(
+		float64(*payload.ClientUserLatitude), float64(*payload.ClientUserLongitude),
+		float64(*payload.ProfileUserLatitude), float64(*payload.ProfileUserLongitude))
 
 	// Create the new service object
 	newService := Service{
@@ -212,7 +205,34 @@ func (sc *ServiceController) CreateService(ctx *gin.Context) {
 	})
 }
 
-func MutateService(tier string, service Service) map[string]interface{} {
+// maskRating keeps the rating fields visible to the user: the whole rating
+// for "<rating>.*", else the score and the text as far as they are visible.
+func maskRating(reviewFields []string, name string, score *int, review string) (map[string]interface{}, bool) {
+	scoreVisible := slices.Contains(reviewFields, name+".score")
+	reviewVisible := slices.Contains(reviewFields, name+".review")
+
+	if !scoreVisible && !reviewVisible {
+		return nil, false
+	}
+
+	rating := map[string]interface{}{}
+
+	if scoreVisible {
+		rating["score"] = score
+	}
+
+	if reviewVisible {
+		rating["review"] = review
+	} else {
+		rating["reviewTextVisible"] = true
+	}
+
+	return rating, true
+}
+
+// MutateService hides the review fields the user isn't entitled to see, see
+// EntitlementService.ReviewFields.
+func MutateService(reviewFields []string, service Service) map[string]interface{} {
 
 	filteredService := make(map[string]interface{})
 
@@ -231,33 +251,20 @@ func MutateService(tier string, service Service) map[string]interface{} {
 	filteredService["distanceBetweenUsers"] = service.DistanceBetweenUsers
 	filteredService["trustedDistance"] = service.TrustedDistance
 
-	// Access control based on user tier
-	if tier == "basic" {
-		// Only expose ProfileRating.Score for basic users, hide UserRating entirely
-		if service.ProfileRating != nil {
-			filteredService["profileRating"] = map[string]interface{}{
-				"score":             service.ProfileRating.Score,
-				"reviewTextVisible": true,
-			}
-		}
-	} else if tier == "expert" {
-		// Expert users can see all ProfileRating fields and only Score from UserRating
-		if service.ProfileRating != nil {
-			filteredService["profileRating"] = map[string]interface{}{
-				"review": service.ProfileRating.Review,
-				"score":  service.ProfileRating.Score,
-			}
-		}
-		if service.ClientUserRating != nil {
-			filteredService["clientUserRating"] = map[string]interface{}{
-				"score":             service.ClientUserRating.Score,
-				"reviewTextVisible": true,
-			}
-		}
-	} else if tier == "guru" {
-		// Guru users can see both ProfileRating and UserRating completely
+	if slices.Contains(reviewFields, ProfileRatingAll) {
 		filteredService["profileRating"] = service.ProfileRating
+	} else if service.ProfileRating != nil {
+		if rating, visible := maskRating(reviewFields, "profileRating", service.ProfileRating.Score, service.ProfileRating.Review); visible {
+			filteredService["profileRating"] = rating
+		}
+	}
+
+	if slices.Contains(reviewFields, ClientUserRatingAll) {
 		filteredService["clientUserRating"] = service.ClientUserRating
+	} else if service.ClientUserRating != nil {
+		if rating, visible := maskRating(reviewFields, "clientUserRating", service.ClientUserRating.Score, service.ClientUserRating.Review); visible {
+			filteredService["clientUserRating"] = rating
+		}
 	}
 
 	return filteredService
@@ -295,8 +302,14 @@ func (sc *ServiceController) GetService(ctx *gin.Context) {
 		return
 	}
 
+	reviewFields, err := sc.entitlements.ReviewFields(currentUser)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to load the tier"})
+		return
+	}
+
 	// Mutate the service based on the user's tier
-	filteredService := MutateService(currentUser.Tier, service)
+	filteredService := MutateService(reviewFields, service)
 
 	// Return the filtered response
 	ctx.JSON(http.StatusOK, SuccessResponse[map[string]interface{}]{
@@ -305,6 +318,12 @@ func (sc *ServiceController) GetService(ctx *gin.Context) {
 	})
 }
 
+// preloadServiceRatings loads the ratings of the services with their tags.
+func preloadServiceRatings(db *gorm.DB) *gorm.DB {
+	return db.Preload("ClientUserRating.RatedUserTags.UserTag").
+		Preload("ProfileRating.RatedProfileTags.ProfileTag")
+}
+
 // GetProfileServices godoc
 //
 //	@Summary		Get all services for a specific profile
@@ -315,29 +334,27 @@ func (sc *ServiceController) GetService(ctx *gin.Context) {
 //	@Param			profileID	path		string	true	"Profile ID"
 //	@Param			page		query		string	false	"Page number"				default(1)
 //	@Param			limit		query		string	false	"Number of items per page"	default(10)
+//	@Param			cursor		query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total		query		bool	false	"Count the items across all pages"
 //	@Success		200			{object}	SuccessPageResponse[ServiceResponse[]]
+//	@Failure		400			{object}	ErrorResponse
 //	@Failure		404			{object}	ErrorResponse
 //	@Failure		500			{object}	ErrorResponse
 //	@Router			/profiles/{profileID}/services [get]
 func (sc *ServiceController) GetProfileServices(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
 	profileID := ctx.Param("profileID")
 	currentUser := ctx.MustGet("currentUser").(User)
 
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("created_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
 
 	var services []Service
-	result := sc.DB.Preload("ClientUserRating.RatedUserTags.UserTag").
-		Preload("ProfileRating.RatedProfileTags.ProfileTag").
-		Where("profile_id = ?", profileID).
-		Limit(intLimit).Offset(offset).
-		Find(&services)
+	page, err := pagination.Find(sc.DB.Model(&Service{}).Where("profile_id = ?", profileID), params, &services, preloadServiceRatings)
 
-	if result.Error != nil {
+	if err != nil {
 		ctx.JSON(http.StatusNotFound, ErrorResponse{
 			Status:  "error",
 			Message: "No services found for specified profile",
@@ -345,21 +362,21 @@ func (sc *ServiceController) GetProfileServices(ctx *gin.Context) {
 		return
 	}
 
+	reviewFields, err := sc.entitlements.ReviewFields(currentUser)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to load the tier"})
+		return
+	}
+
 	// Mutate services based on user tier
 	filteredServices := make([]map[string]interface{}, 0, len(services))
 	for _, service := range services {
-		filteredService := MutateService(currentUser.Tier, service)
+		filteredService := MutateService(reviewFields, service)
 		filteredServices = append(filteredServices, filteredService)
 	}
 
 	// Return the filtered response
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]map[string]interface{}]{
-		Status:  "success",
-		Results: len(filteredServices),
-		Page:    intPage,
-		Limit:   intLimit,
-		Data:    filteredServices,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(filteredServices, len(filteredServices), page))
 }
 
 // ListServices godoc
@@ -371,42 +388,36 @@ func (sc *ServiceController) GetProfileServices(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		string	false	"Page number"				default(1)
 //	@Param			limit	query		string	false	"Number of items per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[ServiceResponse[]]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/services [get]
 func (sc *ServiceController) ListServices(ctx *gin.Context) {
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("created_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
 
 	var services []Service
 
-	dbQuery := sc.DB.Preload("ClientUserRating.RatedUserTags.UserTag").
-		Preload("ProfileRating.RatedProfileTags.ProfileTag").
-		Limit(intLimit).Offset(offset)
-
-	results := dbQuery.Find(&services)
-
-	if results.Error != nil {
+	page, err := pagination.Find(sc.DB.Model(&Service{}), params, &services, preloadServiceRatings)
+	if err != nil {
 		ctx.JSON(http.StatusBadGateway, ErrorResponse{
 			Status:  "error",
-			Message: results.Error.Error(),
+			Message: err.Error(),
 		})
 		return
 	}
 
 	servicesResponse := utils.MapServices(services)
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]ServiceResponse]{
-		Status:  "success",
-		Results: len(services),
-		Limit:   intLimit,
-		Page:    intPage,
-		Data:    servicesResponse,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(servicesResponse, len(services), page))
 }
 
 // ----
@@ -446,7 +457,7 @@ func (sc *ServiceController) UpdateClientUserReviewOnProfile(ctx *gin.Context) {
 		return
 	}
 
-	// Check if the current user is the one who left the review
+	// Only the author rewrites a review, staff moderate it through its visibility
 	if service.ClientUserID != currentUser.ID {
 		ctx.JSON(http.StatusForbidden, ErrorResponse{
 			Status:  "error",
@@ -553,7 +564,6 @@ func (sc *ServiceController) UpdateClientUserReviewOnProfile(ctx *gin.Context) {
 //	@Failure		500			{object}	ErrorResponse
 //	@Router			/reviews/client/visibility [put]
 func (sc *ServiceController) HideProfileOwnerReview(ctx *gin.Context) {
-	currentUser := ctx.MustGet("currentUser").(User)
 	serviceID := ctx.Query("serviceId")
 
 	// Find the service with the associated user review
@@ -572,15 +582,6 @@ func (sc *ServiceController) HideProfileOwnerReview(ctx *gin.Context) {
 		return
 	}
 
-	// Check if the current user is the one who left the review
-	if service.ClientUserID != currentUser.ID {
-		ctx.JSON(http.StatusForbidden, ErrorResponse{
-			Status:  "error",
-			Message: "You are not authorized to update this review",
-		})
-		return
-	}
-
 	var payload *SetReviewVisibilityRequest
 	if err := ctx.ShouldBindJSON(&payload); err != nil {
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{
@@ -647,7 +648,7 @@ func (sc *ServiceController) UpdateProfileOwnerReviewOnClientUser(ctx *gin.Conte
 		return
 	}
 
-	// Check if the current user is the owner of the profile in the service
+	// Only the author rewrites a review, staff moderate it through its visibility
 	if service.ProfileOwnerID != currentUser.ID {
 		ctx.JSON(http.StatusForbidden, ErrorResponse{
 			Status:  "error",
@@ -772,24 +773,6 @@ func (sc *ServiceController) HideUserReview(ctx *gin.Context) {
 		return
 	}
 
-	// Check if the current user is the owner of the profile in the service
-	if service.ProfileOwnerID != currentUser.ID {
-		ctx.JSON(http.StatusForbidden, ErrorResponse{
-			Status:  "error",
-			Message: "You are not authorized to update this review",
-		})
-		return
-	}
-
-	// Check if the user is allowed to hide the review
-	if currentUser.Tier == "basic" {
-		ctx.JSON(http.StatusForbidden, ErrorResponse{
-			Status:  "error",
-			Message: "Basic-tier users can't hide profile reviews",
-		})
-		return
-	}
-
 	// Parse the request payload
 	var payload *SetReviewVisibilityRequest
 	if err := ctx.ShouldBindJSON(&payload); err != nil {
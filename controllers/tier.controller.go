@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var tierNamePattern = regexp.MustCompile(`^[a-z-]{1,50}$`)
+
+// TierController manages the tier catalog: the price of each tier and what it
+// entitles its users to.
+type TierController struct {
+	DB *gorm.DB
+}
+
+func NewTierController(DB *gorm.DB) TierController {
+	return TierController{DB}
+}
+
+// ListTiers godoc
+//
+//	@Summary		Lists the tiers
+//	@Description	Returns the tier catalog with the price and the entitlements of every tier.
+//	@Tags			Tiers
+//	@Produce		json
+//	@Success		200	{object}	SuccessResponse[[]TierResponse]
+//	@Failure		502	{object}	ErrorResponse
+//	@Router			/tiers [get]
+func (tc *TierController) ListTiers(ctx *gin.Context) {
+	var tiers []Tier
+
+	if err := tc.DB.Order("price, name").Find(&tiers).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	tierResponses := make([]TierResponse, len(tiers))
+	for i, tier := range tiers {
+		tierResponses[i] = utils.MapTier(tier)
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[[]TierResponse]{Status: "success", Data: tierResponses})
+}
+
+// UpsertTier godoc
+//
+//	@Summary		Creates or changes a tier (privileged access)
+//	@Description	Sets the price and the entitlements of the tier, adding it to the catalog if it is new. Users of the tier get the new entitlements right away.
+//	@Tags			Tiers
+//	@Accept			json
+//	@Produce		json
+//	@Param			name				path		string				true	"Tier name"
+//	@Param			UpsertTierRequest	body		UpsertTierRequest	true	"Price and entitlements"
+//	@Success		200					{object}	SuccessResponse[TierResponse]
+//	@Failure		400					{object}	ErrorResponse
+//	@Failure		502					{object}	ErrorResponse
+//	@Router			/tiers/{name} [put]
+func (tc *TierController) UpsertTier(ctx *gin.Context) {
+	name := ctx.Param("name")
+
+	if !tierNamePattern.MatchString(name) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "The tier name must be a lowercase word"})
+		return
+	}
+
+	var payload *UpsertTierRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if !utils.ValidReviewFields(payload.ReviewFields) {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: "Review fields must be some of " + strings.Join(ReviewFields, ", ")})
+		return
+	}
+
+	now := time.Now()
+
+	tier := Tier{
+		Name:                 name,
+		Price:                *payload.Price,
+		ReviewFields:         strings.Join(payload.ReviewFields, " "),
+		Search:               payload.Search,
+		ContactRevealsPerDay: payload.ContactRevealsPerDay,
+		ProfilesAllowed:      payload.ProfilesAllowed,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+
+	err := tc.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price", "review_fields", "search", "contact_reveals_per_day", "profiles_allowed", "updated_at"}),
+	}).Create(&tier).Error
+
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[TierResponse]{Status: "success", Data: utils.MapTier(tier)})
+}
+
+// DeleteTier godoc
+//
+//	@Summary		Removes a tier (privileged access)
+//	@Description	Removes a tier nobody is on. The basic tier, which new users get, can't be removed.
+//	@Tags			Tiers
+//	@Produce		json
+//	@Param			name	path		string	true	"Tier name"
+//	@Success		204		{object}	nil
+//	@Failure		404		{object}	ErrorResponse
+//	@Failure		409		{object}	ErrorResponse
+//	@Failure		502		{object}	ErrorResponse
+//	@Router			/tiers/{name} [delete]
+func (tc *TierController) DeleteTier(ctx *gin.Context) {
+	name := ctx.Param("name")
+
+	if name == "basic" {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "New users get the basic tier, it can't be removed"})
+		return
+	}
+
+	var users int64
+	if err := tc.DB.Model(&User{}).Where("tier = ?", name).Count(&users).Error; err != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if users > 0 {
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "Users are on the tier"})
+		return
+	}
+
+	result := tc.DB.Delete(&Tier{}, "name = ?", name)
+	if result.Error != nil {
+		ctx.JSON(http.StatusBadGateway, ErrorResponse{Status: "error", Message: result.Error.Error()})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "Tier not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusNoContent, nil)
+}
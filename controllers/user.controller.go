@@ -6,6 +6,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
 	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"gorm.io/gorm"
 	"net/http"
@@ -51,7 +52,7 @@ func checkAvatar(newAvatarUrl string, oldAvatarUrl string) (string, string) {
 // GetMe godoc
 //
 //	@Summary		Get current authenticated user
-//	@Description	Retrieves the profile of the currently authenticated user
+//	@Description	Retrieves the profile of the currently authenticated user with their subscription, if they have paid for a tier. While impersonating, the staff member acting as the user is shown too.
 //	@Tags			Users
 //	@Accept			json
 //	@Produce		json
@@ -67,10 +68,27 @@ func (uc *UserController) GetMe(ctx *gin.Context) {
 		Phone:     currentUser.Phone,
 		Avatar:    currentUser.Avatar,
 		Verified:  currentUser.Verified,
+		Tier:      currentUser.Tier,
+		Role:      currentUser.Role,
 		CreatedAt: currentUser.CreatedAt,
 		UpdatedAt: currentUser.UpdatedAt,
 	}
 
+	if impersonator, impersonated := ctx.Get("currentImpersonator"); impersonated {
+		actor := impersonator.(ImpersonatorResponse)
+		userResponse.ImpersonatedBy = &actor
+	}
+
+	var subscription Subscription
+	if err := uc.DB.First(&subscription, "user_id = ?", currentUser.ID).Error; err == nil {
+		userResponse.Subscription = &SubscriptionResponse{
+			Tier:      subscription.Tier,
+			Active:    subscription.ExpiredAt == nil && subscription.ExpiresAt.After(time.Now()),
+			StartedAt: subscription.StartedAt,
+			ExpiresAt: subscription.ExpiresAt,
+		}
+	}
+
 	ctx.JSON(http.StatusOK, SuccessResponse[*UserResponse]{
 		Status: "success",
 		Data:   userResponse,
@@ -86,34 +104,40 @@ func (uc *UserController) GetMe(ctx *gin.Context) {
 //	@Produce		json
 //	@Param			page	query		int	false	"Page number"		default(1)
 //	@Param			limit	query		int	false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Param			total	query		bool	false	"Count the items across all pages"
 //	@Success		200		{object}	SuccessPageResponse[[]UserResponse]
+//	@Failure		400		{object}	ErrorResponse
 //	@Failure		502		{object}	ErrorResponse
 //	@Router			/users [get]
 func (uc *UserController) FindUsers(ctx *gin.Context) {
 
 	currentUser := ctx.MustGet("currentUser").(User)
 
-	var page = ctx.DefaultQuery("page", "1")
-	var limit = ctx.DefaultQuery("limit", "10")
-
-	intPage, _ := strconv.Atoi(page)
-	intLimit, _ := strconv.Atoi(limit)
-	offset := (intPage - 1) * intLimit
+	params, err := pagination.FromQuery(ctx, pagination.By("created_at DESC", "id DESC"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
 
 	var users []User
 
-	var results *gorm.DB
+	query := uc.DB.Model(&User{})
 
 	if currentUser.Role == "user" {
-		results = uc.DB.Limit(intLimit).Offset(offset).Find(&users, "role = ?", "user")
+		query = query.Where("role = ?", "user")
 	} else {
-		results = uc.DB.Limit(intLimit).Offset(offset).Find(&users, "role != ?", "owner")
+		query = query.Where("role != ?", "owner")
 	}
 
-	if results.Error != nil {
+	page, err := pagination.Find(query, params, &users)
+	if err != nil {
 		ctx.JSON(http.StatusBadGateway, ErrorResponse{
 			Status:  "error",
-			Message: results.Error.Error(),
+			Message: err.Error(),
 		})
 		return
 	}
@@ -131,13 +155,7 @@ func (uc *UserController) FindUsers(ctx *gin.Context) {
 		}
 	}
 
-	ctx.JSON(http.StatusOK, SuccessPageResponse[[]UserResponse]{
-		Status:  "success",
-		Results: len(users),
-		Data:    userResponses,
-		Page:    intPage,
-		Limit:   intLimit,
-	})
+	ctx.JSON(http.StatusOK, pagination.Response(userResponses, len(users), page))
 }
 
 // GetUser godoc
@@ -242,6 +260,7 @@ func (uc *UserController) DeleteSelf(ctx *gin.Context) {
 
 	if userId != "" {
 		result = uc.DB.Delete(&User{}, "id = ?", userId)
+		initializers.ForgetUser(currentUser.ID)
 	} else {
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{
 			Status:  "error",
@@ -308,6 +327,7 @@ func (uc *UserController) DeleteUser(ctx *gin.Context) {
 	var result *gorm.DB
 	if userId != "" {
 		result = uc.DB.Delete(&User{}, "id = ?", userId)
+		initializers.ForgetUser(targetUser.ID)
 	} else {
 		ctx.JSON(http.StatusBadRequest, ErrorResponse{
 			Status:  "error",
@@ -396,6 +416,13 @@ func (uc *UserController) UpdateSelf(ctx *gin.Context) {
 	// Apply the updates to the database
 	uc.DB.Model(&updatedUser).Updates(userToUpdate)
 
+	// A new phone number has to be verified again
+	if payload.Phone != "" && payload.Phone != currentUser.Phone {
+		uc.DB.Model(&updatedUser).Update("verified", false)
+	}
+
+	initializers.ForgetUser(currentUser.ID)
+
 	// Prepare the user response
 	userResponse := &UserResponse{
 		ID:             updatedUser.ID,
@@ -463,6 +490,17 @@ func (uc *UserController) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
+	// The tier must be in the catalog
+	if payload.Tier != "" {
+		if err := uc.DB.First(&Tier{}, "name = ?", payload.Tier).Error; err != nil {
+			ctx.JSON(http.StatusBadRequest, ErrorResponse{
+				Status:  "error",
+				Message: "Unknown tier",
+			})
+			return
+		}
+	}
+
 	// Parse TelegramUserId if provided
 	var newTelegramId int64
 	var err error
@@ -502,6 +540,8 @@ func (uc *UserController) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
+	initializers.ForgetUser(updatedUser.ID)
+
 	userResponse := &UserResponse{
 		ID:        updatedUser.ID,
 		Name:      updatedUser.Name,
@@ -601,6 +641,8 @@ func (uc *UserController) AssignRole(ctx *gin.Context) {
 		return
 	}
 
+	initializers.ForgetUser(targetUser.ID)
+
 	userResponse := &UserResponse{
 		ID:             targetUser.ID,
 		TelegramUserID: targetUser.TelegramUserId,
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/pagination"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"gorm.io/gorm"
+)
+
+// WalletController shows the balances of users, kept in a double-entry
+// ledger, and lets admins correct them. Balances are topped up and spent
+// through the PaymentController.
+type WalletController struct {
+	DB *gorm.DB
+}
+
+func NewWalletController(DB *gorm.DB) WalletController {
+	return WalletController{DB}
+}
+
+// GetMyWallet godoc
+//
+//	@Summary		Get the current user's balance
+//	@Description	Returns the balance of the current user, which tiers and boosts can be paid from.
+//	@Tags			Wallet
+//	@Produce		json
+//	@Success		200	{object}	SuccessResponse[WalletResponse]
+//	@Failure		500	{object}	ErrorResponse
+//	@Router			/wallet [get]
+func (wc *WalletController) GetMyWallet(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var account LedgerAccount
+	err := wc.DB.Where("user_id = ?", currentUser.ID).Limit(1).Find(&account).Error
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to retrieve the balance"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[WalletResponse]{
+		Status: "success",
+		Data:   WalletResponse{Balance: account.Balance},
+	})
+}
+
+// GetMyWalletEntries godoc
+//
+//	@Summary		Get the current user's balance history
+//	@Description	Retrieves the ledger entries of the balance of the current user, latest first with pagination. Positive amounts were added to the balance, negative ones taken from it.
+//	@Tags			Wallet
+//	@Produce		json
+//	@Param			page	query		int	false	"Page number"		default(1)
+//	@Param			limit	query		int	false	"Limit per page"	default(10)
+//	@Param			cursor	query		string	false	"Cursor of a page returned before, in place of the page number"
+//	@Success		200		{object}	SuccessCountedPageResponse[[]LedgerEntryResponse]
+//	@Failure		400		{object}	ErrorResponse
+//	@Failure		500		{object}	ErrorResponse
+//	@Router			/wallet/entries [get]
+func (wc *WalletController) GetMyWalletEntries(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	params, err := pagination.FromQuery(ctx, pagination.By("ledger_entries.created_at DESC", "ledger_entries.id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{
+			Status:  "error",
+			Message: err.Error(),
+		})
+		return
+	}
+	params.Total = true
+
+	query := wc.DB.Model(&LedgerEntry{}).
+		Joins("JOIN ledger_accounts ON ledger_accounts.id = ledger_entries.account_id").
+		Where("ledger_accounts.user_id = ?", currentUser.ID)
+
+	var entries []LedgerEntry
+	page, err := pagination.Find(query, params, &entries, func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Transaction")
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to retrieve the balance history"})
+		return
+	}
+
+	entryResponses := make([]LedgerEntryResponse, len(entries))
+	for i, entry := range entries {
+		entryResponses[i] = utils.MapLedgerEntry(entry)
+	}
+
+	ctx.JSON(http.StatusOK, pagination.CountedResponse(entryResponses, len(entries), page))
+}
+
+// AdjustBalance godoc
+//
+//	@Summary		Corrects the balance of a user (privileged access)
+//	@Description	Adds the amount to the balance of the user, or takes it away when it is negative, recording the reason in the ledger. The balance can't go below zero.
+//	@Tags			Wallet
+//	@Accept			json
+//	@Produce		json
+//	@Param			AdjustBalanceRequest	body		AdjustBalanceRequest	true	"User, amount and reason"
+//	@Success		201						{object}	SuccessResponse[WalletResponse]
+//	@Failure		400						{object}	ErrorResponse
+//	@Failure		404						{object}	ErrorResponse
+//	@Failure		409						{object}	ErrorResponse
+//	@Failure		500						{object}	ErrorResponse
+//	@Router			/wallet/adjustments [post]
+func (wc *WalletController) AdjustBalance(ctx *gin.Context) {
+	currentUser := ctx.MustGet("currentUser").(User)
+
+	var payload *AdjustBalanceRequest
+
+	if err := ctx.ShouldBindJSON(&payload); err != nil {
+		ctx.JSON(http.StatusBadRequest, ErrorResponse{Status: "error", Message: err.Error()})
+		return
+	}
+
+	if err := wc.DB.Select("id").First(&User{}, "id = ?", payload.UserID).Error; err != nil {
+		ctx.JSON(http.StatusNotFound, ErrorResponse{Status: "error", Message: "User not found"})
+		return
+	}
+
+	var wallet LedgerAccount
+
+	err := wc.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := utils.Adjust(tx, payload.UserID, payload.Amount, payload.Reason, currentUser.ID); err != nil {
+			return err
+		}
+
+		return tx.First(&wallet, "user_id = ?", payload.UserID).Error
+	})
+
+	switch {
+	case errors.Is(err, utils.ErrInsufficientBalance):
+		ctx.JSON(http.StatusConflict, ErrorResponse{Status: "error", Message: "The balance is too low"})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to adjust the balance"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, SuccessResponse[WalletResponse]{
+		Status: "success",
+		Data:   WalletResponse{Balance: wallet.Balance},
+	})
+}
+
+// RebuildBalances godoc
+//
+//	@Summary		Rebuilds the balances from the ledger (privileged access)
+//	@Description	Sets the balance of every ledger account to the sum of its entries and returns how many were off.
+//	@Tags			Wallet
+//	@Produce		json
+//	@Success		200	{object}	SuccessResponse[RebuildBalancesResponse]
+//	@Failure		500	{object}	ErrorResponse
+//	@Router			/wallet/rebuild [post]
+func (wc *WalletController) RebuildBalances(ctx *gin.Context) {
+	corrected, err := utils.RebuildBalances(wc.DB)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, ErrorResponse{Status: "error", Message: "Failed to rebuild the balances"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, SuccessResponse[RebuildBalancesResponse]{
+		Status: "success",
+		Data:   RebuildBalancesResponse{Corrected: corrected},
+	})
+}
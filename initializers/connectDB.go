@@ -9,6 +9,7 @@ import (
 	"time"
 
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -91,6 +92,10 @@ func Migrate() {
 		&IntimateHairCut{},
 		&UserTag{},
 		&ProfileTag{},
+		&Tier{},
+		&BoostPlan{},
+		&LedgerTransaction{},
+		&PaymentReconciliation{},
 		&User{})
 
 	if err != nil {
@@ -99,11 +104,21 @@ func Migrate() {
 
 	log.Printf("Automigrating T-1 models...")
 	err = DB.AutoMigrate(
-		&Profile{},         // needs User
-		&Payment{},         // needs User
-		&Photo{},           // needs Profile
-		&RatedProfileTag{}, // needs ProfileTag
-		&RatedUserTag{},    // needs UserTag
+		&Profile{},           // needs User
+		&Payment{},           // needs User
+		&Session{},           // needs User
+		&PhoneVerification{}, // needs User
+		&PasswordReset{},     // needs User
+		&TwoFactorAuth{},     // needs User
+		&RecoveryCode{},      // needs User
+		&FailedLogin{},       // needs User
+		&ApiKey{},            // needs User
+		&Impersonation{},     // needs User
+		&Subscription{},      // needs User
+		&LedgerAccount{},     // needs User
+		&Photo{},             // needs Profile
+		&RatedProfileTag{},   // needs ProfileTag
+		&RatedUserTag{},      // needs UserTag
 	)
 
 	if err != nil {
@@ -112,15 +127,27 @@ func Migrate() {
 
 	log.Printf("Automigrating T-2 models...")
 	err = DB.AutoMigrate(
-		&Service{},        // needs User, Profile
-		&ProfileBodyArt{}, // needs Profile, BodyArt
-		&ProfileOption{},  // needs Profile, ProfileTag
+		&Service{},             // needs User, Profile
+		&ProfileBodyArt{},      // needs Profile, BodyArt
+		&ProfileOption{},       // needs Profile, ProfileTag
+		&RefreshToken{},        // needs Session
+		&ImpersonatedRequest{}, // needs Impersonation
+		&PaymentEvent{},        // needs Payment
+		&PaymentRefund{},       // needs Payment
+		&PaymentMismatch{},     // needs Payment
+		&ContactReveal{},       // needs User, Profile
+		&Boost{},               // needs Profile, Payment
+		&LedgerEntry{},         // needs LedgerAccount, LedgerTransaction
 	)
 
 	if err != nil {
 		log.Fatalf("Failed to auto-migrate T-2 models: %v", err)
 	}
 
+	if err := utils.CreateProfileSearchTriggers(DB); err != nil {
+		log.Fatalf("Failed to create profile search triggers: %v", err)
+	}
+
 	log.Printf("Automigrating T-3 models...")
 	err = DB.AutoMigrate(
 		&ProfileRating{}, // needs User, Profile, Service, RatedProfileTag
@@ -131,6 +158,14 @@ func Migrate() {
 		log.Fatalf("Failed to auto-migrate T-3 models: %v", err)
 	}
 
+	if err := utils.SeedTiers(DB); err != nil {
+		log.Fatalf("Failed to seed tiers: %v", err)
+	}
+
+	if err := utils.SeedBoostPlans(DB); err != nil {
+		log.Fatalf("Failed to seed boost plans: %v", err)
+	}
+
 	fmt.Println("Creating owner users...")
 	CreateOwnerUser(DB)
 	fmt.Println("Creating owner users... OK")
@@ -0,0 +1,55 @@
+package initializers
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+// CachedAuth is what DeserializeUser loads for an access token.
+type CachedAuth struct {
+	User    User
+	Session *Session
+}
+
+// DefaultUserCacheTTL is how long AuthCache keeps a session unless
+// USER_CACHE_TTL tells otherwise.
+const DefaultUserCacheTTL = 30 * time.Second
+
+// AuthCache keeps what DeserializeUser has loaded, keyed by the session ID of
+// the token (or the user ID for tokens without a session), so requests only
+// read the user and the session from the database once per TTL. It is set up
+// by InitAuthCache, nil only in tests that don't call it.
+//
+// The cache is local to the process: changes made through this instance are
+// dropped from it right away, changes made by other replicas are seen after
+// the TTL at the latest. Keep the TTL short.
+var AuthCache *utils.TTLCache[string, CachedAuth]
+
+func InitAuthCache(config *Config) {
+	ttl := config.UserCacheTTL
+	if ttl <= 0 {
+		ttl = DefaultUserCacheTTL
+	}
+
+	AuthCache = utils.NewTTLCache[string, CachedAuth](ttl)
+}
+
+// ForgetSession drops the cached session, so its next request reads it from the database.
+func ForgetSession(sessionID uuid.UUID) {
+	if AuthCache != nil {
+		AuthCache.Delete(sessionID.String())
+	}
+}
+
+// ForgetUser drops every cached session of the user, so changes to the user
+// are seen by their next request.
+func ForgetUser(userID uuid.UUID) {
+	if AuthCache != nil {
+		AuthCache.DeleteFunc(func(_ string, auth CachedAuth) bool {
+			return auth.User.ID == userID
+		})
+	}
+}
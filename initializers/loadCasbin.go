@@ -5,11 +5,22 @@ import (
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
 	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"gorm.io/gorm"
 	"log"
+	"time"
 )
 
-var Enforcer *casbin.Enforcer
+const defaultCasbinReloadInterval = 10 * time.Second
 
+var Enforcer *casbin.SyncedEnforcer
+
+var policyWatcher *utils.PolicyWatcher
+
+// InitCasbin loads the access policy from the database, it needs ConnectDB
+// first. An empty policy is seeded from CASBIN_POLICY_PATH once, after that
+// the policy is managed through the API and the file is not read anymore.
 func InitCasbin(config *Config) {
 	// Load the model from a file
 	m, err := model.NewModelFromFile(config.CasbinModelPath)
@@ -18,18 +29,158 @@ func InitCasbin(config *Config) {
 		panic(message)
 	}
 
-	a := fileadapter.NewAdapter(config.CasbinPolicyPath)
+	if err := DB.AutoMigrate(&CasbinRule{}, &CasbinPolicyRevision{}); err != nil {
+		log.Fatalf("failed to migrate policy tables: %v", err)
+	}
+
+	a := utils.NewGormAdapter(DB)
 
 	// Create the enforcer with the model and the adapter
-	Enforcer, err = casbin.NewEnforcer(m, a)
+	Enforcer, err = casbin.NewSyncedEnforcer(m, a)
 	if err != nil {
 		message, _ := fmt.Printf("failed to create enforcer: %s", err)
 		panic(message)
 	}
 
-	// Load the policies from the adapter (file)
+	// API keys are limited to their scopes on top of the policy
+	Enforcer.AddFunction("scopeMatch", utils.ScopeMatchFunc)
+
+	var rules int64
+	if err := DB.Model(&CasbinRule{}).Count(&rules).Error; err != nil {
+		log.Fatalf("failed to count policy rules: %v", err)
+	}
+
+	if rules == 0 && config.CasbinPolicyPath != "" {
+		seedPolicy(config.CasbinPolicyPath)
+	} else if config.CasbinPolicyPath != "" {
+		upgradePolicy(config.CasbinPolicyPath)
+	}
+
+	upgradeSearchPolicy(rules == 0)
+
+	// Load the policies from the adapter (database)
 	err = Enforcer.LoadPolicy()
 	if err != nil {
 		log.Fatalf("failed to load policy: %v", err)
 	}
+
+	interval := config.CasbinReloadInterval
+	if interval <= 0 {
+		interval = defaultCasbinReloadInterval
+	}
+
+	if policyWatcher != nil {
+		policyWatcher.Close()
+	}
+
+	policyWatcher, err = utils.NewPolicyWatcher(DB, interval)
+	if err != nil {
+		log.Fatalf("failed to watch policy: %v", err)
+	}
+
+	if err := Enforcer.SetWatcher(policyWatcher); err != nil {
+		log.Fatalf("failed to watch policy: %v", err)
+	}
+
+	// the default callback reloads through the unsynchronized enforcer
+	_ = policyWatcher.SetUpdateCallback(func(string) {
+		if err := Enforcer.LoadPolicy(); err != nil {
+			log.Printf("failed to reload policy: %v", err)
+		}
+	})
+}
+
+// seedPolicy copies the policy file into the database. When several replicas
+// start on an empty database at once only one of them succeeds, the others
+// load what it has written.
+func seedPolicy(path string) {
+	if err := fileadapter.NewAdapter(path).LoadPolicy(Enforcer.GetModel()); err != nil {
+		log.Fatalf("failed to load policy file: %v", err)
+	}
+
+	if err := Enforcer.SavePolicy(); err != nil {
+		log.Printf("failed to seed policy: %v", err)
+		return
+	}
+
+	log.Printf("seeded the policy from %s", path)
+}
+
+// upgradePolicy brings a policy saved before the rules of resource owners
+// existed up to date: their rules are copied from the policy file, and the
+// rules letting any user of a tier set the visibility of reviews, which they
+// replace, are dropped.
+func upgradePolicy(path string) {
+	var owned int64
+	if err := DB.Model(&CasbinRule{}).Where("ptype = ? AND v0 = ?", "p", ResourceOwnerSubject).Count(&owned).Error; err != nil {
+		log.Fatalf("failed to count policy rules: %v", err)
+	}
+
+	if owned > 0 {
+		return
+	}
+
+	file := Enforcer.GetModel().Copy()
+	file.ClearPolicy()
+
+	if err := fileadapter.NewAdapter(path).LoadPolicy(file); err != nil {
+		log.Fatalf("failed to load policy file: %v", err)
+	}
+
+	rules, err := file.GetFilteredPolicy("p", "p", 0, ResourceOwnerSubject)
+	if err != nil || len(rules) == 0 {
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		a := utils.NewGormAdapter(tx)
+
+		for _, rule := range rules {
+			if err := a.AddPolicy("p", "p", rule); err != nil {
+				return err
+			}
+		}
+
+		return a.RemoveFilteredPolicy("p", "p", 0, "user", "reviews", "set-visibility")
+	})
+
+	if err != nil {
+		log.Printf("failed to upgrade policy: %v", err)
+		return
+	}
+
+	log.Printf("added the rules of resource owners from %s", path)
+}
+
+// searchPolicyVersion is the policy version from which search access of
+// users comes from the tier catalog.
+const searchPolicyVersion = 1
+
+// upgradeSearchPolicy replaces the rules letting users of some tiers search
+// profiles, saved before search access came from the tier catalog, with one
+// rule for users of any tier. It runs once, rules added through the API
+// afterwards are kept. A freshly seeded policy is only marked as upgraded.
+func upgradeSearchPolicy(seeded bool) {
+	applied, err := utils.UpgradePolicy(DB, searchPolicyVersion, func(tx *gorm.DB) error {
+		if seeded {
+			return nil
+		}
+
+		a := utils.NewGormAdapter(tx)
+
+		if err := a.RemoveFilteredPolicy("p", "p", 0, "user", "profiles", "query"); err != nil {
+			return err
+		}
+
+		return a.AddPolicy("p", "p", []string{"user", "profiles", "query", "*", "false", "*"})
+	})
+
+	if err != nil {
+		log.Printf("failed to upgrade policy: %v", err)
+		return
+	}
+
+	if applied && !seeded {
+		log.Printf("moved search access of users to the tier catalog")
+	}
 }
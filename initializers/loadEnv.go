@@ -1,6 +1,7 @@
 package initializers
 
 import (
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -18,8 +19,9 @@ type Config struct {
 	ImgProxySigningSaltHex    string `mapstructure:"IMGPROXY_SALT"`
 	ProcessingGoroutinesCount int    `mapstructure:"PROCESSING_GOROUTINES_COUNT"`
 
-	CasbinModelPath  string `mapstructure:"CASBIN_MODEL_PATH"`
-	CasbinPolicyPath string `mapstructure:"CASBIN_POLICY_PATH"`
+	CasbinModelPath      string        `mapstructure:"CASBIN_MODEL_PATH"`
+	CasbinPolicyPath     string        `mapstructure:"CASBIN_POLICY_PATH"`
+	CasbinReloadInterval time.Duration `mapstructure:"CASBIN_RELOAD_INTERVAL"`
 
 	DBHost                 string `mapstructure:"POSTGRES_HOST"`
 	DBUserName             string `mapstructure:"POSTGRES_USER"`
@@ -32,6 +34,10 @@ type Config struct {
 
 	ClientOrigin string `mapstructure:"CLIENT_ORIGIN"`
 
+	// Addresses or CIDRs of the reverse proxies, comma separated. Only they
+	// are trusted to tell the client IP in X-Forwarded-For.
+	TrustedProxies string `mapstructure:"TRUSTED_PROXIES"`
+
 	AccessTokenPrivateKey  string        `mapstructure:"ACCESS_TOKEN_PRIVATE_KEY"`
 	AccessTokenPublicKey   string        `mapstructure:"ACCESS_TOKEN_PUBLIC_KEY"`
 	RefreshTokenPrivateKey string        `mapstructure:"REFRESH_TOKEN_PRIVATE_KEY"`
@@ -41,10 +47,54 @@ type Config struct {
 	AccessTokenMaxAge      int           `mapstructure:"ACCESS_TOKEN_MAXAGE"`
 	RefreshTokenMaxAge     int           `mapstructure:"REFRESH_TOKEN_MAXAGE"`
 
+	// Public keys of retired signing keys, comma separated. Tokens they signed
+	// are accepted until they expire, so keys can be rotated without logging
+	// everybody out.
+	AccessTokenRetiredPublicKeys  string `mapstructure:"ACCESS_TOKEN_RETIRED_PUBLIC_KEYS"`
+	RefreshTokenRetiredPublicKeys string `mapstructure:"REFRESH_TOKEN_RETIRED_PUBLIC_KEYS"`
+
+	UserCacheTTL time.Duration `mapstructure:"USER_CACHE_TTL"`
+
+	ImpersonationTTL time.Duration `mapstructure:"IMPERSONATION_TTL"`
+
+	LoginMaxFailures   int           `mapstructure:"LOGIN_MAX_FAILURES"`
+	LoginIPMaxFailures int           `mapstructure:"LOGIN_IP_MAX_FAILURES"`
+	LoginLockout       time.Duration `mapstructure:"LOGIN_LOCKOUT"`
+
+	TelegramBotToken   string        `mapstructure:"TELEGRAM_BOT_TOKEN"`
+	TelegramAuthMaxAge time.Duration `mapstructure:"TELEGRAM_AUTH_MAX_AGE"`
+	BotApiSecret       string        `mapstructure:"BOT_API_SECRET"`
+	TelegramSender     string        `mapstructure:"TELEGRAM_SENDER"`
+
+	SmsSender         string        `mapstructure:"SMS_SENDER"`
+	SmsFilePath       string        `mapstructure:"SMS_FILE_PATH"`
+	OtpTTL            time.Duration `mapstructure:"OTP_TTL"`
+	OtpResendCooldown time.Duration `mapstructure:"OTP_RESEND_COOLDOWN"`
+	OtpMaxAttempts    int           `mapstructure:"OTP_MAX_ATTEMPTS"`
+	OtpMaxSendsPerDay int           `mapstructure:"OTP_MAX_SENDS_PER_DAY"`
+
+	PasswordResetTTL time.Duration `mapstructure:"PASSWORD_RESET_TTL"`
+
+	TwoFactorIssuer     string        `mapstructure:"TWO_FACTOR_ISSUER"`
+	TwoFactorPreAuthTTL time.Duration `mapstructure:"TWO_FACTOR_PREAUTH_TTL"`
+
 	VerifiedDistanceThreshold int `mapstructure:"VERIFIED_DISTANCE_THRESHOLD"`
 	ReviewUpdateLimitHours    int `mapstructure:"REVIEW_UPDATE_LIMIT_HOURS"`
 
 	ParsedBaseUrl string `mapstructure:"PARSED_BASE_URL"`
+
+	PaymentProvider        string `mapstructure:"PAYMENT_PROVIDER"`
+	PaymentProviderApiKey  string `mapstructure:"PAYMENT_PROVIDER_API_KEY"`
+	PaymentProviderBaseUrl string `mapstructure:"PAYMENT_PROVIDER_BASE_URL"`
+	PaymentWebhookSecret   string `mapstructure:"PAYMENT_WEBHOOK_SECRET"`
+
+	// Directory the payment provider leaves its daily settlement files in.
+	SettlementDir          string        `mapstructure:"SETTLEMENT_DIR"`
+	ReconciliationInterval time.Duration `mapstructure:"RECONCILIATION_INTERVAL"`
+
+	SubscriptionPeriod        time.Duration `mapstructure:"SUBSCRIPTION_PERIOD"`
+	SubscriptionReminder      time.Duration `mapstructure:"SUBSCRIPTION_REMINDER"`
+	SubscriptionCheckInterval time.Duration `mapstructure:"SUBSCRIPTION_CHECK_INTERVAL"`
 }
 
 func LoadConfig(path string) (config Config, err error) {
@@ -62,3 +112,24 @@ func LoadConfig(path string) (config Config, err error) {
 	err = viper.Unmarshal(&config)
 	return
 }
+
+// TrustedProxyList returns the trusted reverse proxies, nil when there are none.
+func (config *Config) TrustedProxyList() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(config.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// AccessTokenVerificationKeys returns every public key access tokens are accepted with.
+func (config *Config) AccessTokenVerificationKeys() []string {
+	return append([]string{config.AccessTokenPublicKey}, strings.Split(config.AccessTokenRetiredPublicKeys, ",")...)
+}
+
+// RefreshTokenVerificationKeys returns every public key refresh tokens are accepted with.
+func (config *Config) RefreshTokenVerificationKeys() []string {
+	return append([]string{config.RefreshTokenPublicKey}, strings.Split(config.RefreshTokenRetiredPublicKeys, ",")...)
+}
@@ -0,0 +1,26 @@
+package initializers
+
+import (
+	"time"
+
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+// InitLoginGuard creates the brute-force guard of password logins. Counters
+// live in process memory.
+func InitLoginGuard(config *Config) *utils.LoginGuard {
+	guard := utils.NewLoginGuard(utils.NewMemoryLoginAttemptStore(time.Hour))
+
+	if config.LoginMaxFailures > 0 {
+		guard.Account.MaxFailures = config.LoginMaxFailures
+	}
+	if config.LoginIPMaxFailures > 0 {
+		guard.IP.MaxFailures = config.LoginIPMaxFailures
+	}
+	if config.LoginLockout > 0 {
+		guard.Account.Lockout = config.LoginLockout
+		guard.IP.Lockout = config.LoginLockout
+	}
+
+	return guard
+}
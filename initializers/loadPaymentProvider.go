@@ -0,0 +1,29 @@
+package initializers
+
+import (
+	"log"
+
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+// InitPaymentProvider picks the payment provider configured by PAYMENT_PROVIDER.
+// The fake provider marks payments paid without charging anyone, so like the
+// senders it has to be asked for explicitly. Webhooks are refused without
+// PAYMENT_WEBHOOK_SECRET, so the server doesn't start without it either.
+func InitPaymentProvider(config *Config) utils.PaymentProvider {
+	if config.PaymentWebhookSecret == "" {
+		log.Fatal("PAYMENT_WEBHOOK_SECRET is not set")
+		return nil
+	}
+
+	switch config.PaymentProvider {
+	case "":
+		log.Fatal("PAYMENT_PROVIDER is not set")
+		return nil
+	case "fake":
+		return utils.NewFakePaymentProvider(config.PaymentWebhookSecret, config.PaymentProviderBaseUrl)
+	default:
+		log.Fatalf("unknown payment provider: %s", config.PaymentProvider)
+		return nil
+	}
+}
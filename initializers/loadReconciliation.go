@@ -0,0 +1,34 @@
+package initializers
+
+import (
+	"time"
+
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+const defaultReconciliationInterval = time.Hour
+
+var stopReconciliation func()
+
+// InitReconciliation starts the job reconciling the payments of the provider
+// with the settlement files it leaves in SETTLEMENT_DIR, it needs ConnectDB
+// first. Without SETTLEMENT_DIR payments are only reconciled on demand.
+func InitReconciliation(config *Config, provider utils.PaymentProvider) {
+	if stopReconciliation != nil {
+		stopReconciliation()
+		stopReconciliation = nil
+	}
+
+	if config.SettlementDir == "" {
+		return
+	}
+
+	interval := config.ReconciliationInterval
+	if interval <= 0 {
+		interval = defaultReconciliationInterval
+	}
+
+	job := &utils.ReconciliationJob{DB: DB, Provider: provider.Name(), Dir: config.SettlementDir}
+
+	stopReconciliation = job.Start(interval)
+}
@@ -0,0 +1,41 @@
+package initializers
+
+import (
+	"log"
+
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+// InitSmsSender picks the SMS delivery backend configured by SMS_SENDER. The
+// log sender writes codes to the logs, so it has to be asked for explicitly.
+func InitSmsSender(config *Config) utils.SmsSender {
+	switch config.SmsSender {
+	case "":
+		log.Fatal("SMS_SENDER is not set")
+		return nil
+	case "log":
+		return utils.LogSmsSender{}
+	case "file":
+		return utils.NewFileSmsSender(config.SmsFilePath)
+	default:
+		log.Fatalf("unknown sms sender: %s", config.SmsSender)
+		return nil
+	}
+}
+
+// InitTelegramSender picks the Telegram delivery backend configured by TELEGRAM_SENDER.
+// Like SMS_SENDER it has no default.
+func InitTelegramSender(config *Config) utils.TelegramSender {
+	switch config.TelegramSender {
+	case "":
+		log.Fatal("TELEGRAM_SENDER is not set")
+		return nil
+	case "log":
+		return utils.LogTelegramSender{}
+	case "bot":
+		return utils.NewBotTelegramSender(config.TelegramBotToken)
+	default:
+		log.Fatalf("unknown telegram sender: %s", config.TelegramSender)
+		return nil
+	}
+}
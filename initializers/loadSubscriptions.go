@@ -0,0 +1,45 @@
+package initializers
+
+import (
+	"time"
+
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+const (
+	defaultSubscriptionPeriod        = 30 * 24 * time.Hour
+	defaultSubscriptionReminder      = 3 * 24 * time.Hour
+	defaultSubscriptionCheckInterval = time.Hour
+)
+
+var stopSubscriptions func()
+
+// InitSubscriptions sets up the subscription service and starts the job
+// reminding of and expiring subscriptions, it needs ConnectDB first.
+func InitSubscriptions(config *Config, sender utils.TelegramSender, smsSender utils.SmsSender) *utils.SubscriptionService {
+	period := config.SubscriptionPeriod
+	if period <= 0 {
+		period = defaultSubscriptionPeriod
+	}
+
+	reminder := config.SubscriptionReminder
+	if reminder <= 0 {
+		reminder = defaultSubscriptionReminder
+	}
+
+	interval := config.SubscriptionCheckInterval
+	if interval <= 0 {
+		interval = defaultSubscriptionCheckInterval
+	}
+
+	subscriptions := utils.NewSubscriptionService(DB, sender, smsSender, period, reminder)
+	subscriptions.OnTierChange = ForgetUser
+
+	if stopSubscriptions != nil {
+		stopSubscriptions()
+	}
+
+	stopSubscriptions = subscriptions.Start(interval)
+
+	return subscriptions
+}
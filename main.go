@@ -12,6 +12,7 @@ import (
 	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	"github.com/ivegotanidea/golang-gorm-postgres/routes"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 )
 
 var (
@@ -19,6 +20,18 @@ var (
 	AuthController      controllers.AuthController
 	AuthRouteController routes.AuthRouteController
 
+	ApiKeyController      controllers.ApiKeyController
+	ApiKeyRouteController routes.ApiKeyRouteController
+
+	ImpersonationController      controllers.ImpersonationController
+	ImpersonationRouteController routes.ImpersonationRouteController
+
+	PolicyController      controllers.PolicyController
+	PolicyRouteController routes.PolicyRouteController
+
+	TierController      controllers.TierController
+	TierRouteController routes.TierRouteController
+
 	UserController      controllers.UserController
 	UserRouteController routes.UserRouteController
 
@@ -35,6 +48,12 @@ var (
 
 	ImageController      controllers.ImageController
 	ImageRouteController routes.ImageRouteController
+
+	PaymentController      controllers.PaymentController
+	PaymentRouteController routes.PaymentRouteController
+
+	WalletController      controllers.WalletController
+	WalletRouteController routes.WalletRouteController
 )
 
 func init() {
@@ -43,20 +62,38 @@ func init() {
 		log.Fatal("🚀 Could not load environment variables", err)
 	}
 
-	initializers.InitCasbin(&config)
 	initializers.ConnectDB(&config)
 	initializers.Migrate()
+	initializers.InitCasbin(&config)
+	initializers.InitAuthCache(&config)
 
-	AuthController = controllers.NewAuthController(initializers.DB)
+	telegramSender := initializers.InitTelegramSender(&config)
+	smsSender := initializers.InitSmsSender(&config)
+
+	AuthController = controllers.NewAuthController(initializers.DB, smsSender, telegramSender, initializers.InitLoginGuard(&config))
 	AuthRouteController = routes.NewAuthRouteController(AuthController)
 
+	ApiKeyController = controllers.NewApiKeyController(initializers.DB)
+	ApiKeyRouteController = routes.NewRouteApiKeyController(ApiKeyController)
+
+	ImpersonationController = controllers.NewImpersonationController(initializers.DB)
+	ImpersonationRouteController = routes.NewRouteImpersonationController(ImpersonationController)
+
+	PolicyController = controllers.NewPolicyController(initializers.DB)
+	PolicyRouteController = routes.NewRoutePolicyController(PolicyController)
+
+	TierController = controllers.NewTierController(initializers.DB)
+	TierRouteController = routes.NewRouteTierController(TierController)
+
+	entitlements := utils.NewEntitlementService(initializers.DB)
+
 	UserController = controllers.NewUserController(initializers.DB)
 	UserRouteController = routes.NewRouteUserController(UserController)
 
-	ProfileController = controllers.NewProfileController(config.ParsedBaseUrl, initializers.DB)
+	ProfileController = controllers.NewProfileController(config.ParsedBaseUrl, initializers.DB, entitlements)
 	ProfileRouteController = routes.NewRouteProfileController(ProfileController)
 
-	ServiceController = controllers.NewServiceController(initializers.DB, config.ReviewUpdateLimitHours)
+	ServiceController = controllers.NewServiceController(initializers.DB, config.ReviewUpdateLimitHours, entitlements)
 	ServiceRouteController = routes.NewRouteServiceController(ServiceController)
 
 	ReviewsRouteController = routes.NewRouteReviewController(ServiceController)
@@ -81,7 +118,22 @@ func init() {
 
 	ImageRouteController = routes.NewRouteImageController(ImageController)
 
+	paymentProvider := initializers.InitPaymentProvider(&config)
+	initializers.InitReconciliation(&config, paymentProvider)
+
+	PaymentController = controllers.NewPaymentController(initializers.DB, paymentProvider, initializers.InitSubscriptions(&config, telegramSender, smsSender))
+	PaymentRouteController = routes.NewRoutePaymentController(PaymentController)
+
+	WalletController = controllers.NewWalletController(initializers.DB)
+	WalletRouteController = routes.NewRouteWalletController(WalletController)
+
 	server = gin.Default()
+
+	// the client IP keys the login limits, so it must not be taken from
+	// headers anybody can send
+	if err := server.SetTrustedProxies(config.TrustedProxyList()); err != nil {
+		log.Fatal("🚀 Could not set trusted proxies", err)
+	}
 }
 
 func healthCheckHandler(ctx *gin.Context) {
@@ -111,15 +163,23 @@ func main() {
 	router.GET("/ping", pingPongHandler)
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	AuthRouteController.WellKnownRoute(router)
+
 	apiRouter := router.Group("/api/v1")
 
 	AuthRouteController.AuthRoute(apiRouter)
+	ApiKeyRouteController.ApiKeyRoute(apiRouter)
+	ImpersonationRouteController.ImpersonationRoute(apiRouter)
+	PolicyRouteController.PolicyRoute(apiRouter)
+	TierRouteController.TierRoute(apiRouter)
 	UserRouteController.UserRoute(apiRouter)
 	ProfileRouteController.ProfileRoute(apiRouter)
 	ServiceRouteController.ServiceRoute(apiRouter)
 	ReviewsRouteController.ReviewsRoute(apiRouter)
 	DictionaryRouteController.DictionaryRoute(apiRouter)
 	ImageRouteController.ImageRoute(apiRouter)
+	PaymentRouteController.PaymentRoute(apiRouter)
+	WalletRouteController.WalletRoute(apiRouter)
 
 	log.Fatal(server.Run(":" + config.ServerPort))
 }
@@ -1,13 +1,21 @@
 package middleware
 
 import (
+	"errors"
+	"slices"
+
 	"github.com/gin-gonic/gin"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"gorm.io/gorm"
 	"net/http"
 )
 
-func AbacMiddleware(obj string, act string) gin.HandlerFunc {
+// AbacMiddleware checks the current user against the access policy. Routes
+// about a single resource pass the owners of it, which the resource-owner
+// rules of the policy are matched against.
+func AbacMiddleware(obj string, act string, owners ...ResourceOwner) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		user, exists := c.Get("currentUser")
@@ -27,18 +35,42 @@ func AbacMiddleware(obj string, act string) gin.HandlerFunc {
 
 		initializers.Enforcer.EnableLog(true)
 
-		hasProfileStr := "false"
-		if currentUser.HasProfile {
-			hasProfileStr = "true"
+		isOwner := false
+		for _, owner := range owners {
+			ownerIDs, err := owner(c)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Resource not found"})
+				c.Abort()
+				return
+			}
+
+			if errors.Is(err, ErrInvalidResourceRequest) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking permissions"})
+				c.Abort()
+				return
+			}
+
+			if slices.Contains(ownerIDs, currentUser.ID) {
+				isOwner = true
+			}
+		}
+
+		// requests made with an API key are limited to the key's scopes
+		scopes := utils.AllScopes
+		if apiKeyScopes, exists := c.Get("currentApiKeyScopes"); exists {
+			scopes = apiKeyScopes.(string)
 		}
 
+		request := utils.NewPolicyRequest(currentUser, obj, act, c.GetBool("currentUserTwoFactor"), isOwner, scopes)
+
 		// Check if the user has permission
-		ok, err := initializers.Enforcer.Enforce(
-			currentUser.Role, // sub
-			obj,              // obj
-			act,              // act
-			currentUser.Tier, // tier
-			hasProfileStr)    // hasProfile
+		ok, err := initializers.Enforcer.Enforce(request.Values()...)
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking permissions"})
@@ -47,6 +79,29 @@ func AbacMiddleware(obj string, act string) gin.HandlerFunc {
 		}
 
 		if !ok {
+			// tell the user when the second factor is all that's missing
+			if request.TwoFactor == "false" {
+				withTwoFactor := request
+				withTwoFactor.TwoFactor = "true"
+
+				if allowed, _ := initializers.Enforcer.Enforce(withTwoFactor.Values()...); allowed {
+					c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for this action"})
+					c.Abort()
+					return
+				}
+			}
+
+			if scopes != utils.AllScopes {
+				withAllScopes := request
+				withAllScopes.Scopes = utils.AllScopes
+
+				if allowed, _ := initializers.Enforcer.Enforce(withAllScopes.Values()...); allowed {
+					c.JSON(http.StatusForbidden, gin.H{"error": "The API key has no scope for this action"})
+					c.Abort()
+					return
+				}
+			}
+
 			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to access this resource"})
 			c.Abort()
 			return
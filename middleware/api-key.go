@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+// deserializeApiKey authenticates the request with a personal API key sent in
+// the X-Api-Key header, on routes of DeserializeUserOrApiKey.
+func deserializeApiKey(ctx *gin.Context, key string) {
+	prefix, ok := utils.ApiKeyLookupPrefix(key)
+
+	var apiKey ApiKey
+	if !ok || initializers.DB.First(&apiKey, "prefix = ?", prefix).Error != nil ||
+		subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(utils.HashToken(key))) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "invalid API key"})
+		return
+	}
+
+	now := time.Now()
+
+	if apiKey.RevokedAt != nil || apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "API key is revoked or expired"})
+		return
+	}
+
+	var user User
+	if err := initializers.DB.First(&user, "id = ?", apiKey.UserID).Error; err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "the user belonging to this key no longer exists"})
+		return
+	}
+
+	// keys of users made staff after issuing them stop working
+	if !utils.ApiKeyAllowed(user.Role) {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "API keys of staff accounts are not accepted"})
+		return
+	}
+
+	// like the last seen of sessions, last used is only a hint
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > time.Minute {
+		initializers.DB.Model(&ApiKey{}).Where("id = ?", apiKey.ID).UpdateColumn("last_used_at", now)
+	}
+
+	ctx.Set("currentUser", user)
+	ctx.Set("currentUserID", user.ID)
+	ctx.Set("currentUserTier", user.Tier)
+	ctx.Set("currentUserRole", user.Role)
+	// a key outlives the session it was created in, so it never stands in for
+	// the second factor
+	ctx.Set("currentUserTwoFactor", false)
+	ctx.Set("currentApiKeyID", apiKey.ID)
+	ctx.Set("currentApiKeyScopes", apiKey.Scopes)
+	ctx.Next()
+}
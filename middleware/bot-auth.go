@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+)
+
+// BotAuthMiddleware only lets through requests that carry the shared bot
+// credential in the X-Bot-Token header.
+func BotAuthMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		config, _ := initializers.LoadConfig(".")
+
+		token := ctx.GetHeader("X-Bot-Token")
+
+		if config.BotApiSecret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.BotApiSecret)) != 1 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "invalid bot credential"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -1,9 +1,10 @@
 package middleware
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
@@ -11,9 +12,38 @@ import (
 	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 )
 
+// the config is read once instead of on every authenticated request
+var loadConfig = sync.OnceValues(func() (initializers.Config, error) {
+	return initializers.LoadConfig(".")
+})
+
+// DeserializeUser authenticates the request with an access token. Personal
+// API keys are refused, routes on the own account, sessions and keys always
+// need a login.
 func DeserializeUser() gin.HandlerFunc {
+	return deserializeUser(false)
+}
+
+// DeserializeUserOrApiKey is DeserializeUser for routes which also take
+// personal API keys. The route must check the scopes of the key with
+// AbacMiddleware.
+func DeserializeUserOrApiKey() gin.HandlerFunc {
+	return deserializeUser(true)
+}
+
+func deserializeUser(acceptApiKeys bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 
+		if apiKey := ctx.GetHeader("X-Api-Key"); apiKey != "" {
+			if !acceptApiKeys {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "API keys are not accepted here"})
+				return
+			}
+
+			deserializeApiKey(ctx, apiKey)
+			return
+		}
+
 		var access_token string
 		cookie, err := ctx.Cookie("access_token")
 
@@ -31,24 +61,89 @@ func DeserializeUser() gin.HandlerFunc {
 			return
 		}
 
-		config, _ := initializers.LoadConfig(".")
-		sub, err := utils.ValidateToken(access_token, config.AccessTokenPublicKey)
+		config, _ := loadConfig()
+		claims, err := utils.ValidateToken(access_token, utils.AccessTokenType, config.AccessTokenVerificationKeys()...)
 		if err != nil {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": err.Error()})
 			return
 		}
 
-		var user User
-		result := initializers.DB.First(&user, "id = ?", fmt.Sprint(sub))
-		if result.Error != nil {
-			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "the user belonging to this token no logger exists"})
+		if claims.ImpersonationID != "" {
+			deserializeImpersonation(ctx, claims)
 			return
 		}
 
+		cacheKey := claims.SessionID
+		if cacheKey == "" {
+			cacheKey = claims.Subject
+		}
+
+		auth, cached := initializers.CachedAuth{}, false
+		if initializers.AuthCache != nil {
+			auth, cached = initializers.AuthCache.Get(cacheKey)
+		}
+
+		if !cached {
+			result := initializers.DB.First(&auth.User, "id = ?", claims.Subject)
+			if result.Error != nil {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "the user belonging to this token no logger exists"})
+				return
+			}
+
+			if claims.SessionID != "" {
+				var session Session
+				result = initializers.DB.First(&session, "id = ? AND user_id = ?", claims.SessionID, auth.User.ID)
+				if result.Error != nil {
+					ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "session is revoked"})
+					return
+				}
+				auth.Session = &session
+			}
+		}
+
+		if initializers.AuthCache != nil && !cached {
+			initializers.AuthCache.Set(cacheKey, auth)
+		}
+
+		twoFactor := false
+
+		if auth.Session != nil {
+			// work on a copy, the cached session is shared between requests
+			session := *auth.Session
+
+			if session.RevokedAt != nil {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "session is revoked"})
+				return
+			}
+
+			// last seen is only a hint for the sessions list, don't write on every request
+			if now := time.Now(); now.Sub(session.LastSeenAt) > time.Minute {
+				initializers.DB.Model(&Session{}).Where("id = ?", session.ID).UpdateColumn("last_seen_at", now)
+
+				// so cached requests don't write it again for another minute
+				if initializers.AuthCache != nil {
+					initializers.AuthCache.Update(cacheKey, func(auth initializers.CachedAuth) initializers.CachedAuth {
+						if auth.Session != nil {
+							seen := *auth.Session
+							seen.LastSeenAt = now
+							auth.Session = &seen
+						}
+						return auth
+					})
+				}
+			}
+
+			ctx.Set("currentSessionID", session.ID)
+			twoFactor = session.TwoFactor
+		}
+
+		user := auth.User
+
 		ctx.Set("currentUser", user)
 		ctx.Set("currentUserID", user.ID)
 		ctx.Set("currentUserTier", user.Tier)
 		ctx.Set("currentUserRole", user.Role)
+		ctx.Set("currentUserTwoFactor", twoFactor)
 		ctx.Next()
 	}
 }
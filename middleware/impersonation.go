@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+// deserializeImpersonation authenticates the request as the impersonated
+// user and records it in the audit trail of the impersonation once handled.
+// Impersonations are never cached, so ending one takes effect immediately.
+func deserializeImpersonation(ctx *gin.Context, claims *utils.TokenClaims) {
+	var impersonation Impersonation
+	result := initializers.DB.First(&impersonation, "id = ? AND actor_id = ? AND subject_id = ?", claims.ImpersonationID, claims.ActorID, claims.Subject)
+	if result.Error != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "impersonation is not valid"})
+		return
+	}
+
+	if impersonation.EndedAt != nil || time.Now().After(impersonation.ExpiresAt) {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "impersonation has ended"})
+		return
+	}
+
+	var actor User
+	if err := initializers.DB.First(&actor, "id = ?", impersonation.ActorID).Error; err != nil || actor.Role != "admin" && actor.Role != "owner" {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "the actor is no longer allowed to impersonate"})
+		return
+	}
+
+	var user User
+	if err := initializers.DB.First(&user, "id = ?", impersonation.SubjectID).Error; err != nil {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "the user belonging to this token no longer exists"})
+		return
+	}
+
+	ctx.Set("currentUser", user)
+	ctx.Set("currentUserID", user.ID)
+	ctx.Set("currentUserTier", user.Tier)
+	ctx.Set("currentUserRole", user.Role)
+	// the second factor of the actor doesn't stand in for the user's
+	ctx.Set("currentUserTwoFactor", false)
+	ctx.Set("currentImpersonator", ImpersonatorResponse{
+		ImpersonationID: impersonation.ID,
+		ActorID:         actor.ID,
+		ActorName:       actor.Name,
+		ActorRole:       actor.Role,
+		ExpiresAt:       impersonation.ExpiresAt,
+	})
+
+	ctx.Next()
+
+	path := ctx.Request.URL.RequestURI()
+	if len(path) > 500 {
+		path = path[:500]
+	}
+
+	request := ImpersonatedRequest{
+		ImpersonationID: impersonation.ID,
+		Method:          ctx.Request.Method,
+		Path:            path,
+		Status:          ctx.Writer.Status(),
+		CreatedAt:       time.Now(),
+	}
+
+	log.Printf("impersonation %s: %s acting as %s: %s %s -> %d", impersonation.ID, actor.ID, user.ID, request.Method, request.Path, request.Status)
+
+	if err := initializers.DB.Create(&request).Error; err != nil {
+		log.Printf("failed to record impersonated request: %v", err)
+	}
+}
+
+// DenyImpersonation refuses requests made while impersonating a user. Put it
+// after DeserializeUser on routes that change roles, credentials or delete
+// data.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, impersonated := ctx.Get("currentImpersonator"); impersonated {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "error", "message": "This action is not allowed while impersonating"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+)
+
+// ResourceOwner finds the users who own the resource a request is about, so
+// the resource-owner rules of the policy apply to them. It returns
+// gorm.ErrRecordNotFound when there is no such resource, and
+// ErrInvalidResourceRequest when the request can't be read.
+type ResourceOwner func(c *gin.Context) ([]uuid.UUID, error)
+
+var ErrInvalidResourceRequest = errors.New("invalid request")
+
+func profileOwner(id string) ([]uuid.UUID, error) {
+	profileID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var profile Profile
+	if err := initializers.DB.Select("user_id").First(&profile, "id = ?", profileID).Error; err != nil {
+		return nil, err
+	}
+
+	return []uuid.UUID{profile.UserID}, nil
+}
+
+func findService(id string) (Service, error) {
+	var service Service
+
+	serviceID, err := uuid.Parse(id)
+	if err != nil {
+		return service, gorm.ErrRecordNotFound
+	}
+
+	err = initializers.DB.Select("client_user_id", "profile_owner_id").First(&service, "id = ?", serviceID).Error
+	return service, err
+}
+
+// ProfileOwner is the owner of the profile with the ID in the path parameter.
+func ProfileOwner(param string) ResourceOwner {
+	return func(c *gin.Context) ([]uuid.UUID, error) {
+		return profileOwner(c.Param(param))
+	}
+}
+
+// FormProfileOwner is the owner of the profile with the ID in the form field.
+func FormProfileOwner(field string) ResourceOwner {
+	return func(c *gin.Context) ([]uuid.UUID, error) {
+		return profileOwner(c.PostForm(field))
+	}
+}
+
+// ServiceClient is the client of the service with the ID in the query.
+func ServiceClient(query string) ResourceOwner {
+	return func(c *gin.Context) ([]uuid.UUID, error) {
+		service, err := findService(c.Query(query))
+		if err != nil {
+			return nil, err
+		}
+
+		return []uuid.UUID{service.ClientUserID}, nil
+	}
+}
+
+// ServiceProfileOwner is the owner of the profile of the service with the ID
+// in the query.
+func ServiceProfileOwner(query string) ResourceOwner {
+	return func(c *gin.Context) ([]uuid.UUID, error) {
+		service, err := findService(c.Query(query))
+		if err != nil {
+			return nil, err
+		}
+
+		return []uuid.UUID{service.ProfileOwnerID}, nil
+	}
+}
+
+// ServiceParties are the client and the profile owner of the service in the
+// JSON body, which is left for the handler to read again.
+func ServiceParties() ResourceOwner {
+	return func(c *gin.Context) ([]uuid.UUID, error) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResourceRequest, err)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var payload CreateServiceRequest
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidResourceRequest, err)
+		}
+
+		owners, err := profileOwner(payload.ProfileID.String())
+		if err != nil {
+			return nil, err
+		}
+
+		return append(owners, payload.ClientUserID), nil
+	}
+}
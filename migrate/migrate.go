@@ -5,6 +5,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"gorm.io/gorm"
 	"log"
 )
@@ -50,15 +51,39 @@ func CreateOwnerUser(db *gorm.DB) {
 func Init() {
 	initializers.DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
 
+	// coordinates used to be saved as text, once they are numeric there is
+	// nothing left to convert
+	if err := utils.ConvertProfileCoordinates(initializers.DB); err != nil {
+		log.Fatalf("Failed to convert profile coordinates: %v", err)
+	}
+
 	err := initializers.DB.AutoMigrate(
+		&ApiKey{},
 		&City{},
+		&ContactReveal{},
 		&Ethnos{},
+		&FailedLogin{},
 		&BodyType{},
 		&BodyArt{},
+		&Boost{},
+		&BoostPlan{},
+		&CasbinPolicyRevision{},
+		&CasbinRule{},
 		&ProfileBodyArt{},
 		&HairColor{},
 		&IntimateHairCut{},
+		&Impersonation{},
+		&LedgerAccount{},
+		&LedgerEntry{},
+		&LedgerTransaction{},
+		&ImpersonatedRequest{},
+		&PasswordReset{},
 		&Payment{},
+		&PaymentEvent{},
+		&PaymentMismatch{},
+		&PaymentReconciliation{},
+		&PaymentRefund{},
+		&PhoneVerification{},
 		&Photo{},
 		&Profile{},
 		&ProfileOption{},
@@ -66,7 +91,13 @@ func Init() {
 		&ProfileTag{},
 		&RatedProfileTag{},
 		&RatedUserTag{},
+		&RecoveryCode{},
+		&RefreshToken{},
 		&Service{},
+		&Session{},
+		&Subscription{},
+		&Tier{},
+		&TwoFactorAuth{},
 		&User{},
 		&UserRating{},
 		&UserTag{})
@@ -76,6 +107,23 @@ func Init() {
 		log.Fatalf("Failed to auto-migrate models: %v", err)
 	}
 
+	if err := utils.CreateProfileSearchTriggers(initializers.DB); err != nil {
+		log.Fatalf("Failed to create profile search triggers: %v", err)
+	}
+
+	// profiles saved before search was added have no search vector
+	if err := utils.RebuildProfileSearch(initializers.DB); err != nil {
+		log.Fatalf("Failed to index profiles: %v", err)
+	}
+
+	if err := utils.SeedTiers(initializers.DB); err != nil {
+		log.Fatalf("Failed to seed tiers: %v", err)
+	}
+
+	if err := utils.SeedBoostPlans(initializers.DB); err != nil {
+		log.Fatalf("Failed to seed boost plans: %v", err)
+	}
+
 	CreateOwnerUser(initializers.DB)
 
 	if err := initializers.DB.Exec("CREATE UNIQUE INDEX unique_owner ON users (tier) WHERE tier = 'owner'").Error; err != nil {
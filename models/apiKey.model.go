@@ -0,0 +1,48 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ApiKey is a personal key of a user for bots and scripts. Only the hash of
+// the key is stored, the prefix is kept in plain text to find the key and to
+// tell keys apart in lists. A key never counts as a second factor, whatever
+// the session it was created in.
+type ApiKey struct {
+	ID      uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID  uuid.UUID `gorm:"type:uuid;not null;index"`
+	Name    string    `gorm:"type:varchar(100);not null"`
+	Prefix  string    `gorm:"type:varchar(16);not null;uniqueIndex"`
+	KeyHash string    `gorm:"type:varchar(64);not null"`
+	// Scopes are space separated "object:action" pairs of the casbin policy.
+	Scopes     string     `gorm:"type:varchar(500);not null"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+	LastUsedAt *time.Time `gorm:"type:timestamp;default:null"`
+	ExpiresAt  *time.Time `gorm:"type:timestamp;default:null"`
+	RevokedAt  *time.Time `gorm:"type:timestamp;default:null"`
+}
+
+type CreateApiKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1,max=20"`
+	// ExpiresInDays of zero creates a key that doesn't expire.
+	ExpiresInDays int `json:"expiresInDays" binding:"min=0,max=365"`
+}
+
+type ApiKeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// CreatedApiKeyResponse carries the key itself, it is shown only once.
+type CreatedApiKeyResponse struct {
+	ApiKeyResponse
+	Key string `json:"key"`
+}
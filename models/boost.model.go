@@ -0,0 +1,58 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// BoostPlan is an entry of the catalog of profile promotions: how long a
+// boost lasts and what it costs. Payments for a boost have the plan as type.
+type BoostPlan struct {
+	Name      string    `gorm:"type:varchar(50);primaryKey"`
+	Minutes   int       `gorm:"type:integer;not null"`
+	Price     float64   `gorm:"type:decimal(10,2);not null"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null"`
+	UpdatedAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+func (plan BoostPlan) Duration() time.Duration {
+	return time.Duration(plan.Minutes) * time.Minute
+}
+
+// Boost promotes a profile in the listings of its city from StartsAt until
+// EndsAt. It is granted by a completed payment, boosts bought while another
+// one runs start when it ends.
+type Boost struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ProfileID uuid.UUID `gorm:"type:uuid;not null;index:idx_boost_profile_window"`
+	Profile   Profile   `gorm:"foreignKey:ProfileID;constraint:OnDelete:CASCADE"`
+	PaymentID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	Payment   Payment   `gorm:"foreignKey:PaymentID;constraint:OnDelete:CASCADE"`
+	Plan      string    `gorm:"type:varchar(50);not null"`
+	StartsAt  time.Time `gorm:"type:timestamp;not null;index:idx_boost_profile_window"`
+	EndsAt    time.Time `gorm:"type:timestamp;not null;index:idx_boost_profile_window"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+type CreateBoostRequest struct {
+	ProfileID uuid.UUID `json:"profileId" binding:"required"`
+	Plan      string    `json:"plan" binding:"required,max=50"`
+	// Wallet pays from the balance of the user instead of the payment provider.
+	Wallet bool `json:"wallet"`
+}
+
+type BoostPlanResponse struct {
+	Name    string  `json:"name"`
+	Minutes int     `json:"minutes"`
+	Price   float64 `json:"price"`
+}
+
+type BoostResponse struct {
+	ID        uuid.UUID `json:"id"`
+	ProfileID uuid.UUID `json:"profileId"`
+	PaymentID uuid.UUID `json:"paymentId"`
+	Plan      string    `json:"plan"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	Active    bool      `json:"active"`
+}
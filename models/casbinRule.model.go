@@ -0,0 +1,105 @@
+package models
+
+import "time"
+
+// CasbinRule is one line of the access policy, in the layout casbin adapters
+// commonly use: the policy type and up to six values.
+type CasbinRule struct {
+	ID    uint   `gorm:"primaryKey;autoIncrement"`
+	Ptype string `gorm:"type:varchar(10);not null;uniqueIndex:unique_casbin_rule"`
+	V0    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_casbin_rule"`
+	V1    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_casbin_rule"`
+	V2    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_casbin_rule"`
+	V3    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_casbin_rule"`
+	V4    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_casbin_rule"`
+	V5    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_casbin_rule"`
+}
+
+// CasbinPolicyRevision is a single row counting policy changes. Replicas
+// reload the policy when it differs from the revision they have loaded.
+// Version is the last one-time upgrade of the saved policy which was applied.
+type CasbinPolicyRevision struct {
+	ID        int       `gorm:"primaryKey"`
+	Revision  int64     `gorm:"not null;default:0"`
+	Version   int       `gorm:"not null;default:0"`
+	UpdatedAt time.Time `gorm:"type:timestamp"`
+}
+
+// ResourceOwnerSubject is the subject of the rules which apply to whoever owns the
+// resource of the request, whatever their role.
+const ResourceOwnerSubject = "resource-owner"
+
+// PolicyRule is a rule of the access policy, see casbin/model.conf.
+type PolicyRule struct {
+	Sub        string `json:"sub" binding:"required,oneof=user moderator admin owner resource-owner"`
+	Obj        string `json:"obj" binding:"required,max=100"`
+	Act        string `json:"act" binding:"required,max=100"`
+	Tier       string `json:"tier" binding:"required,max=50"`
+	HasProfile string `json:"hasProfile" binding:"required,oneof=true false *"`
+	TwoFactor  string `json:"twoFactor" binding:"required,oneof=true false *"`
+}
+
+// NewPolicyRule reads a rule from its values in the field order of the policy.
+func NewPolicyRule(values []string) PolicyRule {
+	rule := PolicyRule{}
+
+	fields := []*string{&rule.Sub, &rule.Obj, &rule.Act, &rule.Tier, &rule.HasProfile, &rule.TwoFactor}
+	for i, value := range values {
+		if i < len(fields) {
+			*fields[i] = value
+		}
+	}
+
+	return rule
+}
+
+// Values returns the rule in the field order of the policy.
+func (rule PolicyRule) Values() []string {
+	return []string{rule.Sub, rule.Obj, rule.Act, rule.Tier, rule.HasProfile, rule.TwoFactor}
+}
+
+type UpdatePolicyRuleRequest struct {
+	Old PolicyRule `json:"old" binding:"required"`
+	New PolicyRule `json:"new" binding:"required"`
+}
+
+// PolicyRequest holds the attributes a request is checked with, in the order
+// of the request definition of the policy.
+type PolicyRequest struct {
+	Sub        string `json:"sub"`
+	Obj        string `json:"obj"`
+	Act        string `json:"act"`
+	Tier       string `json:"tier"`
+	HasProfile string `json:"hasProfile"`
+	TwoFactor  string `json:"twoFactor"`
+	IsOwner    string `json:"isOwner"`
+	Scopes     string `json:"scopes"`
+}
+
+// Values returns the attributes in the field order of the request definition.
+func (request PolicyRequest) Values() []interface{} {
+	return []interface{}{request.Sub, request.Obj, request.Act, request.Tier, request.HasProfile, request.TwoFactor, request.IsOwner, request.Scopes}
+}
+
+type ExplainPolicyRequest struct {
+	UserID    string `form:"userId" binding:"required,uuid"`
+	Obj       string `form:"obj" binding:"required,max=100"`
+	Act       string `form:"act" binding:"required,max=100"`
+	TwoFactor bool   `form:"twoFactor"`
+	IsOwner   bool   `form:"isOwner"`
+	Scopes    string `form:"scopes"`
+}
+
+// PolicyRuleMismatch is a rule for the object and action of a denied request,
+// with the attributes it failed on.
+type PolicyRuleMismatch struct {
+	Rule   PolicyRule `json:"rule"`
+	Fields []string   `json:"fields"`
+}
+
+type PolicyExplanation struct {
+	Allowed    bool                 `json:"allowed"`
+	Rule       *PolicyRule          `json:"rule,omitempty"`
+	Request    PolicyRequest        `json:"request"`
+	Mismatches []PolicyRuleMismatch `json:"mismatches,omitempty"`
+}
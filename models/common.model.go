@@ -22,6 +22,22 @@ type SuccessResponse[T any] struct {
 	Data T `json:"data"`
 }
 
+// PageLinks tell how to get the pages around the current one: the cursors to
+// pass as the cursor query parameter, and the links with them in place.
+// @Description Each is left out when there is no such page.
+type PageLinks struct {
+	// NextCursor is the opaque cursor of the next page.
+	// Example: "eyJzIjoiMWt4M2M5cSIsImEiOlsidDIwMjQtMDMtMDFUMTI6MDE6MDBaIiwidTBiOWY2YTY0LTVkNGUtNGMxYi05YTUzLTJmMGU4ZjZjMWQyYSJdfQ"
+	NextCursor string `json:"nextCursor,omitempty"`
+	// PrevCursor is the opaque cursor of the previous page.
+	PrevCursor string `json:"prevCursor,omitempty"`
+	// Next is the link to the next page.
+	// Example: "/api/payments/me?cursor=eyJzIjoiMWt4M2M5cSIsImEiOlsidDIwMjQtMDMtMDFUMTI6MDE6MDBaIiwidTBiOWY2YTY0LTVkNGUtNGMxYi05YTUzLTJmMGU4ZjZjMWQyYSJdfQ&limit=10"
+	Next string `json:"next,omitempty"`
+	// Prev is the link to the previous page.
+	Prev string `json:"prev,omitempty"`
+}
+
 // SuccessPageResponse represents a paginated response.
 // @Description This model is used when paginated data is returned from the API.
 type SuccessPageResponse[T any] struct {
@@ -34,12 +50,66 @@ type SuccessPageResponse[T any] struct {
 	// Results specifies the number of items returned in the current page.
 	// Example: 10
 	Results int `json:"results"`
-	// Page specifies the current page number in the paginated result set.
+	// Page specifies the current page number in the paginated result set, zero for pages found by cursor.
+	// Example: 1
+	Page int `json:"page"`
+	// Limit specifies the maximum number of items that can be returned in a single page.
+	// Example: 10
+	Limit int `json:"limit"`
+	// Total specifies the number of items across all pages, when asked for with total=true.
+	// Example: 42
+	Total *int64 `json:"total,omitempty"`
+	PageLinks
+}
+
+// SuccessCountedPageResponse represents a paginated response with the number of items across all pages.
+// @Description This model is used when paginated data is returned from the API together with its total count.
+type SuccessCountedPageResponse[T any] struct {
+	// Status represents the status of the response, typically set to "success".
+	// Example: "success"
+	Status string `json:"status"`
+	// Data contains the data payload for the current page. Can be any type of data.
+	// Example: [{"id": 1, "name": "Item 1"}, {"id": 2, "name": "Item 2"}]
+	Data T `json:"data"`
+	// Results specifies the number of items returned in the current page.
+	// Example: 10
+	Results int `json:"results"`
+	// Page specifies the current page number in the paginated result set, zero for pages found by cursor.
+	// Example: 1
+	Page int `json:"page"`
+	// Limit specifies the maximum number of items that can be returned in a single page.
+	// Example: 10
+	Limit int `json:"limit"`
+	// Total specifies the number of items across all pages, zero included.
+	// Example: 42
+	Total int64 `json:"total"`
+	PageLinks
+}
+
+// SuccessFacetedPageResponse represents a paginated response of a profile search with its facets.
+// @Description This model is used when a page of profiles found is returned together with the counts per filter value.
+type SuccessFacetedPageResponse[T any] struct {
+	// Status represents the status of the response, typically set to "success".
+	// Example: "success"
+	Status string `json:"status"`
+	// Data contains the data payload for the current page. Can be any type of data.
+	// Example: [{"id": 1, "name": "Item 1"}, {"id": 2, "name": "Item 2"}]
+	Data T `json:"data"`
+	// Results specifies the number of items returned in the current page.
+	// Example: 10
+	Results int `json:"results"`
+	// Page specifies the current page number in the paginated result set, zero for pages found by cursor.
 	// Example: 1
 	Page int `json:"page"`
 	// Limit specifies the maximum number of items that can be returned in a single page.
 	// Example: 10
 	Limit int `json:"limit"`
+	// Total specifies the number of items across all pages, when asked for with total=true.
+	// Example: 42
+	Total *int64 `json:"total,omitempty"`
+	PageLinks
+	// Facets are the counts of the items found per filter value, when asked for.
+	Facets *ProfileFacets `json:"facets,omitempty"`
 }
 
 // TokenResponse represents a token response, usually after successful authentication.
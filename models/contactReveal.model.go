@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ContactReveal is a profile whose contacts a user has revealed on a day.
+// Revealing them again on the same day doesn't count against the limit of
+// the tier.
+type ContactReveal struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_contact_reveal_day"`
+	ProfileID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_contact_reveal_day"`
+	Profile   Profile   `gorm:"foreignKey:ProfileID;constraint:OnDelete:CASCADE"`
+	Day       time.Time `gorm:"type:date;not null;uniqueIndex:idx_contact_reveal_day"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+type ContactsResponse struct {
+	ProfileID uuid.UUID         `json:"profileId"`
+	Contacts  []ContactResponse `json:"contacts"`
+	// RevealsLeft today, or Unlimited.
+	RevealsLeft int `json:"revealsLeft"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// FailedLogin is an audit record of a failed password login.
+type FailedLogin struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Phone     string     `gorm:"type:varchar(30);not null;index"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"`
+	IP        string     `gorm:"type:varchar(45);not null;index"`
+	UserAgent string     `gorm:"type:varchar(255)"`
+	Reason    string     `gorm:"type:varchar(30);not null"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null;index"`
+}
+
+type FailedLoginResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	Phone     string     `json:"phone"`
+	UserID    *uuid.UUID `json:"userId,omitempty"`
+	IP        string     `json:"ip"`
+	UserAgent string     `json:"userAgent"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type LoginLockoutResponse struct {
+	// Kind is either "account" or "ip".
+	Kind        string    `json:"kind"`
+	Value       string    `json:"value"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+	LockedUntil time.Time `json:"lockedUntil"`
+	Locked      bool      `json:"locked"`
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Impersonation is a staff member acting as a user, e.g. to see what the
+// user sees while debugging. Every request made with it is recorded as an
+// ImpersonatedRequest.
+type Impersonation struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ActorID   uuid.UUID  `gorm:"type:uuid;not null;index"`
+	SubjectID uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Reason    string     `gorm:"type:varchar(255);not null"`
+	IP        string     `gorm:"type:varchar(45)"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+	ExpiresAt time.Time  `gorm:"type:timestamp;not null"`
+	EndedAt   *time.Time `gorm:"type:timestamp;default:null"`
+
+	Requests []ImpersonatedRequest `gorm:"foreignKey:ImpersonationID;constraint:OnDelete:CASCADE;"`
+}
+
+type ImpersonatedRequest struct {
+	ID              uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ImpersonationID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Method          string    `gorm:"type:varchar(10);not null"`
+	Path            string    `gorm:"type:varchar(500);not null"`
+	Status          int       `gorm:"not null"`
+	CreatedAt       time.Time `gorm:"type:timestamp;not null"`
+}
+
+type ImpersonateRequest struct {
+	UserID uuid.UUID `json:"userId" binding:"required"`
+	Reason string    `json:"reason" binding:"required,max=255"`
+}
+
+type ImpersonationTokenResponse struct {
+	Status      string    `json:"status"`
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type ImpersonatedRequestResponse struct {
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ImpersonationResponse struct {
+	ID        uuid.UUID                     `json:"id"`
+	ActorID   uuid.UUID                     `json:"actorId"`
+	SubjectID uuid.UUID                     `json:"subjectId"`
+	Reason    string                        `json:"reason"`
+	IP        string                        `json:"ip"`
+	CreatedAt time.Time                     `json:"createdAt"`
+	ExpiresAt time.Time                     `json:"expiresAt"`
+	EndedAt   *time.Time                    `json:"endedAt,omitempty"`
+	Requests  []ImpersonatedRequestResponse `json:"requests,omitempty"`
+}
+
+// ImpersonatorResponse tells the impersonated view who is actually looking.
+type ImpersonatorResponse struct {
+	ImpersonationID uuid.UUID `json:"impersonationId"`
+	ActorID         uuid.UUID `json:"actorId"`
+	ActorName       string    `json:"actorName"`
+	ActorRole       string    `json:"actorRole"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+}
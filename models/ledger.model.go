@@ -0,0 +1,85 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// System ledger accounts, the other side of the entries on user balances.
+const (
+	LedgerProvider    = "system:provider"    // money paid in and out through the payment provider
+	LedgerRevenue     = "system:revenue"     // purchases paid from balances
+	LedgerAdjustments = "system:adjustments" // corrections by admins
+)
+
+// Kinds of ledger transactions.
+const (
+	LedgerTopUp      = "top_up"
+	LedgerPurchase   = "purchase"
+	LedgerRefund     = "refund"
+	LedgerAdjustment = "adjustment"
+)
+
+// LedgerAccount holds a balance: the wallet of a user or a system account.
+// The balance is the sum of the entries of the account, it is kept on the row
+// so it can be locked and checked, and can always be rebuilt from the entries.
+type LedgerAccount struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Name      string     `gorm:"type:varchar(100);not null;uniqueIndex"` // "user:<id>" or one of the system accounts
+	UserID    *uuid.UUID `gorm:"type:uuid;default:null;uniqueIndex"`
+	Balance   float64    `gorm:"type:decimal(12,2);not null;default:0"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+	UpdatedAt time.Time  `gorm:"type:timestamp;not null"`
+}
+
+// LedgerTransaction is one movement of money. Its entries add up to zero.
+type LedgerTransaction struct {
+	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Kind        string     `gorm:"type:varchar(20);not null"`
+	PaymentID   *uuid.UUID `gorm:"type:uuid;default:null;index"`
+	Description string     `gorm:"type:varchar(255);not null;default:''"`
+	CreatedBy   *uuid.UUID `gorm:"type:uuid;default:null"`
+	CreatedAt   time.Time  `gorm:"type:timestamp;not null"`
+}
+
+// LedgerEntry adds the amount to the balance of the account, or takes it
+// away when it is negative.
+type LedgerEntry struct {
+	ID            uuid.UUID         `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	TransactionID uuid.UUID         `gorm:"type:uuid;not null;index"`
+	Transaction   LedgerTransaction `gorm:"foreignKey:TransactionID;constraint:OnDelete:CASCADE"`
+	AccountID     uuid.UUID         `gorm:"type:uuid;not null;index"`
+	Account       LedgerAccount     `gorm:"foreignKey:AccountID;constraint:OnDelete:RESTRICT"`
+	Amount        float64           `gorm:"type:decimal(12,2);not null"`
+	CreatedAt     time.Time         `gorm:"type:timestamp;not null"`
+}
+
+type TopUpRequest struct {
+	Amount float64 `json:"amount" binding:"required,gt=0,max=100000"`
+}
+
+type AdjustBalanceRequest struct {
+	UserID uuid.UUID `json:"userId" binding:"required"`
+	// Amount is added to the balance, or taken from it when negative.
+	Amount float64 `json:"amount" binding:"required,ne=0,min=-100000,max=100000"`
+	Reason string  `json:"reason" binding:"required,max=255"`
+}
+
+type WalletResponse struct {
+	Balance float64 `json:"balance"`
+}
+
+type LedgerEntryResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	TransactionID uuid.UUID  `json:"transactionId"`
+	Kind          string     `json:"kind"`
+	Amount        float64    `json:"amount"`
+	PaymentID     *uuid.UUID `json:"paymentId,omitempty"`
+	Description   string     `json:"description"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+type RebuildBalancesResponse struct {
+	// Corrected is the number of accounts whose balance didn't match their entries.
+	Corrected int `json:"corrected"`
+}
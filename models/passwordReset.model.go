@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// PasswordReset is a single-use token that lets a user set a new password
+// without knowing the old one. Only the hash of the token is stored.
+type PasswordReset struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index"`
+	TokenHash string     `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Channel   string     `gorm:"type:varchar(20);not null"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+	ExpiresAt time.Time  `gorm:"type:timestamp;not null"`
+	UsedAt    *time.Time `gorm:"type:timestamp;default:null"`
+}
+
+type RequestPasswordResetRequest struct {
+	Phone   string `json:"phone" binding:"required,min=11,max=11,numeric"`
+	Channel string `json:"channel" binding:"required,oneof=sms telegram"`
+}
+
+type ResetPasswordRequest struct {
+	Token           string `json:"token" binding:"required"`
+	Password        string `json:"password" binding:"required,min=8"`
+	PasswordConfirm string `json:"passwordConfirm" binding:"required"`
+}
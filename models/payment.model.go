@@ -12,6 +12,163 @@ type Payment struct {
 	Status      string    `gorm:"type:varchar(50);not null"`
 	CreatedAt   time.Time `gorm:"type:timestamp;not null"`
 	UpdatedAt   time.Time `gorm:"type:timestamp"`
-	PaymentDate time.Time `gorm:"type:timestamp"`            // date at which payment is completed
-	Type        string    `gorm:"type:varchar(50);not null"` // type: subscription, one_hour, three_hours, twelve_hours, two_days, one_week
+	PaymentDate time.Time `gorm:"type:timestamp"`                       // date at which payment is completed
+	Type        string    `gorm:"type:varchar(50);not null"`            // type: subscription, top_up, one_hour, three_hours, twelve_hours, two_days, one_week
+	Tier        string    `gorm:"type:varchar(50);not null;default:''"` // tier paid for by a subscription
+	// ProfileID is the profile promoted by a boost, whose plan is the type.
+	ProfileID *uuid.UUID `gorm:"type:uuid;default:null"`
+	// RefundedAmount is the part of the amount paid back so far.
+	RefundedAmount float64 `gorm:"type:decimal(10,2);not null;default:0"`
+	// Provider takes the payment and knows it by ProviderPaymentID.
+	Provider          string  `gorm:"type:varchar(50);not null;default:'';uniqueIndex:idx_payment_provider_id"`
+	ProviderPaymentID *string `gorm:"type:varchar(100);uniqueIndex:idx_payment_provider_id"`
+}
+
+// Statuses of a payment. A payment starts pending and only moves along
+// PaymentTransitions. A disputed payment is contested with the provider by
+// the payer, it is completed again when the dispute is won and refunded when
+// it is lost.
+const (
+	PaymentPending           = "pending"
+	PaymentCompleted         = "completed"
+	PaymentFailed            = "failed"
+	PaymentCanceled          = "canceled"
+	PaymentPartiallyRefunded = "partially_refunded"
+	PaymentRefunded          = "refunded"
+	PaymentDisputed          = "disputed"
+)
+
+var PaymentTransitions = map[string][]string{
+	PaymentPending:           {PaymentCompleted, PaymentFailed, PaymentCanceled},
+	PaymentCompleted:         {PaymentPartiallyRefunded, PaymentRefunded, PaymentDisputed},
+	PaymentPartiallyRefunded: {PaymentPartiallyRefunded, PaymentRefunded, PaymentDisputed},
+	PaymentDisputed:          {PaymentCompleted, PaymentRefunded},
+}
+
+// PaymentRefund is a refund of a payment, or of a part of it, made by an
+// admin or by the provider.
+type PaymentRefund struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	PaymentID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Payment   Payment   `gorm:"foreignKey:PaymentID;constraint:OnDelete:CASCADE"`
+	Amount    float64   `gorm:"type:decimal(10,2);not null"`
+	Reason    string    `gorm:"type:varchar(255);not null;default:''"`
+	// Status tells whether the refund has been applied to the payment, see
+	// the refund statuses.
+	Status string `gorm:"type:varchar(20);not null;default:'completed'"`
+	// ProviderRefundID is the refund with the provider, none for payments
+	// from the wallet. The webhook event of a refund made here is known by it.
+	ProviderRefundID *string    `gorm:"type:varchar(100);uniqueIndex;default:null"`
+	CreatedBy        *uuid.UUID `gorm:"type:uuid;default:null"` // the admin, none for refunds made with the provider
+	CreatedAt        time.Time  `gorm:"type:timestamp;not null"`
+}
+
+// Statuses of a refund. A refund an admin makes with the provider is pending
+// while the provider is asked, and is applied to the payment once it has paid
+// the refund back. The others are applied right away.
+const (
+	RefundPending   = "pending"
+	RefundCompleted = "completed"
+	RefundFailed    = "failed"
+)
+
+// PaymentReconciliation is a run of the reconciliation of the payments of a
+// day with the settlement file of the provider.
+type PaymentReconciliation struct {
+	Day        time.Time `gorm:"type:date;primaryKey"`
+	Provider   string    `gorm:"type:varchar(50);primaryKey"`
+	Lines      int       `gorm:"not null"`
+	Mismatches int       `gorm:"not null"`
+	CreatedAt  time.Time `gorm:"type:timestamp;not null"`
+	UpdatedAt  time.Time `gorm:"type:timestamp;not null"`
+}
+
+// Kinds of mismatches between the payments and the settlement of the provider.
+const (
+	MismatchMissingLocally      = "missing_locally"       // settled, but not a payment here
+	MismatchMissingInSettlement = "missing_in_settlement" // completed here, but not settled
+	MismatchStatus              = "status"
+	MismatchAmount              = "amount"
+)
+
+// PaymentMismatch is a difference found by reconciling the payments of a day
+// with the settlement file of the provider.
+type PaymentMismatch struct {
+	ID                uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Day               time.Time  `gorm:"type:date;not null;uniqueIndex:idx_payment_mismatch"`
+	Provider          string     `gorm:"type:varchar(50);not null;uniqueIndex:idx_payment_mismatch"`
+	ProviderPaymentID string     `gorm:"type:varchar(100);not null;uniqueIndex:idx_payment_mismatch"`
+	Kind              string     `gorm:"type:varchar(30);not null;uniqueIndex:idx_payment_mismatch"`
+	PaymentID         *uuid.UUID `gorm:"type:uuid;default:null"`
+	LocalStatus       string     `gorm:"type:varchar(50);not null;default:''"`
+	ProviderStatus    string     `gorm:"type:varchar(50);not null;default:''"`
+	LocalAmount       float64    `gorm:"type:decimal(10,2);not null;default:0"`
+	ProviderAmount    float64    `gorm:"type:decimal(10,2);not null;default:0"`
+	CreatedAt         time.Time  `gorm:"type:timestamp;not null"`
+}
+
+type RefundPaymentRequest struct {
+	// Amount to pay back, the rest of the payment when left out.
+	Amount *float64 `json:"amount" binding:"omitempty,gt=0"`
+	Reason string   `json:"reason" binding:"required,max=255"`
+}
+
+type ReconciliationResponse struct {
+	Day        string `json:"day"`
+	Lines      int    `json:"lines"`
+	Mismatches int    `json:"mismatches"`
+}
+
+type PaymentMismatchResponse struct {
+	ID                uuid.UUID  `json:"id"`
+	Day               string     `json:"day"`
+	Provider          string     `json:"provider"`
+	ProviderPaymentID string     `json:"providerPaymentId"`
+	Kind              string     `json:"kind"`
+	PaymentID         *uuid.UUID `json:"paymentId,omitempty"`
+	LocalStatus       string     `json:"localStatus"`
+	ProviderStatus    string     `json:"providerStatus"`
+	LocalAmount       float64    `json:"localAmount"`
+	ProviderAmount    float64    `json:"providerAmount"`
+	CreatedAt         time.Time  `json:"createdAt"`
+}
+
+// PaymentEvent is a webhook event of a payment provider which has been
+// processed, so a redelivered event is not processed twice.
+type PaymentEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Provider  string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_payment_event_provider_id"`
+	EventID   string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_payment_event_provider_id"`
+	PaymentID uuid.UUID `gorm:"type:uuid;not null"`
+	Payment   Payment   `gorm:"foreignKey:PaymentID;constraint:OnDelete:CASCADE"`
+	Status    string    `gorm:"type:varchar(50);not null"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null"`
+}
+
+type CreateCheckoutRequest struct {
+	Tier string `json:"tier" binding:"required,max=50"`
+	// Wallet pays from the balance of the user instead of the payment provider.
+	Wallet bool `json:"wallet"`
+}
+
+type CheckoutResponse struct {
+	PaymentID uuid.UUID `json:"paymentId"`
+	// URL is the page of the payment provider to pay on, payments from the
+	// balance have none, they are completed already.
+	URL string `json:"url,omitempty"`
+}
+
+type PaymentResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"userId"`
+	Amount    float64    `json:"amount"`
+	Status    string     `json:"status"`
+	Type      string     `json:"type"`
+	Tier      string     `json:"tier,omitempty"`
+	ProfileID *uuid.UUID `json:"profileId,omitempty"`
+	// RefundedAmount is the part of the amount paid back so far.
+	RefundedAmount float64   `json:"refundedAmount"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	PaymentDate    time.Time `json:"paymentDate"`
 }
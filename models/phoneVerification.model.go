@@ -0,0 +1,29 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// PhoneVerification is a one-time code sent to the user's phone. Only the hash
+// of the code is stored.
+type PhoneVerification struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Phone      string     `gorm:"type:varchar(30);not null"`
+	CodeHash   string     `gorm:"type:varchar(255);not null"`
+	Attempts   int        `gorm:"type:int;not null;default:0"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+	ExpiresAt  time.Time  `gorm:"type:timestamp;not null"`
+	VerifiedAt *time.Time `gorm:"type:timestamp;default:null"`
+}
+
+type ConfirmPhoneRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type PhoneVerificationResponse struct {
+	Phone       string    `json:"phone"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	ResendAfter time.Time `json:"resendAfter"`
+}
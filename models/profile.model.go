@@ -30,8 +30,8 @@ type Profile struct {
 	Bust              float64          `gorm:"type:float"`
 	Bio               string           `gorm:"type:varchar(2000)"`
 	Sex               string           `gorm:"type:varchar(10)"`
-	AddressLatitude   string           `gorm:"type:varchar(10)"`
-	AddressLongitude  string           `gorm:"type:varchar(10)"`
+	AddressLatitude   *float64         `gorm:"type:double precision;default:null;index:idx_profile_location"`
+	AddressLongitude  *float64         `gorm:"type:double precision;default:null;index:idx_profile_location"`
 
 	PriceInHouseNightRatio float64 `gorm:"type:float;not null;default:1"`
 	PriceInHouseContact    *int    `gorm:"type:int;default:null"`
@@ -66,6 +66,10 @@ type Profile struct {
 	UpdatedBy uuid.UUID      `gorm:"type:uuid;not null"`
 	DeletedAt gorm.DeletedAt `gorm:"index" swaggerignore:"true"`
 
+	// SearchVector holds the words of the name, bio and option comments, it
+	// is kept up to date by the triggers of utils.CreateProfileSearchTriggers.
+	SearchVector string `gorm:"type:tsvector;index:idx_profile_search,type:gin;->:false;<-:false" swaggerignore:"true"`
+
 	BodyArts       []ProfileBodyArt `gorm:"foreignKey:ProfileID;constraint:OnDelete:CASCADE;"`
 	Photos         []Photo          `gorm:"foreignKey:ProfileID;constraint:OnDelete:CASCADE;"`
 	ProfileOptions []ProfileOption  `gorm:"foreignKey:ProfileID;constraint:OnDelete:CASCADE;"`
@@ -92,8 +96,8 @@ type CreateProfileRequest struct {
 
 	Bio string `json:"bio"  binding:"omitempty" validate:"min=100,max=2000"`
 
-	AddressLatitude  string `json:"latitude,omitempty" validate:"latitude"`
-	AddressLongitude string `json:"longitude,omitempty" validate:"longitude"`
+	AddressLatitude  *float64 `json:"latitude,omitempty" binding:"omitempty,latitude"`
+	AddressLongitude *float64 `json:"longitude,omitempty" binding:"omitempty,longitude"`
 
 	//PriceInHouseNightRatio float64 `json:"priceInHouseNightRatio,omitempty"`
 	PriceInHouseContact *int `json:"priceInHouseContact,omitempty" validate:"gte=0"`
@@ -134,8 +138,8 @@ type UpdateOwnProfileRequest struct {
 
 	Bio string `json:"bio"  binding:"omitempty" validate:"min=100,max=2000"`
 
-	AddressLatitude  string `json:"latitude,omitempty" validate:"latitude"`
-	AddressLongitude string `json:"longitude,omitempty" validate:"longitude"`
+	AddressLatitude  *float64 `json:"latitude,omitempty" binding:"omitempty,latitude"`
+	AddressLongitude *float64 `json:"longitude,omitempty" binding:"omitempty,longitude"`
 
 	PriceInHouseNightRatio *float64 `json:"priceInHouseNightRatio,omitempty" validate:"gte=0"`
 	PriceInHouseContact    *int     `json:"priceInHouseContact,omitempty" validate:"gte=0"`
@@ -169,9 +173,10 @@ type UpdateProfileRequest struct {
 	Photos []CreatePhotoRequest `json:"photos" binding:"omitempty,dive"`
 }
 
+// ListProfilesMaxLimit is the most profiles a page of the city listings holds.
+const ListProfilesMaxLimit = 12
+
 type ListProfilesQuery struct {
-	Page   int    `form:"page" validate:"gte=0"`
-	Limit  int    `form:"limit" validate:"gte=0;lte=12"`
 	CityID int    `form:"city" validate:"gte=0;lte=100"`
 	Sex    string `form:"sex" validate:"oneof=female male"`
 }
@@ -189,8 +194,6 @@ type FindProfilesQuery struct {
 	Height                 *int     `json:"height,omitempty" validate:"gte=0,lte=300"`
 	Weight                 *int     `json:"weight,omitempty" validate:"gte=0,lte=150"`
 	Bust                   *float64 `json:"bust,omitempty" validate:"gte=0,lte=10"`
-	AddressLatitude        string   `json:"latitude,omitempty" validate:"latitude"`
-	AddressLongitude       string   `json:"longitude,omitempty" validate:"longitude"`
 	Moderated              *bool    `json:"moderated,omitempty" validate:"boolean"`
 	Verified               *bool    `json:"verified,omitempty" validate:"boolean"`
 	BodyArtIds             []*int   `json:"bodyArtIds,omitempty" validate:"dive gte=0"`
@@ -211,6 +214,50 @@ type FindProfilesQuery struct {
 	PriceCarContactMax     *int     `json:"priceCarContactMax,omitempty" validate:"gte=0"`
 	PriceCarHourMin        *int     `json:"priceCarHourMin,omitempty" validate:"gte=0"`
 	PriceCarHourMax        *int     `json:"priceCarHourMax,omitempty" validate:"gte=0"`
+
+	// Search matches the words of the name, bio and option comments, in
+	// Russian or English, and lists the most relevant profiles first.
+	Search string `json:"search,omitempty" binding:"omitempty,max=200"`
+
+	// Latitude and Longitude give the distance to every profile found, which
+	// RadiusKm keeps within and SortBy "distance" lists the nearest first by.
+	Latitude  *float64 `json:"latitude,omitempty" binding:"omitempty,latitude"`
+	Longitude *float64 `json:"longitude,omitempty" binding:"omitempty,longitude"`
+	RadiusKm  *float64 `json:"radiusKm,omitempty" binding:"omitempty,gt=0,lte=500"`
+	SortBy    string   `json:"sortBy,omitempty" binding:"omitempty,oneof=distance"`
+
+	// Facets adds the counts of the profiles found per value of the filters
+	// and per price, in buckets PriceBucket wide, to the results.
+	Facets      bool `json:"facets,omitempty"`
+	PriceBucket *int `json:"priceBucket,omitempty" binding:"omitempty,gte=100"`
+}
+
+// FacetCount is the number of profiles found with a value of a filter.
+type FacetCount struct {
+	Value int   `json:"value"`
+	Count int64 `json:"count"`
+}
+
+// PriceBucket is the number of profiles found with a price from From up to To, excluded.
+type PriceBucket struct {
+	From  int   `json:"from"`
+	To    int   `json:"to"`
+	Count int64 `json:"count"`
+}
+
+// ProfileFacets are the counts of a profile search. Those of a filter respect
+// all the other filters but not itself, so they tell what picking another
+// value would find.
+type ProfileFacets struct {
+	Cities      []FacetCount `json:"cities"`
+	Ethnoses    []FacetCount `json:"ethnoses"`
+	BodyTypes   []FacetCount `json:"bodyTypes"`
+	HairColors  []FacetCount `json:"hairColors"`
+	BodyArts    []FacetCount `json:"bodyArts"`
+	ProfileTags []FacetCount `json:"profileTags"`
+	// Prices are keyed by the price, as in the filters: inHouseContact,
+	// inHouseHour, saunaContact and so on.
+	Prices map[string][]PriceBucket `json:"prices"`
 }
 
 type ProfileResponse struct {
@@ -224,8 +271,8 @@ type ProfileResponse struct {
 	Weight                 int                      `json:"weight"`
 	Bust                   float64                  `json:"bust"`
 	Bio                    string                   `json:"bio"`
-	AddressLatitude        string                   `json:"addressLatitude"`
-	AddressLongitude       string                   `json:"addressLongitude"`
+	AddressLatitude        *float64                 `json:"addressLatitude"`
+	AddressLongitude       *float64                 `json:"addressLongitude"`
 	CityID                 int                      `json:"cityId"`
 	City                   *CityResponse            `json:"city"`
 	BodyTypeID             *int                     `json:"bodyTypeId"`
@@ -265,6 +312,12 @@ type ProfileResponse struct {
 	ProfileOptions         []ProfileOptionResponse  `json:"profileOptions"`
 	Services               []ServiceResponse        `json:"services"`
 	UpdatedBy              *uuid.UUID               `json:"updatedBy"`
+	Promoted               bool                     `json:"promoted"`
+	// Distance from the point searched from, in km rounded up
+	Distance *float64 `json:"distance,omitempty"`
+	// Highlight is a snippet of the text with the words searched for in
+	// <mark> tags, the rest of it HTML escaped
+	Highlight string `json:"highlight,omitempty"`
 }
 
 type ContactResponse struct {
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Session is a single login of a user. Every refresh token issued for the
+// login belongs to the same session, so revoking the session invalidates
+// the whole refresh token family at once.
+type Session struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	Device     string     `gorm:"type:varchar(100)"`
+	IP         string     `gorm:"type:varchar(45)"`
+	UserAgent  string     `gorm:"type:varchar(255)"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+	UpdatedAt  time.Time  `gorm:"type:timestamp;not null"`
+	LastSeenAt time.Time  `gorm:"type:timestamp"`
+	ExpiresAt  time.Time  `gorm:"type:timestamp;not null"`
+	RevokedAt  *time.Time `gorm:"type:timestamp;default:null"`
+	// TwoFactor is set when the login passed the second factor.
+	TwoFactor     bool           `gorm:"type:boolean;not null;default:false"`
+	RefreshTokens []RefreshToken `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE;"`
+}
+
+// RefreshToken is one link of a session's rotation chain. Its ID is put into
+// the jti claim of the refresh JWT. A token is retired as soon as it is used,
+// so only the newest token of a session is ever accepted.
+type RefreshToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	SessionID uuid.UUID  `gorm:"type:uuid;not null;index"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null"`
+	ExpiresAt time.Time  `gorm:"type:timestamp;not null"`
+	RetiredAt *time.Time `gorm:"type:timestamp;default:null"`
+}
+
+type SessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent"`
+	Current    bool      `json:"current"`
+	TwoFactor  bool      `json:"twoFactor"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Subscription is the tier a user has paid for, until ExpiresAt. Renewals
+// extend it, and once it expires the user is back on the basic tier.
+type Subscription struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	User      User      `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	Tier      string    `gorm:"type:varchar(50);not null"`
+	PaymentID uuid.UUID `gorm:"type:uuid;not null"` // last payment
+	StartedAt time.Time `gorm:"type:timestamp;not null"`
+	ExpiresAt time.Time `gorm:"type:timestamp;not null;index"`
+	// RemindedAt is when the user was told the subscription is about to expire.
+	RemindedAt *time.Time `gorm:"type:timestamp;default:null"`
+	ExpiredAt  *time.Time `gorm:"type:timestamp;default:null"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null"`
+	UpdatedAt  time.Time  `gorm:"type:timestamp;not null"`
+}
+
+type SubscriptionResponse struct {
+	Tier      string    `json:"tier"`
+	Active    bool      `json:"active"`
+	StartedAt time.Time `json:"startedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
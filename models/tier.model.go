@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Unlimited is the limit of entitlements without one.
+const Unlimited = -1
+
+// Review fields a tier can see on services: the score or the text of either
+// rating, or "*" for all of the rating with its tags.
+const (
+	ProfileRatingScore     = "profileRating.score"
+	ProfileRatingReview    = "profileRating.review"
+	ProfileRatingAll       = "profileRating.*"
+	ClientUserRatingScore  = "clientUserRating.score"
+	ClientUserRatingReview = "clientUserRating.review"
+	ClientUserRatingAll    = "clientUserRating.*"
+)
+
+var ReviewFields = []string{
+	ProfileRatingScore, ProfileRatingReview, ProfileRatingAll,
+	ClientUserRatingScore, ClientUserRatingReview, ClientUserRatingAll,
+}
+
+// Tier is an entry of the tier catalog: what it costs and what it entitles
+// its users to. Adding a tier is adding a row.
+type Tier struct {
+	Name  string  `gorm:"type:varchar(50);primaryKey"`
+	Price float64 `gorm:"type:decimal(10,2);not null;default:0"`
+	// ReviewFields are the space separated review fields visible on services.
+	ReviewFields string `gorm:"type:varchar(500);not null;default:''"`
+	// Search of profiles by filters.
+	Search bool `gorm:"type:boolean;not null;default:false"`
+	// ContactRevealsPerDay and ProfilesAllowed are limits, or Unlimited.
+	ContactRevealsPerDay int       `gorm:"type:integer;not null;default:0"`
+	ProfilesAllowed      int       `gorm:"type:integer;not null;default:0"`
+	CreatedAt            time.Time `gorm:"type:timestamp;not null"`
+	UpdatedAt            time.Time `gorm:"type:timestamp;not null"`
+}
+
+type UpsertTierRequest struct {
+	Price                *float64 `json:"price" binding:"required,min=0"`
+	ReviewFields         []string `json:"reviewFields" binding:"max=6"`
+	Search               bool     `json:"search"`
+	ContactRevealsPerDay int      `json:"contactRevealsPerDay" binding:"min=-1"`
+	ProfilesAllowed      int      `json:"profilesAllowed" binding:"min=-1"`
+}
+
+type TierResponse struct {
+	Name                 string    `json:"name"`
+	Price                float64   `json:"price"`
+	ReviewFields         []string  `json:"reviewFields"`
+	Search               bool      `json:"search"`
+	ContactRevealsPerDay int       `json:"contactRevealsPerDay"`
+	ProfilesAllowed      int       `json:"profilesAllowed"`
+	UpdatedAt            time.Time `json:"updatedAt"`
+}
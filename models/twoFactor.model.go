@@ -0,0 +1,54 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// TwoFactorAuth is the TOTP enrollment of a user. The enrollment takes effect
+// once the user confirms it with a valid code.
+type TwoFactorAuth struct {
+	UserID         uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Secret         string     `gorm:"type:varchar(64);not null"`
+	LastUsedStep   int64      `gorm:"type:bigint;not null;default:0"`
+	FailedAttempts int        `gorm:"type:int;not null;default:0"`
+	LockedUntil    *time.Time `gorm:"type:timestamp;default:null"`
+	CreatedAt      time.Time  `gorm:"type:timestamp;not null"`
+	ConfirmedAt    *time.Time `gorm:"type:timestamp;default:null"`
+}
+
+// RecoveryCode can be used once instead of a TOTP code when the user has lost
+// their authenticator. Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID       uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID   uuid.UUID  `gorm:"type:uuid;not null;index"`
+	CodeHash string     `gorm:"type:varchar(255);not null"`
+	UsedAt   *time.Time `gorm:"type:timestamp;default:null"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
+
+type TwoFactorLoginRequest struct {
+	PreAuthToken string `json:"pre_auth_token" binding:"required"`
+	// Code is either a TOTP code or one of the recovery codes.
+	Code string `json:"code" binding:"required,max=20"`
+}
+
+type TwoFactorEnrollmentResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TwoFactorChallengeResponse is returned by the login endpoints instead of
+// TokenResponse when the user has two-factor authentication enabled.
+type TwoFactorChallengeResponse struct {
+	Status            string `json:"status"`
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	PreAuthToken      string `json:"pre_auth_token"`
+}
@@ -25,7 +25,7 @@ type User struct {
 }
 
 type SignUpRequest struct {
-	Phone           string `json:"phone" binding:"required,min=11,max=11"`
+	Phone           string `json:"phone" binding:"required,min=11,max=11,numeric"`
 	Password        string `json:"password" binding:"required,min=8"`
 	PasswordConfirm string `json:"passwordConfirm" binding:"required,min=8"`
 }
@@ -35,14 +35,33 @@ type SignInRequest struct {
 	Password string `json:"password" binding:"required,min=8"`
 }
 
+// TelegramLoginData is the payload of the Telegram Login Widget, signed with the bot token.
+type TelegramLoginData struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+	PhotoURL  string `json:"photo_url,omitempty"`
+	AuthDate  int64  `json:"auth_date"`
+	Hash      string `json:"hash"`
+}
+
+// BotSignUpRequest must carry either the Login Widget data or the WebApp initData
+// of the same Telegram user as TelegramUserId.
 type BotSignUpRequest struct {
-	Name           string `json:"name" binding:"required,min=5"`
-	Phone          string `json:"phone" binding:"required,min=11,max=11"`
-	TelegramUserId string `json:"telegramUserId" binding:"required"`
+	Name           string             `json:"name" binding:"required,min=5"`
+	Phone          string             `json:"phone" binding:"required,min=11,max=11"`
+	TelegramUserId string             `json:"telegramUserId" binding:"required"`
+	Telegram       *TelegramLoginData `json:"telegram,omitempty"`
+	InitData       string             `json:"initData,omitempty"`
 }
 
+// BotSignInRequest must carry either the Login Widget data or the WebApp initData
+// of the same Telegram user as TelegramUserId.
 type BotSignInRequest struct {
-	TelegramUserId string `json:"telegramUserId"  binding:"required"`
+	TelegramUserId string             `json:"telegramUserId"  binding:"required"`
+	Telegram       *TelegramLoginData `json:"telegram,omitempty"`
+	InitData       string             `json:"initData,omitempty"`
 }
 
 type FindUserQuery struct {
@@ -64,6 +83,10 @@ type UserResponse struct {
 	Role           string    `json:"role"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+	// ImpersonatedBy is set by GetMe when staff is looking through the user's eyes.
+	ImpersonatedBy *ImpersonatorResponse `json:"impersonatedBy,omitempty"`
+	// Subscription is set by GetMe for users who have paid for a tier.
+	Subscription *SubscriptionResponse `json:"subscription,omitempty"`
 }
 
 type UpdateUserPrivilegedRequest struct {
@@ -72,7 +95,7 @@ type UpdateUserPrivilegedRequest struct {
 	TelegramUserId string `json:"telegramUserId,omitempty" validate:"omitempty,min=6"`
 	Avatar         string `json:"photo,omitempty"  validate:"omitempty,imageurl"`
 	Verified       bool   `json:"verified,omitempty" validate:"omitempty,boolean"`
-	Tier           string `json:"tier,omitempty" validate:"omitempty,oneof=basic expert guru"`
+	Tier           string `json:"tier,omitempty" validate:"omitempty,max=50"`
 	Active         bool   `json:"active,omitempty" validate:"omitempty,boolean"`
 }
 
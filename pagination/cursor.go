@@ -0,0 +1,163 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errCursorList = errors.New("the cursor is of another list")
+
+// cursor points to a page: the one after the item with the After keys, the
+// one before the item with the Before keys, or the one at Offset for lists
+// paged by offset. Sort tells the order of the list it was made for, At the
+// Unix time of its snapshot, if it has one.
+type cursor struct {
+	Sort   string   `json:"s"`
+	Offset int      `json:"o,omitempty"`
+	After  []string `json:"a,omitempty"`
+	Before []string `json:"b,omitempty"`
+	At     int64    `json:"t,omitempty"`
+}
+
+// sortHash tells the order of the list without telling its columns.
+func sortHash(order Order) string {
+	h := fnv.New32a()
+	h.Write([]byte(order.String()))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+// encodeKey writes the value of a key with its type, so it is read back as
+// the same type. Lists can only be sorted by keys of the types below.
+func encodeKey(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return "t" + v.Format(time.RFC3339Nano), nil
+	case uuid.UUID:
+		return "u" + v.String(), nil
+	case string:
+		return "s" + v, nil
+	case int:
+		return "i" + strconv.Itoa(v), nil
+	case int64:
+		return "i" + strconv.FormatInt(v, 10), nil
+	case uint:
+		return "i" + strconv.FormatUint(uint64(v), 10), nil
+	case float64:
+		return "f" + strconv.FormatFloat(v, 'g', -1, 64), nil
+	default:
+		return "", fmt.Errorf("pagination: can't sort by a key of type %T", value)
+	}
+}
+
+func decodeKey(key string) (interface{}, error) {
+	if key == "" {
+		return nil, errors.New("empty key")
+	}
+
+	switch value := key[1:]; key[0] {
+	case 't':
+		return time.Parse(time.RFC3339Nano, value)
+	case 'u':
+		return uuid.Parse(value)
+	case 's':
+		return value, nil
+	case 'i':
+		return strconv.ParseInt(value, 10, 64)
+	case 'f':
+		return strconv.ParseFloat(value, 64)
+	default:
+		return nil, fmt.Errorf("unknown key type %q", key[0])
+	}
+}
+
+func encodeKeys(values []interface{}) ([]string, error) {
+	keys := make([]string, len(values))
+	for i, value := range values {
+		key, err := encodeKey(value)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+func decodeKeys(keys []string) ([]interface{}, error) {
+	if keys == nil {
+		return nil, nil
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		value, err := decodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (c cursor) encode(order Order) string {
+	c.Sort = sortHash(order)
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// position is where the cursor points to, with the keys read back.
+type position struct {
+	offset int
+	after  []interface{}
+	before []interface{}
+	at     time.Time
+}
+
+func decodeCursor(value string, order Order) (*position, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+
+	var c cursor
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&c); err != nil {
+		return nil, err
+	}
+
+	if c.Sort != sortHash(order) || c.Offset < 0 || c.At < 0 {
+		return nil, errCursorList
+	}
+
+	// lists with an order are paged by keys, the others by offset
+	keyed := c.After != nil || c.Before != nil
+	switch {
+	case len(order) == 0 && keyed,
+		len(order) > 0 && (c.Offset != 0 || (c.After == nil) == (c.Before == nil)),
+		c.After != nil && len(c.After) != len(order),
+		c.Before != nil && len(c.Before) != len(order):
+		return nil, errCursorList
+	}
+
+	p := &position{offset: c.Offset}
+	if c.At != 0 {
+		p.at = time.Unix(c.At, 0)
+	}
+	if p.after, err = decodeKeys(c.After); err != nil {
+		return nil, err
+	}
+	if p.before, err = decodeKeys(c.Before); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
@@ -0,0 +1,367 @@
+// Package pagination reads the pagination parameters of list requests and
+// finds the pages they ask for.
+//
+// A list is sorted by keys, the last of them unique, so every item has its
+// place. Pages are asked for by page and limit, or by the opaque cursor of a
+// page returned before. Cursors point to the item next to the page, so items
+// added or removed meanwhile don't shift the pages after it. Lists sorted by
+// something else than columns, such as relevance, are paged by offset, and
+// their cursors only hide it.
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultLimit = 10
+	MaxLimit     = 100
+)
+
+var (
+	ErrInvalidPage   = errors.New("invalid page or limit")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidTotal  = errors.New("total must be true or false")
+)
+
+// Key is a column the items of a list are sorted by.
+type Key struct {
+	Column string
+	Desc   bool
+}
+
+// Order is the keys a list is sorted by, the last of them unique. An empty
+// one leaves the query ordered as it is and pages it by offset.
+type Order []Key
+
+// By is the order of the keys, columns followed by DESC for a descending
+// order, as in SQL.
+func By(keys ...string) Order {
+	order := make(Order, len(keys))
+	for i, key := range keys {
+		column, direction, _ := strings.Cut(strings.TrimSpace(key), " ")
+		order[i] = Key{Column: column, Desc: strings.EqualFold(strings.TrimSpace(direction), "DESC")}
+	}
+	return order
+}
+
+// String is the order as in SQL, and tells the lists a cursor is made for.
+func (o Order) String() string {
+	keys := make([]string, len(o))
+	for i, key := range o {
+		keys[i] = key.Column
+		if key.Desc {
+			keys[i] += " DESC"
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// reversed is the order the other way around, to find the page before an item.
+func (o Order) reversed() Order {
+	reversed := make(Order, len(o))
+	for i, key := range o {
+		reversed[i] = Key{Column: key.Column, Desc: !key.Desc}
+	}
+	return reversed
+}
+
+// after keeps the items coming after the one with the values of the keys.
+func (o Order) after(values []interface{}) clause.Expr {
+	var sql strings.Builder
+	var vars []interface{}
+
+	// (a > ?) OR (a = ? AND b > ?) OR ...
+	for i, key := range o {
+		if i > 0 {
+			sql.WriteString(" OR ")
+		}
+		sql.WriteString("(")
+		for j := 0; j < i; j++ {
+			sql.WriteString(o[j].Column + " = ? AND ")
+			vars = append(vars, values[j])
+		}
+		if key.Desc {
+			sql.WriteString(key.Column + " < ?)")
+		} else {
+			sql.WriteString(key.Column + " > ?)")
+		}
+		vars = append(vars, values[i])
+	}
+
+	return clause.Expr{SQL: "(" + sql.String() + ")", Vars: vars}
+}
+
+// Params are the pagination parameters of a list request.
+type Params struct {
+	// Page is the number of the page, from 1, unless a cursor is given.
+	Page  int
+	Limit int
+	// Total counts the items across all pages.
+	Total bool
+
+	order  Order
+	cursor *position
+	url    url.URL
+	at     time.Time
+}
+
+// FromQuery reads the page, limit, cursor and total query parameters of a
+// request for the list in the order. A cursor has to be one returned for a
+// list in the same order, and takes the place of the page.
+func FromQuery(ctx *gin.Context, order Order) (Params, error) {
+	return FromQueryMax(ctx, order, MaxLimit)
+}
+
+// FromQueryMax is FromQuery for lists whose pages are smaller than MaxLimit.
+func FromQueryMax(ctx *gin.Context, order Order, maxLimit int) (Params, error) {
+	params := Params{order: order, url: *ctx.Request.URL}
+
+	var err error
+
+	if params.Page, err = strconv.Atoi(ctx.DefaultQuery("page", "1")); err != nil || params.Page < 1 {
+		return params, invalidPage(maxLimit)
+	}
+
+	params.Limit, err = strconv.Atoi(ctx.DefaultQuery("limit", strconv.Itoa(min(DefaultLimit, maxLimit))))
+	if err != nil || params.Limit < 1 || params.Limit > maxLimit {
+		return params, invalidPage(maxLimit)
+	}
+
+	if value := ctx.Query("total"); value != "" {
+		if params.Total, err = strconv.ParseBool(value); err != nil {
+			return params, ErrInvalidTotal
+		}
+	}
+
+	if value := ctx.Query("cursor"); value != "" {
+		if params.cursor, err = decodeCursor(value, order); err != nil {
+			return params, ErrInvalidCursor
+		}
+		params.Page = 0
+	}
+
+	return params, nil
+}
+
+func invalidPage(maxLimit int) error {
+	return fmt.Errorf("%w: page must be positive and limit between 1 and %d", ErrInvalidPage, maxLimit)
+}
+
+// Snapshot is the time the list is read as of. Lists ordered by something
+// which changes over time, such as the turns of promoted profiles, are to be
+// ordered as of the snapshot, so their pages don't overlap or skip items. It
+// is now for the first page, the cursors of the pages after it keep it.
+func (p *Params) Snapshot(now time.Time) time.Time {
+	if p.cursor != nil && !p.cursor.at.IsZero() {
+		p.at = p.cursor.at
+	} else {
+		p.at = now
+	}
+	return p.at
+}
+
+// Page tells where the page found is in the list.
+type Page struct {
+	// Page is the number of the page, zero when found by cursor.
+	Page  int
+	Limit int
+	// Total is the number of items across all pages, when asked for.
+	Total *int64
+	Links PageLinks
+}
+
+// Find loads the page of the items the query finds into dest, sorted by the
+// order of the params. The scopes apply to the page but not to the count, for
+// preloads, or for the ordering of lists paged by offset.
+func Find[T any](db *gorm.DB, params Params, dest *[]T, scopes ...func(*gorm.DB) *gorm.DB) (Page, error) {
+	page := Page{Page: params.Page, Limit: params.Limit}
+
+	if params.Total {
+		var total int64
+		if err := db.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return page, err
+		}
+		page.Total = &total
+	}
+
+	query := db.Session(&gorm.Session{}).Scopes(scopes...)
+
+	offset := (params.Page - 1) * params.Limit
+	order := params.order
+	backward := false
+
+	switch c := params.cursor; {
+	case c == nil:
+		query = query.Offset(offset)
+	case c.after != nil:
+		query = query.Where(order.after(c.after))
+	case c.before != nil:
+		// the page before the item is the one after it the other way around
+		backward = true
+		order = order.reversed()
+		query = query.Where(order.after(c.before))
+	default:
+		offset = c.offset
+		query = query.Offset(offset)
+	}
+
+	if len(order) > 0 {
+		query = query.Order(order.String())
+	}
+
+	// one more tells whether there is a page past this one
+	result := query.Limit(params.Limit + 1).Find(dest)
+	if result.Error != nil {
+		return page, result.Error
+	}
+
+	more := len(*dest) > params.Limit
+	if more {
+		*dest = (*dest)[:params.Limit]
+	}
+
+	if backward {
+		slices.Reverse(*dest)
+	}
+
+	var next, prev *cursor
+
+	switch {
+	case len(params.order) == 0:
+		if more {
+			next = &cursor{Offset: offset + params.Limit}
+		}
+		if offset > 0 {
+			prev = &cursor{Offset: max(0, offset-params.Limit)}
+		}
+	case len(*dest) > 0:
+		first, err := keys(result, params.order, (*dest)[0])
+		if err != nil {
+			return page, err
+		}
+		last, err := keys(result, params.order, (*dest)[len(*dest)-1])
+		if err != nil {
+			return page, err
+		}
+
+		hasNext, hasPrev := more, offset > 0 || params.cursor != nil && params.cursor.after != nil
+		if backward {
+			hasNext, hasPrev = true, more
+		}
+
+		if hasNext {
+			after, err := encodeKeys(last)
+			if err != nil {
+				return page, err
+			}
+			next = &cursor{After: after}
+		}
+		if hasPrev {
+			before, err := encodeKeys(first)
+			if err != nil {
+				return page, err
+			}
+			prev = &cursor{Before: before}
+		}
+	}
+
+	if !params.at.IsZero() {
+		for _, c := range []*cursor{next, prev} {
+			if c != nil {
+				c.At = params.at.Unix()
+			}
+		}
+	}
+
+	if next != nil {
+		page.Links.NextCursor = next.encode(params.order)
+		page.Links.Next = params.link(page.Links.NextCursor)
+	}
+	if prev != nil {
+		page.Links.PrevCursor = prev.encode(params.order)
+		page.Links.Prev = params.link(page.Links.PrevCursor)
+	}
+
+	return page, nil
+}
+
+// keys are the values of the keys of the order of the item.
+func keys[T any](result *gorm.DB, order Order, item T) ([]interface{}, error) {
+	if result.Statement.Schema == nil {
+		return nil, errors.New("pagination: the order needs a model")
+	}
+
+	value := reflect.ValueOf(&item).Elem()
+	values := make([]interface{}, len(order))
+
+	for i, key := range order {
+		column := key.Column
+		if _, name, found := strings.Cut(column, "."); found {
+			column = name
+		}
+
+		field := result.Statement.Schema.LookUpField(column)
+		if field == nil {
+			return nil, fmt.Errorf("pagination: no %s field to sort by", key.Column)
+		}
+
+		values[i], _ = field.ValueOf(result.Statement.Context, value)
+	}
+
+	return values, nil
+}
+
+// link is the URL of the request with the cursor in place of the page.
+func (p Params) link(cursor string) string {
+	link := p.url
+	query := link.Query()
+	query.Del("page")
+	query.Set("cursor", cursor)
+	link.RawQuery = query.Encode()
+	return link.RequestURI()
+}
+
+// Response is the page of data in the response to a list request.
+func Response[T any](data T, results int, page Page) SuccessPageResponse[T] {
+	return SuccessPageResponse[T]{
+		Status:    "success",
+		Data:      data,
+		Results:   results,
+		Page:      page.Page,
+		Limit:     page.Limit,
+		Total:     page.Total,
+		PageLinks: page.Links,
+	}
+}
+
+// CountedResponse is the page of data in the response to a list request which
+// always counts its items, found with Total set.
+func CountedResponse[T any](data T, results int, page Page) SuccessCountedPageResponse[T] {
+	response := SuccessCountedPageResponse[T]{
+		Status:    "success",
+		Data:      data,
+		Results:   results,
+		Page:      page.Page,
+		Limit:     page.Limit,
+		PageLinks: page.Links,
+	}
+
+	if page.Total != nil {
+		response.Total = *page.Total
+	}
+
+	return response
+}
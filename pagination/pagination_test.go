@@ -0,0 +1,353 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestCursor(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 1, 0, 500, time.UTC)
+	id := uuid.New()
+
+	tests := []struct {
+		name     string
+		order    Order
+		cursor   cursor
+		position position
+	}{
+		{
+			name:     "after the keys of an item",
+			order:    By("created_at DESC", "id DESC"),
+			cursor:   cursor{After: []string{"t" + created.Format(time.RFC3339Nano), "u" + id.String()}},
+			position: position{after: []interface{}{created, id}},
+		},
+		{
+			name:     "before the keys of an item",
+			order:    By("name", "rank DESC", "price", "id"),
+			cursor:   cursor{Before: []string{"sAnna", "i-3", "f12.5", "i7"}},
+			position: position{before: []interface{}{"Anna", int64(-3), 12.5, int64(7)}},
+		},
+		{
+			name:     "at an offset",
+			order:    nil,
+			cursor:   cursor{Offset: 20},
+			position: position{offset: 20},
+		},
+		{
+			name:     "with a snapshot",
+			order:    nil,
+			cursor:   cursor{Offset: 10, At: 1709294460},
+			position: position{offset: 10, at: time.Unix(1709294460, 0)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := decodeCursor(test.cursor.encode(test.order), test.order)
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.position, *p)
+			}
+		})
+	}
+}
+
+func TestEncodeKeys(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 1, 0, 0, time.UTC)
+	id := uuid.New()
+
+	keys, err := encodeKeys([]interface{}{created, id, "Anna", 3, int64(4), uint(5), 1.5})
+	if assert.NoError(t, err) {
+		values, err := decodeKeys(keys)
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{created, id, "Anna", int64(3), int64(4), int64(5), 1.5}, values)
+	}
+
+	// lists can't be sorted by keys of other types, which is a bug, not a bad request
+	_, err = encodeKeys([]interface{}{id, []byte("Anna")})
+	assert.ErrorContains(t, err, "can't sort by a key of type []uint8")
+
+	_, err = encodeKey(struct{}{})
+	assert.Error(t, err)
+}
+
+func TestDecodeCursor(t *testing.T) {
+	order := By("created_at DESC", "id DESC")
+	raw := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+	sort := sortHash(order)
+	offsetSort := sortHash(nil)
+
+	tests := []struct {
+		name   string
+		order  Order
+		cursor string
+	}{
+		{"not base64", order, "not a cursor!"},
+		{"not json", order, raw("not json")},
+		{"unknown fields", order, raw(`{"s":"` + sort + `","a":["i1","i2"],"x":1}`)},
+		{"of a list in another order", order, cursor{After: []string{"i1", "i2"}}.encode(By("created_at", "id"))},
+		{"without an order", order, raw(`{"a":["i1","i2"]}`)},
+		{"tampered keys", order, raw(`{"s":"` + sort + `","a":["x1","i2"]}`)},
+		{"tampered time", order, raw(`{"s":"` + sort + `","a":["tyesterday","i2"]}`)},
+		{"empty key", order, raw(`{"s":"` + sort + `","a":["","i2"]}`)},
+		{"too few keys", order, raw(`{"s":"` + sort + `","a":["i1"]}`)},
+		{"both after and before", order, raw(`{"s":"` + sort + `","a":["i1","i2"],"b":["i1","i2"]}`)},
+		{"neither after nor before", order, raw(`{"s":"` + sort + `"}`)},
+		{"an offset of a keyed list", order, raw(`{"s":"` + sort + `","o":10}`)},
+		{"keys of a list paged by offset", nil, raw(`{"s":"` + offsetSort + `","a":["i1"]}`)},
+		{"a negative offset", nil, raw(`{"s":"` + offsetSort + `","o":-10}`)},
+		{"a negative snapshot", nil, raw(`{"s":"` + offsetSort + `","o":10,"t":-1}`)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeCursor(test.cursor, test.order)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func queryContext(query string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/items?"+query, nil)
+	return ctx
+}
+
+func TestFromQuery(t *testing.T) {
+	order := By("created_at DESC", "id DESC")
+	after := cursor{After: []string{"t2024-03-01T12:01:00Z", "u" + uuid.NewString()}}
+
+	tests := []struct {
+		name     string
+		query    string
+		maxLimit int
+		page     int
+		limit    int
+		total    bool
+		err      error
+	}{
+		{name: "defaults", query: "", maxLimit: MaxLimit, page: 1, limit: DefaultLimit},
+		{name: "page and limit", query: "page=3&limit=25", maxLimit: MaxLimit, page: 3, limit: 25},
+		{name: "the max limit", query: "limit=100", maxLimit: MaxLimit, page: 1, limit: 100},
+		{name: "over the max limit", query: "limit=101", maxLimit: MaxLimit, err: ErrInvalidPage},
+		{name: "over the max limit of the list", query: "limit=13", maxLimit: 12, err: ErrInvalidPage},
+		{name: "the max limit of the list", query: "limit=12", maxLimit: 12, page: 1, limit: 12},
+		{name: "default limit over the max limit of the list", query: "", maxLimit: 5, page: 1, limit: 5},
+		{name: "zero limit", query: "limit=0", maxLimit: MaxLimit, err: ErrInvalidPage},
+		{name: "negative limit", query: "limit=-1", maxLimit: MaxLimit, err: ErrInvalidPage},
+		{name: "zero page", query: "page=0", maxLimit: MaxLimit, err: ErrInvalidPage},
+		{name: "page not a number", query: "page=first", maxLimit: MaxLimit, err: ErrInvalidPage},
+		{name: "total", query: "total=true", maxLimit: MaxLimit, page: 1, limit: DefaultLimit, total: true},
+		{name: "no total", query: "total=false", maxLimit: MaxLimit, page: 1, limit: DefaultLimit, total: false},
+		{name: "total not a bool", query: "total=yes", maxLimit: MaxLimit, err: ErrInvalidTotal},
+		{name: "cursor in place of the page", query: "page=2&cursor=" + after.encode(order), maxLimit: MaxLimit, page: 0, limit: DefaultLimit},
+		{name: "cursor of another list", query: "cursor=" + after.encode(By("created_at", "id")), maxLimit: MaxLimit, err: ErrInvalidCursor},
+		{name: "tampered cursor", query: "cursor=" + cursor{After: []string{"t2024-13-01", after.After[1]}}.encode(order), maxLimit: MaxLimit, err: ErrInvalidCursor},
+		{name: "not a cursor", query: "cursor=page-2", maxLimit: MaxLimit, err: ErrInvalidCursor},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params, err := FromQueryMax(queryContext(test.query), order, test.maxLimit)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err), "got %v", err)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.page, params.Page)
+				assert.Equal(t, test.limit, params.Limit)
+				assert.Equal(t, test.total, params.Total)
+			}
+		})
+	}
+
+	t.Run("invalid limit tells the max limit of the list", func(t *testing.T) {
+		_, err := FromQueryMax(queryContext("limit=13"), nil, 12)
+		assert.ErrorContains(t, err, "between 1 and 12")
+
+		_, err = FromQuery(queryContext("limit=101"), nil)
+		assert.ErrorContains(t, err, "between 1 and 100")
+	})
+}
+
+func TestSnapshot(t *testing.T) {
+	now := time.Now()
+	at := now.Add(-time.Hour).Truncate(time.Second)
+
+	params, err := FromQuery(queryContext(""), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, now, params.Snapshot(now))
+
+	params, err = FromQuery(queryContext("cursor="+cursor{Offset: 10, At: at.Unix()}.encode(nil)), nil)
+	assert.NoError(t, err)
+	assert.True(t, at.Equal(params.Snapshot(now)))
+
+	// a cursor from before snapshots were kept starts one
+	params, err = FromQuery(queryContext("cursor="+cursor{Offset: 10}.encode(nil)), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, now, params.Snapshot(now))
+}
+
+type paginationTestItem struct {
+	ID   int `gorm:"primaryKey"`
+	Rank int `gorm:"not null"`
+}
+
+func TestFind(t *testing.T) {
+	config, err := initializers.LoadConfig("../.")
+	if err != nil {
+		log.Fatal("🚀 Could not load environment variables", err)
+	}
+
+	initializers.ConnectDB(&config)
+	db := initializers.DB
+
+	if err := db.AutoMigrate(&paginationTestItem{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Migrator().DropTable(&paginationTestItem{})
+	})
+
+	// ranks repeat, so the id breaks the ties: 7 5 3 6 4 2 1 by rank DESC, id DESC
+	items := []paginationTestItem{{1, 1}, {2, 2}, {3, 3}, {4, 2}, {5, 3}, {6, 2}, {7, 4}}
+	if err := db.Create(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	order := By("rank DESC", "id DESC")
+
+	ids := func(items []paginationTestItem) []int {
+		ids := make([]int, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+		return ids
+	}
+
+	find := func(t *testing.T, order Order, query string) ([]int, Page) {
+		params, err := FromQuery(queryContext(query), order)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+
+		var found []paginationTestItem
+		page, err := Find(db.Model(&paginationTestItem{}), params, &found)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return ids(found), page
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		// walk is the query of the page to go to from the one found first
+		walk func(Page) string
+		ids  []int
+		prev bool
+		next bool
+	}{
+		{name: "first page", query: "limit=3", ids: []int{7, 5, 3}, next: true},
+		{name: "second page by page", query: "page=2&limit=3", ids: []int{6, 4, 2}, prev: true, next: true},
+		{name: "last page by page", query: "page=3&limit=3", ids: []int{1}, prev: true},
+		{name: "past the last page", query: "page=4&limit=3", ids: []int{}},
+		{
+			name:  "second page by cursor",
+			query: "limit=3",
+			walk:  func(page Page) string { return "limit=3&cursor=" + page.Links.NextCursor },
+			ids:   []int{6, 4, 2},
+			prev:  true,
+			next:  true,
+		},
+		{
+			name:  "back from the last page keeps the order of the list",
+			query: "page=3&limit=3",
+			walk:  func(page Page) string { return "limit=3&cursor=" + page.Links.PrevCursor },
+			ids:   []int{6, 4, 2},
+			prev:  true,
+			next:  true,
+		},
+		{
+			name:  "back to the first page has no page before",
+			query: "page=2&limit=3",
+			walk:  func(page Page) string { return "limit=3&cursor=" + page.Links.PrevCursor },
+			ids:   []int{7, 5, 3},
+			next:  true,
+		},
+		{
+			name:  "back from within a page takes what is left",
+			query: "page=2&limit=2",
+			walk:  func(page Page) string { return "limit=3&cursor=" + page.Links.PrevCursor },
+			ids:   []int{7, 5},
+			next:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			found, page := find(t, order, test.query)
+			if test.walk != nil {
+				found, page = find(t, order, test.walk(page))
+			}
+
+			assert.Equal(t, test.ids, found)
+			assert.Equal(t, test.prev, page.Links.PrevCursor != "", "prev")
+			assert.Equal(t, test.next, page.Links.NextCursor != "", "next")
+		})
+	}
+
+	t.Run("total is counted only when asked for", func(t *testing.T) {
+		_, page := find(t, order, "limit=3&total=false")
+		assert.Nil(t, page.Total)
+
+		_, page = find(t, order, "limit=3&total=true")
+		if assert.NotNil(t, page.Total) {
+			assert.Equal(t, int64(len(items)), *page.Total)
+		}
+	})
+
+	t.Run("links keep the query and put the cursor in place of the page", func(t *testing.T) {
+		_, page := find(t, order, "page=2&limit=3")
+		assert.Contains(t, page.Links.Next, "/items?")
+		assert.Contains(t, page.Links.Next, "limit=3")
+		assert.Contains(t, page.Links.Next, "cursor="+page.Links.NextCursor)
+		assert.NotContains(t, page.Links.Next, "page=")
+	})
+
+	t.Run("lists without an order are paged by offset", func(t *testing.T) {
+		params, err := FromQuery(queryContext("limit=3"), nil)
+		assert.NoError(t, err)
+
+		var found []paginationTestItem
+		page, err := Find(db.Model(&paginationTestItem{}), params, &found, func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3}, ids(found))
+		assert.Empty(t, page.Links.PrevCursor)
+
+		params, err = FromQuery(queryContext("limit=3&cursor="+page.Links.NextCursor), nil)
+		assert.NoError(t, err)
+
+		page, err = Find(db.Model(&paginationTestItem{}), params, &found, func(db *gorm.DB) *gorm.DB {
+			return db.Order("id")
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{4, 5, 6}, ids(found))
+		assert.NotEmpty(t, page.Links.PrevCursor)
+		assert.NotEmpty(t, page.Links.NextCursor)
+	})
+}
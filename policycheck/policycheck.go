@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+)
+
+// policyCase is a line of the cases file: the attributes of a request and
+// whether the policy should allow it.
+type policyCase struct {
+	line     int
+	request  PolicyRequest
+	expected bool
+}
+
+func readCases(path string) ([]policyCase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = 9
+
+	var cases []policyCase
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return cases, nil
+			}
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		expected := strings.TrimSpace(record[8])
+		if expected != "allow" && expected != "deny" {
+			return nil, fmt.Errorf("line %d: expected must be allow or deny, not %q", line, expected)
+		}
+
+		cases = append(cases, policyCase{
+			line: line,
+			request: PolicyRequest{
+				Sub:        record[0],
+				Obj:        record[1],
+				Act:        record[2],
+				Tier:       record[3],
+				HasProfile: record[4],
+				TwoFactor:  record[5],
+				IsOwner:    record[6],
+				Scopes:     record[7],
+			},
+			expected: expected == "allow",
+		})
+	}
+}
+
+func decision(allowed bool) string {
+	if allowed {
+		return "allow"
+	}
+	return "deny"
+}
+
+// Evaluates a policy file against a table of test cases, without a database:
+//
+//	go run ./policycheck -model casbin/model.conf -policy casbin/policy.csv -cases casbin/policy_cases.csv
+func main() {
+	modelPath := flag.String("model", "casbin/model.conf", "casbin model")
+	policyPath := flag.String("policy", "casbin/policy.csv", "policy to check")
+	casesPath := flag.String("cases", "casbin/policy_cases.csv", "test cases: sub, obj, act, tier, hasProfile, twoFactor, isOwner, scopes, allow|deny")
+	flag.Parse()
+
+	enforcer, err := casbin.NewEnforcer(*modelPath, *policyPath)
+	if err != nil {
+		log.Fatalf("failed to load policy: %v", err)
+	}
+
+	enforcer.AddFunction("scopeMatch", utils.ScopeMatchFunc)
+
+	cases, err := readCases(*casesPath)
+	if err != nil {
+		log.Fatalf("failed to read cases: %v", err)
+	}
+
+	failed := 0
+	for _, c := range cases {
+		explanation, err := utils.ExplainPolicy(enforcer, c.request)
+		if err != nil {
+			log.Fatalf("line %d: %v", c.line, err)
+		}
+
+		if explanation.Allowed == c.expected {
+			continue
+		}
+
+		failed++
+		fmt.Printf("✗ line %d: %v expected %s, got %s\n", c.line, c.request.Values(), decision(c.expected), decision(explanation.Allowed))
+
+		if explanation.Rule != nil {
+			fmt.Printf("    allowed by %v\n", explanation.Rule.Values())
+		}
+
+		for _, mismatch := range explanation.Mismatches {
+			fmt.Printf("    %v fails on %s\n", mismatch.Rule.Values(), strings.Join(mismatch.Fields, ", "))
+		}
+	}
+
+	fmt.Printf("%d of %d cases passed\n", len(cases)-failed, len(cases))
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/middleware"
+)
+
+type ApiKeyRouteController struct {
+	apiKeyController controllers.ApiKeyController
+}
+
+func NewRouteApiKeyController(apiKeyController controllers.ApiKeyController) ApiKeyRouteController {
+	return ApiKeyRouteController{apiKeyController}
+}
+
+// @BasePath /api/v1/api-keys
+
+func (kc *ApiKeyRouteController) ApiKeyRoute(rg *gin.RouterGroup) {
+	router := rg.Group("api-keys")
+
+	router.Use(middleware.DeserializeUser(), middleware.DenyImpersonation())
+
+	router.POST("/", kc.apiKeyController.CreateApiKey)
+	router.GET("/", kc.apiKeyController.ListApiKeys)
+	router.DELETE("/:id", kc.apiKeyController.RevokeApiKey)
+}
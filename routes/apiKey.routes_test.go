@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"github.com/stretchr/testify/assert"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SetupKCRouter sets up the router for testing, with the user routes to use the keys on.
+func SetupKCRouter(authController *controllers.AuthController, apiKeyController *controllers.ApiKeyController, userController *controllers.UserController) *gin.Engine {
+	r := gin.Default()
+
+	authRouteController := NewAuthRouteController(*authController)
+	apiKeyRouteController := NewRouteApiKeyController(*apiKeyController)
+	userRouteController := NewRouteUserController(*userController)
+
+	api := r.Group("/api")
+	authRouteController.AuthRoute(api)
+	apiKeyRouteController.ApiKeyRoute(api)
+	userRouteController.UserRoute(api)
+
+	return r
+}
+
+func TestApiKeyRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	uc := SetupUCController()
+	kc := controllers.NewApiKeyController(ac.DB)
+
+	router := SetupKCRouter(&ac, &kc, &uc)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	sendJSON := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	withApiKey := func(method string, url string, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("X-Api-Key", key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	createKey := func(t *testing.T, accessTokenCookie *http.Cookie, scopes string) models.CreatedApiKeyResponse {
+		w := sendJSON("POST", "/api/api-keys/", fmt.Sprintf(`{"name": "bot", "scopes": %s}`, scopes), accessTokenCookie)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var keyResponse models.SuccessResponse[models.CreatedApiKeyResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &keyResponse))
+
+		return keyResponse.Data
+	}
+
+	t.Run("POST /api/api-keys + GET /api/users: key works within its scopes", func(t *testing.T) {
+		user := generateUser(random, router, t, "guru")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		apiKey := createKey(t, accessTokenCookie, `["users:list"]`)
+		assert.True(t, strings.HasPrefix(apiKey.Key, apiKey.Prefix+"_"))
+		assert.Equal(t, []string{"users:list"}, apiKey.Scopes)
+
+		var stored models.ApiKey
+		assert.NoError(t, ac.DB.First(&stored, "id = ?", apiKey.ID).Error)
+		assert.NotEqual(t, apiKey.Key, stored.KeyHash)
+		assert.Equal(t, utils.HashToken(apiKey.Key), stored.KeyHash)
+
+		w := withApiKey("GET", "/api/users/", apiKey.Key)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendJSON("GET", "/api/api-keys/", "", accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), apiKey.Key)
+
+		var keysResponse models.SuccessPageResponse[[]models.ApiKeyResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &keysResponse))
+		assert.Len(t, keysResponse.Data, 1)
+		assert.Equal(t, apiKey.Prefix, keysResponse.Data[0].Prefix)
+		assert.NotNil(t, keysResponse.Data[0].LastUsedAt)
+	})
+
+	t.Run("GET /api/users: key without the scope is refused", func(t *testing.T) {
+		user := generateUser(random, router, t, "guru")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		apiKey := createKey(t, accessTokenCookie, `["profiles:*"]`)
+
+		w := withApiKey("GET", "/api/users/", apiKey.Key)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "no scope")
+	})
+
+	t.Run("GET /api/users/me: keys are not accepted outside of the access policy", func(t *testing.T) {
+		user := generateUser(random, router, t, "guru")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		apiKey := createKey(t, accessTokenCookie, `["*"]`)
+
+		w := withApiKey("GET", "/api/users/me", apiKey.Key)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = withApiKey("GET", "/api/api-keys/", apiKey.Key)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("POST /api/api-keys: staff can't create keys and their old keys stop working", func(t *testing.T) {
+		owner := createOwnerUser(ac.DB)
+
+		ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("POST", "/api/api-keys/", `{"name": "bot", "scopes": ["users:list"]}`, ownerAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		user := generateUser(random, router, t, "guru")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		apiKey := createKey(t, accessTokenCookie, `["users:list"]`)
+
+		assert.NoError(t, ac.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("role", "moderator").Error)
+
+		w = withApiKey("GET", "/api/users/", apiKey.Key)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "staff")
+	})
+
+	t.Run("DELETE /api/api-keys/:id: revoked key stops working", func(t *testing.T) {
+		user := generateUser(random, router, t, "guru")
+		other := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		otherAccessToken, err := loginUserGetAccessToken(t, other.Password, other.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		apiKey := createKey(t, accessTokenCookie, `["users:list"]`)
+
+		w := sendJSON("DELETE", fmt.Sprintf("/api/api-keys/%s", apiKey.ID), "", otherAccessToken)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = sendJSON("DELETE", fmt.Sprintf("/api/api-keys/%s", apiKey.ID), "", accessTokenCookie)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = withApiKey("GET", "/api/users/", apiKey.Key)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = withApiKey("GET", "/api/users/", apiKey.Key+"x")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("POST /api/api-keys: invalid scopes are refused", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("POST", "/api/api-keys/", `{"name": "bot", "scopes": ["users"]}`, accessTokenCookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = sendJSON("POST", "/api/api-keys/", `{"name": "bot", "scopes": []}`, accessTokenCookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
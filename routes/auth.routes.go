@@ -19,12 +19,41 @@ func NewAuthRouteController(authController controllers.AuthController) AuthRoute
 func (rc *AuthRouteController) AuthRoute(rg *gin.RouterGroup) {
 	router := rg.Group("auth")
 
-	router.POST("/bot/signup", rc.authController.BotSignUpUser)
-	router.POST("/bot/login", rc.authController.BotSignInUser)
+	router.POST("/bot/signup", middleware.BotAuthMiddleware(), rc.authController.BotSignUpUser)
+	router.POST("/bot/login", middleware.BotAuthMiddleware(), rc.authController.BotSignInUser)
 
 	router.POST("/signup", rc.authController.SignUpUser)
 	router.POST("/login", rc.authController.SignInUser)
+	router.POST("/login/2fa", rc.authController.VerifyTwoFactorLogin)
 
 	router.GET("/refresh", rc.authController.RefreshAccessToken)
 	router.GET("/logout", middleware.DeserializeUser(), rc.authController.LogoutUser)
+
+	router.POST("/phone/otp", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.RequestPhoneVerification)
+	router.POST("/phone/verify", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.ConfirmPhoneVerification)
+
+	router.POST("/2fa/enroll", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.EnrollTwoFactor)
+	router.POST("/2fa/confirm", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.ConfirmTwoFactor)
+	router.POST("/2fa/recovery-codes", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.RegenerateRecoveryCodes)
+	router.DELETE("/2fa", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.DisableTwoFactor)
+
+	router.PUT("/password", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.ChangePassword)
+	router.POST("/password/reset", rc.authController.RequestPasswordReset)
+	router.POST("/password/reset/confirm", rc.authController.ResetPassword)
+
+	router.GET("/sessions", middleware.DeserializeUser(), rc.authController.ListSessions)
+	router.DELETE("/sessions", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.RevokeAllSessions)
+	router.DELETE("/sessions/:id", middleware.DeserializeUser(), middleware.DenyImpersonation(), rc.authController.RevokeSession)
+	router.DELETE("/sessions/user/:id", middleware.DeserializeUserOrApiKey(), middleware.DenyImpersonation(), middleware.AbacMiddleware("sessions", "revoke"), rc.authController.RevokeUserSessions)
+
+	router.GET("/lockouts", middleware.DeserializeUserOrApiKey(), middleware.AbacMiddleware("lockouts", "list"), rc.authController.ListLoginLockouts)
+	router.DELETE("/lockouts", middleware.DeserializeUserOrApiKey(), middleware.DenyImpersonation(), middleware.AbacMiddleware("lockouts", "clear"), rc.authController.ClearLoginLockout)
+	router.GET("/login-failures", middleware.DeserializeUserOrApiKey(), middleware.AbacMiddleware("lockouts", "list"), rc.authController.ListFailedLogins)
+}
+
+// WellKnownRoute registers the discovery endpoints served outside of the API prefix.
+func (rc *AuthRouteController) WellKnownRoute(rg *gin.RouterGroup) {
+	router := rg.Group(".well-known")
+
+	router.GET("/jwks.json", rc.authController.JWKS)
 }
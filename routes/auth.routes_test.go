@@ -1,10 +1,15 @@
 package routes
 
 import (
-	"bytes"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
 	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	"github.com/ivegotanidea/golang-gorm-postgres/models"
@@ -14,6 +19,9 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -26,6 +34,7 @@ func SetupACRouter(authController *controllers.AuthController) *gin.Engine {
 
 	api := r.Group("/api")
 	authRouteController.AuthRoute(api)
+	authRouteController.WellKnownRoute(r.Group("/"))
 
 	return r
 }
@@ -39,14 +48,18 @@ func SetupAuthController() controllers.AuthController {
 
 	initializers.ConnectDB(&config)
 
-	authController := controllers.NewAuthController(initializers.DB)
+	authController := controllers.NewAuthController(initializers.DB, utils.LogSmsSender{}, utils.LogTelegramSender{}, utils.NewLoginGuard(utils.NewMemoryLoginAttemptStore(time.Hour)))
 	authController.DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
 
 	// Migrate the schema
-	if err := authController.DB.AutoMigrate(&models.User{}, &models.Profile{}, &models.Service{}, &models.Photo{}, &models.ProfileOption{}, &models.UserRating{}, &models.ProfileRating{}); err != nil {
+	if err := authController.DB.AutoMigrate(&models.User{}, &models.Profile{}, &models.Service{}, &models.Photo{}, &models.ProfileOption{}, &models.UserRating{}, &models.ProfileRating{}, &models.Session{}, &models.RefreshToken{}, &models.PhoneVerification{}, &models.PasswordReset{}, &models.TwoFactorAuth{}, &models.RecoveryCode{}, &models.FailedLogin{}, &models.ApiKey{}, &models.Impersonation{}, &models.ImpersonatedRequest{}, &models.Tier{}); err != nil {
 		panic("failed to migrate database: " + err.Error())
 	}
 
+	if err := utils.SeedTiers(authController.DB); err != nil {
+		panic("failed to seed tiers: " + err.Error())
+	}
+
 	return authController
 }
 
@@ -66,11 +79,10 @@ func TestAuthRoutes(t *testing.T) {
 		phone := utils.GenerateRandomPhoneNumber(random, 0)
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusCreated, w.Code)
@@ -94,11 +106,10 @@ func TestAuthRoutes(t *testing.T) {
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 		errMessage := "Key: 'BotSignUpRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag"
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -120,11 +131,10 @@ func TestAuthRoutes(t *testing.T) {
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 		errMessage := "Key: 'BotSignUpRequest.Phone' Error:Field validation for 'Phone' failed on the 'required' tag"
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -146,11 +156,10 @@ func TestAuthRoutes(t *testing.T) {
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 		errMessage := "Key: 'BotSignUpRequest.Phone' Error:Field validation for 'Phone' failed on the 'min' tag"
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -172,11 +181,10 @@ func TestAuthRoutes(t *testing.T) {
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 		errMessage := "Key: 'BotSignUpRequest.Phone' Error:Field validation for 'Phone' failed on the 'max' tag"
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -197,11 +205,10 @@ func TestAuthRoutes(t *testing.T) {
 		telegramUserId := ""
 		errMessage := "Key: 'BotSignUpRequest.TelegramUserId' Error:Field validation for 'TelegramUserId' failed on the 'required' tag"
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -222,11 +229,10 @@ func TestAuthRoutes(t *testing.T) {
 		telegramUserId := ""
 		errMessage := "Key: 'BotSignUpRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag\nKey: 'BotSignUpRequest.Phone' Error:Field validation for 'Phone' failed on the 'required' tag\nKey: 'BotSignUpRequest.TelegramUserId' Error:Field validation for 'TelegramUserId' failed on the 'required' tag"
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
@@ -246,11 +252,10 @@ func TestAuthRoutes(t *testing.T) {
 		phone := utils.GenerateRandomPhoneNumber(random, 0)
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusCreated, w.Code)
@@ -268,10 +273,8 @@ func TestAuthRoutes(t *testing.T) {
 		assert.NotEmptyf(t, user["password"], "")
 
 		w = httptest.NewRecorder()
-		password := user["password"].(string)
-		payloadLogin := fmt.Sprintf(`{"telegramUserId": "%s", "password": "%s"}`, telegramUserId, password)
-		loginReq, _ := http.NewRequest("POST", "/api/auth/bot/login", bytes.NewBuffer([]byte(payloadLogin)))
-		loginReq.Header.Set("Content-Type", "application/json")
+		payloadLogin := botSignInPayload(telegramUserId)
+		loginReq := newBotRequest("POST", "/api/auth/bot/login", payloadLogin)
 		router.ServeHTTP(w, loginReq)
 
 		err = json.Unmarshal(w.Body.Bytes(), &jsonResponse)
@@ -341,4 +344,1007 @@ func TestAuthRoutes(t *testing.T) {
 
 		assert.Equal(t, http.StatusForbidden, w.Code)
 	})
+
+	t.Run("GET api/auth/refresh: refresh token is rotated and reuse revokes the session", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		w := httptest.NewRecorder()
+		payloadLogin := botSignInPayload(fmt.Sprintf("%d", user.TelegramUserID))
+		loginReq := newBotRequest("POST", "/api/auth/bot/login", payloadLogin)
+		router.ServeHTTP(w, loginReq)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		firstRefreshToken := findCookie(w.Result().Cookies(), "refresh_token")
+		assert.NotNil(t, firstRefreshToken)
+
+		w = httptest.NewRecorder()
+		refreshReq, _ := http.NewRequest("GET", "/api/auth/refresh", nil)
+		refreshReq.AddCookie(&http.Cookie{Name: firstRefreshToken.Name, Value: firstRefreshToken.Value})
+		router.ServeHTTP(w, refreshReq)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		secondRefreshToken := findCookie(w.Result().Cookies(), "refresh_token")
+		assert.NotNil(t, secondRefreshToken)
+		assert.NotEqual(t, firstRefreshToken.Value, secondRefreshToken.Value)
+
+		// the first token is retired now, using it again must kill the whole session
+		w = httptest.NewRecorder()
+		reuseReq, _ := http.NewRequest("GET", "/api/auth/refresh", nil)
+		reuseReq.AddCookie(&http.Cookie{Name: firstRefreshToken.Name, Value: firstRefreshToken.Value})
+		router.ServeHTTP(w, reuseReq)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = httptest.NewRecorder()
+		revokedReq, _ := http.NewRequest("GET", "/api/auth/refresh", nil)
+		revokedReq.AddCookie(&http.Cookie{Name: secondRefreshToken.Name, Value: secondRefreshToken.Value})
+		router.ServeHTTP(w, revokedReq)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("GET api/auth/logout: session is revoked on the server", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		w := httptest.NewRecorder()
+		payloadLogin := botSignInPayload(fmt.Sprintf("%d", user.TelegramUserID))
+		loginReq := newBotRequest("POST", "/api/auth/bot/login", payloadLogin)
+		router.ServeHTTP(w, loginReq)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		accessTokenCookie := findCookie(w.Result().Cookies(), "access_token")
+		refreshTokenCookie := findCookie(w.Result().Cookies(), "refresh_token")
+		assert.NotNil(t, accessTokenCookie)
+		assert.NotNil(t, refreshTokenCookie)
+
+		w = httptest.NewRecorder()
+		logoutReq, _ := http.NewRequest("GET", "/api/auth/logout", nil)
+		logoutReq.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		logoutReq.AddCookie(&http.Cookie{Name: refreshTokenCookie.Name, Value: refreshTokenCookie.Value})
+		router.ServeHTTP(w, logoutReq)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// a refresh token kept from before the logout must not work anymore
+		w = httptest.NewRecorder()
+		refreshReq, _ := http.NewRequest("GET", "/api/auth/refresh", nil)
+		refreshReq.AddCookie(&http.Cookie{Name: refreshTokenCookie.Name, Value: refreshTokenCookie.Value})
+		router.ServeHTTP(w, refreshReq)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestSessionRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	uc := SetupUCController()
+
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	listSessions := func(accessTokenCookie *http.Cookie) (int, []models.SessionResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/auth/sessions", nil)
+		req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		router.ServeHTTP(w, req)
+
+		var sessionsResponse models.SuccessPageResponse[[]models.SessionResponse]
+		_ = json.Unmarshal(w.Body.Bytes(), &sessionsResponse)
+
+		return w.Code, sessionsResponse.Data
+	}
+
+	t.Run("GET /api/auth/sessions + DELETE /api/auth/sessions/:id", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		firstAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		secondAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		code, sessions := listSessions(secondAccessToken)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, sessions, 2)
+
+		var otherSession models.SessionResponse
+		currentCount := 0
+		for _, session := range sessions {
+			if session.Current {
+				currentCount++
+			} else {
+				otherSession = session
+			}
+		}
+		assert.Equal(t, 1, currentCount)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/auth/sessions/%s", otherSession.ID), nil)
+		req.AddCookie(&http.Cookie{Name: secondAccessToken.Name, Value: secondAccessToken.Value})
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		code, sessions = listSessions(secondAccessToken)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, sessions, 1)
+
+		// the access token of the revoked session is dead right away
+		code, _ = listSessions(firstAccessToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("DELETE /api/auth/sessions: sign out everywhere", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		firstAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		secondAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/auth/sessions", nil)
+		req.AddCookie(&http.Cookie{Name: firstAccessToken.Name, Value: firstAccessToken.Value})
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		code, _ := listSessions(firstAccessToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		code, _ = listSessions(secondAccessToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("DELETE /api/auth/sessions/user/:id: only privileged users can end sessions of others", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+		other := generateUser(random, router, t, "")
+
+		userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		otherAccessToken, err := loginUserGetAccessToken(t, other.Password, other.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/auth/sessions/user/%s", user.ID), nil)
+		req.AddCookie(&http.Cookie{Name: otherAccessToken.Name, Value: otherAccessToken.Value})
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		owner := createOwnerUser(uc.DB)
+		ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, router)
+		assert.NoError(t, err)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/auth/sessions/user/%s", user.ID), nil)
+		req.AddCookie(&http.Cookie{Name: ownerAccessToken.Name, Value: ownerAccessToken.Value})
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		code, _ := listSessions(userAccessToken)
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		code, _ = listSessions(otherAccessToken)
+		assert.Equal(t, http.StatusOK, code)
+	})
+}
+
+func TestTelegramAuthVerification(t *testing.T) {
+
+	ac := SetupAuthController()
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+	config := getTestConfig()
+
+	postBotRequest := func(url string, payload interface{}, botToken string) int {
+		body, _ := json.Marshal(payload)
+
+		w := httptest.NewRecorder()
+		req := newBotRequest("POST", url, body)
+		req.Header.Set("X-Bot-Token", botToken)
+		router.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	t.Run("POST /api/auth/bot/signup: missing bot credential FAIL", func(t *testing.T) {
+		telegramUserId := fmt.Sprintf("%d", rand.Int64())
+		payload := models.BotSignUpRequest{
+			Name:           utils.GenerateRandomStringWithPrefix(random, 10, "test-"),
+			Phone:          utils.GenerateRandomPhoneNumber(random, 0),
+			TelegramUserId: telegramUserId,
+			Telegram:       signedTelegramLogin(telegramUserId),
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, postBotRequest("/api/auth/bot/signup", payload, ""))
+		assert.Equal(t, http.StatusUnauthorized, postBotRequest("/api/auth/bot/signup", payload, "wrong"))
+	})
+
+	t.Run("POST /api/auth/bot/signup: unsigned payload FAIL", func(t *testing.T) {
+		payload := models.BotSignUpRequest{
+			Name:           utils.GenerateRandomStringWithPrefix(random, 10, "test-"),
+			Phone:          utils.GenerateRandomPhoneNumber(random, 0),
+			TelegramUserId: fmt.Sprintf("%d", rand.Int64()),
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, postBotRequest("/api/auth/bot/signup", payload, config.BotApiSecret))
+	})
+
+	t.Run("POST /api/auth/bot/login: forged hash FAIL", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+		telegramUserId := fmt.Sprintf("%d", user.TelegramUserID)
+
+		telegram := signedTelegramLogin(telegramUserId)
+		telegram.Hash = utils.TelegramLoginHash(utils.TelegramLoginFields(telegram), "not-our-bot-token")
+
+		payload := models.BotSignInRequest{TelegramUserId: telegramUserId, Telegram: telegram}
+
+		assert.Equal(t, http.StatusUnauthorized, postBotRequest("/api/auth/bot/login", payload, config.BotApiSecret))
+	})
+
+	t.Run("POST /api/auth/bot/login: payload of another user FAIL", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+		other := generateUser(random, router, t, "")
+
+		payload := models.BotSignInRequest{
+			TelegramUserId: fmt.Sprintf("%d", user.TelegramUserID),
+			Telegram:       signedTelegramLogin(fmt.Sprintf("%d", other.TelegramUserID)),
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, postBotRequest("/api/auth/bot/login", payload, config.BotApiSecret))
+	})
+
+	t.Run("POST /api/auth/bot/login: outdated auth_date FAIL", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+		telegramUserId := fmt.Sprintf("%d", user.TelegramUserID)
+
+		telegram := signedTelegramLogin(telegramUserId)
+		telegram.AuthDate = time.Now().Add(-48 * time.Hour).Unix()
+		telegram.Hash = utils.TelegramLoginHash(utils.TelegramLoginFields(telegram), config.TelegramBotToken)
+
+		payload := models.BotSignInRequest{TelegramUserId: telegramUserId, Telegram: telegram}
+
+		assert.Equal(t, http.StatusUnauthorized, postBotRequest("/api/auth/bot/login", payload, config.BotApiSecret))
+	})
+
+	t.Run("POST /api/auth/bot/login: WebApp initData OK", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		fields := map[string]string{
+			"query_id":  "AAHdF6IQAAAAAN0XohDhrOrc",
+			"user":      fmt.Sprintf(`{"id":%d,"first_name":"test"}`, user.TelegramUserID),
+			"auth_date": fmt.Sprintf("%d", time.Now().Unix()),
+		}
+		fields["hash"] = utils.TelegramInitDataHash(fields, config.TelegramBotToken)
+
+		initData := url.Values{}
+		for key, value := range fields {
+			initData.Set(key, value)
+		}
+
+		payload := models.BotSignInRequest{
+			TelegramUserId: fmt.Sprintf("%d", user.TelegramUserID),
+			InitData:       initData.Encode(),
+		}
+
+		assert.Equal(t, http.StatusOK, postBotRequest("/api/auth/bot/login", payload, config.BotApiSecret))
+	})
+}
+
+// memorySmsSender keeps the last message sent to every phone.
+type memorySmsSender struct {
+	messages map[string]string
+}
+
+func (s *memorySmsSender) Send(phone string, message string) error {
+	s.messages[phone] = message
+	return nil
+}
+
+func (s *memorySmsSender) lastCode(phone string) string {
+	message := s.messages[phone]
+	return message[len(message)-6:]
+}
+
+func TestPhoneVerification(t *testing.T) {
+
+	sender := &memorySmsSender{messages: map[string]string{}}
+
+	ac := SetupAuthController()
+	ac.SmsSender = sender
+
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	postWithToken := func(url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("POST /api/auth/phone/otp + POST /api/auth/phone/verify: phone gets verified", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := postWithToken("/api/auth/phone/otp", "", accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, sender.messages[user.Phone])
+
+		// resend is not allowed right away
+		w = postWithToken("/api/auth/phone/otp", "", accessTokenCookie)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		code := sender.lastCode(user.Phone)
+
+		wrongCode := "000000"
+		if code == wrongCode {
+			wrongCode = "111111"
+		}
+
+		w = postWithToken("/api/auth/phone/verify", fmt.Sprintf(`{"code": "%s"}`, wrongCode), accessTokenCookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = postWithToken("/api/auth/phone/verify", fmt.Sprintf(`{"code": "%s"}`, code), accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var userResponse UserResponse
+		err = json.Unmarshal(w.Body.Bytes(), &userResponse)
+		assert.NoError(t, err)
+		assert.True(t, userResponse.Data.Verified)
+
+		var dbUser models.User
+		assert.NoError(t, ac.DB.First(&dbUser, "id = ?", user.ID).Error)
+		assert.True(t, dbUser.Verified)
+
+		// the code is single use
+		w = postWithToken("/api/auth/phone/verify", fmt.Sprintf(`{"code": "%s"}`, code), accessTokenCookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("POST /api/auth/phone/verify: attempts are limited", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := postWithToken("/api/auth/phone/otp", "", accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		code := sender.lastCode(user.Phone)
+
+		wrongCode := "000000"
+		if code == wrongCode {
+			wrongCode = "111111"
+		}
+
+		for i := 0; i < 5; i++ {
+			w = postWithToken("/api/auth/phone/verify", fmt.Sprintf(`{"code": "%s"}`, wrongCode), accessTokenCookie)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+
+		// even the right code is refused once the attempts are used up
+		w = postWithToken("/api/auth/phone/verify", fmt.Sprintf(`{"code": "%s"}`, code), accessTokenCookie)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+}
+
+type memoryTelegramSender struct {
+	messages map[int64]string
+}
+
+func (s *memoryTelegramSender) SendMessage(chatID int64, text string) error {
+	s.messages[chatID] = text
+	return nil
+}
+
+func lastResetToken(message string) string {
+	return message[strings.LastIndex(message, " ")+1:]
+}
+
+func TestPasswordRoutes(t *testing.T) {
+
+	smsSender := &memorySmsSender{messages: map[string]string{}}
+	telegramSender := &memoryTelegramSender{messages: map[int64]string{}}
+
+	ac := SetupAuthController()
+	ac.SmsSender = smsSender
+	ac.TelegramSender = telegramSender
+
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	sendJSON := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	login := func(phone string, password string) *httptest.ResponseRecorder {
+		return sendJSON("POST", "/api/auth/login", fmt.Sprintf(`{"phone": "%s", "password": "%s"}`, phone, password), nil)
+	}
+
+	t.Run("PUT /api/auth/password: wrong old password is refused", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("PUT", "/api/auth/password", `{"password": "not-my-password", "newPassword": "brand-new-password"}`, accessTokenCookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = login(user.Phone, user.Password)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("PUT /api/auth/password: password is changed and other sessions are revoked", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		currentSession, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		otherSession, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		newPassword := "brand-new-password"
+
+		w := sendJSON("PUT", "/api/auth/password", fmt.Sprintf(`{"password": "%s", "newPassword": "%s"}`, user.Password, newPassword), currentSession)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendJSON("GET", "/api/auth/sessions", "", currentSession)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendJSON("GET", "/api/auth/sessions", "", otherSession)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = login(user.Phone, user.Password)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = login(user.Phone, newPassword)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("POST /api/auth/password/reset: token is sent by the telegram bot and works once", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("POST", "/api/auth/password/reset", fmt.Sprintf(`{"phone": "%s", "channel": "telegram"}`, user.Phone), nil)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.NotEmpty(t, telegramSender.messages[user.TelegramUserID])
+
+		token := lastResetToken(telegramSender.messages[user.TelegramUserID])
+		newPassword := "reset-new-password"
+
+		w = sendJSON("POST", "/api/auth/password/reset/confirm", fmt.Sprintf(`{"token": "%s", "password": "%s", "passwordConfirm": "%s"}`, token, newPassword, newPassword), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// every session ends after a reset
+		w = sendJSON("GET", "/api/auth/sessions", "", accessTokenCookie)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = login(user.Phone, newPassword)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendJSON("POST", "/api/auth/password/reset/confirm", fmt.Sprintf(`{"token": "%s", "password": "another-password", "passwordConfirm": "another-password"}`, token), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("POST /api/auth/password/reset: sms goes only to verified phones", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		w := sendJSON("POST", "/api/auth/password/reset", fmt.Sprintf(`{"phone": "%s", "channel": "sms"}`, user.Phone), nil)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Empty(t, smsSender.messages[user.Phone])
+
+		assert.NoError(t, ac.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("verified", true).Error)
+
+		w = sendJSON("POST", "/api/auth/password/reset", fmt.Sprintf(`{"phone": "%s", "channel": "sms"}`, user.Phone), nil)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.NotEmpty(t, smsSender.messages[user.Phone])
+
+		token := lastResetToken(smsSender.messages[user.Phone])
+
+		w = sendJSON("POST", "/api/auth/password/reset/confirm", fmt.Sprintf(`{"token": "%s", "password": "reset-new-password", "passwordConfirm": "mismatched-password"}`, token), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = sendJSON("POST", "/api/auth/password/reset/confirm", fmt.Sprintf(`{"token": "%s", "password": "reset-new-password", "passwordConfirm": "reset-new-password"}`, token), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("POST /api/auth/password/reset: unknown phone gets the same answer", func(t *testing.T) {
+		w := sendJSON("POST", "/api/auth/password/reset", `{"phone": "00000000000", "channel": "sms"}`, nil)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	})
+}
+
+func TestTwoFactorRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	SetupUCController()
+
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	sendJSON := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	botLogin := func(telegramUserId int64) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newBotRequest("POST", "/api/auth/bot/login", botSignInPayload(fmt.Sprintf("%d", telegramUserId))))
+		return w
+	}
+
+	enroll := func(t *testing.T, user models.UserResponse, accessTokenCookie *http.Cookie) []string {
+		w := sendJSON("POST", "/api/auth/2fa/enroll", "", accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var enrollmentResponse models.SuccessResponse[models.TwoFactorEnrollmentResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrollmentResponse))
+		assert.NotEmpty(t, enrollmentResponse.Data.Secret)
+		assert.True(t, strings.HasPrefix(enrollmentResponse.Data.ProvisioningURI, "otpauth://totp/"))
+		assert.Contains(t, enrollmentResponse.Data.ProvisioningURI, "secret="+enrollmentResponse.Data.Secret)
+
+		w = sendJSON("POST", "/api/auth/2fa/confirm", fmt.Sprintf(`{"code": "%s"}`, currentTotpCode(t, user.ID)), accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var codesResponse models.SuccessResponse[models.RecoveryCodesResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &codesResponse))
+		assert.Len(t, codesResponse.Data.RecoveryCodes, 10)
+
+		return codesResponse.Data.RecoveryCodes
+	}
+
+	t.Run("POST /api/auth/2fa/enroll + POST /api/auth/2fa/confirm + POST /api/auth/login/2fa", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("POST", "/api/auth/2fa/confirm", `{"code": "123456"}`, accessTokenCookie)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		enroll(t, user, accessTokenCookie)
+
+		w = sendJSON("POST", "/api/auth/2fa/enroll", "", accessTokenCookie)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		// the password alone gives only a pre-auth token now
+		w = botLogin(user.TelegramUserID)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Nil(t, findCookie(w.Result().Cookies(), "access_token"))
+
+		var challenge models.TwoFactorChallengeResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+		assert.True(t, challenge.TwoFactorRequired)
+		assert.NotEmpty(t, challenge.PreAuthToken)
+
+		// and the pre-auth token is no access token
+		req, _ := http.NewRequest("GET", "/api/auth/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+challenge.PreAuthToken)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = sendJSON("POST", "/api/auth/login/2fa", fmt.Sprintf(`{"pre_auth_token": "%s", "code": "000000"}`, challenge.PreAuthToken), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		code := currentTotpCode(t, user.ID)
+
+		w = sendJSON("POST", "/api/auth/login/2fa", fmt.Sprintf(`{"pre_auth_token": "%s", "code": "%s"}`, challenge.PreAuthToken, code), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		twoFactorAccessToken := findCookie(w.Result().Cookies(), "access_token")
+		assert.NotNil(t, twoFactorAccessToken)
+
+		// the same code can't be used twice
+		w = sendJSON("POST", "/api/auth/login/2fa", fmt.Sprintf(`{"pre_auth_token": "%s", "code": "%s"}`, challenge.PreAuthToken, code), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = sendJSON("GET", "/api/auth/sessions", "", twoFactorAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var sessionsResponse models.SuccessPageResponse[[]models.SessionResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessionsResponse))
+
+		for _, session := range sessionsResponse.Data {
+			if session.Current {
+				assert.True(t, session.TwoFactor)
+			}
+		}
+	})
+
+	t.Run("POST /api/auth/login/2fa: recovery code works once", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		recoveryCodes := enroll(t, user, accessTokenCookie)
+
+		var challenge models.TwoFactorChallengeResponse
+		assert.NoError(t, json.Unmarshal(botLogin(user.TelegramUserID).Body.Bytes(), &challenge))
+
+		w := sendJSON("POST", "/api/auth/login/2fa", fmt.Sprintf(`{"pre_auth_token": "%s", "code": "%s"}`, challenge.PreAuthToken, recoveryCodes[0]), nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendJSON("POST", "/api/auth/login/2fa", fmt.Sprintf(`{"pre_auth_token": "%s", "code": "%s"}`, challenge.PreAuthToken, recoveryCodes[0]), nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("POST /api/auth/login/2fa: too many invalid codes lock the second factor", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		enroll(t, user, accessTokenCookie)
+
+		var challenge models.TwoFactorChallengeResponse
+		assert.NoError(t, json.Unmarshal(botLogin(user.TelegramUserID).Body.Bytes(), &challenge))
+
+		for i := 0; i < 5; i++ {
+			w := sendJSON("POST", "/api/auth/login/2fa", fmt.Sprintf(`{"pre_auth_token": "%s", "code": "not-a-code"}`, challenge.PreAuthToken), nil)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+
+		w := sendJSON("POST", "/api/auth/login/2fa", fmt.Sprintf(`{"pre_auth_token": "%s", "code": "%s"}`, challenge.PreAuthToken, currentTotpCode(t, user.ID)), nil)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	})
+
+	t.Run("DELETE /api/auth/2fa: disabled second factor gives plain logins again", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		enroll(t, user, accessTokenCookie)
+
+		w := sendJSON("DELETE", "/api/auth/2fa", fmt.Sprintf(`{"code": "%s"}`, currentTotpCode(t, user.ID)), accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = botLogin(user.TelegramUserID)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("DELETE /api/auth/sessions/user/:id: privileged actions need the second factor", func(t *testing.T) {
+		admin := generateUser(random, router, t, "")
+		user := generateUser(random, router, t, "")
+
+		assert.NoError(t, ac.DB.Model(&models.User{}).Where("id = ?", admin.ID).Updates(map[string]interface{}{"role": "admin", "tier": "guru"}).Error)
+
+		// a plain login of a not enrolled admin
+		w := botLogin(admin.TelegramUserID)
+		assert.Equal(t, http.StatusOK, w.Code)
+		plainAccessToken := findCookie(w.Result().Cookies(), "access_token")
+
+		w = sendJSON("DELETE", fmt.Sprintf("/api/auth/sessions/user/%s", user.ID), "", plainAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "Two-factor authentication is required")
+
+		twoFactorAccessToken, err := loginUserGetAccessToken(t, admin.Password, admin.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w = sendJSON("DELETE", fmt.Sprintf("/api/auth/sessions/user/%s", user.ID), "", twoFactorAccessToken)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+}
+
+func generateTestKeyPair(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	assert.NoError(t, err)
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+
+	privatePem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	publicPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})
+
+	return base64.StdEncoding.EncodeToString(privatePem), base64.StdEncoding.EncodeToString(publicPem)
+}
+
+func TestJwksRoute(t *testing.T) {
+
+	ac := SetupAuthController()
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	t.Run("GET /.well-known/jwks.json: lists the key access tokens are signed with", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		token, _, err := new(jwt.Parser).ParseUnverified(accessTokenCookie.Value, jwt.MapClaims{})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, token.Header["kid"])
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var jwks utils.JWKS
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+		assert.NotEmpty(t, jwks.Keys)
+
+		found := false
+		for _, key := range jwks.Keys {
+			assert.Equal(t, "RSA", key.Kty)
+			assert.Equal(t, "RS256", key.Alg)
+			assert.NotEmpty(t, key.N)
+			assert.NotEmpty(t, key.E)
+
+			if key.Kid == token.Header["kid"] {
+				found = true
+			}
+		}
+		assert.True(t, found)
+	})
+
+	t.Run("tokens of a retired signing key stay valid while its public key is kept", func(t *testing.T) {
+		oldPrivateKey, oldPublicKey := generateTestKeyPair(t)
+		_, newPublicKey := generateTestKeyPair(t)
+
+		token, err := utils.CreateToken(time.Minute, utils.TokenClaims{StandardClaims: jwt.StandardClaims{Subject: "subject"}, Type: utils.AccessTokenType}, oldPrivateKey)
+		assert.NoError(t, err)
+
+		claims, err := utils.ValidateToken(token, utils.AccessTokenType, newPublicKey, oldPublicKey)
+		assert.NoError(t, err)
+		assert.Equal(t, "subject", claims.Subject)
+
+		_, err = utils.ValidateToken(token, utils.AccessTokenType, newPublicKey)
+		assert.Error(t, err)
+	})
+}
+
+func TestAccessTokenClaims(t *testing.T) {
+
+	ac := SetupAuthController()
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	getSessions := func(token string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/auth/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("access token carries typed claims of the user", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		var claims utils.TokenClaims
+		_, _, err = new(jwt.Parser).ParseUnverified(accessTokenCookie.Value, &claims)
+		assert.NoError(t, err)
+
+		assert.Equal(t, utils.AccessTokenType, claims.Type)
+		assert.Equal(t, user.ID.String(), claims.Subject)
+		assert.NotEmpty(t, claims.SessionID)
+	})
+
+	t.Run("refresh token is not accepted as access token", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newBotRequest("POST", "/api/auth/bot/login", botSignInPayload(fmt.Sprintf("%d", user.TelegramUserID))))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		refreshTokenCookie := findCookie(w.Result().Cookies(), "refresh_token")
+		assert.NotNil(t, refreshTokenCookie)
+
+		assert.Equal(t, http.StatusUnauthorized, getSessions(refreshTokenCookie.Value))
+	})
+
+	t.Run("cached sessions are dropped when revoked", func(t *testing.T) {
+		initializers.AuthCache = utils.NewTTLCache[string, initializers.CachedAuth](time.Minute)
+		t.Cleanup(func() { initializers.AuthCache = nil })
+
+		user := generateUser(random, router, t, "")
+
+		accessTokenCookie, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, getSessions(accessTokenCookie.Value))
+		assert.Equal(t, http.StatusOK, getSessions(accessTokenCookie.Value))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/auth/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+accessTokenCookie.Value)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, getSessions(accessTokenCookie.Value))
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+
+	ac := SetupAuthController()
+	uc := SetupUCController()
+
+	router := SetupACRouter(&ac)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	sendJSON := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	login := func(phone string, password string) *httptest.ResponseRecorder {
+		return sendJSON("POST", "/api/auth/login", fmt.Sprintf(`{"phone": "%s", "password": "%s"}`, phone, password), nil)
+	}
+
+	t.Run("POST /api/auth/login: repeated wrong passwords are throttled and audited", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		for i := 0; i <= ac.LoginGuard.Account.FreeAttempts; i++ {
+			w := login(user.Phone, "not-my-password")
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+
+		// even the right password has to wait now
+		w := login(user.Phone, user.Password)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		var failedLogins []models.FailedLogin
+		assert.NoError(t, ac.DB.Where("phone = ?", user.Phone).Find(&failedLogins).Error)
+		assert.Len(t, failedLogins, ac.LoginGuard.Account.FreeAttempts+2)
+
+		invalidPasswords := 0
+		for _, failedLogin := range failedLogins {
+			if failedLogin.Reason == "invalid_password" {
+				invalidPasswords++
+				assert.Equal(t, user.ID, *failedLogin.UserID)
+			}
+		}
+		assert.Equal(t, ac.LoginGuard.Account.FreeAttempts+1, invalidPasswords)
+	})
+
+	t.Run("POST /api/auth/login: parallel wrong passwords can't get past the lockout", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		attempts := ac.LoginGuard.Account.MaxFailures * 3
+		codes := make(chan int, attempts)
+
+		var wg sync.WaitGroup
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- login(user.Phone, "not-my-password").Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		checked := 0
+		for code := range codes {
+			if code == http.StatusBadRequest {
+				checked++
+			}
+		}
+
+		assert.LessOrEqual(t, checked, ac.LoginGuard.Account.MaxFailures)
+	})
+
+	t.Run("GET + DELETE /api/auth/lockouts: only privileged users can clear lockouts", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+		other := generateUser(random, router, t, "")
+
+		for i := 0; i < ac.LoginGuard.Account.MaxFailures; i++ {
+			ac.LoginGuard.Failure(user.Phone, "192.0.2.100", time.Now())
+		}
+
+		w := login(user.Phone, user.Password)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		otherAccessToken, err := loginUserGetAccessToken(t, other.Password, other.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w = sendJSON("DELETE", "/api/auth/lockouts?account="+user.Phone, "", otherAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		owner := createOwnerUser(uc.DB)
+		ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, router)
+		assert.NoError(t, err)
+
+		w = sendJSON("GET", "/api/auth/lockouts?kind=account", "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var lockoutsResponse models.SuccessResponse[[]models.LoginLockoutResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &lockoutsResponse))
+
+		found := false
+		for _, lockout := range lockoutsResponse.Data {
+			if lockout.Value == user.Phone {
+				found = true
+				assert.Equal(t, "account", lockout.Kind)
+				assert.True(t, lockout.Locked)
+			}
+		}
+		assert.True(t, found)
+
+		w = sendJSON("DELETE", "/api/auth/lockouts", "", ownerAccessToken)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = sendJSON("DELETE", "/api/auth/lockouts?account="+user.Phone, "", ownerAccessToken)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = login(user.Phone, user.Password)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("GET /api/auth/login-failures: audit trail is filtered by phone", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		w := login(user.Phone, "not-my-password")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		owner := createOwnerUser(uc.DB)
+		ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, router)
+		assert.NoError(t, err)
+
+		w = sendJSON("GET", "/api/auth/login-failures?phone="+user.Phone, "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var failuresResponse models.SuccessPageResponse[[]models.FailedLoginResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &failuresResponse))
+		assert.Len(t, failuresResponse.Data, 1)
+		assert.Equal(t, "invalid_password", failuresResponse.Data[0].Reason)
+		assert.Equal(t, user.Phone, failuresResponse.Data[0].Phone)
+		assert.Nil(t, failuresResponse.Total)
+
+		w = sendJSON("GET", "/api/auth/login-failures?total=true&phone="+user.Phone, "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var countedResponse models.SuccessPageResponse[[]models.FailedLoginResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &countedResponse))
+		if assert.NotNil(t, countedResponse.Total) {
+			assert.Equal(t, int64(1), *countedResponse.Total)
+		}
+
+		for _, query := range []string{"page=0", "limit=-1", "limit=1000000", "page=abc", "total=maybe", "cursor=abc"} {
+			w = sendJSON("GET", "/api/auth/login-failures?"+query, "", ownerAccessToken)
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
 }
@@ -1,7 +1,6 @@
 package routes
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
@@ -26,7 +25,7 @@ func SetupDB() controllers.AuthController {
 
 	initializers.ConnectDB(&config)
 
-	controller := controllers.NewAuthController(initializers.DB)
+	controller := controllers.NewAuthController(initializers.DB, utils.LogSmsSender{}, utils.LogTelegramSender{}, utils.NewLoginGuard(utils.NewMemoryLoginAttemptStore(time.Hour)))
 	controller.DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
 
 	// Migrate the schema
@@ -70,11 +69,10 @@ func TestDbMigrations(t *testing.T) {
 		phone := utils.GenerateRandomPhoneNumber(random, 0)
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusCreated, w.Code)
@@ -19,7 +19,8 @@ func NewRouteImageController(imageController controllers.ImageController) ImageR
 func (ic *ImageRouteController) ImageRoute(rg *gin.RouterGroup) {
 	router := rg.Group("images")
 
-	router.Use(middleware.DeserializeUser())
+	// every route is checked against the access policy, so API keys are taken
+	router.Use(middleware.DeserializeUserOrApiKey())
 
-	router.POST("", ic.imageController.UploadProfileImages)
+	router.POST("", middleware.AbacMiddleware("photos", "upload", middleware.FormProfileOwner("profileID")), ic.imageController.UploadProfileImages)
 }
@@ -268,4 +268,43 @@ func TestImageRoutes(t *testing.T) {
 			assert.True(t, strings.HasSuffix(photoResp.PhrURL, expectedPhotoramaSuffix), "Photo %d photorama URL should end with %s", i+1, expectedPhotoramaSuffix)
 		}
 	})
+
+	t.Run("POST /api/images: fail uploading to other user's profile", func(t *testing.T) {
+		user := generateUser(random, authRouter, t, "")
+		otherUser := generateUser(random, authRouter, t, "guru")
+
+		accessTokenCookie, _ := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, authRouter)
+		otherAccessTokenCookie, _ := loginUserGetAccessToken(t, otherUser.Password, otherUser.TelegramUserID, authRouter)
+
+		profile, _ := createProfile(t, random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors,
+			intimateHairCuts, accessTokenCookie, profileRouter, user.ID.String())
+
+		image, _, err := createTestImage("png")
+		assert.NoError(t, err, "Failed to create test image")
+
+		var requestBody bytes.Buffer
+		writer := multipart.NewWriter(&requestBody)
+
+		assert.NoError(t, writer.WriteField("profileID", profile.Data.ID.String()))
+
+		part, err := writer.CreateFormFile("images", "image.png")
+		assert.NoError(t, err)
+		_, err = io.Copy(part, image)
+		assert.NoError(t, err)
+
+		writer.Close()
+
+		uploadReq, _ := http.NewRequest("POST", "/api/images", &requestBody)
+		uploadReq.AddCookie(&http.Cookie{Name: otherAccessTokenCookie.Name, Value: otherAccessTokenCookie.Value})
+		uploadReq.Header.Set("Content-Type", writer.FormDataContentType())
+
+		w := httptest.NewRecorder()
+		imageRouter.ServeHTTP(w, uploadReq)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		var photos int64
+		pc.DB.Model(&models.Photo{}).Where("profile_id = ?", profile.Data.ID).Count(&photos)
+		assert.Equal(t, int64(len(profile.Data.Photos)), photos)
+	})
 }
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/middleware"
+)
+
+type ImpersonationRouteController struct {
+	impersonationController controllers.ImpersonationController
+}
+
+func NewRouteImpersonationController(impersonationController controllers.ImpersonationController) ImpersonationRouteController {
+	return ImpersonationRouteController{impersonationController}
+}
+
+// @BasePath /api/v1/impersonations
+
+func (ic *ImpersonationRouteController) ImpersonationRoute(rg *gin.RouterGroup) {
+	router := rg.Group("impersonations")
+
+	router.Use(middleware.DeserializeUser())
+
+	router.DELETE("/current", ic.impersonationController.EndImpersonation)
+
+	// routes checked against the access policy also take API keys
+	scoped := rg.Group("impersonations", middleware.DeserializeUserOrApiKey())
+
+	scoped.POST("", middleware.DenyImpersonation(), middleware.AbacMiddleware("users", "impersonate"), ic.impersonationController.Impersonate)
+
+	scoped.GET("", middleware.AbacMiddleware("impersonations", "list"), ic.impersonationController.ListImpersonations)
+	scoped.GET("/:id", middleware.AbacMiddleware("impersonations", "list"), ic.impersonationController.GetImpersonation)
+}
@@ -0,0 +1,158 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/stretchr/testify/assert"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SetupImpersonationRouter sets up the router for testing, with the user routes to impersonate on.
+func SetupImpersonationRouter(authController *controllers.AuthController, impersonationController *controllers.ImpersonationController, userController *controllers.UserController) *gin.Engine {
+	r := gin.Default()
+
+	authRouteController := NewAuthRouteController(*authController)
+	impersonationRouteController := NewRouteImpersonationController(*impersonationController)
+	userRouteController := NewRouteUserController(*userController)
+
+	api := r.Group("/api")
+	authRouteController.AuthRoute(api)
+	impersonationRouteController.ImpersonationRoute(api)
+	userRouteController.UserRoute(api)
+
+	return r
+}
+
+func TestImpersonationRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	uc := SetupUCController()
+	ic := controllers.NewImpersonationController(ac.DB)
+
+	router := SetupImpersonationRouter(&ac, &ic, &uc)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	sendJSON := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	asImpersonator := func(method string, url string, payload string, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	owner := createOwnerUser(uc.DB)
+	ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, router)
+	assert.NoError(t, err)
+
+	impersonate := func(t *testing.T, userID string) models.ImpersonationTokenResponse {
+		w := sendJSON("POST", "/api/impersonations", fmt.Sprintf(`{"userId": "%s", "reason": "my profiles are empty"}`, userID), ownerAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var tokenResponse models.ImpersonationTokenResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokenResponse))
+		assert.NotEmpty(t, tokenResponse.AccessToken)
+
+		return tokenResponse
+	}
+
+	t.Run("POST /api/impersonations: staff sees what the user sees and is audited", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		token := impersonate(t, user.ID.String())
+
+		w := asImpersonator("GET", "/api/users/me", "", token.AccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var meResponse models.SuccessResponse[models.UserResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &meResponse))
+		assert.Equal(t, user.ID, meResponse.Data.ID)
+		assert.NotNil(t, meResponse.Data.ImpersonatedBy)
+		assert.Equal(t, owner.ID, meResponse.Data.ImpersonatedBy.ActorID)
+
+		w = asImpersonator("DELETE", "/api/users/user", "", token.AccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = asImpersonator("PUT", "/api/users/user", `{"phone": "79990001122"}`, token.AccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = asImpersonator("PUT", "/api/users/role", fmt.Sprintf(`{"id": "%s", "role": "admin"}`, user.ID), token.AccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		var stillThere models.User
+		assert.NoError(t, ac.DB.First(&stillThere, "id = ?", user.ID).Error)
+		assert.Equal(t, "user", stillThere.Role)
+
+		var impersonations models.SuccessPageResponse[[]models.ImpersonationResponse]
+		w = sendJSON("GET", fmt.Sprintf("/api/impersonations?subjectId=%s", user.ID), "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &impersonations))
+		assert.Len(t, impersonations.Data, 1)
+
+		var impersonation models.SuccessResponse[models.ImpersonationResponse]
+		w = sendJSON("GET", fmt.Sprintf("/api/impersonations/%s", impersonations.Data[0].ID), "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &impersonation))
+
+		assert.Equal(t, owner.ID, impersonation.Data.ActorID)
+		assert.Equal(t, "my profiles are empty", impersonation.Data.Reason)
+		assert.Len(t, impersonation.Data.Requests, 3)
+		assert.Equal(t, http.StatusOK, impersonation.Data.Requests[0].Status)
+		assert.Equal(t, "/api/users/me", impersonation.Data.Requests[0].Path)
+		assert.Equal(t, http.StatusForbidden, impersonation.Data.Requests[1].Status)
+	})
+
+	t.Run("DELETE /api/impersonations/current: ended impersonation stops working", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+
+		token := impersonate(t, user.ID.String())
+
+		w := asImpersonator("DELETE", "/api/impersonations/current", "", token.AccessToken)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = asImpersonator("GET", "/api/users/me", "", token.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("POST /api/impersonations: only staff can impersonate and never an owner", func(t *testing.T) {
+		user := generateUser(random, router, t, "")
+		other := generateUser(random, router, t, "")
+
+		userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("POST", "/api/impersonations", fmt.Sprintf(`{"userId": "%s", "reason": "curious"}`, other.ID), userAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		admin := generateUser(random, router, t, "")
+		assert.NoError(t, ac.DB.Model(&models.User{}).Where("id = ?", admin.ID).Updates(map[string]interface{}{"role": "admin", "tier": "guru"}).Error)
+
+		adminAccessToken, err := loginUserGetAccessToken(t, admin.Password, admin.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w = sendJSON("POST", "/api/impersonations", fmt.Sprintf(`{"userId": "%s", "reason": "curious"}`, owner.ID), adminAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendJSON("POST", "/api/impersonations", fmt.Sprintf(`{"userId": "%s", "reason": "support ticket"}`, other.ID), adminAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+}
@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/middleware"
+)
+
+type PaymentRouteController struct {
+	paymentController controllers.PaymentController
+}
+
+func NewRoutePaymentController(paymentController controllers.PaymentController) PaymentRouteController {
+	return PaymentRouteController{paymentController}
+}
+
+// @BasePath /api/v1/payments
+
+func (pc *PaymentRouteController) PaymentRoute(rg *gin.RouterGroup) {
+	router := rg.Group("payments")
+
+	// called by the payment provider, which signs the events
+	router.POST("/webhook", pc.paymentController.PaymentWebhook)
+
+	router.Use(middleware.DeserializeUser())
+
+	router.GET("/me", pc.paymentController.GetMyPayments)
+	router.POST("/checkout", middleware.DenyImpersonation(), pc.paymentController.CreateCheckout)
+	router.POST("/top-up", middleware.DenyImpersonation(), pc.paymentController.CreateTopUp)
+
+	router.GET("/boosts/plans", pc.paymentController.ListBoostPlans)
+	router.GET("/boosts", pc.paymentController.GetMyBoosts)
+	router.POST("/boosts", middleware.DenyImpersonation(), pc.paymentController.CreateBoostCheckout)
+
+	// routes checked against the access policy also take API keys
+	scoped := rg.Group("payments", middleware.DeserializeUserOrApiKey())
+
+	scoped.GET("", middleware.AbacMiddleware("payments", "list"), pc.paymentController.ListPayments)
+	scoped.GET("/history/:userID", middleware.AbacMiddleware("payments", "history"), pc.paymentController.GetPaymentHistory)
+	scoped.POST("/:id/refund", middleware.DenyImpersonation(), middleware.AbacMiddleware("payments", "refund"), pc.paymentController.RefundPayment)
+	scoped.POST("/reconciliations", middleware.DenyImpersonation(), middleware.AbacMiddleware("payments", "reconcile"), pc.paymentController.ReconcilePayments)
+	scoped.GET("/mismatches", middleware.AbacMiddleware("payments", "mismatches"), pc.paymentController.ListPaymentMismatches)
+}
@@ -0,0 +1,874 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/clause"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testPaymentProvider = utils.NewFakePaymentProvider("test-payment-webhook-secret", "http://localhost/pay")
+
+// SetupPaymentRouter sets up the router for testing.
+func SetupPaymentRouter(authController *controllers.AuthController, paymentController *controllers.PaymentController) *gin.Engine {
+	r := gin.Default()
+
+	authRouteController := NewAuthRouteController(*authController)
+	paymentRouteController := NewRoutePaymentController(*paymentController)
+
+	api := r.Group("/api")
+	authRouteController.AuthRoute(api)
+	paymentRouteController.PaymentRoute(api)
+
+	return r
+}
+
+func SetupPaymentController(subscriptions *utils.SubscriptionService) controllers.PaymentController {
+	config, err := initializers.LoadConfig("../.")
+	if err != nil {
+		log.Fatal("🚀 Could not load environment variables", err)
+	}
+
+	initializers.ConnectDB(&config)
+	initializers.InitCasbin(&config)
+
+	subscriptions.DB = initializers.DB
+	subscriptions.OnTierChange = initializers.ForgetUser
+
+	paymentController := controllers.NewPaymentController(initializers.DB, testPaymentProvider, subscriptions)
+
+	if err := paymentController.DB.AutoMigrate(&models.Tier{}, &models.Payment{}, &models.PaymentEvent{}, &models.Subscription{},
+		&models.City{}, &models.Profile{}, &models.BoostPlan{}, &models.Boost{},
+		&models.PaymentRefund{}, &models.PaymentReconciliation{}, &models.PaymentMismatch{}); err != nil {
+		panic("failed to migrate database: " + err.Error())
+	}
+
+	if err := utils.SeedTiers(paymentController.DB); err != nil {
+		panic("failed to seed tiers: " + err.Error())
+	}
+
+	if err := utils.SeedBoostPlans(paymentController.DB); err != nil {
+		panic("failed to seed boost plans: " + err.Error())
+	}
+
+	return paymentController
+}
+
+func TestPaymentRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	telegramSender := &memoryTelegramSender{messages: map[int64]string{}}
+	smsSender := &memorySmsSender{messages: map[string]string{}}
+	subscriptions := utils.NewSubscriptionService(nil, telegramSender, smsSender, 30*24*time.Hour, 3*24*time.Hour)
+	pc := SetupPaymentController(subscriptions)
+	router := SetupPaymentRouter(&ac, &pc)
+	uc := SetupUCController()
+	userRouter := SetupUCRouter(&uc)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	send := func(method string, url string, payload string, accessTokenCookie *http.Cookie, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	createPayments := func(userID uuid.UUID, count int, paymentDate time.Time) []models.Payment {
+		payments := make([]models.Payment, count)
+		for i := range payments {
+			payments[i] = models.Payment{
+				UserID:      userID,
+				Amount:      10,
+				Status:      "completed",
+				Type:        "subscription",
+				CreatedAt:   paymentDate,
+				UpdatedAt:   paymentDate,
+				PaymentDate: paymentDate.Add(time.Duration(i) * time.Minute),
+			}
+		}
+		assert.NoError(t, pc.DB.Create(&payments).Error)
+		return payments
+	}
+
+	user := generateUser(random, router, t, "guru")
+	userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+	assert.NoError(t, err)
+
+	admin := generateUser(random, router, t, "")
+	assert.NoError(t, ac.DB.Model(&models.User{}).Where("id = ?", admin.ID).Updates(map[string]interface{}{"role": "admin", "tier": "guru"}).Error)
+
+	adminAccessToken, err := loginUserGetAccessToken(t, admin.Password, admin.TelegramUserID, router)
+	assert.NoError(t, err)
+
+	paidAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	payments := createPayments(user.ID, 3, paidAt)
+
+	t.Run("GET /api/payments/me: pages of own payments with the total", func(t *testing.T) {
+		w := send("GET", "/api/payments/me?page=1&limit=2", "", userAccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var paymentsResponse models.SuccessCountedPageResponse[[]models.PaymentResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &paymentsResponse))
+
+		assert.Equal(t, 2, paymentsResponse.Results)
+		assert.Equal(t, int64(3), paymentsResponse.Total)
+		assert.Equal(t, payments[2].ID, paymentsResponse.Data[0].ID)
+
+		for _, payment := range paymentsResponse.Data {
+			assert.Equal(t, user.ID, payment.UserID)
+		}
+
+		w = send("GET", "/api/payments/me?page=0", "", userAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("GET", "/api/payments/me", "", nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("GET /api/payments/me: cursors walk the pages both ways", func(t *testing.T) {
+		list := func(url string) models.SuccessCountedPageResponse[[]models.PaymentResponse] {
+			w := send("GET", url, "", userAccessToken, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var paymentsResponse models.SuccessCountedPageResponse[[]models.PaymentResponse]
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &paymentsResponse))
+			return paymentsResponse
+		}
+
+		first := list("/api/payments/me?limit=2")
+		assert.Empty(t, first.Prev)
+		assert.NotEmpty(t, first.NextCursor)
+		assert.Contains(t, first.Next, "cursor="+first.NextCursor)
+
+		second := list(first.Next)
+		assert.Equal(t, 0, second.Page)
+		assert.Equal(t, int64(3), second.Total)
+		assert.Empty(t, second.Next)
+		if assert.Len(t, second.Data, 1) {
+			assert.Equal(t, payments[0].ID, second.Data[0].ID)
+		}
+
+		back := list(second.Prev)
+		assert.Empty(t, back.Prev)
+		assert.NotEmpty(t, back.Next)
+		if assert.Len(t, back.Data, 2) {
+			assert.Equal(t, first.Data[0].ID, back.Data[0].ID)
+			assert.Equal(t, first.Data[1].ID, back.Data[1].ID)
+		}
+
+		w := send("GET", "/api/payments/me?cursor=garbage", "", userAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// a cursor is of the list it was returned for only
+		w = send("GET", "/api/payments/mismatches?cursor="+first.NextCursor, "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("GET /api/payments + /api/payments/history/:userID: admins only", func(t *testing.T) {
+		w := send("GET", "/api/payments", "", userAccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send("GET", fmt.Sprintf("/api/payments/history/%s?start=2024-01-01T00:00:00Z&end=2024-12-31T00:00:00Z", user.ID), "", userAccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send("GET", "/api/payments?limit=1", "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var paymentsResponse models.SuccessCountedPageResponse[[]models.PaymentResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &paymentsResponse))
+		assert.Equal(t, 1, paymentsResponse.Results)
+		assert.GreaterOrEqual(t, paymentsResponse.Total, int64(3))
+	})
+
+	t.Run("GET /api/payments/history/:userID: payments between the dates", func(t *testing.T) {
+		query := url.Values{}
+		query.Set("start", paidAt.Add(-time.Hour).Format(time.RFC3339))
+		query.Set("end", paidAt.Add(90*time.Second).Format(time.RFC3339))
+
+		w := send("GET", fmt.Sprintf("/api/payments/history/%s?%s", user.ID, query.Encode()), "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var paymentsResponse models.SuccessCountedPageResponse[[]models.PaymentResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &paymentsResponse))
+		assert.Equal(t, int64(2), paymentsResponse.Total)
+
+		// an empty history still reports its total
+		w = send("GET", fmt.Sprintf("/api/payments/history/%s?start=2020-01-01T00:00:00Z&end=2020-12-31T00:00:00Z", user.ID), "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var emptyResponse map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &emptyResponse))
+		assert.Equal(t, float64(0), emptyResponse["total"])
+
+		w = send("GET", fmt.Sprintf("/api/payments/history/%s?start=yesterday&end=2024-12-31T00:00:00Z", user.ID), "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("GET", fmt.Sprintf("/api/payments/history/%s?start=2024-12-31T00:00:00Z&end=2024-01-01T00:00:00Z", user.ID), "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("GET", "/api/payments/history/someone?start=2024-01-01T00:00:00Z&end=2024-12-31T00:00:00Z", "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	sendEvent := func(eventID string, providerPaymentID string, status string, signature string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"id": "%s", "paymentId": "%s", "status": "%s"}`, eventID, providerPaymentID, status)
+		if signature == "" {
+			signature = testPaymentProvider.Sign([]byte(body))
+		}
+		return send("POST", "/api/payments/webhook", body, nil, map[string]string{"X-Payment-Signature": signature})
+	}
+
+	checkoutAs := func(accessTokenCookie *http.Cookie, tier string) models.Payment {
+		w := send("POST", "/api/payments/checkout", fmt.Sprintf(`{"tier": "%s"}`, tier), accessTokenCookie, nil)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var checkoutResponse models.SuccessResponse[models.CheckoutResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &checkoutResponse))
+
+		var payment models.Payment
+		assert.NoError(t, pc.DB.First(&payment, "id = ?", checkoutResponse.Data.PaymentID).Error)
+		assert.Equal(t, "http://localhost/pay/checkout/"+*payment.ProviderPaymentID, checkoutResponse.Data.URL)
+
+		return payment
+	}
+
+	checkout := func() models.Payment {
+		return checkoutAs(userAccessToken, "expert")
+	}
+
+	paymentStatus := func(id uuid.UUID) string {
+		var payment models.Payment
+		assert.NoError(t, pc.DB.First(&payment, "id = ?", id).Error)
+		return payment.Status
+	}
+
+	t.Run("POST /api/payments/checkout: a pending payment at the tier price", func(t *testing.T) {
+		payment := checkout()
+
+		assert.Equal(t, models.PaymentPending, payment.Status)
+		assert.Equal(t, user.ID, payment.UserID)
+		assert.Equal(t, "expert", payment.Tier)
+		assert.Equal(t, float64(10), payment.Amount)
+
+		w := send("POST", "/api/payments/checkout", `{"tier": "basic"}`, userAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = send("POST", "/api/payments/checkout", `{"tier": "no-such-tier"}`, userAccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("POST /api/payments/webhook: only signed events are processed", func(t *testing.T) {
+		payment := checkout()
+
+		w := sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "forged")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = send("POST", "/api/payments/webhook", fmt.Sprintf(`{"id": "%s", "status": "completed"}`, payment.ID), nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		assert.Equal(t, models.PaymentPending, paymentStatus(payment.ID))
+
+		w = sendEvent(uuid.NewString(), "fake_unknown", models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("POST /api/payments/webhook: events are processed once", func(t *testing.T) {
+		payment := checkout()
+
+		completed := uuid.NewString()
+
+		w := sendEvent(completed, *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.PaymentCompleted, paymentStatus(payment.ID))
+
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentRefunded, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.PaymentRefunded, paymentStatus(payment.ID))
+
+		// a redelivery doesn't bring the refunded payment back
+		w = sendEvent(completed, *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.PaymentRefunded, paymentStatus(payment.ID))
+
+		var events int64
+		pc.DB.Model(&models.PaymentEvent{}).Where("payment_id = ?", payment.ID).Count(&events)
+		assert.Equal(t, int64(2), events)
+	})
+
+	t.Run("POST /api/payments/webhook: only valid status transitions", func(t *testing.T) {
+		payment := checkout()
+
+		w := sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentRefunded, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentFailed, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, models.PaymentFailed, paymentStatus(payment.ID))
+	})
+
+	t.Run("POST /api/payments/webhook: a completed subscription grants and renews the tier", func(t *testing.T) {
+		subscriber := generateUser(random, router, t, "")
+		subscriberAccessToken, err := loginUserGetAccessToken(t, subscriber.Password, subscriber.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		findSubscription := func() models.Subscription {
+			var subscription models.Subscription
+			assert.NoError(t, pc.DB.First(&subscription, "user_id = ?", subscriber.ID).Error)
+			return subscription
+		}
+
+		findTier := func() string {
+			var user models.User
+			assert.NoError(t, pc.DB.First(&user, "id = ?", subscriber.ID).Error)
+			return user.Tier
+		}
+
+		payment := checkoutAs(subscriberAccessToken, "expert")
+		assert.Equal(t, "basic", findTier())
+
+		w := sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		subscription := findSubscription()
+		assert.Equal(t, "expert", findTier())
+		assert.Equal(t, "expert", subscription.Tier)
+		assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), subscription.ExpiresAt, time.Minute)
+
+		renewal := checkoutAs(subscriberAccessToken, "expert")
+		w = sendEvent(uuid.NewString(), *renewal.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		renewed := findSubscription()
+		assert.Equal(t, subscription.ID, renewed.ID)
+		assert.Equal(t, subscription.StartedAt.Unix(), renewed.StartedAt.Unix())
+		assert.WithinDuration(t, subscription.ExpiresAt.Add(30*24*time.Hour), renewed.ExpiresAt, time.Second)
+
+		req, _ := http.NewRequest("GET", "/api/users/me", nil)
+		req.AddCookie(&http.Cookie{Name: subscriberAccessToken.Name, Value: subscriberAccessToken.Value})
+		w = httptest.NewRecorder()
+		userRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var meResponse models.SuccessResponse[models.UserResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &meResponse))
+		assert.Equal(t, "expert", meResponse.Data.Tier)
+		if assert.NotNil(t, meResponse.Data.Subscription) {
+			assert.True(t, meResponse.Data.Subscription.Active)
+			assert.Equal(t, "expert", meResponse.Data.Subscription.Tier)
+		}
+
+		// a few days before the expiry the subscriber is reminded, once
+		beforeExpiry := renewed.ExpiresAt.Add(-24 * time.Hour)
+
+		_, err = subscriptions.Remind(beforeExpiry)
+		assert.NoError(t, err)
+		assert.Contains(t, telegramSender.messages[subscriber.TelegramUserID], "expert subscription expires")
+
+		delete(telegramSender.messages, subscriber.TelegramUserID)
+
+		_, err = subscriptions.Remind(beforeExpiry.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.NotContains(t, telegramSender.messages, subscriber.TelegramUserID)
+
+		// after the expiry the subscriber is back on basic
+		_, err = subscriptions.Expire(renewed.ExpiresAt.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, "expert", findTier())
+
+		_, err = subscriptions.Expire(renewed.ExpiresAt.Add(time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, "basic", findTier())
+		assert.NotNil(t, findSubscription().ExpiredAt)
+	})
+
+	t.Run("POST /api/payments/webhook: a refunded subscription takes the period back", func(t *testing.T) {
+		subscriber := generateUser(random, router, t, "")
+		subscriberAccessToken, err := loginUserGetAccessToken(t, subscriber.Password, subscriber.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		findSubscription := func() models.Subscription {
+			var subscription models.Subscription
+			assert.NoError(t, pc.DB.First(&subscription, "user_id = ?", subscriber.ID).Error)
+			return subscription
+		}
+
+		findTier := func() string {
+			var user models.User
+			assert.NoError(t, pc.DB.First(&user, "id = ?", subscriber.ID).Error)
+			return user.Tier
+		}
+
+		payment := checkoutAs(subscriberAccessToken, "expert")
+		w := sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		renewal := checkoutAs(subscriberAccessToken, "expert")
+		w = sendEvent(uuid.NewString(), *renewal.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		renewed := findSubscription()
+
+		// one of two periods is refunded, the other one stays
+		w = sendEvent(uuid.NewString(), *renewal.ProviderPaymentID, models.PaymentRefunded, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		subscription := findSubscription()
+		assert.Equal(t, "expert", findTier())
+		assert.Nil(t, subscription.ExpiredAt)
+		assert.WithinDuration(t, renewed.ExpiresAt.Add(-30*24*time.Hour), subscription.ExpiresAt, time.Second)
+
+		// nothing is left after the other one
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentRefunded, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, "basic", findTier())
+		assert.NotNil(t, findSubscription().ExpiredAt)
+
+		req, _ := http.NewRequest("GET", "/api/users/me", nil)
+		req.AddCookie(&http.Cookie{Name: subscriberAccessToken.Name, Value: subscriberAccessToken.Value})
+		w = httptest.NewRecorder()
+		userRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var meResponse models.SuccessResponse[models.UserResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &meResponse))
+		assert.Equal(t, "basic", meResponse.Data.Tier)
+	})
+
+	t.Run("POST /api/payments/:id/refund: partial and full refunds take the share of the period back", func(t *testing.T) {
+		subscriber := generateUser(random, router, t, "")
+		subscriberAccessToken, err := loginUserGetAccessToken(t, subscriber.Password, subscriber.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		payment := checkoutAs(subscriberAccessToken, "expert")
+		w := sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var granted models.Subscription
+		assert.NoError(t, pc.DB.First(&granted, "user_id = ?", subscriber.ID).Error)
+
+		refundURL := fmt.Sprintf("/api/payments/%s/refund", payment.ID)
+
+		w = send("POST", refundURL, `{"amount": 5, "reason": "asked"}`, subscriberAccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send("POST", refundURL, `{"amount": 5, "reason": "asked"}`, adminAccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var refundResponse models.SuccessResponse[models.PaymentResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &refundResponse))
+		assert.Equal(t, models.PaymentPartiallyRefunded, refundResponse.Data.Status)
+		assert.Equal(t, float64(5), refundResponse.Data.RefundedAmount)
+
+		var subscription models.Subscription
+		assert.NoError(t, pc.DB.First(&subscription, "user_id = ?", subscriber.ID).Error)
+		assert.Nil(t, subscription.ExpiredAt)
+		assert.WithinDuration(t, granted.ExpiresAt.Add(-15*24*time.Hour), subscription.ExpiresAt, time.Second)
+
+		var refund models.PaymentRefund
+		assert.NoError(t, pc.DB.First(&refund, "payment_id = ?", payment.ID).Error)
+		assert.Equal(t, admin.ID, *refund.CreatedBy)
+		assert.NotNil(t, refund.ProviderRefundID)
+
+		// no more than the rest
+		w = send("POST", refundURL, `{"amount": 6, "reason": "asked"}`, adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// the webhook event of the refund made here changes nothing
+		body := fmt.Sprintf(`{"id": "%s", "paymentId": "%s", "status": "partially_refunded", "refundId": "%s", "amount": 5}`,
+			uuid.NewString(), *payment.ProviderPaymentID, *refund.ProviderRefundID)
+		w = send("POST", "/api/payments/webhook", body, nil, map[string]string{"X-Payment-Signature": testPaymentProvider.Sign([]byte(body))})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.PaymentPartiallyRefunded, paymentStatus(payment.ID))
+
+		// without an amount the rest is refunded
+		w = send("POST", refundURL, `{"reason": "asked again"}`, adminAccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.PaymentRefunded, paymentStatus(payment.ID))
+
+		var user models.User
+		assert.NoError(t, pc.DB.First(&user, "id = ?", subscriber.ID).Error)
+		assert.Equal(t, "basic", user.Tier)
+
+		w = send("POST", refundURL, `{"reason": "once more"}`, adminAccessToken, nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = send("POST", refundURL, `{"amount": 1}`, adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("POST /api/payments/webhook: the event of a pending refund completes it", func(t *testing.T) {
+		subscriber := generateUser(random, router, t, "")
+		subscriberAccessToken, err := loginUserGetAccessToken(t, subscriber.Password, subscriber.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		payment := checkoutAs(subscriberAccessToken, "expert")
+		w := sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// the provider has paid it back, but applying it here failed
+		pending := models.PaymentRefund{PaymentID: payment.ID, Amount: 4, Status: models.RefundPending, CreatedBy: &admin.ID, CreatedAt: time.Now()}
+		assert.NoError(t, pc.DB.Omit(clause.Associations).Create(&pending).Error)
+
+		// the pending refund is held back from the rest
+		w = send("POST", fmt.Sprintf("/api/payments/%s/refund", payment.ID), `{"amount": 7}`, adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, models.PaymentCompleted, paymentStatus(payment.ID))
+
+		body := fmt.Sprintf(`{"id": "%s", "paymentId": "%s", "status": "partially_refunded", "refundId": "fake_refund_%s", "amount": 4}`,
+			uuid.NewString(), *payment.ProviderPaymentID, pending.ID)
+		w = send("POST", "/api/payments/webhook", body, nil, map[string]string{"X-Payment-Signature": testPaymentProvider.Sign([]byte(body))})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.PaymentPartiallyRefunded, paymentStatus(payment.ID))
+
+		var refunds []models.PaymentRefund
+		assert.NoError(t, pc.DB.Find(&refunds, "payment_id = ?", payment.ID).Error)
+		if assert.Len(t, refunds, 1) {
+			assert.Equal(t, models.RefundCompleted, refunds[0].Status)
+			assert.Equal(t, "fake_refund_"+pending.ID.String(), *refunds[0].ProviderRefundID)
+		}
+	})
+
+	t.Run("POST /api/payments/webhook: a lost dispute refunds the payment", func(t *testing.T) {
+		subscriber := generateUser(random, router, t, "")
+		subscriberAccessToken, err := loginUserGetAccessToken(t, subscriber.Password, subscriber.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		findTier := func() string {
+			var user models.User
+			assert.NoError(t, pc.DB.First(&user, "id = ?", subscriber.ID).Error)
+			return user.Tier
+		}
+
+		payment := checkoutAs(subscriberAccessToken, "expert")
+		w := sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// a won dispute keeps the tier
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentDisputed, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "expert", findTier())
+
+		w = send("POST", fmt.Sprintf("/api/payments/%s/refund", payment.ID), `{"reason": "disputed"}`, adminAccessToken, nil)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentCompleted, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "expert", findTier())
+
+		var subscription models.Subscription
+		assert.NoError(t, pc.DB.First(&subscription, "user_id = ?", subscriber.ID).Error)
+		assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), subscription.ExpiresAt, time.Minute)
+
+		// a lost one takes it back
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentDisputed, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = sendEvent(uuid.NewString(), *payment.ProviderPaymentID, models.PaymentRefunded, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.PaymentRefunded, paymentStatus(payment.ID))
+		assert.Equal(t, "basic", findTier())
+	})
+
+	t.Run("POST /api/payments/reconciliations: mismatches with the settlement file", func(t *testing.T) {
+		day := time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC)
+
+		settledPayment := func(amount float64, status string) models.Payment {
+			id := "fake_" + uuid.NewString()
+			payment := models.Payment{
+				UserID:            user.ID,
+				Amount:            amount,
+				Status:            status,
+				Type:              "subscription",
+				Provider:          testPaymentProvider.Name(),
+				ProviderPaymentID: &id,
+				CreatedAt:         day,
+				UpdatedAt:         day,
+				PaymentDate:       day.Add(10 * time.Hour),
+			}
+			assert.NoError(t, pc.DB.Create(&payment).Error)
+			return payment
+		}
+
+		matching := settledPayment(10, models.PaymentCompleted)
+		offAmount := settledPayment(10, models.PaymentCompleted)
+		offStatus := settledPayment(10, models.PaymentCompleted)
+		unsettled := settledPayment(10, models.PaymentCompleted)
+
+		settlement := strings.Join([]string{
+			"payment_id,status,amount",
+			fmt.Sprintf("%s,completed,10.00", *matching.ProviderPaymentID),
+			fmt.Sprintf("%s,completed,7.50", *offAmount.ProviderPaymentID),
+			fmt.Sprintf("%s,refunded,0", *offStatus.ProviderPaymentID),
+			"fake_unknown,completed,10.00",
+		}, "\n")
+
+		w := send("POST", "/api/payments/reconciliations?day=2024-02-10", settlement, userAccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send("POST", "/api/payments/reconciliations?day=2024-02-10", "payment_id,amount\nfake_1,10", adminAccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// a second run replaces the mismatches of the first one
+		for range 2 {
+			w = send("POST", "/api/payments/reconciliations?day=2024-02-10", settlement, adminAccessToken, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var reconciliationResponse models.SuccessResponse[models.ReconciliationResponse]
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reconciliationResponse))
+			assert.Equal(t, 4, reconciliationResponse.Data.Lines)
+			assert.Equal(t, 5, reconciliationResponse.Data.Mismatches)
+		}
+
+		w = send("GET", "/api/payments/mismatches?day=2024-02-10&limit=100", "", adminAccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var mismatchesResponse models.SuccessCountedPageResponse[[]models.PaymentMismatchResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &mismatchesResponse))
+		assert.Equal(t, int64(5), mismatchesResponse.Total)
+
+		kinds := map[string]string{}
+		for _, mismatch := range mismatchesResponse.Data {
+			kinds[mismatch.ProviderPaymentID+" "+mismatch.Kind] = mismatch.ProviderStatus
+		}
+
+		assert.Contains(t, kinds, *offAmount.ProviderPaymentID+" "+models.MismatchAmount)
+		assert.Contains(t, kinds, *offStatus.ProviderPaymentID+" "+models.MismatchStatus)
+		assert.Contains(t, kinds, *offStatus.ProviderPaymentID+" "+models.MismatchAmount)
+		assert.Contains(t, kinds, *unsettled.ProviderPaymentID+" "+models.MismatchMissingInSettlement)
+		assert.Contains(t, kinds, "fake_unknown "+models.MismatchMissingLocally)
+
+		w = send("GET", "/api/payments/mismatches", "", userAccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("Remind: by SMS without Telegram, and again after a failed send", func(t *testing.T) {
+		subscriber := generateUser(random, router, t, "")
+		now := time.Now()
+
+		subscription := models.Subscription{
+			UserID:    subscriber.ID,
+			Tier:      "expert",
+			PaymentID: uuid.New(),
+			StartedAt: now,
+			ExpiresAt: now.Add(30 * 24 * time.Hour),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		assert.NoError(t, pc.DB.Create(&subscription).Error)
+
+		remindedAt := func() *time.Time {
+			var reminded models.Subscription
+			assert.NoError(t, pc.DB.First(&reminded, "id = ?", subscription.ID).Error)
+			return reminded.RemindedAt
+		}
+
+		beforeExpiry := subscription.ExpiresAt.Add(-24 * time.Hour)
+
+		subscriptions.Sender = failingTelegramSender{}
+		_, err := subscriptions.Remind(beforeExpiry)
+		subscriptions.Sender = telegramSender
+		assert.NoError(t, err)
+		assert.Nil(t, remindedAt())
+
+		// the user without Telegram gets an SMS
+		assert.NoError(t, pc.DB.Model(&models.User{}).Where("id = ?", subscriber.ID).Update("telegram_user_id", 0).Error)
+		defer pc.DB.Model(&models.User{}).Where("id = ?", subscriber.ID).Update("telegram_user_id", subscriber.TelegramUserID)
+
+		_, err = subscriptions.Remind(beforeExpiry)
+		assert.NoError(t, err)
+		assert.NotNil(t, remindedAt())
+		assert.Contains(t, smsSender.messages[subscriber.Phone], "expert subscription expires")
+	})
+}
+
+func TestBoosts(t *testing.T) {
+
+	ac := SetupAuthController()
+	subscriptions := utils.NewSubscriptionService(nil, &memoryTelegramSender{messages: map[int64]string{}}, &memorySmsSender{messages: map[string]string{}}, 30*24*time.Hour, 3*24*time.Hour)
+	pc := SetupPaymentController(subscriptions)
+	router := SetupPaymentRouter(&ac, &pc)
+	profileController := controllers.NewProfileController("", pc.DB, utils.NewEntitlementService(pc.DB))
+	profileRouter := SetupPCRouter(&profileController)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	send := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	complete := func(paymentID uuid.UUID, status string) {
+		var payment models.Payment
+		assert.NoError(t, pc.DB.First(&payment, "id = ?", paymentID).Error)
+
+		body := fmt.Sprintf(`{"id": "%s", "paymentId": "%s", "status": "%s"}`, uuid.NewString(), *payment.ProviderPaymentID, status)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/payments/webhook", strings.NewReader(body))
+		req.Header.Set("X-Payment-Signature", testPaymentProvider.Sign([]byte(body)))
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	city := models.City{Name: fmt.Sprintf("boost-%d", random.IntN(1000000)), AliasRu: "Буст", AliasEn: "Boost"}
+	assert.NoError(t, pc.DB.Create(&city).Error)
+
+	owner := generateUser(random, router, t, "")
+	ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserID, router)
+	assert.NoError(t, err)
+
+	stranger := generateUser(random, router, t, "")
+	strangerAccessToken, err := loginUserGetAccessToken(t, stranger.Password, stranger.TelegramUserID, router)
+	assert.NoError(t, err)
+
+	profiles := make([]models.Profile, 3)
+	for i := range profiles {
+		now := time.Now().Add(time.Duration(i) * time.Minute)
+		profiles[i] = models.Profile{
+			UserID: owner.ID, CityID: city.ID, Sex: "female", Active: true,
+			Name: fmt.Sprintf("Profile %d", i), Age: 25, Height: 170, Weight: 55,
+			UpdatedBy: owner.ID, CreatedAt: now, UpdatedAt: now,
+		}
+	}
+	assert.NoError(t, pc.DB.Omit(clause.Associations).Create(&profiles).Error)
+
+	buy := func(profileID uuid.UUID, plan string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		return send("POST", "/api/payments/boosts", fmt.Sprintf(`{"profileId": "%s", "plan": "%s"}`, profileID, plan), accessTokenCookie)
+	}
+
+	listed := func() []models.ProfileResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/profiles/list?city=%d&sex=female&limit=10", city.ID), nil)
+		profileRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var listResponse models.SuccessPageResponse[[]models.ProfileResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listResponse))
+		return listResponse.Data
+	}
+
+	t.Run("POST /api/payments/boosts: only for own profiles and known plans", func(t *testing.T) {
+		w := buy(profiles[0].ID, "one_hour", strangerAccessToken)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = buy(profiles[0].ID, "forever", ownerAccessToken)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = buy(profiles[0].ID, "one_hour", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = send("GET", "/api/payments/boosts/plans", "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var plansResponse models.SuccessResponse[[]models.BoostPlanResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &plansResponse))
+		assert.Equal(t, "one_hour", plansResponse.Data[0].Name)
+	})
+
+	t.Run("POST /api/payments/boosts: a paid boost lists the profile first", func(t *testing.T) {
+		// newest first without boosts
+		assert.Equal(t, profiles[2].ID.String(), listed()[0].ID)
+
+		w := buy(profiles[0].ID, "one_hour", ownerAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var checkoutResponse models.SuccessResponse[models.CheckoutResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &checkoutResponse))
+
+		// not before it is paid
+		assert.Equal(t, profiles[2].ID.String(), listed()[0].ID)
+
+		complete(checkoutResponse.Data.PaymentID, models.PaymentCompleted)
+
+		first := listed()[0]
+		assert.Equal(t, profiles[0].ID.String(), first.ID)
+		assert.True(t, first.Promoted)
+
+		// a second boost queues up after the first one
+		w = buy(profiles[0].ID, "three_hours", ownerAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var renewalResponse models.SuccessResponse[models.CheckoutResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &renewalResponse))
+		complete(renewalResponse.Data.PaymentID, models.PaymentCompleted)
+
+		w = send("GET", fmt.Sprintf("/api/payments/boosts?profileId=%s", profiles[0].ID), "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var boostsResponse models.SuccessPageResponse[[]models.BoostResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &boostsResponse))
+		if assert.Len(t, boostsResponse.Data, 2) {
+			queued, running := boostsResponse.Data[0], boostsResponse.Data[1]
+			assert.True(t, running.Active)
+			assert.False(t, queued.Active)
+			assert.Equal(t, running.EndsAt.Unix(), queued.StartsAt.Unix())
+			assert.Equal(t, 3*time.Hour, queued.EndsAt.Sub(queued.StartsAt))
+		}
+
+		w = send("GET", "/api/payments/boosts", "", strangerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &boostsResponse))
+		assert.Empty(t, boostsResponse.Data)
+
+		// a refund ends the running boost
+		complete(checkoutResponse.Data.PaymentID, models.PaymentRefunded)
+		assert.False(t, listed()[0].Promoted)
+	})
+
+	t.Run("GET /api/profiles/list: promoted profiles take turns on top", func(t *testing.T) {
+		now := time.Now()
+
+		for _, profile := range profiles[1:] {
+			payment := models.Payment{UserID: owner.ID, Amount: 1, Status: models.PaymentCompleted, Type: "one_hour",
+				ProfileID: &profile.ID, CreatedAt: now, UpdatedAt: now, PaymentDate: now}
+			assert.NoError(t, pc.DB.Create(&payment).Error)
+
+			_, err := utils.GrantBoost(pc.DB, payment, now)
+			assert.NoError(t, err)
+		}
+
+		page := listed()
+		assert.True(t, page[0].Promoted)
+		assert.True(t, page[1].Promoted)
+		assert.False(t, page[2].Promoted)
+		assert.Equal(t, profiles[0].ID.String(), page[2].ID)
+	})
+}
+
+// failingTelegramSender fails to send every message.
+type failingTelegramSender struct{}
+
+func (failingTelegramSender) SendMessage(chatID int64, text string) error {
+	return errors.New("telegram is unavailable")
+}
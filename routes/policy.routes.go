@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/middleware"
+)
+
+type PolicyRouteController struct {
+	policyController controllers.PolicyController
+}
+
+func NewRoutePolicyController(policyController controllers.PolicyController) PolicyRouteController {
+	return PolicyRouteController{policyController}
+}
+
+// @BasePath /api/v1/policies
+
+func (pc *PolicyRouteController) PolicyRoute(rg *gin.RouterGroup) {
+	router := rg.Group("policies")
+
+	// every route is checked against the access policy, so API keys are taken
+	router.Use(middleware.DeserializeUserOrApiKey())
+
+	router.GET("", middleware.AbacMiddleware("policies", "list"), pc.policyController.ListPolicyRules)
+	router.GET("/explain", middleware.AbacMiddleware("policies", "explain"), pc.policyController.ExplainPolicy)
+	router.POST("", middleware.DenyImpersonation(), middleware.AbacMiddleware("policies", "add"), pc.policyController.AddPolicyRule)
+	router.PUT("", middleware.DenyImpersonation(), middleware.AbacMiddleware("policies", "update"), pc.policyController.UpdatePolicyRule)
+	router.DELETE("", middleware.DenyImpersonation(), middleware.AbacMiddleware("policies", "remove"), pc.policyController.RemovePolicyRule)
+}
@@ -0,0 +1,214 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SetupPolicyRouter sets up the router for testing.
+func SetupPolicyRouter(authController *controllers.AuthController, policyController *controllers.PolicyController) *gin.Engine {
+	r := gin.Default()
+
+	authRouteController := NewAuthRouteController(*authController)
+	policyRouteController := NewRoutePolicyController(*policyController)
+
+	api := r.Group("/api")
+	authRouteController.AuthRoute(api)
+	policyRouteController.PolicyRoute(api)
+
+	return r
+}
+
+func TestPolicyRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	uc := SetupUCController()
+
+	// reload quickly, so changes of other replicas are seen within the test
+	config, err := initializers.LoadConfig("../.")
+	if err != nil {
+		log.Fatal("🚀 Could not load environment variables", err)
+	}
+	config.CasbinReloadInterval = 100 * time.Millisecond
+	initializers.InitCasbin(&config)
+
+	pc := controllers.NewPolicyController(initializers.DB)
+	router := SetupPolicyRouter(&ac, &pc)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	sendJSON := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	owner := createOwnerUser(uc.DB)
+	ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, router)
+	assert.NoError(t, err)
+
+	rule := `{"sub": "user", "obj": "policy-tests", "act": "read", "tier": "*", "hasProfile": "*", "twoFactor": "*"}`
+	updatedRule := `{"sub": "user", "obj": "policy-tests", "act": "write", "tier": "*", "hasProfile": "*", "twoFactor": "*"}`
+
+	t.Cleanup(func() {
+		_, _ = initializers.Enforcer.RemoveFilteredPolicy(1, "policy-tests")
+	})
+
+	t.Run("GET /api/policies: lists the rules page by page", func(t *testing.T) {
+		w := sendJSON("GET", "/api/policies?limit=100&total=true", "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var rulesResponse models.SuccessPageResponse[[]models.PolicyRule]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rulesResponse))
+		assert.Contains(t, rulesResponse.Data, models.PolicyRule{Sub: "owner", Obj: "*", Act: "*", Tier: "*", HasProfile: "*", TwoFactor: "true"})
+		policy, err := initializers.Enforcer.GetPolicy()
+		assert.NoError(t, err)
+		if assert.NotNil(t, rulesResponse.Total) {
+			assert.Equal(t, int64(len(policy)), *rulesResponse.Total)
+		}
+
+		w = sendJSON("GET", "/api/policies?limit=1", "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var firstResponse models.SuccessPageResponse[[]models.PolicyRule]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &firstResponse))
+		assert.Len(t, firstResponse.Data, 1)
+		assert.NotEmpty(t, firstResponse.NextCursor)
+
+		w = sendJSON("GET", "/api/policies?limit=1&cursor="+firstResponse.NextCursor, "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var secondResponse models.SuccessPageResponse[[]models.PolicyRule]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &secondResponse))
+		if assert.Len(t, secondResponse.Data, 1) && len(firstResponse.Data) == 1 {
+			assert.NotEqual(t, firstResponse.Data[0], secondResponse.Data[0])
+		}
+
+		w = sendJSON("GET", "/api/policies?limit=101", "", ownerAccessToken)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("POST + PUT + DELETE /api/policies: rules are changed and saved", func(t *testing.T) {
+		w := sendJSON("POST", "/api/policies", rule, ownerAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = sendJSON("POST", "/api/policies", rule, ownerAccessToken)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		allowed, err := initializers.Enforcer.Enforce("user", "policy-tests", "read", "basic", "false", "false", "false", utils.AllScopes)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+
+		var stored int64
+		ac.DB.Model(&models.CasbinRule{}).Where("ptype = ? AND v1 = ? AND v2 = ?", "p", "policy-tests", "read").Count(&stored)
+		assert.Equal(t, int64(1), stored)
+
+		w = sendJSON("PUT", "/api/policies", `{"old": `+rule+`, "new": `+updatedRule+`}`, ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		allowed, _ = initializers.Enforcer.Enforce("user", "policy-tests", "read", "basic", "false", "false", "false", utils.AllScopes)
+		assert.False(t, allowed)
+
+		w = sendJSON("DELETE", "/api/policies", updatedRule, ownerAccessToken)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = sendJSON("DELETE", "/api/policies", updatedRule, ownerAccessToken)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		ac.DB.Model(&models.CasbinRule{}).Where("ptype = ? AND v1 = ?", "p", "policy-tests").Count(&stored)
+		assert.Equal(t, int64(0), stored)
+	})
+
+	t.Run("POST /api/policies: only owners change rules of admins, users change nothing", func(t *testing.T) {
+		user := generateUser(random, router, t, "guru")
+
+		userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("POST", "/api/policies", rule, userAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		admin := generateUser(random, router, t, "")
+		assert.NoError(t, ac.DB.Model(&models.User{}).Where("id = ?", admin.ID).Updates(map[string]interface{}{"role": "admin", "tier": "guru"}).Error)
+
+		adminAccessToken, err := loginUserGetAccessToken(t, admin.Password, admin.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w = sendJSON("POST", "/api/policies", `{"sub": "admin", "obj": "policy-tests", "act": "read", "tier": "*", "hasProfile": "*", "twoFactor": "*"}`, adminAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendJSON("POST", "/api/policies", rule, adminAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = sendJSON("DELETE", "/api/policies", rule, adminAccessToken)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("GET /api/policies/explain: tells the rule and the failing attributes", func(t *testing.T) {
+		user := generateUser(random, router, t, "expert")
+
+		var explanation models.SuccessResponse[models.PolicyExplanation]
+
+		w := sendJSON("GET", fmt.Sprintf("/api/policies/explain?userId=%s&obj=users&act=list", user.ID), "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+
+		assert.True(t, explanation.Data.Allowed)
+		assert.Equal(t, &models.PolicyRule{Sub: "user", Obj: "users", Act: "list", Tier: "expert", HasProfile: "false", TwoFactor: "*"}, explanation.Data.Rule)
+		assert.Equal(t, "expert", explanation.Data.Request.Tier)
+
+		explanation = models.SuccessResponse[models.PolicyExplanation]{}
+
+		w = sendJSON("GET", fmt.Sprintf("/api/policies/explain?userId=%s&obj=services&act=list", user.ID), "", ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+
+		assert.False(t, explanation.Data.Allowed)
+		assert.Nil(t, explanation.Data.Rule)
+		assert.Contains(t, explanation.Data.Mismatches, models.PolicyRuleMismatch{
+			Rule:   models.PolicyRule{Sub: "user", Obj: "services", Act: "list", Tier: "guru", HasProfile: "false", TwoFactor: "*"},
+			Fields: []string{"tier"},
+		})
+
+		userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w = sendJSON("GET", fmt.Sprintf("/api/policies/explain?userId=%s&obj=users&act=list", user.ID), "", userAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendJSON("GET", "/api/policies/explain?obj=users&act=list", "", ownerAccessToken)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("changes made by another replica are picked up", func(t *testing.T) {
+		// another replica writes the rule and bumps the revision
+		otherReplica, err := utils.NewPolicyWatcher(ac.DB, time.Hour)
+		assert.NoError(t, err)
+		defer otherReplica.Close()
+
+		assert.NoError(t, utils.NewGormAdapter(ac.DB).AddPolicy("p", "p", []string{"user", "policy-tests", "read", "*", "*", "*"}))
+		assert.NoError(t, otherReplica.Update())
+
+		assert.Eventually(t, func() bool {
+			allowed, _ := initializers.Enforcer.Enforce("user", "policy-tests", "read", "basic", "false", "false", "false", utils.AllScopes)
+			return allowed
+		}, 2*time.Second, 50*time.Millisecond)
+	})
+}
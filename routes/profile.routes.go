@@ -24,19 +24,20 @@ func (pc *ProfileRouteController) ProfileRoute(rg *gin.RouterGroup) {
 
 	router.GET("/my", middleware.DeserializeUser(), pc.profileController.GetMyProfiles)
 
-	router.GET("", middleware.DeserializeUser(), middleware.AbacMiddleware("profiles", "query"), pc.profileController.FindProfiles)
+	router.GET("", middleware.DeserializeUserOrApiKey(), middleware.AbacMiddleware("profiles", "query"), pc.profileController.FindProfiles)
 
 	router.GET("/list", pc.profileController.ListProfilesNonAuth)
-	router.GET("/all", middleware.DeserializeUser(), middleware.AbacMiddleware("profiles", "list"), pc.profileController.ListProfiles)
+	router.GET("/all", middleware.DeserializeUserOrApiKey(), middleware.AbacMiddleware("profiles", "list"), pc.profileController.ListProfiles)
 
-	router.PUT("/my/:id", middleware.DeserializeUser(), pc.profileController.UpdateOwnProfile)
-	router.POST("/:id/photos", middleware.DeserializeUser(), pc.profileController.UpdateProfilePhotos)
+	router.PUT("/my/:id", middleware.DeserializeUserOrApiKey(), middleware.AbacMiddleware("profiles", "edit", middleware.ProfileOwner("id")), pc.profileController.UpdateOwnProfile)
+	router.POST("/:id/photos", middleware.DeserializeUserOrApiKey(), middleware.AbacMiddleware("photos", "update", middleware.ProfileOwner("id")), pc.profileController.UpdateProfilePhotos)
 
-	router.PUT("/update/:id", middleware.DeserializeUser(), middleware.AbacMiddleware("profiles", "update"), pc.profileController.UpdateProfile)
+	router.PUT("/update/:id", middleware.DeserializeUserOrApiKey(), middleware.AbacMiddleware("profiles", "update"), pc.profileController.UpdateProfile)
 
 	// todo: should have captcha set
 	// todo: should have rate limiter set
 	router.GET("/:id", middleware.DeserializeUser(), pc.profileController.FindProfileByID)
+	router.POST("/:id/contacts", middleware.DeserializeUser(), pc.profileController.RevealContacts)
 
-	router.DELETE("/:id", middleware.DeserializeUser(), pc.profileController.DeleteProfile)
+	router.DELETE("/:id", middleware.DeserializeUserOrApiKey(), middleware.DenyImpersonation(), middleware.AbacMiddleware("profiles", "delete", middleware.ProfileOwner("id")), pc.profileController.DeleteProfile)
 }
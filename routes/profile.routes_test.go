@@ -8,11 +8,13 @@ import (
 	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"github.com/stretchr/testify/assert"
 	"log"
 	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -38,7 +40,7 @@ func SetupPCController() controllers.ProfileController {
 	initializers.ConnectDB(&config)
 	initializers.InitCasbin(&config)
 
-	profileController := controllers.NewProfileController(config.ParsedBaseUrl, initializers.DB)
+	profileController := controllers.NewProfileController(config.ParsedBaseUrl, initializers.DB, utils.NewEntitlementService(initializers.DB))
 	profileController.DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
 
 	if err := profileController.DB.AutoMigrate(
@@ -56,10 +58,20 @@ func SetupPCController() controllers.ProfileController {
 		&models.ProfileOption{},
 		&models.UserRating{},
 		&models.ProfileRating{},
+		&models.Tier{},
+		&models.ContactReveal{},
 		&models.ProfileTag{}); err != nil {
 		panic("failed to migrate database: " + err.Error())
 	}
 
+	if err := utils.CreateProfileSearchTriggers(profileController.DB); err != nil {
+		panic("failed to create profile search triggers: " + err.Error())
+	}
+
+	if err := utils.SeedTiers(profileController.DB); err != nil {
+		panic("failed to seed tiers: " + err.Error())
+	}
+
 	return profileController
 }
 
@@ -674,6 +686,279 @@ func TestProfileRoutes(t *testing.T) {
 		assert.Len(t, profilesResponse.Data, profilesResponse.Length)
 	})
 
+	t.Run("GET /api/profiles: profiles within the radius, nearest first", func(t *testing.T) {
+		searcher := generateUser(random, authRouter, t, "")
+		assert.NoError(t, initializers.DB.Model(&models.User{}).Where("id = ?", searcher.ID).Update("tier", "guru").Error)
+
+		searcherAccessTokenCookie, err := loginUserGetAccessToken(t, searcher.Password, searcher.TelegramUserID, authRouter)
+		assert.NoError(t, err)
+
+		createAt := func(latitude float64, longitude float64) string {
+			owner := generateUser(random, authRouter, t, "")
+			ownerAccessTokenCookie, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserID, authRouter)
+			assert.NoError(t, err)
+
+			payload := generateCreateProfileRequest(random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors, intimateHairCuts)
+			payload.AddressLatitude = &latitude
+			payload.AddressLongitude = &longitude
+
+			jsonPayload, _ := json.Marshal(payload)
+
+			req, _ := http.NewRequest("POST", "/api/profiles/", bytes.NewBuffer(jsonPayload))
+			req.AddCookie(&http.Cookie{Name: ownerAccessTokenCookie.Name, Value: ownerAccessTokenCookie.Value})
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusCreated, w.Code)
+
+			var profileResponse CreateProfileResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &profileResponse))
+
+			return profileResponse.Data.ID.String()
+		}
+
+		// far off coordinates keep the profiles of other tests out
+		near := createAt(-54.0, -120.0)
+		nearby := createAt(-54.04, -120.0)
+		createAt(-55.0, -120.0)
+
+		search := func(query string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("GET", "/api/profiles?page=1&limit=10", strings.NewReader(query))
+			req.AddCookie(&http.Cookie{Name: searcherAccessTokenCookie.Name, Value: searcherAccessTokenCookie.Value})
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+			return w
+		}
+
+		w := search(`{"latitude": -54.001, "longitude": -120.0, "radiusKm": 10, "sortBy": "distance"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var profilesResponse ProfilesResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &profilesResponse))
+
+		if assert.Len(t, profilesResponse.Data, 2) {
+			assert.Equal(t, near, profilesResponse.Data[0].ID)
+			assert.Equal(t, nearby, profilesResponse.Data[1].ID)
+
+			// only the rounded distance is told
+			assert.Equal(t, float64(1), *profilesResponse.Data[0].Distance)
+			assert.Equal(t, float64(5), *profilesResponse.Data[1].Distance)
+			assert.Nil(t, profilesResponse.Data[0].AddressLatitude)
+			assert.Nil(t, profilesResponse.Data[0].AddressLongitude)
+		}
+
+		// staff see the contacts, but not where the profiles are either
+		moderator := generateUser(random, authRouter, t, "")
+		_ = assignRole(initializers.DB, t, authRouter, userRouter, moderator.ID.String(), "moderator")
+		assert.NoError(t, initializers.DB.Model(&models.User{}).Where("id = ?", moderator.ID).Update("tier", "guru").Error)
+
+		moderatorAccessTokenCookie, err := loginUserGetAccessToken(t, moderator.Password, moderator.TelegramUserID, authRouter)
+		assert.NoError(t, err)
+
+		req, _ := http.NewRequest("GET", "/api/profiles?page=1&limit=10", strings.NewReader(`{"latitude": -54.001, "longitude": -120.0, "radiusKm": 10}`))
+		req.AddCookie(&http.Cookie{Name: moderatorAccessTokenCookie.Name, Value: moderatorAccessTokenCookie.Value})
+		req.Header.Set("Content-Type", "application/json")
+
+		w = httptest.NewRecorder()
+		profileRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &profilesResponse))
+		if assert.Len(t, profilesResponse.Data, 2) {
+			for _, profile := range profilesResponse.Data {
+				assert.NotNil(t, profile.Distance)
+				assert.Nil(t, profile.AddressLatitude)
+				assert.Nil(t, profile.AddressLongitude)
+			}
+		}
+
+		w = search(`{"longitude": -120.0, "radiusKm": 10}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = search(`{"sortBy": "distance"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = search(`{"latitude": -54.0, "longitude": -120.0, "radiusKm": 5000}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("GET /api/profiles: keyword search ranks and highlights matches", func(t *testing.T) {
+		searcher := generateUser(random, authRouter, t, "")
+		assert.NoError(t, initializers.DB.Model(&models.User{}).Where("id = ?", searcher.ID).Update("tier", "guru").Error)
+
+		searcherAccessTokenCookie, err := loginUserGetAccessToken(t, searcher.Password, searcher.TelegramUserID, authRouter)
+		assert.NoError(t, err)
+
+		word := "quokka"
+		for range 6 {
+			word += string(rune('a' + random.IntN(26)))
+		}
+
+		create := func(payload models.CreateProfileRequest) string {
+			owner := generateUser(random, authRouter, t, "")
+			ownerAccessTokenCookie, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserID, authRouter)
+			assert.NoError(t, err)
+
+			jsonPayload, _ := json.Marshal(payload)
+
+			req, _ := http.NewRequest("POST", "/api/profiles/", bytes.NewBuffer(jsonPayload))
+			req.AddCookie(&http.Cookie{Name: ownerAccessTokenCookie.Name, Value: ownerAccessTokenCookie.Value})
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusCreated, w.Code)
+
+			var profileResponse CreateProfileResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &profileResponse))
+
+			return profileResponse.Data.ID.String()
+		}
+
+		// the word in the bio weighs more than in an option comment
+		inBio := generateCreateProfileRequest(random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors, intimateHairCuts)
+		inBio.Bio = "Привет! Люблю читать книги и гулять по вечерам, а ещё у меня есть " + word + " <дома>."
+		inBioID := create(inBio)
+
+		inComment := generateCreateProfileRequest(random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors, intimateHairCuts)
+		inComment.Options[0].Comment = "Ask about " + word
+		inCommentID := create(inComment)
+
+		search := func(query string) ProfilesResponse {
+			req, _ := http.NewRequest("GET", "/api/profiles?page=1&limit=10", strings.NewReader(query))
+			req.AddCookie(&http.Cookie{Name: searcherAccessTokenCookie.Name, Value: searcherAccessTokenCookie.Value})
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var profilesResponse ProfilesResponse
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &profilesResponse))
+			return profilesResponse
+		}
+
+		found := search(fmt.Sprintf(`{"search": "%s"}`, word))
+		if assert.Len(t, found.Data, 2) {
+			assert.Equal(t, inBioID, found.Data[0].ID)
+			assert.Equal(t, inCommentID, found.Data[1].ID)
+			assert.Contains(t, found.Data[0].Highlight, "<mark>"+word+"</mark>")
+			assert.Contains(t, found.Data[0].Highlight, "&lt;дома&gt;")
+			assert.Contains(t, found.Data[1].Highlight, "<mark>"+word+"</mark>")
+		}
+
+		// Russian words match in other forms
+		found = search(fmt.Sprintf(`{"search": "%s книга"}`, word))
+		if assert.Len(t, found.Data, 1) {
+			assert.Equal(t, inBioID, found.Data[0].ID)
+			assert.Contains(t, found.Data[0].Highlight, "<mark>книги</mark>")
+		}
+
+		// and so do English words
+		inEnglish := generateCreateProfileRequest(random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors, intimateHairCuts)
+		inEnglish.Bio = "Loves dancing with " + word + " till late"
+		inEnglishID := create(inEnglish)
+
+		found = search(fmt.Sprintf(`{"search": "%s dances"}`, word))
+		if assert.Len(t, found.Data, 1) {
+			assert.Equal(t, inEnglishID, found.Data[0].ID)
+			assert.Contains(t, found.Data[0].Highlight, "<mark>dancing</mark>")
+		}
+
+		// comments changed anywhere are searched by their new words
+		other := word + "x"
+		assert.NoError(t, initializers.DB.Model(&models.ProfileOption{}).
+			Where("profile_id = ?", inCommentID).
+			Update("comment", "Ask about "+other).Error)
+
+		found = search(fmt.Sprintf(`{"search": "%s"}`, other))
+		if assert.Len(t, found.Data, 1) {
+			assert.Equal(t, inCommentID, found.Data[0].ID)
+		}
+	})
+
+	t.Run("GET /api/profiles: facets count the values of each filter under the others", func(t *testing.T) {
+		searcher := generateUser(random, authRouter, t, "")
+		assert.NoError(t, initializers.DB.Model(&models.User{}).Where("id = ?", searcher.ID).Update("tier", "guru").Error)
+
+		searcherAccessTokenCookie, err := loginUserGetAccessToken(t, searcher.Password, searcher.TelegramUserID, authRouter)
+		assert.NoError(t, err)
+
+		// a word of their own keeps the profiles of other tests out
+		word := "wombat"
+		for range 6 {
+			word += string(rune('a' + random.IntN(26)))
+		}
+
+		create := func(city models.City, ethnosSet models.Ethnos, price int) {
+			owner := generateUser(random, authRouter, t, "")
+			ownerAccessTokenCookie, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserID, authRouter)
+			assert.NoError(t, err)
+
+			payload := generateCreateProfileRequest(random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors, intimateHairCuts)
+			payload.Bio = "Ask about " + word
+			payload.CityID = city.ID
+			payload.EthnosID = &ethnosSet.ID
+			payload.Sex = ethnosSet.Sex
+			payload.PriceInHouseHour = &price
+
+			jsonPayload, _ := json.Marshal(payload)
+
+			req, _ := http.NewRequest("POST", "/api/profiles/", bytes.NewBuffer(jsonPayload))
+			req.AddCookie(&http.Cookie{Name: ownerAccessTokenCookie.Name, Value: ownerAccessTokenCookie.Value})
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusCreated, w.Code)
+		}
+
+		create(cities[0], ethnos[0], 20000)
+		create(cities[0], ethnos[1], 26000)
+		create(cities[1], ethnos[0], 21000)
+
+		query := fmt.Sprintf(`{"search": "%s", "cityId": %d, "facets": true}`, word, cities[0].ID)
+
+		req, _ := http.NewRequest("GET", "/api/profiles?page=1&limit=10", strings.NewReader(query))
+		req.AddCookie(&http.Cookie{Name: searcherAccessTokenCookie.Name, Value: searcherAccessTokenCookie.Value})
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		profileRouter.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			ProfilesResponse
+			Facets *models.ProfileFacets `json:"facets"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+		assert.Len(t, response.Data, 2)
+
+		if assert.NotNil(t, response.Facets) {
+			// the city filter leaves its own counts alone
+			assert.ElementsMatch(t, []models.FacetCount{
+				{Value: cities[0].ID, Count: 2},
+				{Value: cities[1].ID, Count: 1},
+			}, response.Facets.Cities)
+
+			assert.ElementsMatch(t, []models.FacetCount{
+				{Value: ethnos[0].ID, Count: 1},
+				{Value: ethnos[1].ID, Count: 1},
+			}, response.Facets.Ethnoses)
+
+			assert.Contains(t, response.Facets.BodyArts, models.FacetCount{Value: bodyArts[0].ID, Count: 2})
+
+			assert.Equal(t, []models.PriceBucket{
+				{From: 20000, To: 25000, Count: 1},
+				{From: 25000, To: 30000, Count: 1},
+			}, response.Facets.Prices["inHouseHour"])
+		}
+	})
+
 	t.Run("GET /api/profiles: fail query other user's profile / user:basic", func(t *testing.T) {
 		user := generateUser(random, authRouter, t, "")
 		secondUser := generateUser(random, authRouter, t, "")
@@ -963,8 +1248,12 @@ func TestProfileRoutes(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Equal(t, payload.Phone, findProfileResponse.Data.Phone)
+
+		// others reveal the contacts on their own
+		hidden := payload
+		hidden.ContactPhone, hidden.ContactTG, hidden.ContactWA = "", "", ""
 		checkProfilesMatch(t, user.ID.String(),
-			payload, findProfileResponse, true, false, false)
+			hidden, findProfileResponse, true, false, false)
 
 	})
 
@@ -1110,6 +1399,51 @@ func TestProfileRoutes(t *testing.T) {
 		assert.JSONEq(t, "{\"message\":\"You are not logged in\",\"status\":\"error\"}", w.Body.String())
 	})
 
+	t.Run("PUT /api/profiles/my/id + POST /api/profiles/id/photos + DELETE /api/profiles/id: fail on other user's profile", func(t *testing.T) {
+		user := generateUser(random, authRouter, t, "")
+		otherUser := generateUser(random, authRouter, t, "guru")
+
+		accessTokenCookie, _ := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, authRouter)
+		otherAccessTokenCookie, _ := loginUserGetAccessToken(t, otherUser.Password, otherUser.TelegramUserID, authRouter)
+
+		profile, _ := createProfile(t, random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors,
+			intimateHairCuts, accessTokenCookie, profileRouter, user.ID.String())
+
+		requests := []struct {
+			method  string
+			url     string
+			payload string
+		}{
+			{"PUT", fmt.Sprintf("/api/profiles/my/%s", profile.Data.ID), `{"name": "taken over"}`},
+			{"POST", fmt.Sprintf("/api/profiles/%s/photos", profile.Data.ID), `{"photos": []}`},
+			{"DELETE", fmt.Sprintf("/api/profiles/%s", profile.Data.ID), ""},
+		}
+
+		for _, request := range requests {
+			req, _ := http.NewRequest(request.method, request.url, bytes.NewBufferString(request.payload))
+			req.AddCookie(&http.Cookie{Name: otherAccessTokenCookie.Name, Value: otherAccessTokenCookie.Value})
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code, request.url)
+		}
+
+		var stillThere models.Profile
+		assert.NoError(t, pc.DB.First(&stillThere, "id = ?", profile.Data.ID).Error)
+		assert.Equal(t, profile.Data.Name, stillThere.Name)
+
+		// a profile that doesn't exist isn't anybody's
+		req, _ := http.NewRequest("DELETE", "/api/profiles/00000000-0000-0000-0000-000000000000", nil)
+		req.AddCookie(&http.Cookie{Name: otherAccessTokenCookie.Name, Value: otherAccessTokenCookie.Value})
+
+		w := httptest.NewRecorder()
+		profileRouter.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
 	t.Run("GET /api/profiles/list: success list non authorized user", func(t *testing.T) {
 		user := generateUser(random, authRouter, t, "")
 		secondUser := generateUser(random, authRouter, t, "")
@@ -1202,5 +1536,68 @@ func TestProfileRoutes(t *testing.T) {
 		}
 
 		assert.True(t, foundInactive)
+
+		// the city listings hold at most 12 profiles a page
+		for _, path := range []string{"/api/profiles/all", "/api/profiles/list"} {
+			req, _ := http.NewRequest("GET", path+"?limit=13", nil)
+			req.AddCookie(&http.Cookie{Name: secondUserAccessTokenCookie.Name, Value: secondUserAccessTokenCookie.Value})
+
+			w = httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		}
+	})
+
+	t.Run("POST /api/profiles/id/contacts: reveals are limited per day by the tier", func(t *testing.T) {
+		user := generateUser(random, authRouter, t, "")
+		viewer := generateUser(random, authRouter, t, "")
+
+		accessTokenCookie, _ := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, authRouter)
+		viewerAccessTokenCookie, _ := loginUserGetAccessToken(t, viewer.Password, viewer.TelegramUserID, authRouter)
+
+		first, _ := createProfile(t, random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors,
+			intimateHairCuts, accessTokenCookie, profileRouter, user.ID.String())
+		second, _ := createProfile(t, random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors,
+			intimateHairCuts, accessTokenCookie, profileRouter, user.ID.String())
+
+		reveal := func(profileID string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("POST", fmt.Sprintf("/api/profiles/%s/contacts", profileID), nil)
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+
+			w := httptest.NewRecorder()
+			profileRouter.ServeHTTP(w, req)
+			return w
+		}
+
+		// the basic tier has no reveals
+		w := reveal(first.Data.ID.String(), viewerAccessTokenCookie)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		now := time.Now()
+		tier := models.Tier{Name: "one-reveal", ContactRevealsPerDay: 1, ProfilesAllowed: models.Unlimited, CreatedAt: now, UpdatedAt: now}
+		assert.NoError(t, pc.DB.Where("name = ?", tier.Name).FirstOrCreate(&tier).Error)
+		assert.NoError(t, pc.DB.Model(&models.User{}).Where("id = ?", viewer.ID).Update("tier", tier.Name).Error)
+
+		w = reveal(first.Data.ID.String(), viewerAccessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var contactsResponse models.SuccessResponse[models.ContactsResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &contactsResponse))
+		assert.Equal(t, 0, contactsResponse.Data.RevealsLeft)
+		assert.NotEmpty(t, contactsResponse.Data.Contacts[0].Value)
+
+		// the same profile again is free
+		w = reveal(first.Data.ID.String(), viewerAccessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = reveal(second.Data.ID.String(), viewerAccessTokenCookie)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+		// the owner doesn't count
+		w = reveal(second.Data.ID.String(), accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &contactsResponse))
+		assert.Equal(t, models.Unlimited, contactsResponse.Data.RevealsLeft)
 	})
 }
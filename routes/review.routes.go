@@ -19,12 +19,16 @@ func NewRouteReviewController(serviceController controllers.ServiceController) R
 func (sc *ReviewsRouteController) ReviewsRoute(rg *gin.RouterGroup) {
 	router := rg.Group("reviews")
 
-	router.Use(middleware.DeserializeUser())
+	// every route is checked against the access policy, so API keys are taken
+	router.Use(middleware.DeserializeUserOrApiKey())
 
-	router.PUT("/client", sc.serviceController.UpdateClientUserReviewOnProfile)
-	router.PUT("/client/set-visibility", middleware.AbacMiddleware("reviews", "set-visibility"), sc.serviceController.HideProfileOwnerReview)
+	client := middleware.ServiceClient("serviceId")
+	host := middleware.ServiceProfileOwner("serviceId")
 
-	router.PUT("/host", sc.serviceController.UpdateProfileOwnerReviewOnClientUser)
-	router.PUT("/host/set-visibility", middleware.AbacMiddleware("reviews", "set-visibility"), sc.serviceController.HideUserReview)
+	router.PUT("/client", middleware.AbacMiddleware("reviews", "update", client), sc.serviceController.UpdateClientUserReviewOnProfile)
+	router.PUT("/client/set-visibility", middleware.AbacMiddleware("reviews", "set-visibility", client), sc.serviceController.HideProfileOwnerReview)
+
+	router.PUT("/host", middleware.AbacMiddleware("reviews", "update", host), sc.serviceController.UpdateProfileOwnerReviewOnClientUser)
+	router.PUT("/host/set-visibility", middleware.AbacMiddleware("reviews", "set-visibility", host), sc.serviceController.HideUserReview)
 
 }
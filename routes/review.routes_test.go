@@ -8,6 +8,7 @@ import (
 	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"github.com/stretchr/testify/assert"
 	"log"
 	"math/rand/v2"
@@ -38,7 +39,7 @@ func SetupRCController() controllers.ServiceController {
 	initializers.ConnectDB(&config)
 	initializers.InitCasbin(&config)
 
-	serviceController := controllers.NewServiceController(initializers.DB, config.ReviewUpdateLimitHours)
+	serviceController := controllers.NewServiceController(initializers.DB, config.ReviewUpdateLimitHours, utils.NewEntitlementService(initializers.DB))
 	serviceController.DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
 
 	if err := serviceController.DB.AutoMigrate(
@@ -59,10 +60,15 @@ func SetupRCController() controllers.ServiceController {
 		&models.RatedUserTag{},
 		&models.ProfileRating{},
 		&models.ProfileTag{},
+		&models.Tier{},
 		&models.RatedProfileTag{}); err != nil {
 		panic("failed to migrate database: " + err.Error())
 	}
 
+	if err := utils.SeedTiers(serviceController.DB); err != nil {
+		panic("failed to seed tiers: " + err.Error())
+	}
+
 	return serviceController
 }
 
@@ -864,4 +870,66 @@ func TestReviewsRoutes(t *testing.T) {
 		assert.Equal(t, *updateClientReviewReqBody.Visible, servicesResponse.Data.ClientUserRating.ReviewTextVisible)
 
 	})
+
+	t.Run("PUT /api/reviews: fail updating or hiding the reviews of other users' services", func(t *testing.T) {
+		profileOwner := generateUser(random, authRouter, t, "")
+		clientUser := generateUser(random, authRouter, t, "")
+		stranger := generateUser(random, authRouter, t, "guru")
+
+		accessTokenCookie, _ := loginUserGetAccessToken(t, profileOwner.Password, profileOwner.TelegramUserID, authRouter)
+		strangerAccessTokenCookie, _ := loginUserGetAccessToken(t, stranger.Password, stranger.TelegramUserID, authRouter)
+
+		profile, _ := createProfile(t, random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors,
+			intimateHairCuts, accessTokenCookie, profileRouter, profileOwner.ID.String())
+
+		service, _ := createService(t, clientUser.ID, profile.Data.ID,
+			profileOwner.ID, serviceRouter, accessTokenCookie, userTags, profileTags)
+
+		requests := []struct {
+			url     string
+			payload string
+		}{
+			{"/api/reviews/client", `{"review": "taken over", "score": 1}`},
+			{"/api/reviews/client/set-visibility", `{"visible": false}`},
+			{"/api/reviews/host", `{"review": "taken over", "score": 1}`},
+			{"/api/reviews/host/set-visibility", `{"visible": false}`},
+		}
+
+		for _, request := range requests {
+			updateReq, _ := http.NewRequest("PUT", fmt.Sprintf("%s?serviceId=%s", request.url, service.Data[0].ID), bytes.NewBufferString(request.payload))
+			updateReq.AddCookie(&http.Cookie{Name: strangerAccessTokenCookie.Name, Value: strangerAccessTokenCookie.Value})
+			updateReq.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			reviewRouter.ServeHTTP(w, updateReq)
+
+			assert.Equal(t, http.StatusForbidden, w.Code, request.url)
+		}
+
+		// the profile owner didn't leave the client's review
+		updateReq, _ := http.NewRequest("PUT", fmt.Sprintf("/api/reviews/client?serviceId=%s", service.Data[0].ID), bytes.NewBufferString(`{"review": "taken over", "score": 1}`))
+		updateReq.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		updateReq.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		reviewRouter.ServeHTTP(w, updateReq)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// staff moderate reviews through their visibility, they don't rewrite them
+		owner := createOwnerUser(initializers.DB)
+		ownerAccessTokenCookie, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, authRouter)
+		assert.NoError(t, err)
+
+		for _, url := range []string{"/api/reviews/client", "/api/reviews/host"} {
+			updateReq, _ = http.NewRequest("PUT", fmt.Sprintf("%s?serviceId=%s", url, service.Data[0].ID), bytes.NewBufferString(`{"review": "taken over", "score": 1}`))
+			updateReq.AddCookie(&http.Cookie{Name: ownerAccessTokenCookie.Name, Value: ownerAccessTokenCookie.Value})
+			updateReq.Header.Set("Content-Type", "application/json")
+
+			w = httptest.NewRecorder()
+			reviewRouter.ServeHTTP(w, updateReq)
+
+			assert.Equal(t, http.StatusForbidden, w.Code, url)
+		}
+	})
 }
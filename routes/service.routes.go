@@ -21,11 +21,14 @@ func (sc *ServiceRouteController) ServiceRoute(rg *gin.RouterGroup) {
 
 	router.Use(middleware.DeserializeUser())
 
-	router.POST("/", sc.serviceController.CreateService)
-
 	router.GET("/:profileID", sc.serviceController.GetProfileServices)
 
 	router.GET("/:profileID/service/:serviceID", sc.serviceController.GetService)
 
-	router.GET("/all", middleware.AbacMiddleware("services", "list"), sc.serviceController.ListServices)
+	// routes checked against the access policy also take API keys
+	scoped := rg.Group("services", middleware.DeserializeUserOrApiKey())
+
+	scoped.POST("/", middleware.AbacMiddleware("services", "create", middleware.ServiceParties()), sc.serviceController.CreateService)
+
+	scoped.GET("/all", middleware.AbacMiddleware("services", "list"), sc.serviceController.ListServices)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
 	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
 	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
 	"github.com/stretchr/testify/assert"
 	"log"
 	"math/rand/v2"
@@ -39,7 +40,7 @@ func SetupSCController() controllers.ServiceController {
 	initializers.ConnectDB(&config)
 	initializers.InitCasbin(&config)
 
-	serviceController := controllers.NewServiceController(initializers.DB, config.ReviewUpdateLimitHours)
+	serviceController := controllers.NewServiceController(initializers.DB, config.ReviewUpdateLimitHours, utils.NewEntitlementService(initializers.DB))
 	serviceController.DB.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"")
 
 	if err := serviceController.DB.AutoMigrate(
@@ -60,10 +61,15 @@ func SetupSCController() controllers.ServiceController {
 		&models.RatedUserTag{},
 		&models.ProfileRating{},
 		&models.ProfileTag{},
+		&models.Tier{},
 		&models.RatedProfileTag{}); err != nil {
 		panic("failed to migrate database: " + err.Error())
 	}
 
+	if err := utils.SeedTiers(serviceController.DB); err != nil {
+		panic("failed to seed tiers: " + err.Error())
+	}
+
 	return serviceController
 }
 
@@ -308,6 +314,21 @@ func TestServiceRoutes(t *testing.T) {
 		assert.Equal(t, http.StatusCreated, w.Code)
 	})
 
+	t.Run("POST /api/services/: malformed body is a bad request", func(t *testing.T) {
+		client := generateUser(random, authRouter, t, "")
+		clientAccessTokenCookie, _ := loginUserGetAccessToken(t, client.Password, client.TelegramUserID, authRouter)
+
+		w := httptest.NewRecorder()
+
+		createServiceReq, _ := http.NewRequest("POST", "/api/services/", bytes.NewBufferString(`{"profileId": `))
+		createServiceReq.AddCookie(&http.Cookie{Name: clientAccessTokenCookie.Name, Value: clientAccessTokenCookie.Value})
+		createServiceReq.Header.Set("Content-Type", "application/json")
+
+		serviceRouter.ServeHTTP(w, createServiceReq)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
 	t.Run("POST /api/services/: success with profile author's access_token", func(t *testing.T) {
 		user := generateUser(random, authRouter, t, "")
 		client := generateUser(random, authRouter, t, "")
@@ -373,6 +394,53 @@ func TestServiceRoutes(t *testing.T) {
 
 	})
 
+	t.Run("POST /api/services/: fail for somebody who is neither the client nor the profile owner", func(t *testing.T) {
+		user := generateUser(random, authRouter, t, "")
+		client := generateUser(random, authRouter, t, "")
+		stranger := generateUser(random, authRouter, t, "guru")
+
+		accessTokenCookie, _ := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, authRouter)
+		clientAccessTokenCookie, _ := loginUserGetAccessToken(t, client.Password, client.TelegramUserID, authRouter)
+		strangerAccessTokenCookie, _ := loginUserGetAccessToken(t, stranger.Password, stranger.TelegramUserID, authRouter)
+
+		profile, _ := createProfile(t, random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors,
+			intimateHairCuts, accessTokenCookie, profileRouter, user.ID.String())
+
+		payload := &models.CreateServiceRequest{
+			ClientUserID:        client.ID,
+			ClientUserLatitude:  floatPtr(43.259769),
+			ClientUserLongitude: floatPtr(76.935246),
+
+			ProfileID:            profile.Data.ID,
+			ProfileOwnerID:       profile.Data.UserID,
+			ProfileUserLatitude:  floatPtr(43.259879),
+			ProfileUserLongitude: floatPtr(76.934604),
+		}
+
+		sendPayload := func(accessTokenCookie *http.Cookie) int {
+			jsonPayload, _ := json.Marshal(payload)
+
+			createServiceReq, _ := http.NewRequest("POST", "/api/services/", bytes.NewBuffer(jsonPayload))
+			createServiceReq.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+			createServiceReq.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			serviceRouter.ServeHTTP(w, createServiceReq)
+
+			return w.Code
+		}
+
+		assert.Equal(t, http.StatusForbidden, sendPayload(strangerAccessTokenCookie))
+
+		// the client can't make up the owner of the profile
+		payload.ProfileOwnerID = stranger.ID
+		assert.Equal(t, http.StatusBadRequest, sendPayload(clientAccessTokenCookie))
+
+		var services int64
+		sc.DB.Model(&models.Service{}).Where("profile_id = ?", profile.Data.ID).Count(&services)
+		assert.Equal(t, int64(0), services)
+	})
+
 	t.Run("GET /api/services/:profileID: basic user can only see score,  not review's text or tags", func(t *testing.T) {
 
 		profileOwner := generateUser(random, authRouter, t, "")
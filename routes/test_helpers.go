@@ -16,8 +16,10 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var randomPhotos = []string{
@@ -67,6 +69,54 @@ func boolPtr(v bool) *bool {
 	return &v
 }
 
+func getTestConfig() initializers.Config {
+	config, err := initializers.LoadConfig("../.")
+	if err != nil {
+		log.Fatal("🚀 Could not load environment variables", err)
+	}
+	return config
+}
+
+// signedTelegramLogin returns Login Widget data of the user signed with the configured bot token.
+func signedTelegramLogin(telegramUserId string) *models.TelegramLoginData {
+	id, _ := strconv.ParseInt(telegramUserId, 10, 64)
+
+	data := &models.TelegramLoginData{
+		ID:        id,
+		FirstName: "test",
+		AuthDate:  time.Now().Unix(),
+	}
+	data.Hash = utils.TelegramLoginHash(utils.TelegramLoginFields(data), getTestConfig().TelegramBotToken)
+
+	return data
+}
+
+func botSignUpPayload(name string, phone string, telegramUserId string) []byte {
+	payload, _ := json.Marshal(models.BotSignUpRequest{
+		Name:           name,
+		Phone:          phone,
+		TelegramUserId: telegramUserId,
+		Telegram:       signedTelegramLogin(telegramUserId),
+	})
+	return payload
+}
+
+func botSignInPayload(telegramUserId string) []byte {
+	payload, _ := json.Marshal(models.BotSignInRequest{
+		TelegramUserId: telegramUserId,
+		Telegram:       signedTelegramLogin(telegramUserId),
+	})
+	return payload
+}
+
+// newBotRequest builds a request the way our Telegram bot sends it: json body with the bot credential header.
+func newBotRequest(method string, url string, payload []byte) *http.Request {
+	req, _ := http.NewRequest(method, url, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Bot-Token", getTestConfig().BotApiSecret)
+	return req
+}
+
 func getOwnerUser() models.User {
 	return models.User{
 		ID:             uuid.Max,
@@ -103,11 +153,10 @@ func generateUser(random *rand.Rand, authRouter *gin.Engine, t *testing.T, tier
 	phone := utils.GenerateRandomPhoneNumber(random, 0)
 	telegramUserId := fmt.Sprintf("%d", rand.Int64())
 
-	payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+	payload := botSignUpPayload(name, phone, telegramUserId)
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-	req.Header.Set("Content-Type", "application/json")
+	req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 	authRouter.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusCreated, w.Code)
@@ -236,15 +285,25 @@ func assignRole(db *gorm.DB, t *testing.T, authRouter *gin.Engine, userRouter *g
 func loginUserGetAccessToken(t *testing.T, password string, telegramUserId int64, authRouter *gin.Engine) (*http.Cookie, error) {
 	var jsonResponse map[string]interface{}
 
+	// privileged roles need the second factor for their actions
+	ensurePrivilegedTwoFactor(t, telegramUserId)
+
 	w := httptest.NewRecorder()
-	payloadLogin := fmt.Sprintf(`{"telegramUserId": "%d", "password": "%s"}`, telegramUserId, password)
-	loginReq, _ := http.NewRequest("POST", "/api/auth/bot/login", bytes.NewBuffer([]byte(payloadLogin)))
-	loginReq.Header.Set("Content-Type", "application/json")
+	payloadLogin := botSignInPayload(fmt.Sprintf("%d", telegramUserId))
+	loginReq := newBotRequest("POST", "/api/auth/bot/login", payloadLogin)
 	authRouter.ServeHTTP(w, loginReq)
 
 	err := json.Unmarshal(w.Body.Bytes(), &jsonResponse)
 
 	assert.NoError(t, err)
+
+	if w.Code == http.StatusAccepted {
+		w = completeTwoFactorLogin(t, telegramUserId, fmt.Sprint(jsonResponse["pre_auth_token"]), authRouter)
+
+		jsonResponse = map[string]interface{}{}
+		err = json.Unmarshal(w.Body.Bytes(), &jsonResponse)
+		assert.NoError(t, err)
+	}
 	status := jsonResponse["status"]
 
 	assert.Equal(t, http.StatusOK, w.Code)
@@ -262,6 +321,64 @@ func loginUserGetAccessToken(t *testing.T, password string, telegramUserId int64
 	return nil, errors.New("cookie not found")
 }
 
+// ensurePrivilegedTwoFactor enrolls moderators, admins and owners into
+// two-factor authentication, so their logins pass the second factor.
+func ensurePrivilegedTwoFactor(t *testing.T, telegramUserId int64) {
+	var user models.User
+	if err := initializers.DB.First(&user, "telegram_user_id = ?", telegramUserId).Error; err != nil {
+		return
+	}
+
+	if user.Role != "moderator" && user.Role != "admin" && user.Role != "owner" {
+		return
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	enrollment := models.TwoFactorAuth{UserID: user.ID, Secret: secret, CreatedAt: now, ConfirmedAt: &now}
+
+	assert.NoError(t, initializers.DB.Where("user_id = ?", user.ID).FirstOrCreate(&enrollment).Error)
+}
+
+// currentTotpCode returns a valid TOTP code of the user. It forgets the last
+// used code first, since tests log in many times within one time step.
+func currentTotpCode(t *testing.T, userID uuid.UUID) string {
+	var enrollment models.TwoFactorAuth
+	assert.NoError(t, initializers.DB.First(&enrollment, "user_id = ?", userID).Error)
+	assert.NoError(t, initializers.DB.Model(&enrollment).Update("last_used_step", 0).Error)
+
+	code, err := utils.TotpCode(enrollment.Secret, utils.TotpStep(time.Now()))
+	assert.NoError(t, err)
+
+	return code
+}
+
+func completeTwoFactorLogin(t *testing.T, telegramUserId int64, preAuthToken string, authRouter *gin.Engine) *httptest.ResponseRecorder {
+	var user models.User
+	assert.NoError(t, initializers.DB.First(&user, "telegram_user_id = ?", telegramUserId).Error)
+
+	payload, err := json.Marshal(models.TwoFactorLoginRequest{PreAuthToken: preAuthToken, Code: currentTotpCode(t, user.ID)})
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/auth/login/2fa", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	authRouter.ServeHTTP(w, req)
+
+	return w
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
 func populateProfileTags(db gorm.DB) []models.ProfileTag {
 	var profileTags = []models.ProfileTag{
 		{Name: "classic", AliasRu: "Классика", AliasEn: "Classic"},
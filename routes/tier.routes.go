@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/middleware"
+)
+
+type TierRouteController struct {
+	tierController controllers.TierController
+}
+
+func NewRouteTierController(tierController controllers.TierController) TierRouteController {
+	return TierRouteController{tierController}
+}
+
+// @BasePath /api/v1/tiers
+
+func (tc *TierRouteController) TierRoute(rg *gin.RouterGroup) {
+	router := rg.Group("tiers")
+
+	router.GET("", tc.tierController.ListTiers)
+
+	// every route is checked against the access policy, so API keys are taken
+	router.Use(middleware.DeserializeUserOrApiKey())
+
+	router.PUT("/:name", middleware.DenyImpersonation(), middleware.AbacMiddleware("tiers", "update"), tc.tierController.UpsertTier)
+	router.DELETE("/:name", middleware.DenyImpersonation(), middleware.AbacMiddleware("tiers", "delete"), tc.tierController.DeleteTier)
+}
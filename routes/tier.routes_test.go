@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/initializers"
+	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/stretchr/testify/assert"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SetupTCRouter sets up the router for testing.
+func SetupTCRouter(authController *controllers.AuthController, tierController *controllers.TierController, profileController *controllers.ProfileController) *gin.Engine {
+	r := gin.Default()
+
+	authRouteController := NewAuthRouteController(*authController)
+	tierRouteController := NewRouteTierController(*tierController)
+	profileRouteController := NewRouteProfileController(*profileController)
+
+	api := r.Group("/api")
+	authRouteController.AuthRoute(api)
+	tierRouteController.TierRoute(api)
+	profileRouteController.ProfileRoute(api)
+
+	return r
+}
+
+func TestTierRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	uc := SetupUCController()
+	pc := SetupPCController()
+
+	tc := controllers.NewTierController(initializers.DB)
+	router := SetupTCRouter(&ac, &tc, &pc)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	profileTags := populateProfileTags(*pc.DB)
+	cities := populateCities(*pc.DB)
+	bodyTypes := populateBodyTypes(*pc.DB)
+	ethnos := filterEthnosBySex(populateEthnos(*pc.DB), "female")
+	hairColors := populateHairColors(*pc.DB)
+	intimateHairCuts := populateIntimateHairCuts(*pc.DB)
+	bodyArts := populateBodyArts(*pc.DB)
+
+	sendJSON := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	createProfile := func(accessTokenCookie *http.Cookie) int {
+		payload := generateCreateProfileRequest(random, cities, ethnos, profileTags, bodyArts, bodyTypes, hairColors, intimateHairCuts)
+
+		jsonPayload, err := json.Marshal(payload)
+		assert.NoError(t, err)
+
+		w := sendJSON("POST", "/api/profiles/", string(jsonPayload), accessTokenCookie)
+		return w.Code
+	}
+
+	// tier names are lowercase words
+	tierName := fmt.Sprintf("test-%c%c%c%c", 'a'+random.IntN(26), 'a'+random.IntN(26), 'a'+random.IntN(26), 'a'+random.IntN(26))
+
+	t.Cleanup(func() {
+		pc.DB.Delete(&models.Tier{}, "name = ?", tierName)
+	})
+
+	owner := createOwnerUser(uc.DB)
+	ownerAccessToken, err := loginUserGetAccessToken(t, owner.Password, owner.TelegramUserId, router)
+	assert.NoError(t, err)
+
+	t.Run("GET /api/tiers: lists the default tiers without auth", func(t *testing.T) {
+		w := sendJSON("GET", "/api/tiers", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var tiersResponse models.SuccessResponse[[]models.TierResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tiersResponse))
+
+		names := make([]string, len(tiersResponse.Data))
+		for i, tier := range tiersResponse.Data {
+			names[i] = tier.Name
+		}
+
+		assert.Subset(t, names, []string{"basic", "expert", "guru"})
+	})
+
+	t.Run("PUT /api/tiers/:name: users can't change tiers", func(t *testing.T) {
+		user := generateUser(random, router, t, "guru")
+
+		userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("PUT", "/api/tiers/"+tierName, `{"price": 1}`, userAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendJSON("PUT", "/api/tiers/"+tierName, `{"price": 1, "reviewFields": ["profileRating.secret"]}`, ownerAccessToken)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("PUT /api/tiers/:name: a new tier limits the profiles of its users", func(t *testing.T) {
+		w := sendJSON("PUT", "/api/tiers/"+tierName, `{"price": 3, "reviewFields": ["profileRating.score"], "profilesAllowed": 1}`, ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var tierResponse models.SuccessResponse[models.TierResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tierResponse))
+		assert.Equal(t, 1, tierResponse.Data.ProfilesAllowed)
+		assert.Equal(t, []string{models.ProfileRatingScore}, tierResponse.Data.ReviewFields)
+
+		user := generateUser(random, router, t, "")
+		assert.NoError(t, initializers.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("tier", tierName).Error)
+		initializers.ForgetUser(user.ID)
+
+		userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusCreated, createProfile(userAccessToken))
+		assert.Equal(t, http.StatusForbidden, createProfile(userAccessToken))
+
+		// raising the limit applies right away
+		w = sendJSON("PUT", "/api/tiers/"+tierName, `{"price": 3, "profilesAllowed": -1}`, ownerAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, http.StatusCreated, createProfile(userAccessToken))
+
+		w = sendJSON("DELETE", "/api/tiers/"+tierName, "", ownerAccessToken)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("GET /api/profiles: search follows the tier catalog", func(t *testing.T) {
+		basicUser := generateUser(random, router, t, "")
+		expertUser := generateUser(random, router, t, "expert")
+
+		basicAccessToken, err := loginUserGetAccessToken(t, basicUser.Password, basicUser.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		expertAccessToken, err := loginUserGetAccessToken(t, expertUser.Password, expertUser.TelegramUserID, router)
+		assert.NoError(t, err)
+
+		w := sendJSON("GET", "/api/profiles?page=1&limit=10", "{}", basicAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendJSON("GET", "/api/profiles?page=1&limit=10", "{}", expertAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("DELETE /api/tiers/:name: basic and unknown tiers aren't removed", func(t *testing.T) {
+		w := sendJSON("DELETE", "/api/tiers/basic", "", ownerAccessToken)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		w = sendJSON("DELETE", "/api/tiers/no-such-tier", "", ownerAccessToken)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -21,16 +21,22 @@ func (uc *UserRouteController) UserRoute(rg *gin.RouterGroup) {
 
 	router.Use(middleware.DeserializeUser())
 
-	router.GET("/", middleware.AbacMiddleware("users", "list"), uc.userController.FindUsers)
-
 	router.GET("/me", uc.userController.GetMe)
 	router.GET("/user", uc.userController.GetUser)
 
-	router.DELETE("/user", uc.userController.DeleteSelf)
-	router.DELETE("/user/:id", middleware.AbacMiddleware("users", "delete"), uc.userController.DeleteUser)
+	router.DELETE("/user", middleware.DenyImpersonation(), uc.userController.DeleteSelf)
+
+	// the phone is the login, impersonators must not change it
+	router.PUT("/user", middleware.DenyImpersonation(), uc.userController.UpdateSelf)
+
+	// routes checked against the access policy also take API keys
+	scoped := rg.Group("users", middleware.DeserializeUserOrApiKey())
+
+	scoped.GET("/", middleware.AbacMiddleware("users", "list"), uc.userController.FindUsers)
+
+	scoped.DELETE("/user/:id", middleware.DenyImpersonation(), middleware.AbacMiddleware("users", "delete"), uc.userController.DeleteUser)
 
-	router.PUT("/user", uc.userController.UpdateSelf)
-	router.PUT("/user/:id", middleware.AbacMiddleware("users", "update"), uc.userController.UpdateUser)
+	scoped.PUT("/user/:id", middleware.DenyImpersonation(), middleware.AbacMiddleware("users", "update"), uc.userController.UpdateUser)
 
-	router.PUT("/role", middleware.AbacMiddleware("users", "promote"), uc.userController.AssignRole)
+	scoped.PUT("/role", middleware.DenyImpersonation(), middleware.AbacMiddleware("users", "promote"), uc.userController.AssignRole)
 }
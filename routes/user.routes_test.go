@@ -202,11 +202,10 @@ func TestUserRoutes(t *testing.T) {
 		phone := utils.GenerateRandomPhoneNumber(random, 0)
 		telegramUserId := fmt.Sprintf("%d", rand.Int64())
 
-		payload := fmt.Sprintf(`{"name": "%s", "phone": "%s", "telegramUserId": "%s"}`, name, phone, telegramUserId)
+		payload := botSignUpPayload(name, phone, telegramUserId)
 
 		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("POST", "/api/auth/bot/signup", bytes.NewBuffer([]byte(payload)))
-		req.Header.Set("Content-Type", "application/json")
+		req := newBotRequest("POST", "/api/auth/bot/signup", payload)
 		authRouter.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusCreated, w.Code)
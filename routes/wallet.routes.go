@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/middleware"
+)
+
+type WalletRouteController struct {
+	walletController controllers.WalletController
+}
+
+func NewRouteWalletController(walletController controllers.WalletController) WalletRouteController {
+	return WalletRouteController{walletController}
+}
+
+// @BasePath /api/v1/wallet
+
+func (wc *WalletRouteController) WalletRoute(rg *gin.RouterGroup) {
+	router := rg.Group("wallet", middleware.DeserializeUser())
+
+	router.GET("", wc.walletController.GetMyWallet)
+	router.GET("/entries", wc.walletController.GetMyWalletEntries)
+
+	// routes checked against the access policy also take API keys
+	scoped := rg.Group("wallet", middleware.DeserializeUserOrApiKey(), middleware.DenyImpersonation())
+
+	scoped.POST("/adjustments", middleware.AbacMiddleware("wallets", "adjust"), wc.walletController.AdjustBalance)
+	scoped.POST("/rebuild", middleware.AbacMiddleware("wallets", "rebuild"), wc.walletController.RebuildBalances)
+}
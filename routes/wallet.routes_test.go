@@ -0,0 +1,185 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/ivegotanidea/golang-gorm-postgres/controllers"
+	"github.com/ivegotanidea/golang-gorm-postgres/models"
+	"github.com/ivegotanidea/golang-gorm-postgres/utils"
+	"github.com/stretchr/testify/assert"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SetupWalletRouter sets up the router for testing.
+func SetupWalletRouter(authController *controllers.AuthController, paymentController *controllers.PaymentController, walletController *controllers.WalletController) *gin.Engine {
+	r := gin.Default()
+
+	authRouteController := NewAuthRouteController(*authController)
+	paymentRouteController := NewRoutePaymentController(*paymentController)
+	walletRouteController := NewRouteWalletController(*walletController)
+
+	api := r.Group("/api")
+	authRouteController.AuthRoute(api)
+	paymentRouteController.PaymentRoute(api)
+	walletRouteController.WalletRoute(api)
+
+	return r
+}
+
+func TestWalletRoutes(t *testing.T) {
+
+	ac := SetupAuthController()
+	subscriptions := utils.NewSubscriptionService(nil, &memoryTelegramSender{messages: map[int64]string{}}, &memorySmsSender{messages: map[string]string{}}, 30*24*time.Hour, 3*24*time.Hour)
+	pc := SetupPaymentController(subscriptions)
+	wc := controllers.NewWalletController(pc.DB)
+	router := SetupWalletRouter(&ac, &pc, &wc)
+	random := rand.New(rand.NewPCG(1, uint64(time.Now().Nanosecond())))
+
+	if err := pc.DB.AutoMigrate(&models.LedgerAccount{}, &models.LedgerTransaction{}, &models.LedgerEntry{}); err != nil {
+		panic("failed to migrate database: " + err.Error())
+	}
+
+	send := func(method string, url string, payload string, accessTokenCookie *http.Cookie) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if accessTokenCookie != nil {
+			req.AddCookie(&http.Cookie{Name: accessTokenCookie.Name, Value: accessTokenCookie.Value})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	balance := func(accessTokenCookie *http.Cookie) float64 {
+		w := send("GET", "/api/wallet", "", accessTokenCookie)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var walletResponse models.SuccessResponse[models.WalletResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &walletResponse))
+		return walletResponse.Data.Balance
+	}
+
+	findTier := func(userID uuid.UUID) string {
+		var user models.User
+		assert.NoError(t, pc.DB.First(&user, "id = ?", userID).Error)
+		return user.Tier
+	}
+
+	user := generateUser(random, router, t, "")
+	userAccessToken, err := loginUserGetAccessToken(t, user.Password, user.TelegramUserID, router)
+	assert.NoError(t, err)
+
+	admin := generateUser(random, router, t, "")
+	assert.NoError(t, pc.DB.Model(&models.User{}).Where("id = ?", admin.ID).Updates(map[string]interface{}{"role": "admin", "tier": "guru"}).Error)
+
+	adminAccessToken, err := loginUserGetAccessToken(t, admin.Password, admin.TelegramUserID, router)
+	assert.NoError(t, err)
+
+	t.Run("POST /api/payments/top-up: a completed top-up adds to the balance", func(t *testing.T) {
+		assert.Equal(t, float64(0), balance(userAccessToken))
+
+		w := send("POST", "/api/payments/top-up", `{"amount": 12.5}`, userAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var checkoutResponse models.SuccessResponse[models.CheckoutResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &checkoutResponse))
+
+		// nothing before it is paid
+		assert.Equal(t, float64(0), balance(userAccessToken))
+
+		var payment models.Payment
+		assert.NoError(t, pc.DB.First(&payment, "id = ?", checkoutResponse.Data.PaymentID).Error)
+		assert.Equal(t, "top_up", payment.Type)
+
+		body := fmt.Sprintf(`{"id": "%s", "paymentId": "%s", "status": "completed"}`, uuid.NewString(), *payment.ProviderPaymentID)
+		req, _ := http.NewRequest("POST", "/api/payments/webhook", strings.NewReader(body))
+		req.Header.Set("X-Payment-Signature", testPaymentProvider.Sign([]byte(body)))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, 12.5, balance(userAccessToken))
+
+		w = send("POST", "/api/payments/top-up", `{"amount": -1}`, userAccessToken)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// rounds to nothing
+		w = send("POST", "/api/payments/top-up", `{"amount": 0.001}`, userAccessToken)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("POST /api/payments/checkout: a tier paid from the balance", func(t *testing.T) {
+		// expert costs 10, guru 25
+		w := send("POST", "/api/payments/checkout", `{"tier": "guru", "wallet": true}`, userAccessToken)
+		assert.Equal(t, http.StatusPaymentRequired, w.Code)
+		assert.Equal(t, "basic", findTier(user.ID))
+		assert.Equal(t, 12.5, balance(userAccessToken))
+
+		w = send("POST", "/api/payments/checkout", `{"tier": "expert", "wallet": true}`, userAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		var checkoutResponse models.SuccessResponse[models.CheckoutResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &checkoutResponse))
+		assert.Empty(t, checkoutResponse.Data.URL)
+
+		var payment models.Payment
+		assert.NoError(t, pc.DB.First(&payment, "id = ?", checkoutResponse.Data.PaymentID).Error)
+		assert.Equal(t, models.PaymentCompleted, payment.Status)
+		assert.Equal(t, utils.WalletProvider, payment.Provider)
+
+		assert.Equal(t, "expert", findTier(user.ID))
+		assert.Equal(t, 2.5, balance(userAccessToken))
+
+		w = send("GET", "/api/wallet/entries", "", userAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var entriesResponse models.SuccessCountedPageResponse[[]models.LedgerEntryResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entriesResponse))
+		assert.Equal(t, int64(2), entriesResponse.Total)
+		assert.Equal(t, models.LedgerPurchase, entriesResponse.Data[0].Kind)
+		assert.Equal(t, float64(-10), entriesResponse.Data[0].Amount)
+		assert.Equal(t, models.LedgerTopUp, entriesResponse.Data[1].Kind)
+	})
+
+	t.Run("POST /api/wallet/adjustments: admins correct balances", func(t *testing.T) {
+		payload := fmt.Sprintf(`{"userId": "%s", "amount": 5, "reason": "compensation"}`, user.ID)
+
+		w := send("POST", "/api/wallet/adjustments", payload, userAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send("POST", "/api/wallet/adjustments", payload, adminAccessToken)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, 7.5, balance(userAccessToken))
+
+		w = send("POST", "/api/wallet/adjustments", fmt.Sprintf(`{"userId": "%s", "amount": -100, "reason": "too much"}`, user.ID), adminAccessToken)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Equal(t, 7.5, balance(userAccessToken))
+	})
+
+	t.Run("POST /api/wallet/rebuild: balances are the sums of the entries", func(t *testing.T) {
+		assert.NoError(t, pc.DB.Model(&models.LedgerAccount{}).Where("user_id = ?", user.ID).Update("balance", 1000).Error)
+
+		w := send("POST", "/api/wallet/rebuild", "", userAccessToken)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send("POST", "/api/wallet/rebuild", "", adminAccessToken)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var rebuildResponse models.SuccessResponse[models.RebuildBalancesResponse]
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rebuildResponse))
+		assert.GreaterOrEqual(t, rebuildResponse.Data.Corrected, 1)
+		assert.Equal(t, 7.5, balance(userAccessToken))
+
+		// every transaction is balanced
+		var unbalanced int64
+		pc.DB.Model(&models.LedgerEntry{}).Select("transaction_id").Group("transaction_id").Having("SUM(amount) <> 0").Count(&unbalanced)
+		assert.Equal(t, int64(0), unbalanced)
+	})
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BoostRotation is how long promoted profiles keep their order in the
+// listings, after that they are shuffled so each gets its turn on top.
+const BoostRotation = 10 * time.Minute
+
+// DefaultBoostPlans is the catalog of promotions a new database starts with,
+// named after the payment types which were there for them.
+var DefaultBoostPlans = []BoostPlan{
+	{Name: "one_hour", Minutes: 60, Price: 1},
+	{Name: "three_hours", Minutes: 3 * 60, Price: 2},
+	{Name: "twelve_hours", Minutes: 12 * 60, Price: 5},
+	{Name: "two_days", Minutes: 2 * 24 * 60, Price: 15},
+	{Name: "one_week", Minutes: 7 * 24 * 60, Price: 40},
+}
+
+// SeedBoostPlans adds the default boost plans missing from the catalog, plans
+// already there are left as they are.
+func SeedBoostPlans(db *gorm.DB) error {
+	now := time.Now()
+
+	plans := make([]BoostPlan, len(DefaultBoostPlans))
+	for i, plan := range DefaultBoostPlans {
+		plan.CreatedAt = now
+		plan.UpdatedAt = now
+		plans[i] = plan
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&plans).Error
+}
+
+// GrantBoost promotes the profile of the completed boost payment, within the
+// transaction of the payment. The boost starts right away, or when the last
+// boost of the profile ends.
+func GrantBoost(tx *gorm.DB, payment Payment, now time.Time) (Boost, error) {
+	boost := Boost{PaymentID: payment.ID, Plan: payment.Type, CreatedAt: now}
+
+	if payment.ProfileID == nil {
+		return boost, errors.New("the boost payment has no profile")
+	}
+
+	boost.ProfileID = *payment.ProfileID
+
+	var plan BoostPlan
+	if err := tx.First(&plan, "name = ?", payment.Type).Error; err != nil {
+		return boost, err
+	}
+
+	// boosts of the profile bought at the same time queue up one after the other
+	if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&Profile{}, "id = ?", boost.ProfileID).Error; err != nil {
+		return boost, err
+	}
+
+	boost.StartsAt = now
+
+	var last Boost
+	err := tx.Where("profile_id = ? AND ends_at > ?", boost.ProfileID, now).Order("ends_at DESC").First(&last).Error
+	if err == nil {
+		boost.StartsAt = last.EndsAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return boost, err
+	}
+
+	boost.EndsAt = boost.StartsAt.Add(plan.Duration())
+
+	err = tx.Omit(clause.Associations).Create(&boost).Error
+	return boost, err
+}
+
+// PromotedFirst joins the running boosts to a query of profiles and puts the
+// promoted profiles first. They come in an order which changes every
+// BoostRotation, the others newest first.
+func PromotedFirst(db *gorm.DB, now time.Time) *gorm.DB {
+	rotation := strconv.FormatInt(now.Truncate(BoostRotation).Unix(), 10)
+
+	return db.
+		Joins("LEFT JOIN boosts ON boosts.profile_id = profiles.id AND boosts.starts_at <= ? AND boosts.ends_at > ?", now, now).
+		Clauses(clause.OrderBy{Expression: clause.Expr{
+			SQL:                "boosts.id IS NULL, md5(boosts.id::text || ?), profiles.created_at DESC, profiles.id",
+			Vars:               []interface{}{rotation},
+			WithoutParentheses: true,
+		}})
+}
+
+// PromotedProfiles returns which of the profiles have a running boost.
+func PromotedProfiles(db *gorm.DB, profileIDs []uuid.UUID, now time.Time) (map[uuid.UUID]bool, error) {
+	var promoted []uuid.UUID
+
+	err := db.Model(&Boost{}).
+		Where("profile_id IN ? AND starts_at <= ? AND ends_at > ?", profileIDs, now, now).
+		Pluck("profile_id", &promoted).Error
+
+	result := make(map[uuid.UUID]bool, len(promoted))
+	for _, id := range promoted {
+		result[id] = true
+	}
+
+	return result, err
+}
+
+// RevokeBoost takes the refunded share of the duration off the boost of the
+// payment, within the transaction of the payment. A boost left without any
+// time to run ends, or is dropped when it hasn't started yet.
+func RevokeBoost(tx *gorm.DB, payment Payment, share float64, now time.Time) error {
+	var boost Boost
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&boost, "payment_id = ?", payment.ID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !boost.EndsAt.After(now) {
+		return nil
+	}
+
+	endsAt := boost.EndsAt.Add(-time.Duration(float64(boost.EndsAt.Sub(boost.StartsAt)) * share))
+
+	switch {
+	case endsAt.After(boost.StartsAt) && endsAt.After(now):
+		return tx.Model(&boost).Update("ends_at", endsAt).Error
+	case boost.StartsAt.After(now):
+		return tx.Delete(&boost).Error
+	default:
+		return tx.Model(&boost).Update("ends_at", now).Error
+	}
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type ttlCacheItem[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// TTLCache is an in-memory map whose entries expire after a fixed TTL.
+// It is safe for concurrent use.
+type TTLCache[K comparable, V any] struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	items     map[K]ttlCacheItem[V]
+	lastSweep time.Time
+}
+
+func NewTTLCache[K comparable, V any](ttl time.Duration) *TTLCache[K, V] {
+	return &TTLCache[K, V]{ttl: ttl, items: map[K]ttlCacheItem[V]{}, lastSweep: time.Now()}
+}
+
+func (c *TTLCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || time.Now().After(item.expiresAt) {
+		var zero V
+		return zero, false
+	}
+
+	return item.value, true
+}
+
+func (c *TTLCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	// drop expired entries now and then, so keys that are never read again don't pile up
+	if now.Sub(c.lastSweep) > c.ttl {
+		for k, item := range c.items {
+			if now.After(item.expiresAt) {
+				delete(c.items, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	c.items[key] = ttlCacheItem[V]{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+// Update replaces a live entry with what the function returns for it,
+// without extending its TTL.
+func (c *TTLCache[K, V]) Update(key K, update func(value V) V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || time.Now().After(item.expiresAt) {
+		return
+	}
+
+	item.value = update(item.value)
+	c.items[key] = item
+}
+
+func (c *TTLCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, key)
+}
+
+// DeleteFunc removes every entry the function returns true for.
+func (c *TTLCache[K, V]) DeleteFunc(del func(key K, value V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, item := range c.items {
+		if del(k, item.value) {
+			delete(c.items, k)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"errors"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+)
+
+// GormAdapter stores the casbin policy in the casbin_rules table.
+type GormAdapter struct {
+	DB *gorm.DB
+}
+
+func NewGormAdapter(db *gorm.DB) *GormAdapter {
+	return &GormAdapter{DB: db}
+}
+
+func newCasbinRule(ptype string, rule []string) CasbinRule {
+	line := CasbinRule{Ptype: ptype}
+
+	values := []*string{&line.V0, &line.V1, &line.V2, &line.V3, &line.V4, &line.V5}
+	for i, value := range rule {
+		if i < len(values) {
+			*values[i] = value
+		}
+	}
+
+	return line
+}
+
+func casbinRuleValues(line CasbinRule) []string {
+	values := []string{line.V0, line.V1, line.V2, line.V3, line.V4, line.V5}
+
+	// trailing empty values aren't part of the rule
+	for len(values) > 0 && values[len(values)-1] == "" {
+		values = values[:len(values)-1]
+	}
+
+	return values
+}
+
+// filter restricts the query to the rules with the given values, starting at
+// fieldIndex. Empty values match anything.
+func filter(db *gorm.DB, ptype string, fieldIndex int, fieldValues ...string) *gorm.DB {
+	columns := []string{"v0", "v1", "v2", "v3", "v4", "v5"}
+
+	db = db.Where("ptype = ?", ptype)
+	for i, value := range fieldValues {
+		if value != "" && fieldIndex+i < len(columns) {
+			db = db.Where(columns[fieldIndex+i]+" = ?", value)
+		}
+	}
+
+	return db
+}
+
+// exactly restricts the query to the one rule.
+func exactly(db *gorm.DB, ptype string, rule []string) *gorm.DB {
+	line := newCasbinRule(ptype, rule)
+	return db.Where("ptype = ? AND v0 = ? AND v1 = ? AND v2 = ? AND v3 = ? AND v4 = ? AND v5 = ?",
+		line.Ptype, line.V0, line.V1, line.V2, line.V3, line.V4, line.V5)
+}
+
+func (a *GormAdapter) LoadPolicy(m model.Model) error {
+	var lines []CasbinRule
+	if err := a.DB.Order("id").Find(&lines).Error; err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if err := persist.LoadPolicyArray(append([]string{line.Ptype}, casbinRuleValues(line)...), m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *GormAdapter) SavePolicy(m model.Model) error {
+	var lines []CasbinRule
+	for _, sec := range []string{"p", "g"} {
+		for ptype, assertion := range m[sec] {
+			for _, rule := range assertion.Policy {
+				lines = append(lines, newCasbinRule(ptype, rule))
+			}
+		}
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&CasbinRule{}).Error; err != nil {
+			return err
+		}
+
+		if len(lines) == 0 {
+			return nil
+		}
+
+		return tx.Create(&lines).Error
+	})
+}
+
+func (a *GormAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	line := newCasbinRule(ptype, rule)
+	return a.DB.Create(&line).Error
+}
+
+func (a *GormAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return exactly(a.DB, ptype, rule).Delete(&CasbinRule{}).Error
+}
+
+func (a *GormAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return filter(a.DB, ptype, fieldIndex, fieldValues...).Delete(&CasbinRule{}).Error
+}
+
+func (a *GormAdapter) UpdatePolicy(sec string, ptype string, oldRule, newRule []string) error {
+	return a.UpdatePolicies(sec, ptype, [][]string{oldRule}, [][]string{newRule})
+}
+
+func (a *GormAdapter) UpdatePolicies(sec string, ptype string, oldRules, newRules [][]string) error {
+	if len(oldRules) != len(newRules) {
+		return errors.New("the number of old and new rules differs")
+	}
+
+	return a.DB.Transaction(func(tx *gorm.DB) error {
+		for i := range oldRules {
+			line := newCasbinRule(ptype, newRules[i])
+
+			result := exactly(tx.Model(&CasbinRule{}), ptype, oldRules[i]).
+				Select("v0", "v1", "v2", "v3", "v4", "v5").
+				Updates(&line)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		return nil
+	})
+}
+
+func (a *GormAdapter) UpdateFilteredPolicies(sec string, ptype string, newRules [][]string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	var oldRules [][]string
+
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		var lines []CasbinRule
+		if err := filter(tx, ptype, fieldIndex, fieldValues...).Find(&lines).Error; err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			oldRules = append(oldRules, casbinRuleValues(line))
+		}
+
+		if err := filter(tx, ptype, fieldIndex, fieldValues...).Delete(&CasbinRule{}).Error; err != nil {
+			return err
+		}
+
+		for _, rule := range newRules {
+			line := newCasbinRule(ptype, rule)
+			if err := tx.Create(&line).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return oldRules, err
+}
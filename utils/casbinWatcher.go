@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const policyRevisionID = 1
+
+// PolicyWatcher lets every replica pick up policy changes without a restart.
+// A change bumps the policy revision in the database, and every replica
+// polls the revision and reloads the policy when it moved.
+type PolicyWatcher struct {
+	DB *gorm.DB
+
+	mu       sync.Mutex
+	callback func(string)
+	revision int64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func createPolicyRevision(db *gorm.DB) error {
+	row := CasbinPolicyRevision{ID: policyRevisionID, UpdatedAt: time.Now()}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+}
+
+// UpgradePolicy applies a one-time upgrade of the saved policy, unless the
+// policy is at the version already. The upgrade runs in a transaction holding
+// the revision row, so only one replica applies it, and bumps the revision so
+// the others reload the policy. It reports whether the upgrade was applied.
+func UpgradePolicy(db *gorm.DB, version int, upgrade func(tx *gorm.DB) error) (bool, error) {
+	if err := createPolicyRevision(db); err != nil {
+		return false, err
+	}
+
+	applied := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var row CasbinPolicyRevision
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, policyRevisionID).Error; err != nil {
+			return err
+		}
+
+		if row.Version >= version {
+			return nil
+		}
+
+		if upgrade != nil {
+			if err := upgrade(tx); err != nil {
+				return err
+			}
+		}
+
+		applied = true
+
+		return tx.Model(&row).Updates(map[string]interface{}{
+			"version":    version,
+			"revision":   gorm.Expr("revision + 1"),
+			"updated_at": time.Now(),
+		}).Error
+	})
+
+	return applied && err == nil, err
+}
+
+// NewPolicyWatcher starts polling the policy revision every interval.
+func NewPolicyWatcher(db *gorm.DB, interval time.Duration) (*PolicyWatcher, error) {
+	row := CasbinPolicyRevision{ID: policyRevisionID}
+	if err := createPolicyRevision(db); err != nil {
+		return nil, err
+	}
+
+	if err := db.First(&row, policyRevisionID).Error; err != nil {
+		return nil, err
+	}
+
+	w := &PolicyWatcher{DB: db, revision: row.Revision, stop: make(chan struct{})}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.poll()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+func (w *PolicyWatcher) poll() {
+	var row CasbinPolicyRevision
+	if err := w.DB.First(&row, policyRevisionID).Error; err != nil {
+		log.Printf("failed to read the policy revision: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	changed := row.Revision != w.revision
+	w.revision = row.Revision
+	callback := w.callback
+	w.mu.Unlock()
+
+	if changed && callback != nil {
+		callback("")
+	}
+}
+
+func (w *PolicyWatcher) SetUpdateCallback(callback func(string)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.callback = callback
+	return nil
+}
+
+// Update is called by the enforcer after it changed the policy.
+func (w *PolicyWatcher) Update() error {
+	var row CasbinPolicyRevision
+	result := w.DB.Model(&row).
+		Clauses(clause.Returning{}).
+		Where("id = ?", policyRevisionID).
+		Updates(map[string]interface{}{"revision": gorm.Expr("revision + 1"), "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	w.mu.Lock()
+	// another replica changed the policy since the last poll, that change
+	// has to be loaded too
+	missed := row.Revision != w.revision+1
+	w.revision = row.Revision
+	callback := w.callback
+	w.mu.Unlock()
+
+	// the enforcer calls Update while it holds its lock, reloading has to wait for it
+	if missed && callback != nil {
+		go callback("")
+	}
+
+	return nil
+}
+
+func (w *PolicyWatcher) Close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
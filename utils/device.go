@@ -0,0 +1,29 @@
+package utils
+
+import "strings"
+
+// DetectDevice returns a coarse, human readable device name for the user agent.
+func DetectDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case ua == "":
+		return "unknown"
+	case strings.Contains(ua, "telegrambot") || strings.Contains(ua, "bot"):
+		return "bot"
+	case strings.Contains(ua, "iphone"):
+		return "iPhone"
+	case strings.Contains(ua, "ipad"):
+		return "iPad"
+	case strings.Contains(ua, "android"):
+		return "Android"
+	case strings.Contains(ua, "windows"):
+		return "Windows"
+	case strings.Contains(ua, "macintosh") || strings.Contains(ua, "mac os"):
+		return "Mac"
+	case strings.Contains(ua, "linux"):
+		return "Linux"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotEntitled          = errors.New("the tier doesn't include it")
+	ErrEntitlementExhausted = errors.New("the limit of the tier is reached")
+)
+
+// Entitlement is something a tier entitles its users to, possibly up to a
+// limit.
+type Entitlement string
+
+const (
+	SearchProfiles       Entitlement = "search"
+	ContactRevealsPerDay Entitlement = "contact-reveals-per-day"
+	ProfilesAllowed      Entitlement = "profiles"
+)
+
+// DefaultTiers is the catalog a new database starts with. Review fields and
+// search are what the tiers had before they became data.
+var DefaultTiers = []Tier{
+	{
+		Name:                 "basic",
+		ReviewFields:         ProfileRatingScore,
+		ContactRevealsPerDay: 0,
+		ProfilesAllowed:      Unlimited,
+	},
+	{
+		Name:                 "expert",
+		Price:                10,
+		ReviewFields:         strings.Join([]string{ProfileRatingScore, ProfileRatingReview, ClientUserRatingScore}, " "),
+		Search:               true,
+		ContactRevealsPerDay: 5,
+		ProfilesAllowed:      Unlimited,
+	},
+	{
+		Name:                 "guru",
+		Price:                25,
+		ReviewFields:         strings.Join([]string{ProfileRatingAll, ClientUserRatingAll}, " "),
+		Search:               true,
+		ContactRevealsPerDay: Unlimited,
+		ProfilesAllowed:      Unlimited,
+	},
+}
+
+// SeedTiers adds the default tiers missing from the catalog, tiers already
+// there are left as they are.
+func SeedTiers(db *gorm.DB) error {
+	now := time.Now()
+
+	tiers := make([]Tier, len(DefaultTiers))
+	for i, tier := range DefaultTiers {
+		tier.CreatedAt = now
+		tier.UpdatedAt = now
+		tiers[i] = tier
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tiers).Error
+}
+
+// EntitlementService answers what the tier of a user entitles them to, from
+// the tier catalog in the database. Admins and owners aren't limited by tiers.
+type EntitlementService struct {
+	DB *gorm.DB
+}
+
+func NewEntitlementService(db *gorm.DB) *EntitlementService {
+	return &EntitlementService{DB: db}
+}
+
+func isStaff(user User) bool {
+	return user.Role == "admin" || user.Role == "owner"
+}
+
+// Tier finds the tier in the catalog, gorm.ErrRecordNotFound tells there is
+// no such tier.
+func (s *EntitlementService) Tier(name string) (Tier, error) {
+	var tier Tier
+	err := s.DB.First(&tier, "name = ?", name).Error
+	return tier, err
+}
+
+// tierOf is the tier of the user, users of a tier missing from the catalog
+// aren't entitled to anything.
+func (s *EntitlementService) tierOf(user User) (Tier, error) {
+	tier, err := s.Tier(user.Tier)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Tier{Name: user.Tier}, nil
+	}
+	return tier, err
+}
+
+// Entitled tells whether the user can do it, and how much of it: the limit
+// or Unlimited.
+func (s *EntitlementService) Entitled(user User, entitlement Entitlement) (bool, int, error) {
+	if isStaff(user) {
+		return true, Unlimited, nil
+	}
+
+	tier, err := s.tierOf(user)
+	if err != nil {
+		return false, 0, err
+	}
+
+	limit := 0
+
+	switch entitlement {
+	case SearchProfiles:
+		if tier.Search {
+			limit = Unlimited
+		}
+	case ContactRevealsPerDay:
+		limit = tier.ContactRevealsPerDay
+	case ProfilesAllowed:
+		limit = tier.ProfilesAllowed
+	}
+
+	return limit != 0, limit, nil
+}
+
+// RevealContacts records that the user reveals the contacts of the profile
+// today and returns how many reveals are left today, or Unlimited. Profiles
+// already revealed today are free. ErrNotEntitled tells the tier has no
+// reveals, ErrEntitlementExhausted that the user has run out of them.
+func (s *EntitlementService) RevealContacts(user User, profileID uuid.UUID, now time.Time) (int, error) {
+	entitled, limit, err := s.Entitled(user, ContactRevealsPerDay)
+	if err != nil {
+		return 0, err
+	}
+
+	if !entitled {
+		return 0, ErrNotEntitled
+	}
+
+	day := now.UTC().Truncate(24 * time.Hour)
+	left := Unlimited
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		// parallel reveals of the user wait for each other, so they can't
+		// get past the limit
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&User{}, "id = ?", user.ID).Error; err != nil {
+			return err
+		}
+
+		var revealed []uuid.UUID
+		if err := tx.Model(&ContactReveal{}).Where("user_id = ? AND day = ?", user.ID, day).Pluck("profile_id", &revealed).Error; err != nil {
+			return err
+		}
+
+		if slices.Contains(revealed, profileID) {
+			if limit != Unlimited {
+				left = max(limit-len(revealed), 0)
+			}
+			return nil
+		}
+
+		if limit != Unlimited && len(revealed) >= limit {
+			return ErrEntitlementExhausted
+		}
+
+		if limit != Unlimited {
+			left = limit - len(revealed) - 1
+		}
+
+		return tx.Omit(clause.Associations).Create(&ContactReveal{UserID: user.ID, ProfileID: profileID, Day: day, CreatedAt: now}).Error
+	})
+
+	return left, err
+}
+
+// ReviewFields are the review fields the user can see on services.
+func (s *EntitlementService) ReviewFields(user User) ([]string, error) {
+	if isStaff(user) {
+		return []string{ProfileRatingAll, ClientUserRatingAll}, nil
+	}
+
+	tier, err := s.tierOf(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(tier.ReviewFields), nil
+}
+
+// ValidReviewFields reports whether all fields are known review fields.
+func ValidReviewFields(fields []string) bool {
+	for _, field := range fields {
+		if !slices.Contains(ReviewFields, field) {
+			return false
+		}
+	}
+	return true
+}
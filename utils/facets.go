@@ -0,0 +1,98 @@
+package utils
+
+import (
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+)
+
+// Facets of the profile search, each named after the filter whose values it
+// counts.
+const (
+	FacetCity       = "city"
+	FacetEthnos     = "ethnos"
+	FacetBodyType   = "bodyType"
+	FacetHairColor  = "hairColor"
+	FacetBodyArt    = "bodyArt"
+	FacetProfileTag = "profileTag"
+)
+
+// DefaultPriceBucket is the width of the price buckets, unless asked otherwise.
+const DefaultPriceBucket = 5000
+
+// ProfilePrices are the price columns of profiles, by the name of their facet.
+var ProfilePrices = map[string]string{
+	"inHouseContact": "price_in_house_contact",
+	"inHouseHour":    "price_in_house_hour",
+	"saunaContact":   "price_sauna_contact",
+	"saunaHour":      "price_sauna_hour",
+	"visitContact":   "price_visit_contact",
+	"visitHour":      "price_visit_hour",
+	"carContact":     "price_car_contact",
+	"carHour":        "price_car_hour",
+}
+
+// ProfileFacetCounts counts the profiles found per value of every filter and
+// per bucket of every price. filtered returns the profiles found by all the
+// filters but the one of the facet named.
+func ProfileFacetCounts(filtered func(except string) *gorm.DB, bucket int) (ProfileFacets, error) {
+	var facets ProfileFacets
+	var err error
+
+	values := []struct {
+		facet  string
+		column string
+		join   string
+		counts *[]FacetCount
+	}{
+		{FacetCity, "profiles.city_id", "", &facets.Cities},
+		{FacetEthnos, "profiles.ethnos_id", "", &facets.Ethnoses},
+		{FacetBodyType, "profiles.body_type_id", "", &facets.BodyTypes},
+		{FacetHairColor, "profiles.hair_color_id", "", &facets.HairColors},
+		{FacetBodyArt, "profile_body_arts.body_art_id", "JOIN profile_body_arts ON profile_body_arts.profile_id = profiles.id", &facets.BodyArts},
+		{FacetProfileTag, "profile_options.profile_tag_id", "JOIN profile_options ON profile_options.profile_id = profiles.id", &facets.ProfileTags},
+	}
+
+	for _, v := range values {
+		db := filtered(v.facet)
+		if v.join != "" {
+			db = db.Joins(v.join)
+		}
+
+		*v.counts = []FacetCount{}
+		err = db.Select(v.column + " AS value, COUNT(DISTINCT profiles.id) AS count").
+			Where(v.column + " IS NOT NULL").
+			Group(v.column).
+			Order("count DESC, value").
+			Scan(v.counts).Error
+		if err != nil {
+			return facets, err
+		}
+	}
+
+	facets.Prices = make(map[string][]PriceBucket, len(ProfilePrices))
+
+	for facet, column := range ProfilePrices {
+		var rows []struct {
+			Bucket int
+			Count  int64
+		}
+
+		column = "profiles." + column
+		err = filtered(facet).Select(column+" / ? AS bucket, COUNT(*) AS count", bucket).
+			Where(column + " IS NOT NULL").
+			Group("bucket").
+			Order("bucket").
+			Scan(&rows).Error
+		if err != nil {
+			return facets, err
+		}
+
+		buckets := make([]PriceBucket, len(rows))
+		for i, row := range rows {
+			buckets[i] = PriceBucket{From: row.Bucket * bucket, To: (row.Bucket + 1) * bucket, Count: row.Count}
+		}
+		facets.Prices[facet] = buckets
+	}
+
+	return facets, nil
+}
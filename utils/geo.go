@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const earthRadiusKm = 6371
+
+// MaxSearchRadiusKm bounds radius searches, wider ones can't use the index.
+const MaxSearchRadiusKm = 500
+
+func degToRad(deg float64) float64 {
+	return deg * (math.Pi / 180)
+}
+
+// Distance is the great-circle distance in km between two points, by the
+// haversine formula.
+func Distance(latA, lonA, latB, lonB float64) float64 {
+	dLat := degToRad(latB - latA)
+	dLon := degToRad(lonB - lonA)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Sin(dLon/2)*math.Sin(dLon/2)*math.Cos(degToRad(latA))*math.Cos(degToRad(latB))
+
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// RoundDistance rounds a distance up to whole km, so it can't be used to
+// tell where a profile is exactly.
+func RoundDistance(km float64) float64 {
+	return math.Max(1, math.Ceil(km))
+}
+
+// profileDistanceSQL is Distance from the point to the profile in SQL.
+const profileDistanceSQL = `? * 2 * asin(least(1, sqrt(
+	power(sin(radians(profiles.address_latitude - ?) / 2), 2) +
+	cos(radians(?)) * cos(radians(profiles.address_latitude)) * power(sin(radians(profiles.address_longitude - ?) / 2), 2))))`
+
+// ProfileDistance is the distance in km from the point to the profile, for
+// queries over profiles.
+func ProfileDistance(lat, lon float64) clause.Expr {
+	return clause.Expr{SQL: profileDistanceSQL, Vars: []interface{}{earthRadiusKm, lat, lat, lon}}
+}
+
+// WithinRadius keeps the profiles within km of the point. The box around the
+// circle is matched first, on idx_profile_location.
+func WithinRadius(db *gorm.DB, lat, lon, km float64) *gorm.DB {
+	dLat := km / earthRadiusKm * 180 / math.Pi
+	minLat, maxLat := lat-dLat, lat+dLat
+
+	db = db.Where("profiles.address_latitude BETWEEN ? AND ?", math.Max(minLat, -90), math.Min(maxLat, 90))
+
+	// a box around a pole or as wide as the globe spans every longitude
+	if minLat > -90 && maxLat < 90 {
+		dLon := math.Asin(math.Min(1, math.Sin(km/earthRadiusKm)/math.Cos(degToRad(lat)))) * 180 / math.Pi
+		minLon, maxLon := lon-dLon, lon+dLon
+
+		switch {
+		case dLon >= 180:
+		case minLon < -180:
+			db = db.Where("(profiles.address_longitude >= ? OR profiles.address_longitude <= ?)", minLon+360, maxLon)
+		case maxLon > 180:
+			db = db.Where("(profiles.address_longitude >= ? OR profiles.address_longitude <= ?)", minLon, maxLon-360)
+		default:
+			db = db.Where("profiles.address_longitude BETWEEN ? AND ?", minLon, maxLon)
+		}
+	}
+
+	return db.Where("? <= ?", ProfileDistance(lat, lon), km)
+}
+
+// ConvertProfileCoordinates clears the profile coordinates saved as text
+// which aren't numbers, so the migration can make the columns numeric. It is
+// a one-way conversion run by the migrate command only, before the profiles
+// are migrated, and does nothing once the columns are numeric.
+func ConvertProfileCoordinates(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Profile{}) {
+		return nil
+	}
+
+	columnTypes, err := db.Migrator().ColumnTypes(&Profile{})
+	if err != nil {
+		return err
+	}
+
+	for _, columnType := range columnTypes {
+		name := columnType.Name()
+		if name != "address_latitude" && name != "address_longitude" {
+			continue
+		}
+
+		if !strings.Contains(strings.ToLower(columnType.DatabaseTypeName()), "char") {
+			continue
+		}
+
+		sql := fmt.Sprintf(`UPDATE profiles SET %[1]s = NULL WHERE %[1]s !~ '^\s*-?[0-9]+(\.[0-9]+)?\s*$'`, name)
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// KeySet holds the key new tokens are signed with and every key tokens are
+// verified with. Keys are identified by their RFC 7638 thumbprint, which is
+// put into the kid header of every signed token. Keeping the previous public
+// keys in the set lets tokens signed before a key rotation stay valid.
+type KeySet struct {
+	signingKey   *rsa.PrivateKey
+	signingKeyID string
+	publicKeys   map[string]*rsa.PublicKey
+	keyIDs       []string
+}
+
+// JWK is the JSON Web Key representation of an RSA public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet builds a key set from base64 encoded PEM keys. privateKey may be
+// empty for a set that only verifies tokens. Its public key is always part of
+// the set.
+func NewKeySet(privateKey string, publicKeys ...string) (*KeySet, error) {
+	ks := &KeySet{publicKeys: map[string]*rsa.PublicKey{}}
+
+	if privateKey != "" {
+		decodedPrivateKey, err := base64.StdEncoding.DecodeString(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode key: %w", err)
+		}
+
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(decodedPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("create: parse key: %w", err)
+		}
+
+		ks.signingKey = key
+		ks.signingKeyID = ks.add(&key.PublicKey)
+	}
+
+	for _, publicKey := range publicKeys {
+		publicKey = strings.TrimSpace(publicKey)
+		if publicKey == "" {
+			continue
+		}
+
+		decodedPublicKey, err := base64.StdEncoding.DecodeString(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode: %w", err)
+		}
+
+		key, err := jwt.ParseRSAPublicKeyFromPEM(decodedPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("validate: parse key: %w", err)
+		}
+
+		ks.add(key)
+	}
+
+	return ks, nil
+}
+
+func (ks *KeySet) add(key *rsa.PublicKey) string {
+	kid := KeyID(key)
+
+	if _, ok := ks.publicKeys[kid]; !ok {
+		ks.publicKeys[kid] = key
+		ks.keyIDs = append(ks.keyIDs, kid)
+	}
+
+	return kid
+}
+
+// SigningKeyID returns the kid of the key new tokens are signed with.
+func (ks *KeySet) SigningKeyID() string {
+	return ks.signingKeyID
+}
+
+// CreateToken signs the claims with the signing key of the set. Issued at,
+// not before and expiration are set from ttl.
+func (ks *KeySet) CreateToken(ttl time.Duration, claims *TokenClaims) (string, error) {
+	if ks.signingKey == nil {
+		return "", fmt.Errorf("create: no signing key")
+	}
+
+	now := time.Now().UTC()
+
+	claims.ExpiresAt = now.Add(ttl).Unix()
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ks.signingKeyID
+
+	signed, err := token.SignedString(ks.signingKey)
+	if err != nil {
+		return "", fmt.Errorf("create: sign token: %w", err)
+	}
+
+	return signed, nil
+}
+
+// ValidateToken checks the token with the key named by its kid header and
+// returns its claims. Tokens issued before kid headers were introduced are
+// checked against every key of the set.
+func (ks *KeySet) ValidateToken(token string) (*TokenClaims, error) {
+	unverified, _, err := new(jwt.Parser).ParseUnverified(token, &TokenClaims{})
+	if err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	candidates := ks.keyIDs
+	if kid, ok := unverified.Header["kid"].(string); ok {
+		if _, found := ks.publicKeys[kid]; !found {
+			return nil, fmt.Errorf("validate: unknown key: %s", kid)
+		}
+		candidates = []string{kid}
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("validate: no verification keys")
+	}
+
+	var parsedToken *jwt.Token
+
+	for _, kid := range candidates {
+		key := ks.publicKeys[kid]
+
+		parsedToken, err = jwt.ParseWithClaims(token, &TokenClaims{}, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected method: %s", t.Header["alg"])
+			}
+			return key, nil
+		})
+
+		// only a wrong signature means another key could fit
+		var validationErr *jwt.ValidationError
+		if err == nil || !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+
+	claims, ok := parsedToken.Claims.(*TokenClaims)
+	if !ok || !parsedToken.Valid {
+		return nil, fmt.Errorf("validate: invalid token")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys of the set in JSON Web Key Set format.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keyIDs))}
+
+	for _, kid := range ks.keyIDs {
+		key := ks.publicKeys[kid]
+		n, e := jwkModulusExponent(key)
+
+		jwks.Keys = append(jwks.Keys, JWK{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: kid, N: n, E: e})
+	}
+
+	return jwks
+}
+
+func jwkModulusExponent(key *rsa.PublicKey) (string, string) {
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	return n, e
+}
+
+// KeyID returns the RFC 7638 JWK thumbprint of the key.
+func KeyID(key *rsa.PublicKey) string {
+	n, e := jwkModulusExponent(key)
+
+	// members in lexicographic order, no whitespace, as the RFC requires
+	canonical, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{e, "RSA", n})
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
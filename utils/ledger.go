@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WalletProvider is the provider of payments paid from the balance of the user.
+const WalletProvider = "wallet"
+
+var ErrInsufficientBalance = errors.New("the balance is too low")
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+func userAccountName(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+// ledgerAccount finds the account by its name, creating it empty at first use.
+func ledgerAccount(tx *gorm.DB, name string, userID *uuid.UUID) (LedgerAccount, error) {
+	now := time.Now()
+	account := LedgerAccount{Name: name, UserID: userID, CreatedAt: now, UpdatedAt: now}
+
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&account).Error; err != nil {
+		return account, err
+	}
+
+	err := tx.First(&account, "name = ?", name).Error
+	return account, err
+}
+
+// UserAccount is the wallet of the user.
+func UserAccount(tx *gorm.DB, userID uuid.UUID) (LedgerAccount, error) {
+	return ledgerAccount(tx, userAccountName(userID), &userID)
+}
+
+// SystemAccount is one of the system accounts, LedgerProvider, LedgerRevenue
+// or LedgerAdjustments.
+func SystemAccount(tx *gorm.DB, name string) (LedgerAccount, error) {
+	return ledgerAccount(tx, name, nil)
+}
+
+// Transfer records the transaction moving the amount from one account to the
+// other, as an entry taking it from the first and one adding it to the
+// second, and updates both balances. It has to run in a DB transaction. The
+// wallet of a user can't go below zero, unless overdraw is set, and
+// ErrInsufficientBalance is returned instead.
+func Transfer(tx *gorm.DB, transaction LedgerTransaction, from LedgerAccount, to LedgerAccount, amount float64, overdraw bool) (LedgerTransaction, error) {
+	amount = roundCents(amount)
+	if amount <= 0 {
+		return transaction, errors.New("the amount of a transfer must be positive")
+	}
+
+	// accounts are always locked in the order of their ids, so transfers
+	// between the same accounts in both directions don't deadlock
+	var accounts []LedgerAccount
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", []uuid.UUID{from.ID, to.ID}).Order("id").Find(&accounts).Error; err != nil {
+		return transaction, err
+	}
+
+	for _, account := range accounts {
+		if account.ID == from.ID && account.UserID != nil && !overdraw && roundCents(account.Balance-amount) < 0 {
+			return transaction, ErrInsufficientBalance
+		}
+	}
+
+	now := time.Now()
+	transaction.CreatedAt = now
+
+	if err := tx.Omit(clause.Associations).Create(&transaction).Error; err != nil {
+		return transaction, err
+	}
+
+	entries := []LedgerEntry{
+		{TransactionID: transaction.ID, AccountID: from.ID, Amount: -amount, CreatedAt: now},
+		{TransactionID: transaction.ID, AccountID: to.ID, Amount: amount, CreatedAt: now},
+	}
+
+	if err := tx.Omit(clause.Associations).Create(&entries).Error; err != nil {
+		return transaction, err
+	}
+
+	for _, entry := range entries {
+		err := tx.Model(&LedgerAccount{}).Where("id = ?", entry.AccountID).
+			Updates(map[string]interface{}{"balance": gorm.Expr("balance + ?", entry.Amount), "updated_at": now}).Error
+		if err != nil {
+			return transaction, err
+		}
+	}
+
+	return transaction, nil
+}
+
+// TopUp adds a completed top-up payment to the wallet of its user, within the
+// transaction of the payment.
+func TopUp(tx *gorm.DB, payment Payment) error {
+	provider, err := SystemAccount(tx, LedgerProvider)
+	if err != nil {
+		return err
+	}
+
+	wallet, err := UserAccount(tx, payment.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = Transfer(tx, LedgerTransaction{Kind: LedgerTopUp, PaymentID: &payment.ID}, provider, wallet, payment.Amount, false)
+	return err
+}
+
+// RefundTopUp takes the refunded amount of a top-up back from the wallet of
+// its user. The money may have been spent already, so the balance may go
+// below zero.
+func RefundTopUp(tx *gorm.DB, payment Payment, amount float64) error {
+	provider, err := SystemAccount(tx, LedgerProvider)
+	if err != nil {
+		return err
+	}
+
+	wallet, err := UserAccount(tx, payment.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = Transfer(tx, LedgerTransaction{Kind: LedgerRefund, PaymentID: &payment.ID}, wallet, provider, amount, true)
+	return err
+}
+
+// RefundPurchase pays the refunded amount of a payment made from the wallet
+// back to the wallet of its user.
+func RefundPurchase(tx *gorm.DB, payment Payment, amount float64) error {
+	revenue, err := SystemAccount(tx, LedgerRevenue)
+	if err != nil {
+		return err
+	}
+
+	wallet, err := UserAccount(tx, payment.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = Transfer(tx, LedgerTransaction{Kind: LedgerRefund, PaymentID: &payment.ID}, revenue, wallet, amount, false)
+	return err
+}
+
+// Purchase pays for the payment from the wallet of its user, within the
+// transaction of the payment.
+func Purchase(tx *gorm.DB, payment Payment) error {
+	wallet, err := UserAccount(tx, payment.UserID)
+	if err != nil {
+		return err
+	}
+
+	revenue, err := SystemAccount(tx, LedgerRevenue)
+	if err != nil {
+		return err
+	}
+
+	_, err = Transfer(tx, LedgerTransaction{Kind: LedgerPurchase, PaymentID: &payment.ID}, wallet, revenue, payment.Amount, false)
+	return err
+}
+
+// Adjust adds the amount to the wallet of the user, or takes it away when it
+// is negative, on behalf of an admin.
+func Adjust(tx *gorm.DB, userID uuid.UUID, amount float64, reason string, adminID uuid.UUID) (LedgerTransaction, error) {
+	wallet, err := UserAccount(tx, userID)
+	if err != nil {
+		return LedgerTransaction{}, err
+	}
+
+	adjustments, err := SystemAccount(tx, LedgerAdjustments)
+	if err != nil {
+		return LedgerTransaction{}, err
+	}
+
+	transaction := LedgerTransaction{Kind: LedgerAdjustment, Description: reason, CreatedBy: &adminID}
+
+	if amount < 0 {
+		return Transfer(tx, transaction, wallet, adjustments, -amount, false)
+	}
+
+	return Transfer(tx, transaction, adjustments, wallet, amount, false)
+}
+
+// RebuildBalances sets the balance of every account to the sum of its
+// entries and returns how many of them were off.
+func RebuildBalances(db *gorm.DB) (int, error) {
+	corrected := 0
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// no transfer may run meanwhile
+		var accounts []LedgerAccount
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Find(&accounts).Error; err != nil {
+			return err
+		}
+
+		type sum struct {
+			AccountID uuid.UUID
+			Total     float64
+		}
+
+		var sums []sum
+		if err := tx.Model(&LedgerEntry{}).Select("account_id, SUM(amount) AS total").Group("account_id").Scan(&sums).Error; err != nil {
+			return err
+		}
+
+		totals := make(map[uuid.UUID]float64, len(sums))
+		for _, s := range sums {
+			totals[s.AccountID] = roundCents(s.Total)
+		}
+
+		for _, account := range accounts {
+			if roundCents(account.Balance) == totals[account.ID] {
+				continue
+			}
+
+			err := tx.Model(&account).Updates(map[string]interface{}{"balance": totals[account.ID], "updated_at": time.Now()}).Error
+			if err != nil {
+				return err
+			}
+
+			corrected++
+		}
+
+		return nil
+	})
+
+	return corrected, err
+}
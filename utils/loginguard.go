@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LoginAttempts counts the failed logins of one key: an account or a client IP.
+type LoginAttempts struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+// LoginAttemptStore keeps the counters of LoginGuard. Implementations must be
+// safe for concurrent use.
+type LoginAttemptStore interface {
+	Get(key string) (LoginAttempts, bool)
+	// Update replaces the counter of the key with what update returns for it,
+	// atomically: concurrent updates of a key see each other's results. found
+	// tells whether the key had a counter.
+	Update(key string, update func(attempts LoginAttempts, found bool) LoginAttempts) LoginAttempts
+	Delete(key string)
+	// List returns the counters whose key starts with prefix.
+	List(prefix string) []LoginAttempts
+}
+
+// LoginLimits configure the guard for one kind of key.
+type LoginLimits struct {
+	// FreeAttempts failures are allowed without any delay.
+	FreeAttempts int
+	// BaseDelay is the wait after the first failure past the free ones, it
+	// doubles with every further failure.
+	BaseDelay time.Duration
+	// MaxFailures failures lock the key for Lockout.
+	MaxFailures int
+	Lockout     time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+const (
+	LoginAccountKeyPrefix = "account:"
+	LoginIPKeyPrefix      = "ip:"
+)
+
+// LoginGuard slows down and then locks out password guessing, both per
+// account and per client IP.
+type LoginGuard struct {
+	Store   LoginAttemptStore
+	Account LoginLimits
+	IP      LoginLimits
+}
+
+func NewLoginGuard(store LoginAttemptStore) *LoginGuard {
+	return &LoginGuard{
+		Store: store,
+		Account: LoginLimits{
+			FreeAttempts: 3,
+			BaseDelay:    time.Second,
+			MaxFailures:  10,
+			Lockout:      15 * time.Minute,
+			Window:       time.Hour,
+		},
+		// a lot of users can share an IP, so it gets more room
+		IP: LoginLimits{
+			FreeAttempts: 10,
+			BaseDelay:    time.Second,
+			MaxFailures:  50,
+			Lockout:      15 * time.Minute,
+			Window:       time.Hour,
+		},
+	}
+}
+
+// wait returns how long the key has to wait before the next attempt.
+func (limits LoginLimits) wait(attempts LoginAttempts, now time.Time) time.Duration {
+	if now.Before(attempts.LockedUntil) {
+		return attempts.LockedUntil.Sub(now)
+	}
+
+	extra := attempts.Failures - limits.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+
+	delay := limits.BaseDelay << min(extra-1, 16)
+	if delay > limits.Lockout {
+		delay = limits.Lockout
+	}
+
+	return max(attempts.LastFailure.Add(delay).Sub(now), 0)
+}
+
+// fresh returns the counter of the key, or a new one once the failures have
+// been forgotten.
+func (limits LoginLimits) fresh(key string, attempts LoginAttempts, found bool, now time.Time) LoginAttempts {
+	if !found || now.Sub(attempts.LastFailure) > limits.Window && now.After(attempts.LockedUntil) {
+		return LoginAttempts{Key: key}
+	}
+	return attempts
+}
+
+func (limits LoginLimits) fail(attempts LoginAttempts, now time.Time) LoginAttempts {
+	attempts.Failures++
+	attempts.LastFailure = now
+
+	if attempts.Failures >= limits.MaxFailures {
+		attempts.LockedUntil = now.Add(limits.Lockout)
+	}
+
+	return attempts
+}
+
+// take counts an attempt for the key unless it has to wait, in which case it
+// returns how long.
+func (g *LoginGuard) take(key string, limits LoginLimits, now time.Time) time.Duration {
+	var wait time.Duration
+
+	g.Store.Update(key, func(attempts LoginAttempts, found bool) LoginAttempts {
+		attempts = limits.fresh(key, attempts, found, now)
+
+		if wait = limits.wait(attempts, now); wait > 0 {
+			return attempts
+		}
+
+		return limits.fail(attempts, now)
+	})
+
+	return wait
+}
+
+// refund takes back an attempt counted by take.
+func (g *LoginGuard) refund(key string, limits LoginLimits) {
+	g.Store.Update(key, func(attempts LoginAttempts, found bool) LoginAttempts {
+		if !found {
+			return LoginAttempts{Key: key}
+		}
+
+		if attempts.Failures > 0 {
+			attempts.Failures--
+		}
+
+		if attempts.Failures < limits.MaxFailures {
+			attempts.LockedUntil = time.Time{}
+		}
+
+		return attempts
+	})
+}
+
+// Attempt returns how long the client has to wait before it may try to log
+// into the account again. Zero means the attempt is allowed, and it is
+// counted as failed right away so parallel guesses can't get past the
+// limits; Success takes it back.
+func (g *LoginGuard) Attempt(account string, ip string, now time.Time) time.Duration {
+	if wait := g.take(LoginAccountKeyPrefix+account, g.Account, now); wait > 0 {
+		return wait
+	}
+
+	if wait := g.take(LoginIPKeyPrefix+ip, g.IP, now); wait > 0 {
+		g.refund(LoginAccountKeyPrefix+account, g.Account)
+		return wait
+	}
+
+	return 0
+}
+
+// Failure records a failed attempt for the account and the IP.
+func (g *LoginGuard) Failure(account string, ip string, now time.Time) {
+	g.fail(LoginAccountKeyPrefix+account, g.Account, now)
+	g.fail(LoginIPKeyPrefix+ip, g.IP, now)
+}
+
+func (g *LoginGuard) fail(key string, limits LoginLimits, now time.Time) {
+	g.Store.Update(key, func(attempts LoginAttempts, found bool) LoginAttempts {
+		return limits.fail(limits.fresh(key, attempts, found, now), now)
+	})
+}
+
+// Success forgets the failures of the account and takes back the attempt
+// counted for the IP. The other failures of the IP are kept, so logging into
+// an own account doesn't help guessing passwords of others.
+func (g *LoginGuard) Success(account string, ip string) {
+	g.Store.Delete(LoginAccountKeyPrefix + account)
+	g.refund(LoginIPKeyPrefix+ip, g.IP)
+}
+
+// MemoryLoginAttemptStore keeps the counters in process memory. With several
+// replicas every replica counts on its own.
+type MemoryLoginAttemptStore struct {
+	mu        sync.Mutex
+	items     map[string]LoginAttempts
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+// NewMemoryLoginAttemptStore creates a store that drops counters untouched for ttl.
+func NewMemoryLoginAttemptStore(ttl time.Duration) *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{items: map[string]LoginAttempts{}, ttl: ttl, lastSweep: time.Now()}
+}
+
+func (s *MemoryLoginAttemptStore) expired(attempts LoginAttempts, now time.Time) bool {
+	return now.Sub(attempts.LastFailure) > s.ttl && now.After(attempts.LockedUntil)
+}
+
+func (s *MemoryLoginAttemptStore) Get(key string) (LoginAttempts, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.items[key]
+	return attempts, ok
+}
+
+func (s *MemoryLoginAttemptStore) Update(key string, update func(attempts LoginAttempts, found bool) LoginAttempts) LoginAttempts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, item := range s.items {
+			if s.expired(item, now) {
+				delete(s.items, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	attempts, found := s.items[key]
+	attempts = update(attempts, found)
+	attempts.Key = key
+	s.items[key] = attempts
+
+	return attempts
+}
+
+func (s *MemoryLoginAttemptStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.items, key)
+}
+
+func (s *MemoryLoginAttemptStore) List(prefix string) []LoginAttempts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	list := make([]LoginAttempts, 0)
+
+	for key, item := range s.items {
+		if strings.HasPrefix(key, prefix) && !s.expired(item, now) {
+			list = append(list, item)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastFailure.After(list[j].LastFailure)
+	})
+
+	return list
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	. "github.com/ivegotanidea/golang-gorm-postgres/models"
 	"strings"
+	"time"
 )
 
 func MapBodyArts(bodyArts []ProfileBodyArt) []ProfileBodyArtResponse {
@@ -139,20 +140,7 @@ func MapProfile(newProfile *Profile, baseUrl string) *ProfileResponse {
 	profileResponse.ProfileOptions = MapProfileOptions(newProfile.ProfileOptions)
 	profileResponse.Services = MapServices(newProfile.Services)
 
-	profileResponse.Contacts = []ContactResponse{
-		{
-			ContactType: "phone",
-			Value:       newProfile.ContactPhone,
-		},
-		{
-			ContactType: "telegram",
-			Value:       newProfile.ContactTG,
-		},
-		{
-			ContactType: "whatsapp",
-			Value:       newProfile.ContactWA,
-		},
-	}
+	profileResponse.Contacts = MapContacts(newProfile)
 
 	profileResponse.Prices = []PriceResponse{
 		{
@@ -345,3 +333,113 @@ func MapProfileRating(profileRating *ProfileRating) *ProfileRatingResponse {
 		UpdatedBy:         profileRating.UpdatedBy,
 	}
 }
+
+func MapTier(tier Tier) TierResponse {
+	return TierResponse{
+		Name:                 tier.Name,
+		Price:                tier.Price,
+		ReviewFields:         strings.Fields(tier.ReviewFields),
+		Search:               tier.Search,
+		ContactRevealsPerDay: tier.ContactRevealsPerDay,
+		ProfilesAllowed:      tier.ProfilesAllowed,
+		UpdatedAt:            tier.UpdatedAt,
+	}
+}
+
+func MapPayment(payment Payment) PaymentResponse {
+	return PaymentResponse{
+		ID:             payment.ID,
+		UserID:         payment.UserID,
+		Amount:         payment.Amount,
+		Status:         payment.Status,
+		Type:           payment.Type,
+		Tier:           payment.Tier,
+		ProfileID:      payment.ProfileID,
+		RefundedAmount: payment.RefundedAmount,
+		CreatedAt:      payment.CreatedAt,
+		UpdatedAt:      payment.UpdatedAt,
+		PaymentDate:    payment.PaymentDate,
+	}
+}
+
+func MapPaymentMismatch(mismatch PaymentMismatch) PaymentMismatchResponse {
+	return PaymentMismatchResponse{
+		ID:                mismatch.ID,
+		Day:               mismatch.Day.Format(time.DateOnly),
+		Provider:          mismatch.Provider,
+		ProviderPaymentID: mismatch.ProviderPaymentID,
+		Kind:              mismatch.Kind,
+		PaymentID:         mismatch.PaymentID,
+		LocalStatus:       mismatch.LocalStatus,
+		ProviderStatus:    mismatch.ProviderStatus,
+		LocalAmount:       mismatch.LocalAmount,
+		ProviderAmount:    mismatch.ProviderAmount,
+		CreatedAt:         mismatch.CreatedAt,
+	}
+}
+
+func MapBoostPlan(plan BoostPlan) BoostPlanResponse {
+	return BoostPlanResponse{
+		Name:    plan.Name,
+		Minutes: plan.Minutes,
+		Price:   plan.Price,
+	}
+}
+
+func MapBoost(boost Boost, now time.Time) BoostResponse {
+	return BoostResponse{
+		ID:        boost.ID,
+		ProfileID: boost.ProfileID,
+		PaymentID: boost.PaymentID,
+		Plan:      boost.Plan,
+		StartsAt:  boost.StartsAt,
+		EndsAt:    boost.EndsAt,
+		Active:    !boost.StartsAt.After(now) && boost.EndsAt.After(now),
+	}
+}
+
+func MapLedgerEntry(entry LedgerEntry) LedgerEntryResponse {
+	return LedgerEntryResponse{
+		ID:            entry.ID,
+		TransactionID: entry.TransactionID,
+		Kind:          entry.Transaction.Kind,
+		Amount:        entry.Amount,
+		PaymentID:     entry.Transaction.PaymentID,
+		Description:   entry.Transaction.Description,
+		CreatedAt:     entry.CreatedAt,
+	}
+}
+
+func MapContacts(profile *Profile) []ContactResponse {
+	return []ContactResponse{
+		{
+			ContactType: "phone",
+			Value:       profile.ContactPhone,
+		},
+		{
+			ContactType: "telegram",
+			Value:       profile.ContactTG,
+		},
+		{
+			ContactType: "whatsapp",
+			Value:       profile.ContactWA,
+		},
+	}
+}
+
+// HideContacts drops the contacts and the exact location from the profile,
+// for users who have to reveal them.
+func HideContacts(profile *ProfileResponse) {
+	profile.ContactPhone = ""
+	profile.ContactWA = ""
+	profile.ContactTG = ""
+	profile.Contacts = []ContactResponse{}
+	HideLocation(profile)
+}
+
+// HideLocation drops the coordinates of the profile, the rounded distance is
+// told instead.
+func HideLocation(profile *ProfileResponse) {
+	profile.AddressLatitude = nil
+	profile.AddressLongitude = nil
+}
@@ -1,8 +1,13 @@
 package utils
 
 import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"math/rand/v2"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -28,3 +33,49 @@ func HashPassword(password string) (string, error) {
 func VerifyPassword(hashedPassword string, candidatePassword string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(candidatePassword))
 }
+
+// GenerateSecureToken returns a random url-safe token built from n random bytes.
+func GenerateSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate token %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the SHA256 of a high-entropy token. Unlike passwords such
+// tokens don't need a slow hash, and the hash can be looked up directly.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ApiKeyPrefix starts every personal API key, so leaked keys are easy to spot.
+const ApiKeyPrefix = "ggp_"
+
+const apiKeyLookupLength = len(ApiKeyPrefix) + 8
+
+// GenerateApiKey returns a new API key and its lookup prefix, which is safe
+// to store and show in plain text.
+func GenerateApiKey() (key string, prefix string, err error) {
+	lookup := make([]byte, 4)
+	if _, err := cryptorand.Read(lookup); err != nil {
+		return "", "", fmt.Errorf("could not generate api key %w", err)
+	}
+
+	secret, err := GenerateSecureToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix = ApiKeyPrefix + hex.EncodeToString(lookup)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// ApiKeyLookupPrefix returns the lookup prefix of an API key.
+func ApiKeyLookupPrefix(key string) (string, bool) {
+	if len(key) <= apiKeyLookupLength+1 || !strings.HasPrefix(key, ApiKeyPrefix) || key[apiKeyLookupLength] != '_' {
+		return "", false
+	}
+	return key[:apiKeyLookupLength], true
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+)
+
+var ErrInvalidPaymentSignature = errors.New("invalid payment webhook signature")
+
+// Checkout is a payment started with the provider: its ID there and the page
+// the user pays on.
+type Checkout struct {
+	ProviderPaymentID string
+	URL               string
+}
+
+// ProviderEvent is a webhook event of a payment provider telling the new
+// status of a payment. Refund events tell the refund and its amount, which is
+// the rest of the payment when zero.
+type ProviderEvent struct {
+	ID                string
+	ProviderPaymentID string
+	Status            string
+	RefundID          string
+	Amount            float64
+	OccurredAt        time.Time
+}
+
+// PaymentProvider takes payments on behalf of the application and tells
+// about them through webhooks.
+type PaymentProvider interface {
+	// Name is stored with payments and events to tell providers apart.
+	Name() string
+	CreateCheckout(payment Payment) (Checkout, error)
+	// VerifySignature returns ErrInvalidPaymentSignature unless the webhook
+	// request body was signed by the provider.
+	VerifySignature(body []byte, signature string) error
+	// ParseEvent reads the event from a verified webhook request body.
+	ParseEvent(body []byte) (ProviderEvent, error)
+	// Refund pays the amount of the payment back to the payer and returns the
+	// ID of the refund with the provider. Asking again with the same
+	// idempotency key returns the same refund instead of paying twice.
+	Refund(payment Payment, amount float64, idempotencyKey string) (string, error)
+}
+
+// ValidPaymentTransition reports whether a payment can move from one status
+// to the other.
+func ValidPaymentTransition(from string, to string) bool {
+	return slices.Contains(PaymentTransitions[from], to)
+}
+
+// FakePaymentProvider takes no money: checkouts point to BaseURL and webhook
+// events are JSON signed with HMAC-SHA256 of Secret. Meant for local
+// development and tests.
+type FakePaymentProvider struct {
+	Secret  string
+	BaseURL string
+}
+
+// fakeProviderEvent is the body of a webhook request of the fake provider.
+type fakeProviderEvent struct {
+	ID         string    `json:"id"`
+	PaymentID  string    `json:"paymentId"`
+	Status     string    `json:"status"`
+	RefundID   string    `json:"refundId"`
+	Amount     float64   `json:"amount"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func NewFakePaymentProvider(secret string, baseURL string) *FakePaymentProvider {
+	return &FakePaymentProvider{Secret: secret, BaseURL: baseURL}
+}
+
+func (p *FakePaymentProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakePaymentProvider) CreateCheckout(payment Payment) (Checkout, error) {
+	id := "fake_" + uuid.New().String()
+
+	return Checkout{
+		ProviderPaymentID: id,
+		URL:               strings.TrimSuffix(p.BaseURL, "/") + "/checkout/" + id,
+	}, nil
+}
+
+// Sign is the signature the fake provider sends with the webhook request body.
+func (p *FakePaymentProvider) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(p.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *FakePaymentProvider) VerifySignature(body []byte, signature string) error {
+	if p.Secret == "" || !hmac.Equal([]byte(p.Sign(body)), []byte(signature)) {
+		return ErrInvalidPaymentSignature
+	}
+	return nil
+}
+
+func (p *FakePaymentProvider) ParseEvent(body []byte) (ProviderEvent, error) {
+	var event fakeProviderEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return ProviderEvent{}, err
+	}
+
+	if event.ID == "" || event.PaymentID == "" || event.Status == "" {
+		return ProviderEvent{}, errors.New("id, paymentId and status are required")
+	}
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	return ProviderEvent{
+		ID:                event.ID,
+		ProviderPaymentID: event.PaymentID,
+		Status:            event.Status,
+		RefundID:          event.RefundID,
+		Amount:            event.Amount,
+		OccurredAt:        event.OccurredAt,
+	}, nil
+}
+
+func (p *FakePaymentProvider) Refund(payment Payment, amount float64, idempotencyKey string) (string, error) {
+	if payment.ProviderPaymentID == nil {
+		return "", errors.New("the payment hasn't been started with the provider")
+	}
+
+	return "fake_refund_" + idempotencyKey, nil
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"strconv"
+
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+)
+
+// PolicyEnforcer is the part of the casbin enforcers ExplainPolicy needs.
+type PolicyEnforcer interface {
+	EnforceEx(rvals ...interface{}) (bool, []string, error)
+	GetPolicy() ([][]string, error)
+}
+
+// NewPolicyRequest gives the attributes the user is checked with for the
+// action on the object.
+func NewPolicyRequest(user User, obj string, act string, twoFactor bool, isOwner bool, scopes string) PolicyRequest {
+	return PolicyRequest{
+		Sub:        user.Role,
+		Obj:        obj,
+		Act:        act,
+		Tier:       user.Tier,
+		HasProfile: strconv.FormatBool(user.HasProfile),
+		TwoFactor:  strconv.FormatBool(twoFactor),
+		IsOwner:    strconv.FormatBool(isOwner),
+		Scopes:     scopes,
+	}
+}
+
+func matches(ruleValue string, value string) bool {
+	return ruleValue == "*" || ruleValue == value
+}
+
+// ExplainPolicy tells whether the policy allows the request and which rule
+// allowed it. A denied request comes with the rules for its object and action
+// and the attributes each of them failed on, checked the way casbin/model.conf
+// matches them.
+func ExplainPolicy(enforcer PolicyEnforcer, request PolicyRequest) (PolicyExplanation, error) {
+	explanation := PolicyExplanation{Request: request}
+
+	allowed, matched, err := enforcer.EnforceEx(request.Values()...)
+	if err != nil {
+		return explanation, err
+	}
+
+	explanation.Allowed = allowed
+
+	if allowed {
+		rule := NewPolicyRule(matched)
+		explanation.Rule = &rule
+		return explanation, nil
+	}
+
+	policy, err := enforcer.GetPolicy()
+	if err != nil {
+		return explanation, err
+	}
+
+	for _, values := range policy {
+		rule := NewPolicyRule(values)
+
+		if !matches(rule.Obj, request.Obj) || !matches(rule.Act, request.Act) {
+			continue
+		}
+
+		var fields []string
+
+		if rule.Sub == ResourceOwnerSubject {
+			if request.IsOwner != "true" {
+				fields = append(fields, "isOwner")
+			}
+		} else if rule.Sub != request.Sub {
+			fields = append(fields, "sub")
+		}
+
+		if !matches(rule.Tier, request.Tier) {
+			fields = append(fields, "tier")
+		}
+
+		if !matches(rule.HasProfile, request.HasProfile) {
+			fields = append(fields, "hasProfile")
+		}
+
+		if !matches(rule.TwoFactor, request.TwoFactor) {
+			fields = append(fields, "twoFactor")
+		}
+
+		if !ScopeAllows(request.Scopes, request.Obj, request.Act) {
+			fields = append(fields, "scopes")
+		}
+
+		explanation.Mismatches = append(explanation.Mismatches, PolicyRuleMismatch{Rule: rule, Fields: fields})
+	}
+
+	return explanation, nil
+}
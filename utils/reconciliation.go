@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SettlementLine is a payment in the settlement file of a provider: its ID
+// there, its status and the amount settled, which is the amount paid less
+// the refunds.
+type SettlementLine struct {
+	ProviderPaymentID string
+	Status            string
+	Amount            float64
+}
+
+// ParseSettlement reads a settlement file, a CSV with a header naming the
+// payment_id, status and amount columns, in any order.
+func ParseSettlement(r io.Reader) ([]SettlementLine, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header: %w", err)
+	}
+
+	columns := map[string]int{"payment_id": -1, "status": -1, "amount": -1}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := columns[name]; ok {
+			columns[name] = i
+		}
+	}
+
+	for name, i := range columns {
+		if i < 0 {
+			return nil, fmt.Errorf("the %s column is missing", name)
+		}
+	}
+
+	var lines []SettlementLine
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		amount, err := strconv.ParseFloat(strings.TrimSpace(record[columns["amount"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount", line)
+		}
+
+		id := strings.TrimSpace(record[columns["payment_id"]])
+		if id == "" {
+			return nil, fmt.Errorf("line %d: payment_id is empty", line)
+		}
+
+		lines = append(lines, SettlementLine{
+			ProviderPaymentID: id,
+			Status:            strings.ToLower(strings.TrimSpace(record[columns["status"]])),
+			Amount:            roundCents(amount),
+		})
+	}
+}
+
+// settledStatuses are the statuses of payments which the provider took money for.
+var settledStatuses = []string{PaymentCompleted, PaymentPartiallyRefunded, PaymentRefunded, PaymentDisputed}
+
+// Reconcile compares the settlement of the provider for the day with the
+// payments and saves the mismatches found: payments settled but unknown here,
+// payments settled here on the day but not by the provider, and payments
+// whose status or settled amount differ. Running it again for the day
+// replaces the mismatches found before.
+func Reconcile(db *gorm.DB, provider string, day time.Time, lines []SettlementLine) (PaymentReconciliation, error) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+
+	run := PaymentReconciliation{Day: day, Provider: provider, Lines: len(lines), CreatedAt: now, UpdatedAt: now}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// runs for the same day wait for each other
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "day"}, {Name: "provider"}},
+			DoUpdates: clause.AssignmentColumns([]string{"lines", "updated_at"}),
+		}).Create(&run).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("day = ? AND provider = ?", day, provider).Delete(&PaymentMismatch{}).Error; err != nil {
+			return err
+		}
+
+		ids := make([]string, len(lines))
+		for i, line := range lines {
+			ids[i] = line.ProviderPaymentID
+		}
+
+		var payments []Payment
+		if err := tx.Where("provider = ?", provider).
+			Where(tx.Where("provider_payment_id IN ?", ids).
+				Or("status IN ? AND payment_date >= ? AND payment_date < ?", settledStatuses, day, day.AddDate(0, 0, 1))).
+			Find(&payments).Error; err != nil {
+			return err
+		}
+
+		local := make(map[string]Payment, len(payments))
+		for _, payment := range payments {
+			if payment.ProviderPaymentID != nil {
+				local[*payment.ProviderPaymentID] = payment
+			}
+		}
+
+		var mismatches []PaymentMismatch
+
+		mismatch := func(kind string, id string, payment *Payment, line *SettlementLine) {
+			m := PaymentMismatch{Day: day, Provider: provider, ProviderPaymentID: id, Kind: kind, CreatedAt: now}
+			if payment != nil {
+				m.PaymentID = &payment.ID
+				m.LocalStatus = payment.Status
+				m.LocalAmount = roundCents(payment.Amount - payment.RefundedAmount)
+			}
+			if line != nil {
+				m.ProviderStatus = line.Status
+				m.ProviderAmount = line.Amount
+			}
+			mismatches = append(mismatches, m)
+		}
+
+		settled := make(map[string]bool, len(lines))
+
+		for i := range lines {
+			line := &lines[i]
+			settled[line.ProviderPaymentID] = true
+
+			payment, ok := local[line.ProviderPaymentID]
+			if !ok {
+				mismatch(MismatchMissingLocally, line.ProviderPaymentID, nil, line)
+				continue
+			}
+
+			if payment.Status != line.Status {
+				mismatch(MismatchStatus, line.ProviderPaymentID, &payment, line)
+			}
+
+			if math.Abs(roundCents(payment.Amount-payment.RefundedAmount)-line.Amount) >= 0.01 {
+				mismatch(MismatchAmount, line.ProviderPaymentID, &payment, line)
+			}
+		}
+
+		// the rest are the payments settled here on the day
+		for i := range payments {
+			if id := payments[i].ProviderPaymentID; id != nil && !settled[*id] {
+				mismatch(MismatchMissingInSettlement, *id, &payments[i], nil)
+			}
+		}
+
+		if len(mismatches) > 0 {
+			// a file listing a payment twice gives its mismatches once
+			if err := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(&mismatches).Error; err != nil {
+				return err
+			}
+		}
+
+		run.Mismatches = len(mismatches)
+
+		return tx.Model(&run).Update("mismatches", run.Mismatches).Error
+	})
+
+	return run, err
+}
+
+// ReconciliationJob reconciles the payments of every day with the settlement
+// file the provider leaves for it in Dir, named <provider>-<YYYY-MM-DD>.csv.
+type ReconciliationJob struct {
+	DB       *gorm.DB
+	Provider string
+	Dir      string
+}
+
+// SettlementPath is where the settlement file of the day is expected.
+func (j *ReconciliationJob) SettlementPath(day time.Time) string {
+	return filepath.Join(j.Dir, fmt.Sprintf("%s-%s.csv", j.Provider, day.Format(time.DateOnly)))
+}
+
+// Run reconciles the day, unless it has been reconciled already. It reports
+// whether it did, a day whose file isn't there yet is left for a later run.
+func (j *ReconciliationJob) Run(day time.Time) (bool, error) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	var runs int64
+	if err := j.DB.Model(&PaymentReconciliation{}).Where("day = ? AND provider = ?", day, j.Provider).Count(&runs).Error; err != nil {
+		return false, err
+	}
+
+	if runs > 0 {
+		return false, nil
+	}
+
+	file, err := os.Open(j.SettlementPath(day))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	lines, err := ParseSettlement(file)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", file.Name(), err)
+	}
+
+	run, err := Reconcile(j.DB, j.Provider, day, lines)
+	if err != nil {
+		return false, err
+	}
+
+	if run.Mismatches > 0 {
+		log.Printf("reconciliation of %s payments on %s found %d mismatches", j.Provider, day.Format(time.DateOnly), run.Mismatches)
+	}
+
+	return true, nil
+}
+
+// Start reconciles the previous day every interval, until stop is called.
+func (j *ReconciliationJob) Start(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := j.Run(time.Now().UTC().AddDate(0, 0, -1)); err != nil {
+					log.Printf("failed to reconcile payments: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// AllScopes is the scope of requests authenticated with a login session,
+// they can do whatever the role of the user allows.
+const AllScopes = "*"
+
+// ApiKeyAllowed reports whether users of the role may hold API keys. Staff
+// routes need the second factor, which a key never stands in for, so keys
+// are not issued to moderators, admins and owners at all.
+func ApiKeyAllowed(role string) bool {
+	switch role {
+	case "moderator", "admin", "owner":
+		return false
+	}
+	return true
+}
+
+var scopePattern = regexp.MustCompile(`^(\*|[a-z-]+):(\*|[a-z-]+)$`)
+
+// ValidScope reports whether scope is "*" or an "object:action" pair of the
+// casbin policy, either side of which may be "*".
+func ValidScope(scope string) bool {
+	return scope == AllScopes || scopePattern.MatchString(scope)
+}
+
+// ScopeAllows reports whether one of the space separated scopes covers the
+// action on the object.
+func ScopeAllows(scopes string, obj string, act string) bool {
+	for _, scope := range strings.Fields(scopes) {
+		if scope == AllScopes {
+			return true
+		}
+
+		scopeObj, scopeAct, _ := strings.Cut(scope, ":")
+		if (scopeObj == "*" || scopeObj == obj) && (scopeAct == "*" || scopeAct == act) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ScopeMatchFunc is ScopeAllows for casbin matchers:
+// scopeMatch(r.scopes, r.obj, r.act).
+func ScopeMatchFunc(args ...interface{}) (interface{}, error) {
+	if len(args) != 3 {
+		return false, fmt.Errorf("scopeMatch expects 3 arguments, got %d", len(args))
+	}
+
+	scopes, ok1 := args[0].(string)
+	obj, ok2 := args[1].(string)
+	act, ok3 := args[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return false, fmt.Errorf("scopeMatch expects string arguments")
+	}
+
+	return ScopeAllows(scopes, obj, act), nil
+}
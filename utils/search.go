@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// profileSearchVectorSQL weighs the words of the name over the bio over the
+// option comments, stemmed both as Russian and as English.
+const profileSearchVectorSQL = `
+	setweight(to_tsvector('russian', coalesce(profiles.name, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(profiles.name, '')), 'A') ||
+	setweight(to_tsvector('russian', coalesce(profiles.bio, '')), 'B') ||
+	setweight(to_tsvector('english', coalesce(profiles.bio, '')), 'B') ||
+	setweight(to_tsvector('russian', coalesce(options.comments, '')), 'C') ||
+	setweight(to_tsvector('english', coalesce(options.comments, '')), 'C')`
+
+// profileSearchTriggersSQL refreshes the search vector of a profile in the
+// database whenever its name, bio or options change, whatever changes them.
+const profileSearchTriggersSQL = `
+	CREATE OR REPLACE FUNCTION refresh_profile_search(target uuid) RETURNS void AS $$
+		UPDATE profiles SET search_vector = ` + profileSearchVectorSQL + `
+		FROM (SELECT string_agg(comment, ' ') AS comments FROM profile_options WHERE profile_options.profile_id = $1) AS options
+		WHERE profiles.id = $1
+	$$ LANGUAGE sql;
+
+	CREATE OR REPLACE FUNCTION profile_search_trigger() RETURNS trigger AS $$
+	BEGIN
+		IF TG_TABLE_NAME = 'profiles' THEN
+			PERFORM refresh_profile_search(NEW.id);
+		ELSIF TG_OP = 'DELETE' THEN
+			PERFORM refresh_profile_search(OLD.profile_id);
+		ELSE
+			PERFORM refresh_profile_search(NEW.profile_id);
+			IF TG_OP = 'UPDATE' AND OLD.profile_id <> NEW.profile_id THEN
+				PERFORM refresh_profile_search(OLD.profile_id);
+			END IF;
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql;
+
+	CREATE OR REPLACE TRIGGER profile_search AFTER INSERT OR UPDATE OF name, bio ON profiles
+		FOR EACH ROW EXECUTE FUNCTION profile_search_trigger();
+
+	CREATE OR REPLACE TRIGGER profile_option_search AFTER INSERT OR UPDATE OR DELETE ON profile_options
+		FOR EACH ROW EXECUTE FUNCTION profile_search_trigger();`
+
+// CreateProfileSearchTriggers has the database keep the search vectors of the
+// profiles up to date, so the profiles and options changed anywhere are
+// found by their new words. Run it once the profiles and their options are
+// migrated.
+func CreateProfileSearchTriggers(db *gorm.DB) error {
+	return db.Exec(profileSearchTriggersSQL).Error
+}
+
+// RebuildProfileSearch fills the search vectors of the profiles which have
+// none yet, saved before the triggers were there. It is run by the migrate
+// command only.
+func RebuildProfileSearch(db *gorm.DB) error {
+	return db.Exec(`UPDATE profiles SET search_vector = ` + profileSearchVectorSQL + `
+		FROM (SELECT profiles.id, string_agg(profile_options.comment, ' ') AS comments
+			FROM profiles LEFT JOIN profile_options ON profile_options.profile_id = profiles.id
+			WHERE profiles.search_vector IS NULL
+			GROUP BY profiles.id) AS options
+		WHERE profiles.id = options.id`).Error
+}
+
+// ProfileSearchQuery is the words of the search, as typed in a search box,
+// matched in Russian or in English.
+func ProfileSearchQuery(search string) clause.Expr {
+	return clause.Expr{
+		SQL:  "(websearch_to_tsquery('russian', ?) || websearch_to_tsquery('english', ?))",
+		Vars: []interface{}{search, search},
+	}
+}
+
+// MatchingProfiles keeps the profiles matching the search.
+func MatchingProfiles(db *gorm.DB, search string) *gorm.DB {
+	return db.Where("profiles.search_vector @@ ?", ProfileSearchQuery(search))
+}
+
+// MostRelevantFirst orders the profiles by how well they match the search.
+func MostRelevantFirst(db *gorm.DB, search string) *gorm.DB {
+	return db.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:                "ts_rank(profiles.search_vector, ?) DESC, profiles.id",
+		Vars:               []interface{}{ProfileSearchQuery(search)},
+		WithoutParentheses: true,
+	}})
+}
+
+// ProfileHighlights returns snippets of the name, bio and option comments of
+// the profiles with the words of the search in <mark> tags. The rest of the
+// text is HTML escaped. Like the search vector, the text is read both as
+// Russian and as English, and highlighted in the language it matches better.
+func ProfileHighlights(db *gorm.DB, profileIDs []uuid.UUID, search string) (map[uuid.UUID]string, error) {
+	var rows []struct {
+		ID        uuid.UUID
+		Highlight string
+	}
+
+	if len(profileIDs) == 0 {
+		return map[uuid.UUID]string{}, nil
+	}
+
+	options := "MaxFragments=2, MaxWords=20, MinWords=5, StartSel=<mark>, StopSel=</mark>"
+
+	err := db.Raw(`SELECT profiles.id,
+			CASE WHEN ts_rank(to_tsvector('english', doc.text), doc.english) > ts_rank(to_tsvector('russian', doc.text), doc.russian)
+				THEN ts_headline('english', doc.text, doc.english, ?)
+				ELSE ts_headline('russian', doc.text, doc.russian, ?)
+			END AS highlight
+		FROM profiles
+		LEFT JOIN (SELECT profile_id, string_agg(comment, ' ') AS comments FROM profile_options WHERE profile_id IN ? GROUP BY profile_id) AS options
+			ON options.profile_id = profiles.id
+		CROSS JOIN LATERAL (SELECT
+			replace(replace(replace(concat_ws(' ', profiles.name, profiles.bio, options.comments), '&', '&amp;'), '<', '&lt;'), '>', '&gt;') AS text,
+			websearch_to_tsquery('russian', ?) AS russian,
+			websearch_to_tsquery('english', ?) AS english) AS doc
+		WHERE profiles.id IN ?`, options, options, profileIDs, search, search, profileIDs).Scan(&rows).Error
+
+	highlights := make(map[uuid.UUID]string, len(rows))
+	for _, row := range rows {
+		highlights[row.ID] = row.Highlight
+	}
+
+	return highlights, err
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SmsSender delivers text messages to phone numbers.
+type SmsSender interface {
+	Send(phone string, message string) error
+}
+
+// LogSmsSender writes messages to the application log instead of sending them.
+// Meant for local development.
+type LogSmsSender struct{}
+
+func (LogSmsSender) Send(phone string, message string) error {
+	log.Printf("📨 SMS to %s: %s", phone, message)
+	return nil
+}
+
+// FileSmsSender appends messages to a file, one JSON object per line.
+// Meant for local development and tests.
+type FileSmsSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+type smsRecord struct {
+	Phone   string    `json:"phone"`
+	Message string    `json:"message"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+func NewFileSmsSender(path string) *FileSmsSender {
+	return &FileSmsSender{Path: path}
+}
+
+func (s *FileSmsSender) Send(phone string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("sms: open file: %w", err)
+	}
+	defer file.Close()
+
+	record, err := json.Marshal(smsRecord{Phone: phone, Message: message, SentAt: time.Now()})
+	if err != nil {
+		return fmt.Errorf("sms: encode message: %w", err)
+	}
+
+	if _, err := file.Write(append(record, '\n')); err != nil {
+		return fmt.Errorf("sms: write message: %w", err)
+	}
+
+	return nil
+}
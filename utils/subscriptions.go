@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SubscriptionService grants tiers for completed subscription payments and
+// takes them back when the subscriptions expire, telling users RemindBefore
+// the expiry, on Telegram or by SMS when they have no Telegram account.
+type SubscriptionService struct {
+	DB           *gorm.DB
+	Sender       TelegramSender
+	SmsSender    SmsSender
+	Period       time.Duration
+	RemindBefore time.Duration
+	// OnTierChange is called with the users whose tier has been taken back.
+	OnTierChange func(userID uuid.UUID)
+}
+
+func NewSubscriptionService(db *gorm.DB, sender TelegramSender, smsSender SmsSender, period time.Duration, remindBefore time.Duration) *SubscriptionService {
+	return &SubscriptionService{DB: db, Sender: sender, SmsSender: smsSender, Period: period, RemindBefore: remindBefore}
+}
+
+func (s *SubscriptionService) tierChanged(userID uuid.UUID) {
+	if s.OnTierChange != nil {
+		s.OnTierChange(userID)
+	}
+}
+
+// Grant gives the user the tier paid for by the completed payment, within the
+// transaction of the payment. Paying for the tier of an active subscription
+// extends it by a period, paying for another tier starts a new period with it.
+func (s *SubscriptionService) Grant(tx *gorm.DB, payment Payment, now time.Time) (Subscription, error) {
+	var subscription Subscription
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(Subscription{UserID: payment.UserID}).
+		Attrs(Subscription{CreatedAt: now}).
+		FirstOrInit(&subscription).Error
+	if err != nil {
+		return subscription, err
+	}
+
+	active := subscription.ExpiredAt == nil && subscription.ExpiresAt.After(now)
+
+	if active && subscription.Tier == payment.Tier {
+		subscription.ExpiresAt = subscription.ExpiresAt.Add(s.Period)
+	} else {
+		subscription.Tier = payment.Tier
+		subscription.StartedAt = now
+		subscription.ExpiresAt = now.Add(s.Period)
+	}
+
+	subscription.PaymentID = payment.ID
+	subscription.RemindedAt = nil
+	subscription.ExpiredAt = nil
+	subscription.UpdatedAt = now
+
+	if err := tx.Omit(clause.Associations).Save(&subscription).Error; err != nil {
+		return subscription, err
+	}
+
+	// staff keep the tier of their role
+	err = tx.Model(&User{}).Where("id = ? AND role = ?", payment.UserID, "user").
+		Updates(map[string]interface{}{"tier": payment.Tier, "updated_at": now}).Error
+
+	return subscription, err
+}
+
+// Revoke takes back what the refunded share of the payment granted, within
+// the transaction of the payment. While the subscription runs on the tier of
+// the payment it loses the share of the period paid for, and when nothing of
+// it is left it expires and the user is back on the basic tier. A payment for
+// a tier the user has switched away from since granted nothing which is left.
+// It reports whether the tier of the user has been taken back.
+func (s *SubscriptionService) Revoke(tx *gorm.DB, payment Payment, share float64, now time.Time) (bool, error) {
+	var subscription Subscription
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", payment.UserID).
+		First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if subscription.ExpiredAt != nil || subscription.Tier != payment.Tier {
+		return false, nil
+	}
+
+	subscription.ExpiresAt = subscription.ExpiresAt.Add(-time.Duration(float64(s.Period) * share))
+	subscription.UpdatedAt = now
+
+	if subscription.ExpiresAt.After(now) {
+		return false, tx.Omit(clause.Associations).Save(&subscription).Error
+	}
+
+	subscription.ExpiredAt = &now
+
+	if err := tx.Omit(clause.Associations).Save(&subscription).Error; err != nil {
+		return false, err
+	}
+
+	err = tx.Model(&User{}).Where("id = ? AND role = ? AND tier = ?", payment.UserID, "user", subscription.Tier).
+		Updates(map[string]interface{}{"tier": "basic", "updated_at": now}).Error
+
+	return err == nil, err
+}
+
+// Remind tells the users whose subscriptions expire within RemindBefore about
+// it, once per period. A subscription is only marked reminded once the message
+// is sent, the ones which failed are retried on the next run.
+func (s *SubscriptionService) Remind(now time.Time) (int, error) {
+	var subscriptions []Subscription
+
+	err := s.DB.Preload("User").
+		Where("expired_at IS NULL AND reminded_at IS NULL AND expires_at > ? AND expires_at <= ?", now, now.Add(s.RemindBefore)).
+		Find(&subscriptions).Error
+	if err != nil {
+		return 0, err
+	}
+
+	reminded := 0
+	for _, subscription := range subscriptions {
+		sent := false
+
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			// another replica may be reminding at the same time, it skips the
+			// subscriptions this one holds
+			var claimed []Subscription
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("id = ? AND reminded_at IS NULL", subscription.ID).
+				Find(&claimed).Error
+			if err != nil || len(claimed) == 0 {
+				return err
+			}
+
+			if err := s.remind(subscription); err != nil {
+				log.Printf("failed to remind user %s of the subscription expiry: %v", subscription.UserID, err)
+				return nil
+			}
+
+			sent = true
+
+			return tx.Model(&Subscription{}).Where("id = ?", subscription.ID).Update("reminded_at", now).Error
+		})
+
+		if err != nil {
+			return reminded, err
+		}
+
+		if sent {
+			reminded++
+		}
+	}
+
+	return reminded, nil
+}
+
+func (s *SubscriptionService) remind(subscription Subscription) error {
+	message := fmt.Sprintf("Your %s subscription expires on %s. Renew it to keep your tier.",
+		subscription.Tier, subscription.ExpiresAt.Format("2006-01-02 15:04 MST"))
+
+	if subscription.User.TelegramUserId != 0 {
+		return s.Sender.SendMessage(subscription.User.TelegramUserId, message)
+	}
+
+	if s.SmsSender == nil || subscription.User.Phone == "" {
+		return errors.New("the user can't be reached")
+	}
+
+	return s.SmsSender.Send(subscription.User.Phone, message)
+}
+
+// Expire ends the subscriptions past their expiry and puts their users back
+// on the basic tier, unless their tier has been changed since.
+func (s *SubscriptionService) Expire(now time.Time) (int, error) {
+	var subscriptions []Subscription
+
+	if err := s.DB.Where("expired_at IS NULL AND expires_at <= ?", now).Find(&subscriptions).Error; err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, subscription := range subscriptions {
+		ended := false
+
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			// another replica may be expiring at the same time
+			claimed := tx.Model(&Subscription{}).
+				Where("id = ? AND expired_at IS NULL AND expires_at <= ?", subscription.ID, now).
+				Updates(map[string]interface{}{"expired_at": now, "updated_at": now})
+			if claimed.Error != nil || claimed.RowsAffected == 0 {
+				return claimed.Error
+			}
+
+			ended = true
+
+			return tx.Model(&User{}).Where("id = ? AND role = ? AND tier = ?", subscription.UserID, "user", subscription.Tier).
+				Updates(map[string]interface{}{"tier": "basic", "updated_at": now}).Error
+		})
+
+		if err != nil {
+			return expired, err
+		}
+
+		if ended {
+			expired++
+			s.tierChanged(subscription.UserID)
+		}
+	}
+
+	return expired, nil
+}
+
+// Start reminds and expires subscriptions every interval, until stop is called.
+func (s *SubscriptionService) Start(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+
+				if _, err := s.Remind(now); err != nil {
+					log.Printf("failed to remind of subscription expiries: %v", err)
+				}
+
+				if _, err := s.Expire(now); err != nil {
+					log.Printf("failed to expire subscriptions: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
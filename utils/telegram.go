@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/ivegotanidea/golang-gorm-postgres/models"
+)
+
+// TelegramLoginHash computes the hash Telegram puts into Login Widget data.
+// The secret key is SHA256 of the bot token.
+func TelegramLoginHash(fields map[string]string, botToken string) string {
+	secretKey := sha256.Sum256([]byte(botToken))
+	return telegramHash(fields, secretKey[:])
+}
+
+// TelegramInitDataHash computes the hash Telegram puts into WebApp initData.
+// The secret key is HMAC-SHA256 of the bot token keyed with "WebAppData".
+func TelegramInitDataHash(fields map[string]string, botToken string) string {
+	mac := hmac.New(sha256.New, []byte("WebAppData"))
+	mac.Write([]byte(botToken))
+	return telegramHash(fields, mac.Sum(nil))
+}
+
+// telegramHash builds the data-check-string (all fields but hash, sorted by
+// key, as key=value lines) and signs it with the secret key.
+func telegramHash(fields map[string]string, secretKey []byte) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key != "hash" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + "=" + fields[key]
+	}
+
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TelegramLoginFields turns Login Widget data into the flat set of fields the hash is calculated over.
+func TelegramLoginFields(data *TelegramLoginData) map[string]string {
+	fields := map[string]string{
+		"id":        strconv.FormatInt(data.ID, 10),
+		"auth_date": strconv.FormatInt(data.AuthDate, 10),
+	}
+
+	optional := map[string]string{
+		"first_name": data.FirstName,
+		"last_name":  data.LastName,
+		"username":   data.Username,
+		"photo_url":  data.PhotoURL,
+	}
+
+	for key, value := range optional {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+
+	return fields
+}
+
+func checkAuthDate(authDate int64, maxAge time.Duration) error {
+	if authDate <= 0 {
+		return errors.New("telegram: auth_date is missing")
+	}
+
+	age := time.Since(time.Unix(authDate, 0))
+
+	if age > maxAge {
+		return errors.New("telegram: auth data is outdated")
+	}
+
+	// allow a bit of clock skew, but not payloads from the future
+	if age < -time.Minute {
+		return errors.New("telegram: auth_date is in the future")
+	}
+
+	return nil
+}
+
+// VerifyTelegramLogin checks the Login Widget payload was signed by our bot
+// and is not older than maxAge, and returns the Telegram user ID.
+func VerifyTelegramLogin(data *TelegramLoginData, botToken string, maxAge time.Duration) (int64, error) {
+	if botToken == "" {
+		return 0, errors.New("telegram: bot token is not configured")
+	}
+
+	if data.ID == 0 || data.Hash == "" {
+		return 0, errors.New("telegram: id and hash are required")
+	}
+
+	expected := TelegramLoginHash(TelegramLoginFields(data), botToken)
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(data.Hash))) {
+		return 0, errors.New("telegram: invalid hash")
+	}
+
+	if err := checkAuthDate(data.AuthDate, maxAge); err != nil {
+		return 0, err
+	}
+
+	return data.ID, nil
+}
+
+// VerifyTelegramInitData checks the WebApp initData query string was signed
+// by our bot and is not older than maxAge, and returns the Telegram user ID.
+func VerifyTelegramInitData(initData string, botToken string, maxAge time.Duration) (int64, error) {
+	if botToken == "" {
+		return 0, errors.New("telegram: bot token is not configured")
+	}
+
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return 0, fmt.Errorf("telegram: parse init data: %w", err)
+	}
+
+	fields := make(map[string]string, len(values))
+	for key := range values {
+		fields[key] = values.Get(key)
+	}
+
+	hash := fields["hash"]
+	if hash == "" {
+		return 0, errors.New("telegram: hash is required")
+	}
+
+	expected := TelegramInitDataHash(fields, botToken)
+
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(hash))) {
+		return 0, errors.New("telegram: invalid hash")
+	}
+
+	authDate, err := strconv.ParseInt(fields["auth_date"], 10, 64)
+	if err != nil {
+		return 0, errors.New("telegram: invalid auth_date")
+	}
+
+	if err := checkAuthDate(authDate, maxAge); err != nil {
+		return 0, err
+	}
+
+	var user struct {
+		ID int64 `json:"id"`
+	}
+
+	if err := json.Unmarshal([]byte(fields["user"]), &user); err != nil || user.ID == 0 {
+		return 0, errors.New("telegram: user is missing in init data")
+	}
+
+	return user.ID, nil
+}
+
+// TelegramSender delivers messages to Telegram users through our bot.
+type TelegramSender interface {
+	SendMessage(chatID int64, text string) error
+}
+
+// LogTelegramSender writes messages to the application log instead of sending them.
+// Meant for local development.
+type LogTelegramSender struct{}
+
+func (LogTelegramSender) SendMessage(chatID int64, text string) error {
+	log.Printf("📨 Telegram message to %d: %s", chatID, text)
+	return nil
+}
+
+// BotTelegramSender sends messages with the Telegram Bot API.
+type BotTelegramSender struct {
+	BotToken string
+	Client   *http.Client
+}
+
+func NewBotTelegramSender(botToken string) *BotTelegramSender {
+	return &BotTelegramSender{BotToken: botToken, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *BotTelegramSender) SendMessage(chatID int64, text string) error {
+	body, err := json.Marshal(map[string]interface{}{"chat_id": chatID, "text": text})
+	if err != nil {
+		return fmt.Errorf("telegram: encode message: %w", err)
+	}
+
+	resp, err := s.Client.Post(
+		fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", s.BotToken),
+		"application/json",
+		bytes.NewReader(body))
+
+	if err != nil {
+		return fmt.Errorf("telegram: send message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram: send message: unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
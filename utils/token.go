@@ -1,68 +1,63 @@
 package utils
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
-func CreateToken(ttl time.Duration, payload interface{}, privateKey string) (string, error) {
-	decodedPrivateKey, err := base64.StdEncoding.DecodeString(privateKey)
-	if err != nil {
-		return "", fmt.Errorf("could not decode key: %w", err)
-	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(decodedPrivateKey)
-
-	if err != nil {
-		return "", fmt.Errorf("create: parse key: %w", err)
-	}
-
-	now := time.Now().UTC()
-
-	claims := make(jwt.MapClaims)
-	claims["sub"] = payload
-	claims["exp"] = now.Add(ttl).Unix()
-	claims["iat"] = now.Unix()
-	claims["nbf"] = now.Unix()
-
-	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
-
-	if err != nil {
-		return "", fmt.Errorf("create: sign token: %w", err)
-	}
+// Token types, put into the typ claim. A token is only accepted where its type is expected,
+// so e.g. a refresh token can't be used as an access token.
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+	PreAuthTokenType = "preauth"
+)
 
-	return token, nil
+// TokenClaims are the claims of every token we issue. The role and tier of
+// the user are not among them: they change while the token is valid, so
+// DeserializeUser reads them from AuthCache instead.
+type TokenClaims struct {
+	jwt.StandardClaims
+	Type      string `json:"typ"`
+	SessionID string `json:"sid,omitempty"`
+	// ActorID and ImpersonationID are set on impersonation tokens: the
+	// subject is the impersonated user, the actor is the staff member.
+	ActorID         string `json:"act,omitempty"`
+	ImpersonationID string `json:"imp,omitempty"`
 }
 
-func ValidateToken(token string, publicKey string) (interface{}, error) {
-	decodedPublicKey, err := base64.StdEncoding.DecodeString(publicKey)
+// CreateToken signs the claims with the private key. Issued at, not before
+// and expiration are set from ttl.
+func CreateToken(ttl time.Duration, claims TokenClaims, privateKey string) (string, error) {
+	keys, err := NewKeySet(privateKey)
 	if err != nil {
-		return nil, fmt.Errorf("could not decode: %w", err)
+		return "", err
 	}
 
-	key, err := jwt.ParseRSAPublicKeyFromPEM(decodedPublicKey)
+	return keys.CreateToken(ttl, &claims)
+}
 
+// ValidateToken validates the token with any of the public keys, checks it
+// has the expected type and returns its claims. Pass the current public key
+// together with the ones of retired signing keys, so tokens issued before a
+// rotation stay valid.
+func ValidateToken(token string, tokenType string, publicKeys ...string) (*TokenClaims, error) {
+	keys, err := NewKeySet("", publicKeys...)
 	if err != nil {
-		return "", fmt.Errorf("validate: parse key: %w", err)
+		return nil, err
 	}
 
-	parsedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected method: %s", t.Header["alg"])
-		}
-		return key, nil
-	})
-
+	claims, err := keys.ValidateToken(token)
 	if err != nil {
-		return nil, fmt.Errorf("validate: %w", err)
+		return nil, err
 	}
 
-	claims, ok := parsedToken.Claims.(jwt.MapClaims)
-	if !ok || !parsedToken.Valid {
-		return nil, fmt.Errorf("validate: invalid token")
+	if claims.Type != tokenType {
+		return nil, fmt.Errorf("validate: %w", errors.New("unexpected token type"))
 	}
 
-	return claims["sub"], nil
+	return claims, nil
 }